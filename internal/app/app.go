@@ -121,14 +121,16 @@ func (a *App) Start() error {
 	a.groupManager.Initialize()
 
 	// Create HTTP server
+	// WriteTimeout is lifted per request for streaming responses so long streams are not cut off
 	serverConfig := a.configManager.GetEffectiveServerConfig()
 	a.httpServer = &http.Server{
-		Addr:           fmt.Sprintf("%s:%d", serverConfig.Host, serverConfig.Port),
-		Handler:        a.engine,
-		ReadTimeout:    time.Duration(serverConfig.ReadTimeout) * time.Second,
-		WriteTimeout:   time.Duration(serverConfig.WriteTimeout) * time.Second,
-		IdleTimeout:    time.Duration(serverConfig.IdleTimeout) * time.Second,
-		MaxHeaderBytes: 1 << 20,
+		Addr:              fmt.Sprintf("%s:%d", serverConfig.Host, serverConfig.Port),
+		Handler:           a.engine,
+		ReadTimeout:       time.Duration(serverConfig.ReadTimeout) * time.Second,
+		ReadHeaderTimeout: time.Duration(serverConfig.ReadHeaderTimeout) * time.Second,
+		WriteTimeout:      time.Duration(serverConfig.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(serverConfig.IdleTimeout) * time.Second,
+		MaxHeaderBytes:    1 << 20,
 	}
 
 	// Start HTTP server in a new goroutine
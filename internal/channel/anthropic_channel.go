@@ -5,19 +5,18 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	app_errors "gpt-load/internal/errors"
 	"gpt-load/internal/models"
 	"gpt-load/internal/utils"
 	"io"
 	"net/http"
 	"net/url"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 func init() {
 	Register("anthropic", newAnthropicChannel)
+	registerDefaultTestModel("anthropic", "claude-3-haiku-20240307")
 }
 
 type AnthropicChannel struct {
@@ -43,23 +42,7 @@ func (ch *AnthropicChannel) ModifyRequest(req *http.Request, apiKey *models.APIK
 
 // IsStreamRequest checks if the request is for a streaming response using the pre-read body.
 func (ch *AnthropicChannel) IsStreamRequest(c *gin.Context, bodyBytes []byte) bool {
-	if strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
-		return true
-	}
-
-	if c.Query("stream") == "true" {
-		return true
-	}
-
-	type streamPayload struct {
-		Stream bool `json:"stream"`
-	}
-	var p streamPayload
-	if err := json.Unmarshal(bodyBytes, &p); err == nil {
-		return p.Stream
-	}
-
-	return false
+	return isStandardStreamRequest(c, bodyBytes)
 }
 
 func (ch *AnthropicChannel) ExtractModel(c *gin.Context, bodyBytes []byte) string {
@@ -91,7 +74,7 @@ func (ch *AnthropicChannel) ValidateKey(ctx context.Context, apiKey *models.APIK
 
 	// Use a minimal, low-cost payload for validation
 	payload := gin.H{
-		"model":      ch.TestModel,
+		"model":      ch.testModel(),
 		"max_tokens": 100,
 		"messages": []gin.H{
 			{"role": "user", "content": "hi"},
@@ -133,10 +116,5 @@ func (ch *AnthropicChannel) ValidateKey(ctx context.Context, apiKey *models.APIK
 		return false, fmt.Errorf("key is invalid (status %d), but failed to read error body: %w", resp.StatusCode, err)
 	}
 
-	// Use the new parser to extract a clean error message.
-	parsedError := app_errors.ParseUpstreamError(errorBody)
-
-	return false, fmt.Errorf("[status %d] %s", resp.StatusCode, parsedError)
+	return false, validationError(group, resp.StatusCode, errorBody)
 }
-
-func (ch *AnthropicChannel) ReshapeStreamReqBody(req *http.Request) {}
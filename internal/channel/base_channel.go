@@ -3,6 +3,7 @@ package channel
 import (
 	"bytes"
 	"fmt"
+	app_errors "gpt-load/internal/errors"
 	"gpt-load/internal/models"
 	"gpt-load/internal/types"
 	"net/http"
@@ -119,7 +120,28 @@ func (b *BaseChannel) GetStreamClient() *http.Client {
 	return b.StreamClient
 }
 
+// base returns the underlying BaseChannel of a concrete channel.
+func (b *BaseChannel) base() *BaseChannel {
+	return b
+}
+
+// ReshapeStreamReqBody leaves the stream request body as it is. Channels that need to adjust
+// it override this.
+func (b *BaseChannel) ReshapeStreamReqBody(req *http.Request, injectDone bool) {}
+
 // GetChannelType returns the channel type identifier
 func (b *BaseChannel) GetChannelType() string {
 	return b.channelType
 }
+
+// validationError describes a failed key validation by its status and parsed upstream message.
+// With the group's standard error envelope enabled, the standardized code is included too.
+func validationError(group *models.Group, statusCode int, errorBody []byte) error {
+	parsedError := app_errors.ParseUpstreamError(errorBody)
+	if group.EffectiveConfig.StandardErrorEnvelope > 0 {
+		mapping := app_errors.ParseErrorCodeMapping(group.EffectiveConfig.ErrorCodeMapping)
+		code := app_errors.ClassifyUpstreamError(statusCode, errorBody, mapping)
+		return fmt.Errorf("[status %d] %s: %s", statusCode, code, parsedError)
+	}
+	return fmt.Errorf("[status %d] %s", statusCode, parsedError)
+}
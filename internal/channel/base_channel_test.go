@@ -0,0 +1,26 @@
+package channel
+
+import (
+	"testing"
+
+	"gpt-load/internal/models"
+)
+
+func TestValidationErrorIncludesStandardCode(t *testing.T) {
+	body := []byte(`{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`)
+	group := &models.Group{}
+
+	if got := validationError(group, 401, body).Error(); got != "[status 401] invalid x-api-key" {
+		t.Errorf("Expected the plain validation error by default, got %q", got)
+	}
+
+	group.EffectiveConfig.StandardErrorEnvelope = 1
+	if got := validationError(group, 401, body).Error(); got != "[status 401] auth_error: invalid x-api-key" {
+		t.Errorf("Expected the standardized code in the validation error, got %q", got)
+	}
+
+	group.EffectiveConfig.ErrorCodeMapping = "authentication_error=upstream_unavailable"
+	if got := validationError(group, 401, body).Error(); got != "[status 401] upstream_unavailable: invalid x-api-key" {
+		t.Errorf("Expected the configured mapping to apply, got %q", got)
+	}
+}
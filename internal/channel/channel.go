@@ -35,8 +35,9 @@ type ChannelProxy interface {
 	// ValidateKey checks if the given API key is valid.
 	ValidateKey(ctx context.Context, apiKey *models.APIKey, group *models.Group) (bool, error)
 
-	// Reshape the Stream request body(At present, the main anti-truncation treatment)
-	ReshapeStreamReqBody(req *http.Request)
+	// Reshape the Stream request body(At present, the main anti-truncation treatment).
+	// injectDone is false when the client opted out of the done-token prompt for this request.
+	ReshapeStreamReqBody(req *http.Request, injectDone bool)
 
 	// GetChannelType returns the channel type identifier
 	GetChannelType() string
@@ -6,6 +6,8 @@ import (
 	"gpt-load/internal/config"
 	"gpt-load/internal/httpclient"
 	"gpt-load/internal/models"
+	"gpt-load/internal/utils"
+	"net/http"
 	"net/url"
 	"sync"
 	"time"
@@ -43,6 +45,7 @@ type Factory struct {
 	settingsManager *config.SystemSettingsManager
 	clientManager   *httpclient.HTTPClientManager
 	channelCache    map[uint]ChannelProxy
+	warmers         map[uint]*upstreamWarmer
 	cacheLock       sync.Mutex
 }
 
@@ -52,17 +55,29 @@ func NewFactory(settingsManager *config.SystemSettingsManager, clientManager *ht
 		settingsManager: settingsManager,
 		clientManager:   clientManager,
 		channelCache:    make(map[uint]ChannelProxy),
+		warmers:         make(map[uint]*upstreamWarmer),
 	}
 }
 
 // GetChannel returns a channel proxy based on the group's channel type.
 func (f *Factory) GetChannel(group *models.Group) (ChannelProxy, error) {
+	channel, stale, err := f.cachedChannel(group)
+	// Stopping waits for the warmer to exit, so it happens outside the cache lock
+	if stale != nil {
+		stale.stop()
+	}
+	return channel, err
+}
+
+// cachedChannel returns the cached channel for the group, creating it if missing or stale.
+// It also returns the warmer a recreated channel replaces, which the caller must stop.
+func (f *Factory) cachedChannel(group *models.Group) (ChannelProxy, *upstreamWarmer, error) {
 	f.cacheLock.Lock()
 	defer f.cacheLock.Unlock()
 
 	if channel, ok := f.channelCache[group.ID]; ok {
 		if !channel.IsConfigStale(group) {
-			return channel, nil
+			return channel, nil, nil
 		}
 	}
 
@@ -70,14 +85,68 @@ func (f *Factory) GetChannel(group *models.Group) (ChannelProxy, error) {
 
 	constructor, ok := channelRegistry[group.ChannelType]
 	if !ok {
-		return nil, fmt.Errorf("unsupported channel type: %s", group.ChannelType)
+		return nil, nil, fmt.Errorf("unsupported channel type: %s", group.ChannelType)
 	}
 	channel, err := constructor(f, group)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	f.channelCache[group.ID] = channel
-	return channel, nil
+	return channel, f.resetWarmer(group, channel), nil
+}
+
+// resetWarmer starts a warm-up loop for the group if configured and returns the loop it
+// replaces, if any, for the caller to stop once the cache lock is released.
+func (f *Factory) resetWarmer(group *models.Group, channel ChannelProxy) *upstreamWarmer {
+	old := f.warmers[group.ID]
+	delete(f.warmers, group.ID)
+
+	interval := group.EffectiveConfig.UpstreamWarmupInterval
+	poolSize := group.EffectiveConfig.UpstreamWarmConnections
+	if interval <= 0 && poolSize <= 0 {
+		return old
+	}
+
+	b, ok := channel.(interface{ base() *BaseChannel })
+	if !ok {
+		return old
+	}
+	base := b.base()
+
+	warmer := newUpstreamWarmer(time.Duration(interval)*time.Second, poolSize, []*http.Client{base.HTTPClient, base.StreamClient}, base.Upstreams)
+	warmer.start()
+	f.warmers[group.ID] = warmer
+	logrus.Debugf("Started upstream warm-up for group %d every %ds with %d connections per upstream", group.ID, interval, warmer.poolSize)
+	return old
+}
+
+// RetainGroups drops the cached channels of all groups not listed and stops their warm-up
+// loops, so that deleted groups stop pinging their upstreams.
+func (f *Factory) RetainGroups(groupIDs []uint) {
+	keep := make(map[uint]struct{}, len(groupIDs))
+	for _, id := range groupIDs {
+		keep[id] = struct{}{}
+	}
+
+	var stale []*upstreamWarmer
+	f.cacheLock.Lock()
+	for id := range f.channelCache {
+		if _, ok := keep[id]; !ok {
+			delete(f.channelCache, id)
+		}
+	}
+	for id, warmer := range f.warmers {
+		if _, ok := keep[id]; !ok {
+			stale = append(stale, warmer)
+			delete(f.warmers, id)
+			logrus.Debugf("Stopping upstream warm-up for removed group %d", id)
+		}
+	}
+	f.cacheLock.Unlock()
+
+	for _, warmer := range stale {
+		warmer.stop()
+	}
 }
 
 // newBaseChannel is a helper function to create and configure a BaseChannel.
@@ -109,13 +178,21 @@ func (f *Factory) newBaseChannel(name string, group *models.Group) (*BaseChannel
 		upstreamInfos = append(upstreamInfos, UpstreamInfo{URL: u, Weight: weight})
 	}
 
+	minTLSVersion, err := httpclient.ParseTLSVersion(group.EffectiveConfig.MinTLSVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid min_tls_version for %s channel: %w", name, err)
+	}
+
 	// Base configuration for regular requests, derived from the group's effective settings.
+	// The pool must be allowed to keep the warmed connections idle
+	maxIdleConnsPerHost := max(group.EffectiveConfig.MaxIdleConnsPerHost, group.EffectiveConfig.UpstreamWarmConnections)
+
 	clientConfig := &httpclient.Config{
 		ConnectTimeout:        time.Duration(group.EffectiveConfig.ConnectTimeout) * time.Second,
 		RequestTimeout:        time.Duration(group.EffectiveConfig.RequestTimeout) * time.Second,
 		IdleConnTimeout:       time.Duration(group.EffectiveConfig.IdleConnTimeout) * time.Second,
-		MaxIdleConns:          group.EffectiveConfig.MaxIdleConns,
-		MaxIdleConnsPerHost:   group.EffectiveConfig.MaxIdleConnsPerHost,
+		MaxIdleConns:          max(group.EffectiveConfig.MaxIdleConns, maxIdleConnsPerHost),
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
 		ResponseHeaderTimeout: time.Duration(group.EffectiveConfig.ResponseHeaderTimeout) * time.Second,
 		ProxyURL:              group.EffectiveConfig.ProxyURL,
 		DisableCompression:    false,
@@ -124,6 +201,9 @@ func (f *Factory) newBaseChannel(name string, group *models.Group) (*BaseChannel
 		ForceAttemptHTTP2:     true,
 		TLSHandshakeTimeout:   15 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
+		MinTLSVersion:         minTLSVersion,
+		MaxRedirects:          group.EffectiveConfig.UpstreamMaxRedirects,
+		RedirectHosts:         utils.SplitAndTrim(group.EffectiveConfig.UpstreamRedirectHosts, ","),
 	}
 
 	// Create a dedicated configuration for streaming requests.
@@ -134,7 +214,7 @@ func (f *Factory) newBaseChannel(name string, group *models.Group) (*BaseChannel
 	streamConfig.ReadBufferSize = 0
 	// Use a larger, independent connection pool for streaming clients to avoid exhaustion.
 	streamConfig.MaxIdleConns = max(group.EffectiveConfig.MaxIdleConns*2, 50)
-	streamConfig.MaxIdleConnsPerHost = max(group.EffectiveConfig.MaxIdleConnsPerHost*2, 20)
+	streamConfig.MaxIdleConnsPerHost = max(maxIdleConnsPerHost*2, 20)
 
 	// Get both clients from the manager using their respective configurations.
 	httpClient := f.clientManager.GetClient(clientConfig)
@@ -5,7 +5,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	app_errors "gpt-load/internal/errors"
 	"gpt-load/internal/models"
 	"gpt-load/internal/utils"
 	"io"
@@ -19,6 +18,7 @@ import (
 
 func init() {
 	Register("gemini", newGeminiChannel)
+	registerDefaultTestModel("gemini", "gemini-2.0-flash-lite")
 }
 
 type GeminiChannel struct {
@@ -36,13 +36,18 @@ func newGeminiChannel(f *Factory, group *models.Group) (ChannelProxy, error) {
 	}, nil
 }
 
-// ModifyRequest adds the API key as a query parameter for Gemini requests.
+// ModifyRequest adds the API key as a query parameter for Gemini requests. Streams are
+// requested with alt=sse, so they arrive SSE-framed rather than as a JSON array, unless the
+// group disables gemini_stream_sse or the client chose a framing with its own alt parameter.
 func (ch *GeminiChannel) ModifyRequest(req *http.Request, apiKey *models.APIKey, group *models.Group) {
 	if strings.Contains(req.URL.Path, "v1beta/openai") {
 		req.Header.Set("Authorization", "Bearer "+apiKey.KeyValue)
 	} else {
 		q := req.URL.Query()
 		q.Set("key", apiKey.KeyValue)
+		if group.EffectiveConfig.GeminiStreamSSE > 0 && strings.HasSuffix(req.URL.Path, ":streamGenerateContent") && q.Get("alt") == "" {
+			q.Set("alt", "sse")
+		}
 		req.URL.RawQuery = q.Encode()
 	}
 }
@@ -55,22 +60,7 @@ func (ch *GeminiChannel) IsStreamRequest(c *gin.Context, bodyBytes []byte) bool
 	}
 
 	// Also check for standard streaming indicators as a fallback.
-	if strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
-		return true
-	}
-	if c.Query("stream") == "true" {
-		return true
-	}
-
-	type streamPayload struct {
-		Stream bool `json:"stream"`
-	}
-	var p streamPayload
-	if err := json.Unmarshal(bodyBytes, &p); err == nil {
-		return p.Stream
-	}
-
-	return false
+	return isStandardStreamRequest(c, bodyBytes)
 }
 
 func (ch *GeminiChannel) ExtractModel(c *gin.Context, bodyBytes []byte) string {
@@ -104,7 +94,7 @@ func (ch *GeminiChannel) ValidateKey(ctx context.Context, apiKey *models.APIKey,
 	}
 
 	// Safely join the path segments
-	reqURL, err := url.JoinPath(upstreamURL.String(), "v1beta", "models", ch.TestModel+":generateContent")
+	reqURL, err := url.JoinPath(upstreamURL.String(), "v1beta", "models", ch.testModel()+":generateContent")
 	if err != nil {
 		return false, fmt.Errorf("failed to create gemini validation path: %w", err)
 	}
@@ -151,13 +141,14 @@ func (ch *GeminiChannel) ValidateKey(ctx context.Context, apiKey *models.APIKey,
 		return false, fmt.Errorf("key is invalid (status %d), but failed to read error body: %w", resp.StatusCode, err)
 	}
 
-	// Use the new parser to extract a clean error message.
-	parsedError := app_errors.ParseUpstreamError(errorBody)
-
-	return false, fmt.Errorf("[status %d] %s", resp.StatusCode, parsedError)
+	return false, validationError(group, resp.StatusCode, errorBody)
 }
 
-func (ch *GeminiChannel) ReshapeStreamReqBody(req *http.Request) {
+func (ch *GeminiChannel) ReshapeStreamReqBody(req *http.Request, injectDone bool) {
+    if !injectDone {
+        return
+    }
+
     bodyBytes, err := io.ReadAll(req.Body)
     if err != nil {
 				logrus.Errorf("Failed to read request body: %v", err)
@@ -184,13 +175,16 @@ func (ch *GeminiChannel) ReshapeStreamReqBody(req *http.Request) {
 }
 
 
+// doneTokenDirective is the system prompt part asking the model to end with the [done] token.
+const doneTokenDirective = "IMPORTANT: At the very end of your entire response, you must write the token [done] to signal completion. This is a mandatory technical requirement."
+
 // InjectSystemPrompt injects a system prompt to ensure the [done] token is present.
 // It intelligently handles both system_instruction (snake_case) and systemInstruction (camelCase)
 // by merging the content of system_instruction into systemInstruction before processing.
 // systemInstruction is the officially recommended format.
 func injectSystemPrompt(body map[string]interface{}) {
 	newSystemPromptPart := map[string]interface{}{
-		"text": "IMPORTANT: At the very end of your entire response, you must write the token [done] to signal completion. This is a mandatory technical requirement.",
+		"text": doneTokenDirective,
 	}
 
 	// Standardize: If system_instruction exists, merge its content into systemInstruction.
@@ -246,6 +240,12 @@ func injectSystemPrompt(body map[string]interface{}) {
 		return
 	}
 
-	// Case 3: The instruction field and its 'parts' array both exist. Append to the existing array.
+	// Case 3: The instruction field and its 'parts' array both exist. Append to the existing
+	// array, unless the directive is already there, as in a retry built from an injected body.
+	for _, part := range parts {
+		if p, ok := part.(map[string]interface{}); ok && p["text"] == doneTokenDirective {
+			return
+		}
+	}
 	instruction["parts"] = append(parts, newSystemPromptPart)
 }
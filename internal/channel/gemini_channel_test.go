@@ -0,0 +1,78 @@
+package channel
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"gpt-load/internal/models"
+)
+
+func TestGeminiReshapeSkipsInjectionWhenDisabled(t *testing.T) {
+	ch := &GeminiChannel{BaseChannel: &BaseChannel{}}
+	body := `{"contents":[{"role":"user","parts":[{"text":"hi"}]}]}`
+
+	req := httptest.NewRequest(http.MethodPost, "/v1beta/models/m:streamGenerateContent", bytes.NewBufferString(body))
+	ch.ReshapeStreamReqBody(req, false)
+	got, _ := io.ReadAll(req.Body)
+	if string(got) != body {
+		t.Errorf("Expected body to be left untouched when injection is disabled, got %s", got)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1beta/models/m:streamGenerateContent", bytes.NewBufferString(body))
+	ch.ReshapeStreamReqBody(req, true)
+	got, _ = io.ReadAll(req.Body)
+	if !strings.Contains(string(got), "[done]") {
+		t.Errorf("Expected done-token prompt to be injected by default, got %s", got)
+	}
+}
+
+func TestInjectSystemPromptIsIdempotent(t *testing.T) {
+	body := map[string]interface{}{
+		"system_instruction": map[string]interface{}{"parts": []interface{}{map[string]interface{}{"text": "Be brief."}}},
+		"contents":           []interface{}{},
+	}
+
+	injectSystemPrompt(body)
+	injectSystemPrompt(body)
+
+	parts := body["systemInstruction"].(map[string]interface{})["parts"].([]interface{})
+	directives := 0
+	for _, part := range parts {
+		if part.(map[string]interface{})["text"] == doneTokenDirective {
+			directives++
+		}
+	}
+	if directives != 1 || len(parts) != 2 {
+		t.Errorf("Expected the original part and the directive once, got %v", parts)
+	}
+}
+
+func TestGeminiModifyRequestAddsAltSSE(t *testing.T) {
+	ch := &GeminiChannel{BaseChannel: &BaseChannel{}}
+	apiKey := &models.APIKey{KeyValue: "gm-key"}
+	modify := func(target string, enabled int) url.Values {
+		group := &models.Group{}
+		group.EffectiveConfig.GeminiStreamSSE = enabled
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		ch.ModifyRequest(req, apiKey, group)
+		return req.URL.Query()
+	}
+
+	if q := modify("/v1beta/models/m:streamGenerateContent", 1); q.Get("alt") != "sse" || q.Get("key") != "gm-key" {
+		t.Errorf("Expected alt=sse to be added to a streaming request, got %s", q.Encode())
+	}
+	if q := modify("/v1beta/models/m:streamGenerateContent?alt=json", 1); q.Get("alt") != "json" {
+		t.Errorf("Expected the client's alt parameter to be kept, got %q", q.Get("alt"))
+	}
+	if q := modify("/v1beta/models/m:streamGenerateContent", 0); q.Has("alt") {
+		t.Errorf("Expected no alt parameter when disabled, got %q", q.Get("alt"))
+	}
+	if q := modify("/v1beta/models/m:generateContent", 1); q.Has("alt") {
+		t.Errorf("Expected non-streaming requests to be left alone, got %q", q.Get("alt"))
+	}
+}
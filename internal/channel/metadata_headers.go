@@ -0,0 +1,67 @@
+package channel
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gpt-load/internal/models"
+)
+
+// GoogRequestParamsHeader carries the routing parameters of a Google API request, in the
+// same form as gRPC request metadata.
+const GoogRequestParamsHeader = "X-Goog-Request-Params"
+
+// MetadataHeaderProvider is implemented by channels whose upstreams expect request metadata,
+// such as the model or the project and location, in dedicated headers. The headers are
+// computed from the outgoing upstream request.
+type MetadataHeaderProvider interface {
+	MetadataHeaders(req *http.Request, group *models.Group) http.Header
+}
+
+// ApplyMetadataHeaders sets the metadata headers a channel declares for an upstream request.
+// Headers the client already sent are left as they are.
+func ApplyMetadataHeaders(ch ChannelProxy, req *http.Request, group *models.Group) {
+	provider, ok := ch.(MetadataHeaderProvider)
+	if !ok {
+		return
+	}
+	for key, values := range provider.MetadataHeaders(req, group) {
+		if req.Header.Get(key) != "" {
+			continue
+		}
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+}
+
+// MetadataHeaders declares the x-goog-request-params header, naming the model resource the
+// request addresses: "models/{model}" on the Gemini API, or the full
+// "projects/{project}/locations/{location}/publishers/{publisher}/models/{model}" on Vertex AI.
+func (ch *GeminiChannel) MetadataHeaders(req *http.Request, group *models.Group) http.Header {
+	resource := geminiModelResource(req.URL.Path)
+	if resource == "" {
+		return nil
+	}
+	return http.Header{GoogRequestParamsHeader: {"model=" + url.QueryEscape(resource)}}
+}
+
+// geminiModelResource extracts the model resource name from a Gemini or Vertex AI request
+// path, without the ":method" suffix, or returns "" if the path names no model.
+func geminiModelResource(path string) string {
+	start := strings.Index(path, "/projects/")
+	if start < 0 {
+		start = strings.Index(path, "/models/")
+	}
+	if start < 0 {
+		return ""
+	}
+
+	resource := path[start+1:]
+	resource, _, _ = strings.Cut(resource, ":")
+	if !strings.Contains(resource, "models/") || strings.HasSuffix(resource, "/") {
+		return ""
+	}
+	return resource
+}
@@ -0,0 +1,39 @@
+package channel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gpt-load/internal/models"
+)
+
+func TestGeminiRequestParamsHeader(t *testing.T) {
+	ch := &GeminiChannel{BaseChannel: &BaseChannel{}}
+	group := &models.Group{Name: "gemini"}
+
+	tests := []struct {
+		path   string
+		header string
+	}{
+		{"/v1beta/models/gemini-1.5-pro:streamGenerateContent", "model=models%2Fgemini-1.5-pro"},
+		{"/v1/projects/my-project/locations/us-central1/publishers/google/models/gemini-2.0-flash:generateContent", "model=projects%2Fmy-project%2Flocations%2Fus-central1%2Fpublishers%2Fgoogle%2Fmodels%2Fgemini-2.0-flash"},
+		{"/v1beta/models", ""},
+		{"/v1beta/openai/chat/completions", ""},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "https://generativelanguage.googleapis.com"+test.path, nil)
+		ApplyMetadataHeaders(ch, req, group)
+		if got := req.Header.Get(GoogRequestParamsHeader); got != test.header {
+			t.Errorf("%s: expected request params %q, got %q", test.path, test.header, got)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1beta/models/gemini-1.5-pro:generateContent", nil)
+	req.Header.Set(GoogRequestParamsHeader, "model=custom")
+	ApplyMetadataHeaders(ch, req, group)
+	if got := req.Header.Values(GoogRequestParamsHeader); len(got) != 1 || got[0] != "model=custom" {
+		t.Errorf("Expected the client's header to be kept, got %v", got)
+	}
+}
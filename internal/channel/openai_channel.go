@@ -5,19 +5,19 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	app_errors "gpt-load/internal/errors"
 	"gpt-load/internal/models"
 	"gpt-load/internal/utils"
 	"io"
 	"net/http"
 	"net/url"
-	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
 func init() {
 	Register("openai", newOpenAIChannel)
+	registerDefaultTestModel("openai", "gpt-4.1-nano")
 }
 
 type OpenAIChannel struct {
@@ -42,23 +42,7 @@ func (ch *OpenAIChannel) ModifyRequest(req *http.Request, apiKey *models.APIKey,
 
 // IsStreamRequest checks if the request is for a streaming response using the pre-read body.
 func (ch *OpenAIChannel) IsStreamRequest(c *gin.Context, bodyBytes []byte) bool {
-	if strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
-		return true
-	}
-
-	if c.Query("stream") == "true" {
-		return true
-	}
-
-	type streamPayload struct {
-		Stream bool `json:"stream"`
-	}
-	var p streamPayload
-	if err := json.Unmarshal(bodyBytes, &p); err == nil {
-		return p.Stream
-	}
-
-	return false
+	return isStandardStreamRequest(c, bodyBytes)
 }
 
 func (ch *OpenAIChannel) ExtractModel(c *gin.Context, bodyBytes []byte) string {
@@ -90,7 +74,7 @@ func (ch *OpenAIChannel) ValidateKey(ctx context.Context, apiKey *models.APIKey,
 
 	// Use a minimal, low-cost payload for validation
 	payload := gin.H{
-		"model": ch.TestModel,
+		"model": ch.testModel(),
 		"messages": []gin.H{
 			{"role": "user", "content": "hi"},
 		},
@@ -130,10 +114,45 @@ func (ch *OpenAIChannel) ValidateKey(ctx context.Context, apiKey *models.APIKey,
 		return false, fmt.Errorf("key is invalid (status %d), but failed to read error body: %w", resp.StatusCode, err)
 	}
 
-	// Use the new parser to extract a clean error message.
-	parsedError := app_errors.ParseUpstreamError(errorBody)
-
-	return false, fmt.Errorf("[status %d] %s", resp.StatusCode, parsedError)
+	return false, validationError(group, resp.StatusCode, errorBody)
 }
 
-func (ch *OpenAIChannel) ReshapeStreamReqBody(req *http.Request) {}
+// ReshapeStreamReqBody asks for a final usage chunk by setting stream_options.include_usage
+// when the group enables stream usage. A value the client set itself is kept.
+func (ch *OpenAIChannel) ReshapeStreamReqBody(req *http.Request, injectDone bool) {
+	if ch.effectiveConfig == nil || ch.effectiveConfig.StreamIncludeUsage == 0 {
+		return
+	}
+
+	bodyBytes, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		logrus.Errorf("Failed to read request body: %v", err)
+		return
+	}
+	// The body is restored as it was unless it is rewritten below
+	req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(bodyBytes, &data); err != nil {
+		logrus.Errorf("Failed to unmarshal request body: %v", err)
+		return
+	}
+	options, _ := data["stream_options"].(map[string]interface{})
+	if options == nil {
+		options = make(map[string]interface{})
+	}
+	if _, set := options["include_usage"]; set {
+		return
+	}
+	options["include_usage"] = true
+	data["stream_options"] = options
+
+	newBody, err := json.Marshal(data)
+	if err != nil {
+		logrus.Errorf("Failed to marshal new request body: %v", err)
+		return
+	}
+	req.Body = io.NopCloser(bytes.NewReader(newBody))
+	req.ContentLength = int64(len(newBody))
+}
@@ -0,0 +1,49 @@
+package channel
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gpt-load/internal/types"
+)
+
+func TestOpenAIReshapeAddsIncludeUsage(t *testing.T) {
+	config := &types.SystemSettings{}
+	ch := &OpenAIChannel{BaseChannel: &BaseChannel{effectiveConfig: config}}
+	reshape := func(body string) map[string]interface{} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewBufferString(body))
+		ch.ReshapeStreamReqBody(req, true)
+		got, _ := io.ReadAll(req.Body)
+		if req.ContentLength != int64(len(got)) {
+			t.Errorf("Expected content length %d to match the body, got %d", len(got), req.ContentLength)
+		}
+		var data map[string]interface{}
+		if err := json.Unmarshal(got, &data); err != nil {
+			t.Fatalf("Expected a JSON body, got %s", got)
+		}
+		return data
+	}
+
+	body := `{"model":"gpt-4o","stream":true,"messages":[]}`
+	if data := reshape(body); data["stream_options"] != nil {
+		t.Errorf("Expected the body untouched while disabled, got %v", data)
+	}
+
+	config.StreamIncludeUsage = 1
+	data := reshape(body)
+	if options, _ := data["stream_options"].(map[string]interface{}); options["include_usage"] != true {
+		t.Errorf("Expected stream_options.include_usage to be set, got %v", data)
+	}
+	if data["model"] != "gpt-4o" || data["stream"] != true {
+		t.Errorf("Expected the other fields to be kept, got %v", data)
+	}
+
+	data = reshape(`{"model":"gpt-4o","stream":true,"stream_options":{"include_usage":false}}`)
+	if options, _ := data["stream_options"].(map[string]interface{}); options["include_usage"] != false {
+		t.Errorf("Expected the client's include_usage to be kept, got %v", data)
+	}
+}
@@ -0,0 +1,58 @@
+package channel
+
+import (
+	"encoding/json"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isStandardStreamRequest checks the streaming indicators shared by all channels: an Accept
+// header asking for text/event-stream, a truthy stream or alt=sse query parameter, and a
+// truthy "stream" field in a JSON body. Channels add their own indicators on top of it.
+func isStandardStreamRequest(c *gin.Context, bodyBytes []byte) bool {
+	return acceptsEventStream(c.GetHeader("Accept")) ||
+		isTruthy(c.Query("stream")) ||
+		strings.EqualFold(c.Query("alt"), "sse") ||
+		bodyRequestsStream(bodyBytes)
+}
+
+// acceptsEventStream reports whether an Accept header lists text/event-stream.
+func acceptsEventStream(accept string) bool {
+	for _, entry := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(entry))
+		if err == nil && mediaType == "text/event-stream" {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyRequestsStream reports whether a JSON body sets "stream" to true. Besides a boolean,
+// the string "true" sent by some clients is accepted.
+func bodyRequestsStream(bodyBytes []byte) bool {
+	var p struct {
+		Stream json.RawMessage `json:"stream"`
+	}
+	if err := json.Unmarshal(bodyBytes, &p); err != nil || len(p.Stream) == 0 {
+		return false
+	}
+
+	var stream bool
+	if err := json.Unmarshal(p.Stream, &stream); err == nil {
+		return stream
+	}
+	var value string
+	if err := json.Unmarshal(p.Stream, &value); err == nil {
+		return isTruthy(value)
+	}
+	return false
+}
+
+// isTruthy parses a query or string flag such as "true", "True" or "1".
+func isTruthy(value string) bool {
+	parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+	return err == nil && parsed
+}
@@ -0,0 +1,48 @@
+package channel
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIsStreamRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		target string
+		accept string
+		body   string
+		stream bool
+	}{
+		{"body stream true", "/v1/chat/completions", "", `{"model":"m","stream":true}`, true},
+		{"body stream string", "/v1/chat/completions", "", `{"model":"m","stream":"true"}`, true},
+		{"body stream false", "/v1/chat/completions", "", `{"model":"m","stream":false}`, false},
+		{"accept header", "/v1/chat/completions", "application/json, Text/Event-Stream; q=0.9", `{"model":"m"}`, true},
+		{"stream query", "/v1/chat/completions?stream=1", "", `{"model":"m"}`, true},
+		{"sse query", "/v1/messages?alt=sse", "", `{"model":"m"}`, true},
+		{"no indicator", "/v1/chat/completions", "application/json", `{"model":"m"}`, false},
+		{"invalid body", "/v1/chat/completions", "", `stream:true`, false},
+	}
+
+	channels := map[string]ChannelProxy{
+		"openai":    &OpenAIChannel{BaseChannel: &BaseChannel{}},
+		"anthropic": &AnthropicChannel{BaseChannel: &BaseChannel{}},
+	}
+	for channelType, ch := range channels {
+		for _, test := range tests {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, test.target, bytes.NewBufferString(test.body))
+			if test.accept != "" {
+				c.Request.Header.Set("Accept", test.accept)
+			}
+			if got := ch.IsStreamRequest(c, []byte(test.body)); got != test.stream {
+				t.Errorf("%s %s: expected stream=%v, got %v", channelType, test.name, test.stream, got)
+			}
+		}
+	}
+}
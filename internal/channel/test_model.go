@@ -0,0 +1,23 @@
+package channel
+
+// defaultTestModels holds the cheap, fast model each channel type validates keys with
+// when its group does not set one.
+var defaultTestModels = make(map[string]string)
+
+// registerDefaultTestModel declares the default test model of a channel type.
+func registerDefaultTestModel(channelType, model string) {
+	defaultTestModels[channelType] = model
+}
+
+// DefaultTestModel returns the default test model of a channel type, or "" if it has none.
+func DefaultTestModel(channelType string) string {
+	return defaultTestModels[channelType]
+}
+
+// testModel returns the model used for key validation.
+func (b *BaseChannel) testModel() string {
+	if b.TestModel != "" {
+		return b.TestModel
+	}
+	return DefaultTestModel(b.channelType)
+}
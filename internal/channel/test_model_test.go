@@ -0,0 +1,52 @@
+package channel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"gpt-load/internal/models"
+)
+
+func TestValidateKeyUsesChannelDefaultTestModel(t *testing.T) {
+	var gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	upstream, _ := url.Parse(server.URL)
+	ch := &GeminiChannel{BaseChannel: &BaseChannel{
+		Name:        "gemini",
+		Upstreams:   []UpstreamInfo{{URL: upstream, Weight: 1}},
+		HTTPClient:  server.Client(),
+		channelType: "gemini",
+	}}
+
+	valid, err := ch.ValidateKey(context.Background(), &models.APIKey{KeyValue: "sk-test"}, &models.Group{ChannelType: "gemini"})
+	if !valid || err != nil {
+		t.Fatalf("Expected the key to validate, got %v, %v", valid, err)
+	}
+	if want := "/v1beta/models/" + DefaultTestModel("gemini") + ":generateContent"; gotPath != want {
+		t.Errorf("Expected validation against %s, got %s", want, gotPath)
+	}
+
+	ch.TestModel = "gemini-custom"
+	if _, err := ch.ValidateKey(context.Background(), &models.APIKey{KeyValue: "sk-test"}, &models.Group{ChannelType: "gemini"}); err != nil {
+		t.Fatalf("Expected the key to validate, got %v", err)
+	}
+	if gotPath != "/v1beta/models/gemini-custom:generateContent" {
+		t.Errorf("Expected the group's test model to take precedence, got %s", gotPath)
+	}
+}
+
+func TestEveryChannelDeclaresDefaultTestModel(t *testing.T) {
+	for _, channelType := range GetChannels() {
+		if DefaultTestModel(channelType) == "" {
+			t.Errorf("Expected channel %s to declare a default test model", channelType)
+		}
+	}
+}
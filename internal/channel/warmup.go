@@ -0,0 +1,124 @@
+package channel
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// warmupPingTimeout bounds a warm-up ping when there is no interval to bound it by.
+const warmupPingTimeout = 10 * time.Second
+
+// upstreamWarmer periodically pings every upstream of a channel so that the
+// client's connection pool keeps warm connections for bursty traffic. With a pool
+// size it also opens that many connections per upstream right away, so the first
+// burst after the channel is created does not have to dial them all at once.
+type upstreamWarmer struct {
+	interval time.Duration
+	poolSize int
+	clients  []*http.Client
+	urls     []string
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// newUpstreamWarmer creates a warmer for the given upstreams. It does not start pinging until start is called.
+// A zero interval only warms the pool once; a pool size below 1 keeps a single connection per upstream.
+func newUpstreamWarmer(interval time.Duration, poolSize int, clients []*http.Client, upstreams []UpstreamInfo) *upstreamWarmer {
+	urls := make([]string, 0, len(upstreams))
+	for _, up := range upstreams {
+		urls = append(urls, up.URL.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &upstreamWarmer{
+		interval: interval,
+		poolSize: max(poolSize, 1),
+		clients:  clients,
+		urls:     urls,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// start launches the background ping loop, warming the pool first if a pool size is set.
+func (w *upstreamWarmer) start() {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		if w.poolSize > 1 {
+			w.pingAll()
+		}
+		if w.interval <= 0 {
+			return
+		}
+
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				w.pingAll()
+			case <-w.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stop terminates the ping loop, cancelling any pings in flight, and waits for it to exit.
+func (w *upstreamWarmer) stop() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+// pingAll issues lightweight HEAD requests against every upstream with every client, poolSize
+// of them at once so that each needs a connection of its own.
+func (w *upstreamWarmer) pingAll() {
+	for _, client := range w.clients {
+		for _, u := range w.urls {
+			if w.ctx.Err() != nil {
+				return
+			}
+			var wg sync.WaitGroup
+			for i := 0; i < w.poolSize; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					w.ping(client, u)
+				}()
+			}
+			wg.Wait()
+		}
+	}
+}
+
+// ping sends a single HEAD request and drains the response so the connection returns to the pool.
+func (w *upstreamWarmer) ping(client *http.Client, u string) {
+	timeout := w.interval
+	if timeout <= 0 {
+		timeout = warmupPingTimeout
+	}
+	ctx, cancel := context.WithTimeout(w.ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		logrus.Debugf("Failed to create warm-up request for %s: %v", u, err)
+		return
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logrus.Debugf("Warm-up ping to %s failed: %v", u, err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+}
@@ -0,0 +1,167 @@
+package channel
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUpstreamWarmerPingsAtInterval(t *testing.T) {
+	var pings int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("Expected HEAD warm-up request, got %s", r.Method)
+		}
+		atomic.AddInt32(&pings, 1)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	warmer := newUpstreamWarmer(20*time.Millisecond, 0, []*http.Client{server.Client()}, []UpstreamInfo{{URL: u, Weight: 1}})
+	warmer.start()
+
+	time.Sleep(110 * time.Millisecond)
+	warmer.stop()
+
+	got := atomic.LoadInt32(&pings)
+	if got < 3 {
+		t.Errorf("Expected at least 3 warm-up pings, got %d", got)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if after := atomic.LoadInt32(&pings); after != got {
+		t.Errorf("Expected no pings after stop, got %d more", after-got)
+	}
+}
+
+func TestWarmPoolReducesConnectionsDialedByBurst(t *testing.T) {
+	const burst = 8
+
+	dialedDuringBurst := func(poolSize int) int32 {
+		var dialed int32
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Hold each request so concurrent ones cannot share a connection
+			time.Sleep(20 * time.Millisecond)
+		}))
+		server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&dialed, 1)
+			}
+		}
+		server.Start()
+		defer server.Close()
+
+		client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: burst}}
+		u, _ := url.Parse(server.URL)
+		if poolSize > 0 {
+			warmer := newUpstreamWarmer(0, poolSize, []*http.Client{client}, []UpstreamInfo{{URL: u, Weight: 1}})
+			warmer.start()
+			// Without an interval the loop exits once the pool is warm; stop would cancel it early
+			warmer.wg.Wait()
+			warmer.stop()
+		}
+
+		before := atomic.LoadInt32(&dialed)
+		var wg sync.WaitGroup
+		for i := 0; i < burst; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := client.Get(server.URL)
+				if err != nil {
+					t.Errorf("Burst request failed: %v", err)
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}()
+		}
+		wg.Wait()
+		return atomic.LoadInt32(&dialed) - before
+	}
+
+	cold := dialedDuringBurst(0)
+	warm := dialedDuringBurst(burst)
+	if cold < burst/2 {
+		t.Fatalf("Expected a cold burst to dial most of its connections, got %d", cold)
+	}
+	if warm >= cold || warm > burst/4 {
+		t.Errorf("Expected a warmed pool to serve the burst with few new connections, got %d warm vs %d cold", warm, cold)
+	}
+}
+
+func TestUpstreamWarmerStopCancelsPingsInFlight(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	warmer := newUpstreamWarmer(0, 2, []*http.Client{server.Client()}, []UpstreamInfo{{URL: u, Weight: 1}})
+	warmer.start()
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		warmer.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Expected stop to cancel the hanging ping instead of waiting for it")
+	}
+}
+
+func TestRetainGroupsStopsWarmersOfRemovedGroups(t *testing.T) {
+	var pings int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&pings, 1)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	kept := newUpstreamWarmer(time.Hour, 0, []*http.Client{server.Client()}, []UpstreamInfo{{URL: u, Weight: 1}})
+	removed := newUpstreamWarmer(10*time.Millisecond, 0, []*http.Client{server.Client()}, []UpstreamInfo{{URL: u, Weight: 1}})
+	kept.start()
+	removed.start()
+	defer kept.stop()
+
+	f := &Factory{
+		channelCache: map[uint]ChannelProxy{1: nil, 2: nil},
+		warmers:      map[uint]*upstreamWarmer{1: kept, 2: removed},
+	}
+	f.RetainGroups([]uint{1})
+
+	if _, ok := f.channelCache[2]; ok {
+		t.Error("Expected the channel of the removed group to be dropped")
+	}
+	if _, ok := f.warmers[1]; !ok {
+		t.Error("Expected the warmer of the remaining group to be kept")
+	}
+	if removed.ctx.Err() == nil {
+		t.Error("Expected the warmer of the removed group to be stopped")
+	}
+
+	got := atomic.LoadInt32(&pings)
+	time.Sleep(50 * time.Millisecond)
+	if after := atomic.LoadInt32(&pings); after != got {
+		t.Errorf("Expected no pings after the group was removed, got %d more", after-got)
+	}
+}
@@ -74,6 +74,7 @@ func (m *Manager) ReloadConfig() error {
 			Port:                    utils.ParseInteger(os.Getenv("PORT"), 3001),
 			Host:                    utils.GetEnvOrDefault("HOST", "0.0.0.0"),
 			ReadTimeout:             utils.ParseInteger(os.Getenv("SERVER_READ_TIMEOUT"), 60),
+			ReadHeaderTimeout:       utils.ParseInteger(os.Getenv("SERVER_READ_HEADER_TIMEOUT"), 10),
 			WriteTimeout:            utils.ParseInteger(os.Getenv("SERVER_WRITE_TIMEOUT"), 600),
 			IdleTimeout:             utils.ParseInteger(os.Getenv("SERVER_IDLE_TIMEOUT"), 120),
 			GracefulShutdownTimeout: utils.ParseInteger(os.Getenv("SERVER_GRACEFUL_SHUTDOWN_TIMEOUT"), 10),
@@ -201,6 +202,7 @@ func (m *Manager) DisplayServerConfig() {
 	logrus.Infof("    Listen Address: %s:%d", serverConfig.Host, serverConfig.Port)
 	logrus.Infof("    Graceful Shutdown Timeout: %d seconds", serverConfig.GracefulShutdownTimeout)
 	logrus.Infof("    Read Timeout: %d seconds", serverConfig.ReadTimeout)
+	logrus.Infof("    Read Header Timeout: %d seconds", serverConfig.ReadHeaderTimeout)
 	logrus.Infof("    Write Timeout: %d seconds", serverConfig.WriteTimeout)
 	logrus.Infof("    Idle Timeout: %d seconds", serverConfig.IdleTimeout)
 
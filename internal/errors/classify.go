@@ -0,0 +1,140 @@
+package errors
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Standardized upstream error codes, shared by every channel type.
+const (
+	UpstreamAuthError       = "auth_error"
+	UpstreamRateLimited     = "rate_limited"
+	UpstreamInvalidRequest  = "invalid_request"
+	UpstreamUnavailable     = "upstream_unavailable"
+	UpstreamContentFiltered = "content_filtered"
+)
+
+// providerErrorResponse matches the error fields OpenAI, Gemini and Anthropic use to
+// classify their errors: OpenAI's type and code, Gemini's status and Anthropic's type.
+type providerErrorResponse struct {
+	Error struct {
+		Type    string          `json:"type"`
+		Code    json.RawMessage `json:"code"`
+		Status  string          `json:"status"`
+		Message string          `json:"message"`
+	} `json:"error"`
+}
+
+// providerCodes maps provider error types, codes and statuses to standardized codes.
+var providerCodes = map[string]string{
+	// OpenAI
+	"invalid_api_key":          UpstreamAuthError,
+	"authentication_error":     UpstreamAuthError,
+	"insufficient_quota":       UpstreamRateLimited,
+	"rate_limit_exceeded":      UpstreamRateLimited,
+	"invalid_request_error":    UpstreamInvalidRequest,
+	"content_filter":           UpstreamContentFiltered,
+	"content_policy_violation": UpstreamContentFiltered,
+	"server_error":             UpstreamUnavailable,
+
+	// Gemini
+	"UNAUTHENTICATED":     UpstreamAuthError,
+	"PERMISSION_DENIED":   UpstreamAuthError,
+	"RESOURCE_EXHAUSTED":  UpstreamRateLimited,
+	"INVALID_ARGUMENT":    UpstreamInvalidRequest,
+	"FAILED_PRECONDITION": UpstreamInvalidRequest,
+	"NOT_FOUND":           UpstreamInvalidRequest,
+	"UNAVAILABLE":         UpstreamUnavailable,
+	"INTERNAL":            UpstreamUnavailable,
+	"DEADLINE_EXCEEDED":   UpstreamUnavailable,
+
+	// Anthropic
+	"permission_error": UpstreamAuthError,
+	"rate_limit_error": UpstreamRateLimited,
+	"not_found_error":  UpstreamInvalidRequest,
+	"overloaded_error": UpstreamUnavailable,
+	"api_error":        UpstreamUnavailable,
+}
+
+// ParseErrorCodeMapping parses the error_code_mapping setting, a comma-separated list of
+// provider=standard pairs such as "insufficient_quota=auth_error". Entries that are malformed
+// or name an unknown standard code are skipped.
+func ParseErrorCodeMapping(spec string) map[string]string {
+	mapping := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		provider, standard, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		provider, standard = strings.TrimSpace(provider), strings.TrimSpace(standard)
+		if !ok || provider == "" || !isStandardCode(standard) {
+			continue
+		}
+		mapping[provider] = standard
+	}
+	return mapping
+}
+
+func isStandardCode(code string) bool {
+	switch code {
+	case UpstreamAuthError, UpstreamRateLimited, UpstreamInvalidRequest, UpstreamUnavailable, UpstreamContentFiltered:
+		return true
+	}
+	return false
+}
+
+// ClassifyUpstreamError maps a provider error response to a standardized error code. The
+// provider's own error code wins over its type or status, and the HTTP status decides when
+// the body is not recognized. Entries in mapping take precedence over the built-in ones.
+func ClassifyUpstreamError(statusCode int, body []byte, mapping map[string]string) string {
+	var providerErr providerErrorResponse
+	if err := json.Unmarshal(body, &providerErr); err == nil {
+		e := providerErr.Error
+		var code string
+		_ = json.Unmarshal(e.Code, &code)
+		values := []string{code, e.Type, e.Status}
+
+		for _, value := range values {
+			if standard, ok := mapping[value]; ok && value != "" {
+				return standard
+			}
+		}
+		// Gemini reports invalid keys as INVALID_ARGUMENT, recognizable only by the message
+		if strings.Contains(strings.ToLower(e.Message), "api key not valid") {
+			return UpstreamAuthError
+		}
+		for _, value := range values {
+			if standard, ok := providerCodes[value]; ok {
+				return standard
+			}
+		}
+	}
+
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return UpstreamAuthError
+	case statusCode == http.StatusTooManyRequests:
+		return UpstreamRateLimited
+	case statusCode >= 400 && statusCode < 500:
+		return UpstreamInvalidRequest
+	default:
+		return UpstreamUnavailable
+	}
+}
+
+// NewUpstreamErrorEnvelope wraps an upstream error response in the standardized envelope,
+// {"error": {"code", "message", "status", "upstream"}}, with the provider's original error
+// nested under upstream so no detail is lost.
+func NewUpstreamErrorEnvelope(statusCode int, body []byte, mapping map[string]string) map[string]any {
+	var upstream any
+	if err := json.Unmarshal(body, &upstream); err != nil {
+		upstream = truncateString(string(body), maxErrorBodyLength)
+	}
+
+	return map[string]any{
+		"error": map[string]any{
+			"code":     ClassifyUpstreamError(statusCode, body, mapping),
+			"message":  ParseUpstreamError(body),
+			"status":   statusCode,
+			"upstream": upstream,
+		},
+	}
+}
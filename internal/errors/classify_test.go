@@ -0,0 +1,94 @@
+package errors
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestClassifyUpstreamError(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		expected   string
+	}{
+		{"openai invalid key", 401, `{"error":{"message":"Incorrect API key provided","type":"invalid_request_error","code":"invalid_api_key"}}`, UpstreamAuthError},
+		{"openai rate limit", 429, `{"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}`, UpstreamRateLimited},
+		{"openai quota", 429, `{"error":{"message":"You exceeded your current quota","type":"insufficient_quota","code":"insufficient_quota"}}`, UpstreamRateLimited},
+		{"openai bad request", 400, `{"error":{"message":"Unknown parameter","type":"invalid_request_error","code":null}}`, UpstreamInvalidRequest},
+		{"openai content filter", 400, `{"error":{"message":"Blocked by safety system","type":"invalid_request_error","code":"content_policy_violation"}}`, UpstreamContentFiltered},
+		{"openai server error", 500, `{"error":{"message":"The server had an error","type":"server_error","code":null}}`, UpstreamUnavailable},
+		{"gemini invalid key", 400, `{"error":{"code":400,"message":"API key not valid. Please pass a valid API key.","status":"INVALID_ARGUMENT"}}`, UpstreamAuthError},
+		{"gemini permission denied", 403, `{"error":{"code":403,"message":"Permission denied","status":"PERMISSION_DENIED"}}`, UpstreamAuthError},
+		{"gemini exhausted", 429, `{"error":{"code":429,"message":"Resource has been exhausted","status":"RESOURCE_EXHAUSTED"}}`, UpstreamRateLimited},
+		{"gemini invalid argument", 400, `{"error":{"code":400,"message":"Invalid JSON payload","status":"INVALID_ARGUMENT"}}`, UpstreamInvalidRequest},
+		{"gemini unavailable", 503, `{"error":{"code":503,"message":"The model is overloaded","status":"UNAVAILABLE"}}`, UpstreamUnavailable},
+		{"anthropic auth", 401, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`, UpstreamAuthError},
+		{"anthropic rate limit", 429, `{"type":"error","error":{"type":"rate_limit_error","message":"Number of requests has exceeded your rate limit"}}`, UpstreamRateLimited},
+		{"anthropic bad request", 400, `{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: field required"}}`, UpstreamInvalidRequest},
+		{"anthropic overloaded", 529, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, UpstreamUnavailable},
+		{"unrecognized body by status", 403, `forbidden`, UpstreamAuthError},
+		{"unrecognized client error", 422, `{"detail":"bad"}`, UpstreamInvalidRequest},
+		{"unrecognized server error", 502, `Bad Gateway`, UpstreamUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyUpstreamError(tt.statusCode, []byte(tt.body), nil); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestErrorCodeMappingOverridesBuiltIn(t *testing.T) {
+	mapping := ParseErrorCodeMapping(" insufficient_quota = auth_error, UNAVAILABLE=bogus, =rate_limited, FAILED_PRECONDITION=upstream_unavailable")
+	if len(mapping) != 2 {
+		t.Fatalf("Expected invalid entries to be skipped, got %v", mapping)
+	}
+
+	quota := []byte(`{"error":{"message":"quota","type":"insufficient_quota","code":"insufficient_quota"}}`)
+	if got := ClassifyUpstreamError(429, quota, mapping); got != UpstreamAuthError {
+		t.Errorf("Expected the configured mapping to win, got %q", got)
+	}
+	precondition := []byte(`{"error":{"code":400,"message":"User location is not supported","status":"FAILED_PRECONDITION"}}`)
+	if got := ClassifyUpstreamError(400, precondition, mapping); got != UpstreamUnavailable {
+		t.Errorf("Expected the configured mapping to win, got %q", got)
+	}
+	unavailable := []byte(`{"error":{"code":503,"message":"overloaded","status":"UNAVAILABLE"}}`)
+	if got := ClassifyUpstreamError(503, unavailable, mapping); got != UpstreamUnavailable {
+		t.Errorf("Expected the built-in mapping when no override applies, got %q", got)
+	}
+}
+
+func TestUpstreamErrorEnvelopeNestsProviderDetail(t *testing.T) {
+	body := []byte(`{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`)
+	payload, err := json.Marshal(NewUpstreamErrorEnvelope(http.StatusTooManyRequests, body, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var envelope struct {
+		Error struct {
+			Code     string         `json:"code"`
+			Message  string         `json:"message"`
+			Status   int            `json:"status"`
+			Upstream map[string]any `json:"upstream"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Error.Code != UpstreamRateLimited || envelope.Error.Message != "slow down" || envelope.Error.Status != 429 {
+		t.Errorf("Unexpected envelope: %s", payload)
+	}
+	if inner, _ := envelope.Error.Upstream["error"].(map[string]any); inner["type"] != "rate_limit_error" {
+		t.Errorf("Expected the original error nested under upstream, got %s", payload)
+	}
+
+	plain, _ := json.Marshal(NewUpstreamErrorEnvelope(http.StatusBadGateway, []byte("Bad Gateway"), nil))
+	if got := string(plain); got != `{"error":{"code":"upstream_unavailable","message":"Bad Gateway","status":502,"upstream":"Bad Gateway"}}` {
+		t.Errorf("Expected a non-JSON body nested as a string, got %s", got)
+	}
+}
@@ -38,6 +38,10 @@ var (
 	ErrNoActiveKeys       = &APIError{HTTPStatus: http.StatusServiceUnavailable, Code: "NO_ACTIVE_KEYS", Message: "No active API keys available for this group"}
 	ErrMaxRetriesExceeded = &APIError{HTTPStatus: http.StatusBadGateway, Code: "MAX_RETRIES_EXCEEDED", Message: "Request failed after maximum retries"}
 	ErrNoKeysAvailable    = &APIError{HTTPStatus: http.StatusServiceUnavailable, Code: "NO_KEYS_AVAILABLE", Message: "No API keys available to process the request"}
+	ErrBudgetExhausted    = &APIError{HTTPStatus: http.StatusGatewayTimeout, Code: "REQUEST_BUDGET_EXHAUSTED", Message: "Request time budget exhausted"}
+	ErrRateLimited        = &APIError{HTTPStatus: http.StatusTooManyRequests, Code: "RATE_LIMITED", Message: "Group request rate limit exceeded"}
+	ErrQueueTimeout       = &APIError{HTTPStatus: http.StatusServiceUnavailable, Code: "QUEUE_TIMEOUT", Message: "Timed out waiting for a free upstream slot"}
+	ErrKeyQuotaExhausted  = &APIError{HTTPStatus: http.StatusServiceUnavailable, Code: "KEY_QUOTA_EXHAUSTED", Message: "All API keys have used up their quota for the current period"}
 )
 
 // NewAPIError creates a new APIError with a custom message.
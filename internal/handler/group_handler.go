@@ -193,11 +193,8 @@ func (s *Server) CreateGroup(c *gin.Context) {
 		return
 	}
 
+	// An empty test model falls back to the channel's default
 	testModel := strings.TrimSpace(req.TestModel)
-	if testModel == "" {
-		response.Error(c, app_errors.NewAPIError(app_errors.ErrValidation, "Test model is required"))
-		return
-	}
 
 	cleanedUpstreams, err := validateAndCleanUpstreams(req.Upstreams)
 	if err != nil {
@@ -310,7 +307,7 @@ type GroupUpdateRequest struct {
 	Upstreams          json.RawMessage     `json:"upstreams"`
 	ChannelType        *string             `json:"channel_type,omitempty"`
 	Sort               *int                `json:"sort"`
-	TestModel          string              `json:"test_model"`
+	TestModel          *string             `json:"test_model,omitempty"`
 	ValidationEndpoint *string             `json:"validation_endpoint,omitempty"`
 	ParamOverrides     map[string]any      `json:"param_overrides"`
 	Config             map[string]any      `json:"config"`
@@ -385,13 +382,9 @@ func (s *Server) UpdateGroup(c *gin.Context) {
 	if req.Sort != nil {
 		group.Sort = *req.Sort
 	}
-	if req.TestModel != "" {
-		cleanedTestModel := strings.TrimSpace(req.TestModel)
-		if cleanedTestModel == "" {
-			response.Error(c, app_errors.NewAPIError(app_errors.ErrValidation, "Test model cannot be empty or just spaces."))
-			return
-		}
-		group.TestModel = cleanedTestModel
+	if req.TestModel != nil {
+		// An empty test model resets the group to the channel's default
+		group.TestModel = strings.TrimSpace(*req.TestModel)
 	}
 	if req.ParamOverrides != nil {
 		group.ParamOverrides = req.ParamOverrides
@@ -1,10 +1,13 @@
 package httpclient
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -27,8 +30,21 @@ type Config struct {
 	TLSHandshakeTimeout   time.Duration
 	ExpectContinueTimeout time.Duration
 	ProxyURL              string
+	// MinTLSVersion is the lowest TLS version accepted for upstream connections, e.g.
+	// tls.VersionTLS12. Zero keeps the Go default.
+	MinTLSVersion uint16
+	// MaxRedirects is the number of upstream redirects followed before the request fails.
+	// Zero refuses every redirect.
+	MaxRedirects int
+	// RedirectHosts lists the hosts, besides the original one, a redirect may lead to.
+	// Redirects elsewhere are refused, since the request still carries the API key.
+	RedirectHosts []string
 }
 
+// ErrRedirectRefused is returned, wrapped in a *url.Error, for an upstream redirect that
+// exceeds the configured hops or leads to a host outside the allowlist.
+var ErrRedirectRefused = errors.New("upstream redirect refused")
+
 // HTTPClientManager manages the lifecycle of HTTP clients.
 // It creates and caches clients based on their configuration fingerprint,
 // ensuring that clients with the same configuration are reused.
@@ -85,6 +101,10 @@ func (m *HTTPClientManager) GetClient(config *Config) *http.Client {
 		ReadBufferSize:        config.ReadBufferSize,
 	}
 
+	if config.MinTLSVersion != 0 {
+		transport.TLSClientConfig = &tls.Config{MinVersion: config.MinTLSVersion}
+	}
+
 	// Set http proxy.
 	if config.ProxyURL != "" {
 		proxyURL, err := url.Parse(config.ProxyURL)
@@ -99,18 +119,48 @@ func (m *HTTPClientManager) GetClient(config *Config) *http.Client {
 	}
 
 	newClient := &http.Client{
-		Transport: transport,
-		Timeout:   config.RequestTimeout,
+		Transport:     transport,
+		Timeout:       config.RequestTimeout,
+		CheckRedirect: checkRedirect(config.MaxRedirects, config.RedirectHosts),
 	}
 
 	m.clients[fingerprint] = newClient
 	return newClient
 }
 
+// checkRedirect returns a redirect policy following at most maxRedirects redirects. A hop
+// within the host it was sent to, or back to the original host, is always followed; only a
+// hop to another host must lead to one of the allowed hosts.
+func checkRedirect(maxRedirects int, allowedHosts []string) func(req *http.Request, via []*http.Request) error {
+	allowed := make(map[string]struct{}, len(allowedHosts))
+	for _, host := range allowedHosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			allowed[host] = struct{}{}
+		}
+	}
+
+	return func(req *http.Request, via []*http.Request) error {
+		// Only the host is reported, since the URL may carry the key in its query
+		if len(via) > maxRedirects {
+			return fmt.Errorf("%w: redirect to %s exceeds the limit of %d", ErrRedirectRefused, req.URL.Host, maxRedirects)
+		}
+		if strings.EqualFold(req.URL.Host, via[len(via)-1].URL.Host) || strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+			return nil
+		}
+		if _, ok := allowed[strings.ToLower(req.URL.Host)]; ok {
+			return nil
+		}
+		if _, ok := allowed[strings.ToLower(req.URL.Hostname())]; ok {
+			return nil
+		}
+		return fmt.Errorf("%w: redirect to %s is not in the allowed hosts", ErrRedirectRefused, req.URL.Host)
+	}
+}
+
 // getFingerprint generates a unique string representation of the client configuration.
 func (c *Config) getFingerprint() string {
 	return fmt.Sprintf(
-		"ct:%.0fs|rt:%.0fs|it:%.0fs|mic:%d|mich:%d|rht:%.0fs|dc:%t|wbs:%d|rbs:%d|fh2:%t|tlst:%.0fs|ect:%.0fs|proxy:%s",
+		"ct:%.0fs|rt:%.0fs|it:%.0fs|mic:%d|mich:%d|rht:%.0fs|dc:%t|wbs:%d|rbs:%d|fh2:%t|tlst:%.0fs|ect:%.0fs|proxy:%s|tlsmin:%d|redir:%d|redirhosts:%s",
 		c.ConnectTimeout.Seconds(),
 		c.RequestTimeout.Seconds(),
 		c.IdleConnTimeout.Seconds(),
@@ -124,5 +174,27 @@ func (c *Config) getFingerprint() string {
 		c.TLSHandshakeTimeout.Seconds(),
 		c.ExpectContinueTimeout.Seconds(),
 		c.ProxyURL,
+		c.MinTLSVersion,
+		c.MaxRedirects,
+		strings.Join(c.RedirectHosts, ","),
 	)
 }
+
+// ParseTLSVersion converts a version such as "1.2" to its crypto/tls constant.
+// An empty version returns 0, which keeps the Go default.
+func ParseTLSVersion(version string) (uint16, error) {
+	switch strings.TrimSpace(version) {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q", version)
+	}
+}
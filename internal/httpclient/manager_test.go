@@ -0,0 +1,118 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMinTLSVersionRejectsOlderServers(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS11}
+	server.StartTLS()
+	defer server.Close()
+
+	manager := NewHTTPClientManager()
+	get := func(version string) error {
+		minVersion, err := ParseTLSVersion(version)
+		if err != nil {
+			t.Fatalf("Failed to parse TLS version %q: %v", version, err)
+		}
+		resp, err := manager.GetClient(&Config{MinTLSVersion: minVersion}).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	err := get("1.2")
+	if err == nil || !strings.Contains(err.Error(), "protocol version") {
+		t.Errorf("Expected the handshake with a TLS 1.1 server to fail on the protocol version, got %v", err)
+	}
+
+	// With 1.0 allowed the handshake gets as far as the untrusted test certificate
+	err = get("1.0")
+	if err == nil || strings.Contains(err.Error(), "protocol version") {
+		t.Errorf("Expected TLS 1.1 to be negotiated when 1.0 is the minimum, got %v", err)
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	if v, err := ParseTLSVersion(" 1.3 "); err != nil || v != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3, got %d, %v", v, err)
+	}
+	if v, err := ParseTLSVersion(""); err != nil || v != 0 {
+		t.Errorf("Expected empty version to keep the default, got %d, %v", v, err)
+	}
+	if _, err := ParseTLSVersion("1.4"); err == nil {
+		t.Error("Expected an unsupported version to be rejected")
+	}
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	targetHits := 0
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targetHits++
+		if r.URL.Path == "/hop" {
+			http.Redirect(w, r, "/moved", http.StatusFound)
+		}
+	}))
+	defer target.Close()
+	targetHost := strings.TrimPrefix(target.URL, "http://")
+
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/same-host" {
+			http.Redirect(w, r, "/moved", http.StatusFound)
+			return
+		}
+		if r.URL.Path == "/moved" {
+			return
+		}
+		if r.URL.Path == "/cross-then-same" {
+			http.Redirect(w, r, target.URL+"/hop", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, target.URL+"/moved", http.StatusMovedPermanently)
+	}))
+	defer redirector.Close()
+
+	manager := NewHTTPClientManager()
+	get := func(config *Config, path string) error {
+		resp, err := manager.GetClient(config).Get(redirector.URL + path)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := get(&Config{MaxRedirects: 2}, "/"); !errors.Is(err, ErrRedirectRefused) {
+		t.Errorf("Expected a redirect to an unlisted host to be refused, got %v", err)
+	}
+	if targetHits != 0 {
+		t.Errorf("Expected the unlisted host not to be contacted, got %d requests", targetHits)
+	}
+
+	if err := get(&Config{MaxRedirects: 2, RedirectHosts: []string{targetHost}}, "/"); err != nil {
+		t.Errorf("Expected a redirect to a listed host to be followed, got %v", err)
+	}
+	if targetHits != 1 {
+		t.Errorf("Expected the listed host to be contacted once, got %d requests", targetHits)
+	}
+
+	if err := get(&Config{MaxRedirects: 2, RedirectHosts: []string{targetHost}}, "/cross-then-same"); err != nil {
+		t.Errorf("Expected a hop within a listed host to be followed, got %v", err)
+	}
+	if err := get(&Config{MaxRedirects: 1, RedirectHosts: []string{targetHost}}, "/cross-then-same"); !errors.Is(err, ErrRedirectRefused) {
+		t.Errorf("Expected hops beyond the limit to be refused, got %v", err)
+	}
+
+	if err := get(&Config{MaxRedirects: 1}, "/same-host"); err != nil {
+		t.Errorf("Expected a redirect within the original host to be followed, got %v", err)
+	}
+	if err := get(&Config{}, "/same-host"); !errors.Is(err, ErrRedirectRefused) {
+		t.Errorf("Expected redirects to be refused when none are allowed, got %v", err)
+	}
+}
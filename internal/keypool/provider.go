@@ -20,6 +20,7 @@ type KeyProvider struct {
 	db              *gorm.DB
 	store           store.Store
 	settingsManager *config.SystemSettingsManager
+	now             func() time.Time
 }
 
 // NewProvider 创建一个新的 KeyProvider 实例。
@@ -0,0 +1,96 @@
+package keypool
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	app_errors "gpt-load/internal/errors"
+	"gpt-load/internal/models"
+)
+
+// maxQuotaScan 限制一次选择中为跳过超额 Key 而轮换的最大次数。
+const maxQuotaScan = 1000
+
+// KeyQuota 定义单个 Key 在每个周期内允许的请求数，Requests 为 0 表示不限制。
+type KeyQuota struct {
+	Requests int64
+	Period   time.Duration
+}
+
+// KeyQuotaOf 返回分组配置的 Key 配额。
+func KeyQuotaOf(group *models.Group) KeyQuota {
+	return KeyQuota{
+		Requests: int64(group.EffectiveConfig.KeyQuotaRequests),
+		Period:   time.Duration(group.EffectiveConfig.KeyQuotaPeriod) * time.Second,
+	}
+}
+
+func (q KeyQuota) enabled() bool {
+	return q.Requests > 0 && q.Period > 0
+}
+
+// SelectKeyWithinQuota 与 SelectKey 相同，但会跳过在当前周期内已用完配额的 Key，并为选中的 Key
+// 计入一次请求。周期按固定窗口划分，进入新周期后 Key 自动恢复可用。
+// 所有 Key 都已超额时返回 ErrKeyQuotaExhausted。
+func (p *KeyProvider) SelectKeyWithinQuota(groupID uint, quota KeyQuota) (*models.APIKey, error) {
+	if !quota.enabled() {
+		return p.SelectKey(groupID)
+	}
+
+	window := p.clock().Truncate(quota.Period).Unix()
+	var firstID uint
+	for i := 0; i < maxQuotaScan; i++ {
+		apiKey, err := p.SelectKey(groupID)
+		if err != nil {
+			return nil, err
+		}
+		if apiKey.ID == firstID {
+			break
+		}
+		if firstID == 0 {
+			firstID = apiKey.ID
+		}
+
+		ok, err := p.consumeQuota(apiKey.ID, window, quota.Requests)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return apiKey, nil
+		}
+	}
+	return nil, app_errors.ErrKeyQuotaExhausted
+}
+
+// consumeQuota 在 Key 当前周期的用量未达到上限时计入一次请求并返回 true。
+// 用量与周期起点一同记录在 Key 的 HASH 中，周期变化时重新计数。
+func (p *KeyProvider) consumeQuota(keyID uint, window, limit int64) (bool, error) {
+	keyHashKey := fmt.Sprintf("key:%d", keyID)
+	details, err := p.store.HGetAll(keyHashKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to get usage for key ID %d: %w", keyID, err)
+	}
+
+	if usageWindow, _ := strconv.ParseInt(details["usage_window"], 10, 64); usageWindow != window {
+		if err := p.store.HSet(keyHashKey, map[string]any{"usage_window": window, "usage_requests": 0}); err != nil {
+			return false, fmt.Errorf("failed to reset usage for key ID %d: %w", keyID, err)
+		}
+	} else if used, _ := strconv.ParseInt(details["usage_requests"], 10, 64); used >= limit {
+		return false, nil
+	}
+
+	used, err := p.store.HIncrBy(keyHashKey, "usage_requests", 1)
+	if err != nil {
+		return false, fmt.Errorf("failed to record usage for key ID %d: %w", keyID, err)
+	}
+	// A concurrent request may have taken the last slot in the meantime
+	return used <= limit, nil
+}
+
+func (p *KeyProvider) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
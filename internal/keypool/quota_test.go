@@ -0,0 +1,56 @@
+package keypool
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	app_errors "gpt-load/internal/errors"
+	"gpt-load/internal/models"
+	"gpt-load/internal/store"
+)
+
+func TestKeyOverQuotaIsSkippedUntilWindowResets(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	memStore.HSet("key:1", map[string]any{"key_string": "sk-one", "status": models.KeyStatusActive})
+	memStore.HSet("key:2", map[string]any{"key_string": "sk-two", "status": models.KeyStatusActive})
+	memStore.LPush("group:1:active_keys", "1", "2")
+
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	provider := NewProvider(nil, memStore, nil)
+	provider.now = func() time.Time { return now }
+	quota := KeyQuota{Requests: 2, Period: time.Hour}
+
+	used := map[string]int{}
+	for i := 0; i < 4; i++ {
+		apiKey, err := provider.SelectKeyWithinQuota(1, quota)
+		if err != nil {
+			t.Fatalf("Expected request %d to find a key within quota, got %v", i+1, err)
+		}
+		used[apiKey.KeyValue]++
+	}
+	if used["sk-one"] != 2 || used["sk-two"] != 2 {
+		t.Errorf("Expected each key to serve its quota of 2 requests, got %v", used)
+	}
+
+	if _, err := provider.SelectKeyWithinQuota(1, quota); !errors.Is(err, app_errors.ErrKeyQuotaExhausted) {
+		t.Errorf("Expected keys over quota to be skipped, got %v", err)
+	}
+
+	// Exhaust one key only: the other keeps serving requests alone
+	now = now.Add(time.Hour)
+	memStore.HSet("key:1", map[string]any{"usage_window": now.Unix(), "usage_requests": 2})
+	for i := 0; i < 2; i++ {
+		apiKey, err := provider.SelectKeyWithinQuota(1, quota)
+		if err != nil {
+			t.Fatalf("Expected the key re-enabled by the new window to be selected, got %v", err)
+		}
+		if apiKey.KeyValue != "sk-two" {
+			t.Errorf("Expected the key over quota to be skipped, got %s", apiKey.KeyValue)
+		}
+	}
+
+	if _, err := provider.SelectKeyWithinQuota(1, KeyQuota{}); err != nil {
+		t.Errorf("Expected no quota to select keys regardless of usage, got %v", err)
+	}
+}
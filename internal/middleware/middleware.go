@@ -162,6 +162,7 @@ func ProxyAuth(gm *services.GroupManager) gin.HandlerFunc {
 		_, existsInGroup := group.ProxyKeysMap[key]
 
 		if existsInEffective || existsInGroup {
+			c.Set("proxyKey", key)
 			c.Next()
 			return
 		}
@@ -26,17 +26,84 @@ type SystemSetting struct {
 // GroupConfig 存储特定于分组的配置
 type GroupConfig struct {
 	RequestTimeout               *int    `json:"request_timeout,omitempty"`
+	RequestBudget                *int    `json:"request_budget,omitempty"`
 	IdleConnTimeout              *int    `json:"idle_conn_timeout,omitempty"`
 	ConnectTimeout               *int    `json:"connect_timeout,omitempty"`
 	MaxIdleConns                 *int    `json:"max_idle_conns,omitempty"`
 	MaxIdleConnsPerHost          *int    `json:"max_idle_conns_per_host,omitempty"`
 	ResponseHeaderTimeout        *int    `json:"response_header_timeout,omitempty"`
 	ProxyURL                     *string `json:"proxy_url,omitempty"`
+	MinTLSVersion                *string `json:"min_tls_version,omitempty"`
+	UpstreamMaxRedirects         *int    `json:"upstream_max_redirects,omitempty"`
+	UpstreamRedirectHosts        *string `json:"upstream_redirect_hosts,omitempty"`
+	UpstreamWarmupInterval       *int    `json:"upstream_warmup_interval,omitempty"`
+	UpstreamWarmConnections      *int    `json:"upstream_warm_connections,omitempty"`
+	ParamClamps                  *string `json:"param_clamps,omitempty"`
+	MaxResponseBodyKB            *int    `json:"max_response_body_kb,omitempty"`
+	UpstreamDrainLimitKB         *int    `json:"upstream_drain_limit_kb,omitempty"`
+	UnbufferedBodyKB             *int    `json:"unbuffered_body_kb,omitempty"`
+	ForwardUpstreamTrailers      *int    `json:"forward_upstream_trailers,omitempty"`
+	MaxForwardedHeaderBytes      *int    `json:"max_forwarded_header_bytes,omitempty"`
+	UpstreamUserTag              *string `json:"upstream_user_tag,omitempty"`
+	RateLimitRequests            *int    `json:"rate_limit_requests,omitempty"`
+	RateLimitWindow              *int    `json:"rate_limit_window,omitempty"`
+	RequestPriority              *int    `json:"request_priority,omitempty"`
+	FallbackGroups               *string `json:"fallback_groups,omitempty"`
+	FallbackModelMapping         *string `json:"fallback_model_mapping,omitempty"`
+	RouteHeaderGroups            *string `json:"route_header_groups,omitempty"`
+	AllowedHeaderModels          *string `json:"allowed_header_models,omitempty"`
+	LogLevel                     *string `json:"log_level,omitempty"`
+	RequestLogSampleRate         *int    `json:"request_log_sample_rate,omitempty"`
+	RequestBodyLogPercent        *int    `json:"request_body_log_percent,omitempty"`
+	ConfigDebug                  *int    `json:"config_debug,omitempty"`
+	KeyIDHeader                  *int    `json:"key_id_header,omitempty"`
+	StandardErrorEnvelope        *int    `json:"standard_error_envelope,omitempty"`
+	ErrorCodeMapping             *string `json:"error_code_mapping,omitempty"`
+	MaxChunkChars                *int    `json:"max_chunk_chars,omitempty"`
+	FirstAttemptPunctuation      *int    `json:"first_attempt_punctuation,omitempty"`
 	MaxRetries                   *int    `json:"max_retries,omitempty"`
+	RetryableErrorMessages       *string `json:"retryable_error_messages,omitempty"`
 	BlacklistThreshold           *int    `json:"blacklist_threshold,omitempty"`
+	KeyQuotaRequests             *int    `json:"key_quota_requests,omitempty"`
+	KeyQuotaPeriod               *int    `json:"key_quota_period,omitempty"`
 	KeyValidationIntervalMinutes *int    `json:"key_validation_interval_minutes,omitempty"`
 	KeyValidationConcurrency     *int    `json:"key_validation_concurrency,omitempty"`
 	KeyValidationTimeoutSeconds  *int    `json:"key_validation_timeout_seconds,omitempty"`
+	StreamingMode                *string `json:"streaming_mode,omitempty"`
+	StreamJSONResponse           *string `json:"stream_json_response,omitempty"`
+	NonStreamingModels           *string `json:"non_streaming_models,omitempty"`
+	NonStreamingMode             *string `json:"non_streaming_mode,omitempty"`
+	StreamMaxRetries             *int    `json:"stream_max_retries,omitempty"`
+	StreamDoneTokens             *string `json:"stream_done_tokens,omitempty"`
+	StreamPunctuationHeuristic   *string `json:"stream_punctuation_heuristic,omitempty"`
+	StreamRetryDelayMs           *int    `json:"stream_retry_delay_ms,omitempty"`
+	StreamRetryBackoffPercent    *int    `json:"stream_retry_backoff_percent,omitempty"`
+	StreamMaxRetryDelayMs        *int    `json:"stream_max_retry_delay_ms,omitempty"`
+	StreamGoAwayReconnect        *int    `json:"stream_goaway_reconnect,omitempty"`
+	StreamRetryDecider           *string `json:"stream_retry_decider,omitempty"`
+	StreamHedgeDelayMs           *int    `json:"stream_hedge_delay_ms,omitempty"`
+	ContentAnalysisMinChars      *int    `json:"content_analysis_min_chars,omitempty"`
+	CodeFenceCompletion          *int    `json:"code_fence_completion,omitempty"`
+	StrictCompletion             *int    `json:"strict_completion,omitempty"`
+	StreamTokenBudget            *int    `json:"stream_token_budget,omitempty"`
+	StreamIncludeUsage           *int    `json:"stream_include_usage,omitempty"`
+	GeminiStreamSSE              *int    `json:"gemini_stream_sse,omitempty"`
+	DedupeStreamChunks           *int    `json:"dedupe_stream_chunks,omitempty"`
+	ClientWriteTimeout           *int    `json:"client_write_timeout,omitempty"`
+	StreamIdleTimeout            *int    `json:"stream_idle_timeout,omitempty"`
+	StopRetryPhrases             *string `json:"stop_retry_phrases,omitempty"`
+	ContinuationMarker           *string `json:"continuation_marker,omitempty"`
+	RetryNonceHeader             *string `json:"retry_nonce_header,omitempty"`
+	RetryNonceParam              *string `json:"retry_nonce_param,omitempty"`
+	NormalizeRetryContext        *int    `json:"normalize_retry_context,omitempty"`
+	ContinuationFillerPhrases    *string `json:"continuation_filler_phrases,omitempty"`
+	OpenAITerminalFinishReasons  *string `json:"openai_terminal_finish_reasons,omitempty"`
+	GeminiTerminalFinishReasons  *string `json:"gemini_terminal_finish_reasons,omitempty"`
+	EmptyStreamDiagnostic        *int    `json:"empty_stream_diagnostic,omitempty"`
+	StreamTeeDir                 *string `json:"stream_tee_dir,omitempty"`
+	DeadLetterDir                *string `json:"dead_letter_dir,omitempty"`
+	DeadLetterSnapshots          *int    `json:"dead_letter_snapshots,omitempty"`
+	JSONRepairAttempts           *int    `json:"json_repair_attempts,omitempty"`
 }
 
 // HeaderRule defines a single rule for header manipulation.
@@ -104,6 +171,7 @@ type RequestLog struct {
 	Retries      int       `gorm:"not null" json:"retries"`
 	UpstreamAddr string    `gorm:"type:varchar(500)" json:"upstream_addr"`
 	IsStream     bool      `gorm:"not null" json:"is_stream"`
+	RequestBody  string    `gorm:"type:text" json:"request_body,omitempty"`
 }
 
 // StatCard 用于仪表盘的单个统计卡片数据
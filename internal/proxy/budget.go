@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gpt-load/internal/models"
+)
+
+// RequestTimeoutHeader tells upstreams that honour it how many seconds are left to answer.
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// remainingBudget returns how much of the group's end-to-end time budget is left for the
+// next attempt. limited is false when the group has no budget configured.
+func remainingBudget(group *models.Group, startTime time.Time) (remaining time.Duration, limited bool) {
+	budget := group.EffectiveConfig.RequestBudget
+	if budget <= 0 {
+		return 0, false
+	}
+	return time.Duration(budget)*time.Second - time.Since(startTime), true
+}
+
+// attemptTimeout caps an attempt's own timeout (0 for none) by the remaining budget.
+func attemptTimeout(timeout, remaining time.Duration, limited bool) time.Duration {
+	if limited && (timeout <= 0 || remaining < timeout) {
+		return remaining
+	}
+	return timeout
+}
+
+// setRequestTimeoutHeader propagates the attempt's deadline to the upstream, rounded up to
+// whole seconds so a sub-second remainder is not sent as zero.
+func setRequestTimeoutHeader(req *http.Request, timeout time.Duration) {
+	req.Header.Set(RequestTimeoutHeader, strconv.Itoa(int(math.Ceil(timeout.Seconds()))))
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	app_errors "gpt-load/internal/errors"
+	"gpt-load/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestBudgetShrinksAcrossAttempts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var timeouts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeouts = append(timeouts, r.Header.Get(RequestTimeoutHeader))
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "budget"}
+	group.EffectiveConfig.RequestBudget = 5
+	group.EffectiveConfig.RequestTimeout = 600
+	ps := &ProxyServer{keyProvider: newTestKeyProvider(group.ID), retrySlots: &retrySemaphore{}}
+	ch := &stubChannel{upstream: server.URL, channelType: "gemini"}
+
+	newContext := func() *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat", strings.NewReader(`{}`))
+		return c
+	}
+
+	// The first attempt gets the whole budget, each retry only what the earlier attempts left
+	ps.executeRequestWithRetry(newContext(), ch, group, []byte(`{}`), false, time.Now(), 0, nil)
+	for _, elapsed := range []time.Duration{1500 * time.Millisecond, 3200 * time.Millisecond} {
+		resp, err := ps.createRetryRequest(newContext(), ch, group, []byte(`{}`), "partial", time.Now().Add(-elapsed))
+		if err != nil {
+			t.Fatalf("Expected retry within budget to succeed, got %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	expected := []string{"5", "4", "2"}
+	if strings.Join(timeouts, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected upstream timeouts %v, got %v", expected, timeouts)
+	}
+}
+
+func TestRequestBudgetExhaustedFailsFast(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "budget"}
+	group.EffectiveConfig.RequestBudget = 5
+	group.EffectiveConfig.RequestTimeout = 600
+	group.EffectiveConfig.MaxRetries = 3
+	ps := &ProxyServer{keyProvider: newTestKeyProvider(group.ID), retrySlots: &retrySemaphore{}}
+	ch := &stubChannel{upstream: server.URL, channelType: "gemini"}
+	startTime := time.Now().Add(-6 * time.Second)
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat", strings.NewReader(`{}`))
+	ps.executeRequestWithRetry(c, ch, group, []byte(`{}`), false, startTime, 1, nil)
+
+	if recorder.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected status %d once the budget is spent, got %d", http.StatusGatewayTimeout, recorder.Code)
+	}
+	if !strings.Contains(recorder.Body.String(), app_errors.ErrBudgetExhausted.Code) {
+		t.Errorf("Expected budget error in response, got %q", recorder.Body.String())
+	}
+
+	_, err := ps.createRetryRequest(c, ch, group, []byte(`{}`), "partial", startTime)
+	if !errors.Is(err, app_errors.ErrBudgetExhausted) {
+		t.Errorf("Expected stream retry to fail with budget error, got %v", err)
+	}
+	if hits != 0 {
+		t.Errorf("Expected no upstream requests after the budget is spent, got %d", hits)
+	}
+}
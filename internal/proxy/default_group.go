@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"errors"
+	"strings"
+
+	"gpt-load/internal/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// settingsProvider returns the current system settings, as config.SystemSettingsManager does.
+type settingsProvider interface {
+	GetSettings() types.SystemSettings
+}
+
+// DefaultGroupRouting routes proxy requests whose group does not exist to the configured
+// default group. The group name and path are rewritten before authentication, so the
+// request is authorized and forwarded exactly as if it had addressed the default group.
+// Requests for existing groups, and all requests when no default is set, are untouched.
+func (ps *ProxyServer) DefaultGroupRouting() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		groupName := c.Param("group_name")
+		defaultGroup := ps.defaultGroupName()
+		if defaultGroup == "" || defaultGroup == groupName {
+			c.Next()
+			return
+		}
+
+		if _, err := ps.groupManager.GetGroupByName(groupName); !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.Next()
+			return
+		}
+		if _, err := ps.groupManager.GetGroupByName(defaultGroup); err != nil {
+			logrus.Warnf("Default group '%s' is unavailable for unmatched group '%s': %v", defaultGroup, groupName, err)
+			c.Next()
+			return
+		}
+
+		logrus.Infof("No group named '%s', routing request to default group '%s'", groupName, defaultGroup)
+		c.Set(routedFromKey, groupName)
+		c.Set(routedByKey, routedByDefaultGroup)
+		for i := range c.Params {
+			if c.Params[i].Key == "group_name" {
+				c.Params[i].Value = defaultGroup
+			}
+		}
+		c.Request.URL.Path = "/proxy/" + defaultGroup + c.Param("path")
+		c.Request.URL.RawPath = ""
+		c.Next()
+	}
+}
+
+// defaultGroupName returns the configured default group, or "" if there is none.
+func (ps *ProxyServer) defaultGroupName() string {
+	if ps.settingsManager == nil {
+		return ""
+	}
+	return strings.TrimSpace(ps.settingsManager.GetSettings().DefaultGroup)
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gpt-load/internal/channel"
+	"gpt-load/internal/models"
+	"gpt-load/internal/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stubSettings returns fixed system settings.
+type stubSettings struct {
+	settings types.SystemSettings
+}
+
+func (s *stubSettings) GetSettings() types.SystemSettings {
+	return s.settings
+}
+
+func TestUnmatchedGroupRoutesToDefaultGroup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "main", ChannelType: "openai"}
+	group.EffectiveConfig.MaxRetries = 1
+	groups := &stubGroups{
+		groups:   map[string]*models.Group{"main": group},
+		channels: map[string]channel.ChannelProxy{"main": &stubChannel{upstream: server.URL, channelType: "openai"}},
+	}
+	settings := &stubSettings{}
+	ps := &ProxyServer{
+		keyProvider:     newTestKeyProvider(group.ID),
+		groupManager:    groups,
+		settingsManager: settings,
+		channelFactory:  groups,
+		retrySlots:      &retrySemaphore{},
+	}
+
+	engine := gin.New()
+	engine.Any("/proxy/:group_name/*path", ps.DefaultGroupRouting(), ps.HandleProxy)
+	send := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/proxy/unknown/v1/chat/completions", strings.NewReader(`{"model":"m"}`)))
+		return recorder
+	}
+
+	if recorder := send(); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown group to fail without a default group, got %d", recorder.Code)
+	}
+
+	settings.settings.DefaultGroup = "main"
+	recorder := send()
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the default group to serve the request, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if gotPath != "/proxy/main/v1/chat/completions" {
+		t.Errorf("Expected the request to be rewritten to the default group, got path %q", gotPath)
+	}
+}
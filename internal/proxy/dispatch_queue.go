@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// dispatchQueueWait is how long a request waits in the queue before it is rejected.
+const dispatchQueueWait = 30 * time.Second
+
+var errDispatchQueueTimeout = errors.New("timed out waiting for a free upstream slot")
+
+// dispatchSlotKey holds the release function of the upstream slot a request occupies.
+const dispatchSlotKey = "dispatchSlot"
+
+// dispatchQueue caps the number of requests being served upstream across the whole process.
+// Requests that find all slots taken wait in a priority queue: when a slot frees up it goes
+// to the waiting request with the highest priority, and to the earliest among equals. Like
+// retrySemaphore, the limit is read from settings on every acquire.
+type dispatchQueue struct {
+	mu      sync.Mutex
+	active  int
+	limit   int
+	seq     uint64
+	waiters waiterHeap
+}
+
+// dispatchWaiter is a request waiting for a slot. ready is closed once it has been granted one.
+type dispatchWaiter struct {
+	priority int
+	seq      uint64
+	index    int
+	ready    chan struct{}
+}
+
+// acquire reserves an upstream slot for a request of the given priority, waiting at most
+// wait for one. A limit of 0 disables the cap. The returned release function must be called once.
+func (q *dispatchQueue) acquire(ctx context.Context, limit, priority int, wait time.Duration) (func(), error) {
+	if q == nil || limit <= 0 {
+		return func() {}, nil
+	}
+
+	q.mu.Lock()
+	q.limit = limit
+	if q.active < q.limit && q.waiters.Len() == 0 {
+		q.active++
+		q.mu.Unlock()
+		return q.releaseFunc(), nil
+	}
+	q.seq++
+	waiter := &dispatchWaiter{priority: priority, seq: q.seq, ready: make(chan struct{})}
+	heap.Push(&q.waiters, waiter)
+	q.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-waiter.ready:
+		return q.releaseFunc(), nil
+	case <-timer.C:
+		err = errDispatchQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	q.mu.Lock()
+	if waiter.index >= 0 {
+		heap.Remove(&q.waiters, waiter.index)
+		q.mu.Unlock()
+		return nil, err
+	}
+	q.mu.Unlock()
+	// The slot was granted while giving up; hand it on
+	q.release()
+	return nil, err
+}
+
+func (q *dispatchQueue) releaseFunc() func() {
+	var once sync.Once
+	return func() { once.Do(q.release) }
+}
+
+// release frees a slot and grants free slots to the highest-priority waiters.
+func (q *dispatchQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.active--
+	for q.active < q.limit && q.waiters.Len() > 0 {
+		waiter := heap.Pop(&q.waiters).(*dispatchWaiter)
+		q.active++
+		close(waiter.ready)
+	}
+}
+
+// waiterHeap orders waiters by descending priority, then by arrival.
+type waiterHeap []*dispatchWaiter
+
+func (h waiterHeap) Len() int { return len(h) }
+
+func (h waiterHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h waiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *waiterHeap) Push(x any) {
+	waiter := x.(*dispatchWaiter)
+	waiter.index = len(*h)
+	*h = append(*h, waiter)
+}
+
+func (h *waiterHeap) Pop() any {
+	old := *h
+	waiter := old[len(old)-1]
+	old[len(old)-1] = nil
+	waiter.index = -1
+	*h = old[:len(old)-1]
+	return waiter
+}
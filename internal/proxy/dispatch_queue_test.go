@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// queued returns how many requests are waiting in the queue.
+func (q *dispatchQueue) queued() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.waiters.Len()
+}
+
+func TestDispatchQueueAdmitsHigherPriorityFirst(t *testing.T) {
+	q := &dispatchQueue{}
+	release, err := q.acquire(context.Background(), 1, 0, time.Second)
+	if err != nil {
+		t.Fatalf("Expected the first request to be admitted, got %v", err)
+	}
+
+	admitted := make(chan string, 3)
+	enqueue := func(name string, priority int) {
+		waiting := q.queued()
+		go func() {
+			release, err := q.acquire(context.Background(), 1, priority, time.Second)
+			if err != nil {
+				t.Errorf("Expected %s to be admitted, got %v", name, err)
+				return
+			}
+			admitted <- name
+			release()
+		}()
+		for q.queued() == waiting {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Lower-priority requests are queued first
+	enqueue("free-1", 0)
+	enqueue("free-2", 0)
+	enqueue("premium", 5)
+	release()
+
+	var order []string
+	for i := 0; i < 3; i++ {
+		select {
+		case name := <-admitted:
+			order = append(order, name)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected all queued requests to be admitted, got %v", order)
+		}
+	}
+	if order[0] != "premium" || order[1] != "free-1" || order[2] != "free-2" {
+		t.Errorf("Expected premium first, then free requests in arrival order, got %v", order)
+	}
+}
+
+func TestDispatchQueueTimesOutWhenSaturated(t *testing.T) {
+	q := &dispatchQueue{}
+	release, err := q.acquire(context.Background(), 1, 0, time.Second)
+	if err != nil {
+		t.Fatalf("Expected the first request to be admitted, got %v", err)
+	}
+
+	if _, err := q.acquire(context.Background(), 1, 9, 10*time.Millisecond); !errors.Is(err, errDispatchQueueTimeout) {
+		t.Errorf("Expected a queue timeout, got %v", err)
+	}
+	if q.queued() != 0 {
+		t.Errorf("Expected the timed-out request to leave the queue, %d still queued", q.queued())
+	}
+
+	release()
+	release, err = q.acquire(context.Background(), 1, 0, time.Second)
+	if err != nil {
+		t.Fatalf("Expected the freed slot to be reusable, got %v", err)
+	}
+	release()
+
+	if release, err := (*dispatchQueue)(nil).acquire(context.Background(), 1, 0, 0); err != nil {
+		t.Errorf("Expected a nil queue to admit immediately, got %v", err)
+	} else {
+		release()
+	}
+}
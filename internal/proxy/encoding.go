@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// acceptsEncoding reports whether an Accept-Encoding header value allows a content coding.
+// Explicit entries take precedence over the "*" wildcard and a q-value of 0 rules a coding
+// out. A request without the header is only sent identity-encoded responses, and identity
+// remains acceptable unless it is explicitly refused.
+func acceptsEncoding(acceptEncoding, coding string) bool {
+	coding = strings.ToLower(coding)
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return coding == "identity"
+	}
+
+	explicit, wildcard := -1.0, -1.0
+	for _, entry := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(entry, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if key, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(key) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = parsed
+			}
+		}
+
+		switch name {
+		case coding:
+			explicit = q
+		case "*":
+			wildcard = q
+		}
+	}
+
+	if explicit >= 0 {
+		return explicit > 0
+	}
+	if wildcard >= 0 {
+		return wildcard > 0
+	}
+	return coding == "identity"
+}
+
+// gzipBody decompresses an upstream body as it is read, closing the upstream body with it.
+type gzipBody struct {
+	*gzip.Reader
+	upstream io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.upstream.Close()
+}
+
+// decodeUpstreamBody removes the gzip content coding from an upstream response when the
+// client did not accept gzip, or when the proxy itself must read the body as text, so a
+// client never receives a compressed body it opted out of.
+func decodeUpstreamBody(resp *http.Response, acceptEncoding string, mustDecode bool) error {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return nil
+	}
+	if !mustDecode && acceptsEncoding(acceptEncoding, "gzip") {
+		return nil
+	}
+
+	reader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to decode gzip response: %w", err)
+	}
+	resp.Body = &gzipBody{Reader: reader, upstream: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
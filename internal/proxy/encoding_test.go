@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		coding         string
+		accepted       bool
+	}{
+		{"identity", "gzip", false},
+		{"identity", "identity", true},
+		{"gzip", "gzip", true},
+		{"gzip", "identity", true},
+		{"", "gzip", false},
+		{"", "identity", true},
+		{"br, gzip;q=0.5", "gzip", true},
+		{"gzip;q=0, identity", "gzip", false},
+		{"*;q=0, identity", "gzip", false},
+		{"*", "gzip", true},
+		{"GZIP;q=1.0", "gzip", true},
+		{"gzip, identity;q=0", "identity", false},
+	}
+
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.acceptEncoding, tt.coding); got != tt.accepted {
+			t.Errorf("acceptsEncoding(%q, %q) = %v, expected %v", tt.acceptEncoding, tt.coding, got, tt.accepted)
+		}
+	}
+}
+
+func TestDecodeUpstreamBodyHonorsClientEncoding(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte("hello"))
+	gz.Close()
+
+	run := func(acceptEncoding string, mustDecode bool) (string, string) {
+		resp := &http.Response{
+			Header: http.Header{"Content-Encoding": []string{"gzip"}},
+			Body:   io.NopCloser(bytes.NewReader(compressed.Bytes())),
+		}
+		if err := decodeUpstreamBody(resp, acceptEncoding, mustDecode); err != nil {
+			t.Fatalf("Expected body to decode, got %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.Header.Get("Content-Encoding"), string(body)
+	}
+
+	for _, acceptEncoding := range []string{"identity", ""} {
+		if encoding, body := run(acceptEncoding, false); encoding != "" || body != "hello" {
+			t.Errorf("Expected uncompressed body for Accept-Encoding %q, got encoding %q body %q", acceptEncoding, encoding, body)
+		}
+	}
+	if encoding, _ := run("gzip", false); encoding != "gzip" {
+		t.Errorf("Expected gzip body to be passed through to a gzip client, got encoding %q", encoding)
+	}
+	if encoding, body := run("gzip", true); encoding != "" || body != "hello" {
+		t.Errorf("Expected body read by the proxy to be decoded, got encoding %q body %q", encoding, body)
+	}
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	app_errors "gpt-load/internal/errors"
+	"gpt-load/internal/keypool"
+	"gpt-load/internal/models"
+	"gpt-load/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStandardErrorEnvelopeOnExhaustedRetries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	upstreamError := `{"error":{"code":429,"message":"Resource has been exhausted","status":"RESOURCE_EXHAUSTED"}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(upstreamError))
+	}))
+	defer server.Close()
+
+	send := func(enabled int) *httptest.ResponseRecorder {
+		group := &models.Group{ID: 1, Name: "envelope"}
+		group.EffectiveConfig.RequestTimeout = 600
+		group.EffectiveConfig.StandardErrorEnvelope = enabled
+		// The key is already marked invalid, so its failures don't touch the database
+		memStore := store.NewMemoryStore()
+		memStore.HSet("key:1", map[string]any{"key_string": "gm-key", "status": models.KeyStatusInvalid})
+		memStore.LPush("group:1:active_keys", "1")
+		ps := &ProxyServer{keyProvider: keypool.NewProvider(nil, memStore, nil), retrySlots: &retrySemaphore{}}
+		ch := &stubChannel{upstream: server.URL, channelType: "gemini"}
+
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/m:generateContent", strings.NewReader(`{}`))
+		ps.executeRequestWithRetry(c, ch, group, []byte(`{}`), false, time.Now(), 0, nil)
+		return recorder
+	}
+
+	if recorder := send(0); recorder.Body.String() != upstreamError {
+		t.Errorf("Expected the upstream error forwarded as is by default, got %s", recorder.Body.String())
+	}
+
+	recorder := send(1)
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the upstream status to be kept, got %d", recorder.Code)
+	}
+	var envelope struct {
+		Error struct {
+			Code     string          `json:"code"`
+			Upstream json.RawMessage `json:"upstream"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("Expected a JSON envelope, got %s", recorder.Body.String())
+	}
+	if envelope.Error.Code != app_errors.UpstreamRateLimited {
+		t.Errorf("Expected code %q, got %q", app_errors.UpstreamRateLimited, envelope.Error.Code)
+	}
+	if string(envelope.Error.Upstream) != upstreamError {
+		t.Errorf("Expected the original error nested under upstream, got %s", envelope.Error.Upstream)
+	}
+}
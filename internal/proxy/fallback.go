@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gpt-load/internal/channel"
+	"gpt-load/internal/models"
+	"gpt-load/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// fallbackStateKey holds the fallback progress of a request in the gin context.
+	fallbackStateKey = "fallbackState"
+	// clientBodyKey holds the request body as the client sent it, before any group changed it.
+	clientBodyKey = "clientBody"
+)
+
+var (
+	errUnsupportedTranslation = errors.New("unsupported cross-provider translation")
+	errNoFallbackModel        = errors.New("no fallback model mapped")
+)
+
+// fallbackState remembers the client's original request, so every fallback group is
+// translated from it rather than from an earlier fallback's translation.
+type fallbackState struct {
+	originGroup string
+	originType  string
+	model       string
+	body        []byte
+	url         *url.URL
+	writer      gin.ResponseWriter
+	groups      []string
+	next        int
+}
+
+// parseFallbackGroups splits the comma-separated fallback_groups setting.
+func parseFallbackGroups(value string) []string {
+	var groups []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			groups = append(groups, name)
+		}
+	}
+	return groups
+}
+
+// parseFallbackModelMapping parses the fallback_model_mapping setting, a comma-separated list
+// of source=target model pairs. A source of * matches every model without an entry of its own.
+func parseFallbackModelMapping(value string) map[string]string {
+	mapping := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		source, target, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		source, target = strings.TrimSpace(source), strings.TrimSpace(target)
+		if !ok || source == "" || target == "" {
+			continue
+		}
+		mapping[source] = target
+	}
+	return mapping
+}
+
+// fallbackModel returns the model a fallback group maps the client's model to.
+func fallbackModel(group *models.Group, model string) (string, bool) {
+	mapping := parseFallbackModelMapping(group.EffectiveConfig.FallbackModelMapping)
+	if target, ok := mapping[model]; ok {
+		return target, true
+	}
+	target, ok := mapping["*"]
+	return target, ok
+}
+
+// tryFallback routes the request to the next fallback group of the group the client
+// addressed, once the current group's keys or retries are exhausted. Fallback groups are
+// tried in the configured order; their own fallback settings are ignored. It returns false
+// when no fallback is left, in which case the caller reports the current group's error.
+func (ps *ProxyServer) tryFallback(c *gin.Context, group *models.Group, channelHandler channel.ChannelProxy, bodyBytes []byte, isStream bool, startTime time.Time) bool {
+	log := utils.GroupLogger(group)
+
+	var state *fallbackState
+	if value, ok := c.Get(fallbackStateKey); ok {
+		state = value.(*fallbackState)
+	} else {
+		groups := parseFallbackGroups(group.EffectiveConfig.FallbackGroups)
+		if len(groups) == 0 {
+			return false
+		}
+		originURL := *c.Request.URL
+		// A fallback group gets the client's own request, not the one the origin group clamped,
+		// overrode and tagged
+		clientBody := bodyBytes
+		if value, ok := c.Get(clientBodyKey); ok {
+			clientBody = value.([]byte)
+		}
+		state = &fallbackState{
+			originGroup: group.Name,
+			originType:  channelHandler.GetChannelType(),
+			model:       channelHandler.ExtractModel(c, bodyBytes),
+			body:        clientBody,
+			url:         &originURL,
+			writer:      c.Writer,
+			groups:      groups,
+		}
+		c.Set(fallbackStateKey, state)
+	}
+
+	for state.next < len(state.groups) {
+		name := state.groups[state.next]
+		state.next++
+
+		if name == state.originGroup {
+			continue
+		}
+		fallbackGroup, err := ps.groupManager.GetGroupByName(name)
+		if err != nil {
+			log.Warnf("Fallback group %s not found: %v", name, err)
+			continue
+		}
+		fallbackChannel, err := ps.channelFactory.GetChannel(fallbackGroup)
+		if err != nil {
+			log.Warnf("Failed to get channel for fallback group %s: %v", name, err)
+			continue
+		}
+
+		fallbackType := fallbackChannel.GetChannelType()
+		targetURL, fallbackBody, err := translateFallbackRequest(state, fallbackGroup, fallbackType, isStream)
+		if err != nil {
+			log.Warnf("Skipping fallback group %s (%s -> %s): %v", name, state.originType, fallbackType, err)
+			continue
+		}
+		if c.GetBool(bufferedStreamKey) {
+			if fallbackBody, err = bufferStreamRequest(c, fallbackBody); err != nil {
+				log.Warnf("Skipping fallback group %s: failed to remove streaming parameters: %v", name, err)
+				continue
+			}
+		}
+		if fallbackBody, err = ps.prepareGroupBody(c, fallbackBody, fallbackGroup, fallbackType); err != nil {
+			log.Warnf("Skipping fallback group %s: %v", name, err)
+			continue
+		}
+
+		// The exhausted group is done upstream, so its slot is handed on before the fallback
+		// group is admitted like a direct request
+		if release, ok := c.Get(dispatchSlotKey); ok {
+			release.(func())()
+		}
+		releaseSlot, _, err := ps.admitRequest(c, fallbackGroup)
+		if err != nil {
+			log.Warnf("Skipping fallback group %s: %v", name, err)
+			continue
+		}
+		defer releaseSlot()
+
+		log.Infof("Group %s exhausted, falling back to group %s (%s)", group.Name, fallbackGroup.Name, fallbackType)
+		c.Request.URL = targetURL
+		c.Writer = state.writer
+
+		var translator *geminiResponseWriter
+		if state.originType == "gemini" && fallbackType == "openai" {
+			translator = newGeminiResponseWriter(state.writer, isStream)
+			c.Writer = translator
+		}
+
+		ps.executeRequestWithRetry(c, fallbackChannel, fallbackGroup, fallbackBody, isStream, startTime, 0, nil)
+
+		if translator != nil {
+			translator.finish()
+		}
+		return true
+	}
+
+	return false
+}
+
+// translateFallbackRequest rewrites the client's original request for a fallback group.
+// Requests between channels of the same type are replayed unchanged. A Gemini request is
+// translated to an OpenAI chat completion, while OpenAI requests reach a Gemini group
+// through its OpenAI-compatible endpoint. Cross-provider requests use the model the fallback
+// group's fallback_model_mapping assigns to the client's model, and skip groups without one.
+func translateFallbackRequest(state *fallbackState, group *models.Group, targetType string, isStream bool) (*url.URL, []byte, error) {
+	proxyPrefix := "/proxy/" + group.Name
+	targetURL := *state.url
+
+	switch {
+	case state.originType == targetType:
+		targetURL.Path = proxyPrefix + strings.TrimPrefix(state.url.Path, "/proxy/"+state.originGroup)
+		return &targetURL, state.body, nil
+
+	case state.originType == "gemini" && targetType == "openai":
+		model, ok := fallbackModel(group, state.model)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w for model %q", errNoFallbackModel, state.model)
+		}
+		body, err := geminiToOpenAIRequest(state.body, model, isStream)
+		if err != nil {
+			return nil, nil, err
+		}
+		targetURL.Path = proxyPrefix + "/v1/chat/completions"
+		targetURL.RawQuery = ""
+		return &targetURL, body, nil
+
+	case state.originType == "openai" && targetType == "gemini":
+		model, ok := fallbackModel(group, state.model)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w for model %q", errNoFallbackModel, state.model)
+		}
+		body, err := replaceModel(state.body, model)
+		if err != nil {
+			return nil, nil, err
+		}
+		targetURL.Path = proxyPrefix + "/v1beta/openai/chat/completions"
+		targetURL.RawQuery = ""
+		return &targetURL, body, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w: %s -> %s", errUnsupportedTranslation, state.originType, targetType)
+}
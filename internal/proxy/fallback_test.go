@@ -0,0 +1,320 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gpt-load/internal/channel"
+	"gpt-load/internal/keypool"
+	"gpt-load/internal/models"
+	"gpt-load/internal/store"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// stubGroups resolves groups and their channels from fixed maps.
+type stubGroups struct {
+	groups   map[string]*models.Group
+	channels map[string]channel.ChannelProxy
+}
+
+func (s *stubGroups) GetGroupByName(name string) (*models.Group, error) {
+	if group, ok := s.groups[name]; ok {
+		return group, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *stubGroups) GroupNames() []string {
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *stubGroups) GetChannel(group *models.Group) (channel.ChannelProxy, error) {
+	return s.channels[group.Name], nil
+}
+
+func TestPrimaryOutageFallsBackToTranslatedProvider(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	primaryHits := 0
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryHits++
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":{"message":"overloaded"}}`))
+	}))
+	defer primary.Close()
+
+	var fallbackPath, fallbackAuth string
+	var fallbackBody map[string]interface{}
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallbackPath, fallbackAuth = r.URL.Path, r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &fallbackBody)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"model":"gpt-4o-mini","choices":[{"delta":{"content":"Hello"},"finish_reason":null}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"model":"gpt-4o-mini","choices":[{"delta":{"content":" there"},"finish_reason":"stop"}]}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer fallback.Close()
+
+	// The primary key is already marked invalid, so its failures don't touch the database.
+	memStore := store.NewMemoryStore()
+	memStore.HSet("key:1", map[string]any{"key_string": "gm-key", "status": models.KeyStatusInvalid})
+	memStore.LPush("group:1:active_keys", "1")
+	memStore.HSet("key:2", map[string]any{"key_string": "sk-fallback", "status": models.KeyStatusActive})
+	memStore.LPush("group:2:active_keys", "2")
+
+	primaryGroup := &models.Group{ID: 1, Name: "gemini-main", ChannelType: "gemini"}
+	primaryGroup.EffectiveConfig.MaxRetries = 1
+	primaryGroup.EffectiveConfig.FallbackGroups = "missing, openai-backup"
+	fallbackGroup := &models.Group{ID: 2, Name: "openai-backup", ChannelType: "openai", TestModel: "gpt-4o-validate"}
+	fallbackGroup.EffectiveConfig.FallbackModelMapping = "*=gpt-4o-mini"
+
+	groups := &stubGroups{
+		groups: map[string]*models.Group{"gemini-main": primaryGroup, "openai-backup": fallbackGroup},
+		channels: map[string]channel.ChannelProxy{
+			"gemini-main":   &stubChannel{upstream: primary.URL, channelType: "gemini"},
+			"openai-backup": &stubChannel{upstream: fallback.URL, channelType: "openai"},
+		},
+	}
+	ps := &ProxyServer{
+		keyProvider:    keypool.NewProvider(nil, memStore, nil),
+		groupManager:   groups,
+		channelFactory: groups,
+		retrySlots:     &retrySemaphore{},
+	}
+
+	engine := gin.New()
+	engine.Any("/proxy/:group_name/*path", ps.HandleProxy)
+	request := `{"systemInstruction":{"parts":[{"text":"Be brief."}]},"contents":[{"role":"user","parts":[{"text":"Hi"}]}],"generationConfig":{"maxOutputTokens":64}}`
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/proxy/gemini-main/v1beta/models/gemini-pro:streamGenerateContent?alt=sse", strings.NewReader(request)))
+
+	if primaryHits != 2 {
+		t.Errorf("Expected the primary to be tried until its retries were exhausted, got %d requests", primaryHits)
+	}
+	// stubChannel forwards the proxy path verbatim, so the fallback group's prefix is kept
+	if fallbackPath != "/proxy/openai-backup/v1/chat/completions" {
+		t.Errorf("Expected fallback to receive an OpenAI chat completion request, got path %q", fallbackPath)
+	}
+	if fallbackAuth != "Bearer sk-fallback" {
+		t.Errorf("Expected fallback to use its own key, got %q", fallbackAuth)
+	}
+	if fallbackBody["model"] != "gpt-4o-mini" || fallbackBody["max_tokens"] != float64(64) || fallbackBody["stream"] != true {
+		t.Errorf("Expected translated model, max_tokens and stream, got %v", fallbackBody)
+	}
+	if messages, _ := fallbackBody["messages"].([]interface{}); len(messages) != 2 {
+		t.Errorf("Expected system and user messages, got %v", fallbackBody["messages"])
+	}
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected fallback response to succeed, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	body := recorder.Body.String()
+	if strings.Contains(body, "[DONE]") || strings.Contains(body, `"choices"`) {
+		t.Errorf("Expected OpenAI framing to be translated away, got %q", body)
+	}
+	var text strings.Builder
+	var finishReason string
+	for _, line := range strings.Split(body, "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var chunk struct {
+			Candidates []struct {
+				Content struct {
+					Parts []struct {
+						Text string `json:"text"`
+					} `json:"parts"`
+				} `json:"content"`
+				FinishReason string `json:"finishReason"`
+			} `json:"candidates"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &chunk); err != nil || len(chunk.Candidates) != 1 {
+			t.Fatalf("Expected Gemini JSON chunks, got %q", line)
+		}
+		for _, part := range chunk.Candidates[0].Content.Parts {
+			text.WriteString(part.Text)
+		}
+		if chunk.Candidates[0].FinishReason != "" {
+			finishReason = chunk.Candidates[0].FinishReason
+		}
+	}
+	if text.String() != "Hello there" || finishReason != "STOP" {
+		t.Errorf("Expected Gemini chunks with text %q and finishReason STOP, got %q and %q", "Hello there", text.String(), finishReason)
+	}
+}
+
+func TestFallbackSkipsUnmappedAndRateLimitedGroups(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":{"message":"overloaded"}}`))
+	}))
+	defer primary.Close()
+
+	hits := map[string]int{}
+	var servedModel string
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[strings.Split(r.URL.Path, "/")[2]]++
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		servedModel, _ = body["model"].(string)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"Hi!"},"finish_reason":"stop"}]}`))
+	}))
+	defer backup.Close()
+
+	memStore := store.NewMemoryStore()
+	memStore.HSet("key:1", map[string]any{"key_string": "gm-key", "status": models.KeyStatusInvalid})
+	memStore.LPush("group:1:active_keys", "1")
+	for id := 2; id <= 4; id++ {
+		memStore.HSet(fmt.Sprintf("key:%d", id), map[string]any{"key_string": "sk-backup", "status": models.KeyStatusActive})
+		memStore.LPush(fmt.Sprintf("group:%d:active_keys", id), fmt.Sprint(id))
+	}
+
+	primaryGroup := &models.Group{ID: 1, Name: "gemini-main", ChannelType: "gemini"}
+	primaryGroup.EffectiveConfig.FallbackGroups = "unmapped,limited,mapped"
+	// A single upstream slot, which the primary hands on to the fallback
+	primaryGroup.EffectiveConfig.MaxConcurrentUpstream = 1
+	// Only the test model is known, which must not be used for fallback traffic
+	unmapped := &models.Group{ID: 2, Name: "unmapped", ChannelType: "openai", TestModel: "gpt-4o-validate"}
+	limited := &models.Group{ID: 3, Name: "limited", ChannelType: "openai"}
+	limited.EffectiveConfig.FallbackModelMapping = "*=gpt-4o"
+	limited.EffectiveConfig.RateLimitRequests = 1
+	limited.EffectiveConfig.RateLimitWindow = 60
+	mapped := &models.Group{ID: 4, Name: "mapped", ChannelType: "openai"}
+	mapped.EffectiveConfig.FallbackModelMapping = "*=gpt-4o"
+	mapped.EffectiveConfig.MaxConcurrentUpstream = 1
+
+	groups := &stubGroups{
+		groups:   map[string]*models.Group{"gemini-main": primaryGroup, "unmapped": unmapped, "limited": limited, "mapped": mapped},
+		channels: map[string]channel.ChannelProxy{"gemini-main": &stubChannel{upstream: primary.URL, channelType: "gemini"}},
+	}
+	for _, name := range []string{"unmapped", "limited", "mapped"} {
+		groups.channels[name] = &stubChannel{upstream: backup.URL, channelType: "openai"}
+	}
+	ps := &ProxyServer{
+		keyProvider:    keypool.NewProvider(nil, memStore, nil),
+		groupManager:   groups,
+		channelFactory: groups,
+		retrySlots:     &retrySemaphore{},
+		rateLimiter:    newSlidingWindowLimiter(),
+		dispatchSlots:  &dispatchQueue{},
+	}
+	ps.rateLimiter.allow(limited.ID, 1, time.Minute)
+
+	engine := gin.New()
+	engine.Any("/proxy/:group_name/*path", ps.HandleProxy)
+	recorder := httptest.NewRecorder()
+	request := `{"contents":[{"role":"user","parts":[{"text":"Hi"}]}]}`
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/proxy/gemini-main/v1beta/models/gemini-pro:generateContent", strings.NewReader(request)))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the mapped group to serve the request, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if hits["unmapped"] != 0 || hits["limited"] != 0 || hits["mapped"] != 1 {
+		t.Errorf("Expected only the mapped group within its rate limit to be tried, got %v", hits)
+	}
+	if servedModel != "gpt-4o" {
+		t.Errorf("Expected the mapped fallback model, got %q", servedModel)
+	}
+}
+
+func TestFallbackPreparesClientBodyForFallbackGroup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var primaryBody map[string]interface{}
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&primaryBody)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":{"message":"overloaded"}}`))
+	}))
+	defer primary.Close()
+
+	var fallbackBody map[string]interface{}
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&fallbackBody)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"Hi!"},"finish_reason":"stop"}]}`))
+	}))
+	defer backup.Close()
+
+	memStore := store.NewMemoryStore()
+	memStore.HSet("key:1", map[string]any{"key_string": "sk-main", "status": models.KeyStatusInvalid})
+	memStore.LPush("group:1:active_keys", "1")
+	memStore.HSet("key:2", map[string]any{"key_string": "sk-backup", "status": models.KeyStatusActive})
+	memStore.LPush("group:2:active_keys", "2")
+
+	primaryGroup := &models.Group{ID: 1, Name: "main", ChannelType: "openai", ParamOverrides: map[string]any{"temperature": 0.1}}
+	primaryGroup.EffectiveConfig.FallbackGroups = "backup"
+	primaryGroup.EffectiveConfig.ParamClamps = "max_tokens=1:10"
+	primaryGroup.EffectiveConfig.UpstreamUserTag = "main-team"
+	fallbackGroup := &models.Group{ID: 2, Name: "backup", ChannelType: "openai", ParamOverrides: map[string]any{"top_p": 0.5}}
+	fallbackGroup.EffectiveConfig.ParamClamps = "max_tokens=1:20"
+	fallbackGroup.EffectiveConfig.UpstreamUserTag = "backup-team"
+
+	groups := &stubGroups{
+		groups: map[string]*models.Group{"main": primaryGroup, "backup": fallbackGroup},
+		channels: map[string]channel.ChannelProxy{
+			"main":   &stubChannel{upstream: primary.URL, channelType: "openai"},
+			"backup": &stubChannel{upstream: backup.URL, channelType: "openai"},
+		},
+	}
+	ps := &ProxyServer{
+		keyProvider:    keypool.NewProvider(nil, memStore, nil),
+		groupManager:   groups,
+		channelFactory: groups,
+		retrySlots:     &retrySemaphore{},
+	}
+
+	engine := gin.New()
+	engine.Any("/proxy/:group_name/*path", ps.HandleProxy)
+	recorder := httptest.NewRecorder()
+	request := `{"model":"gpt-4o","max_tokens":50,"messages":[{"role":"user","content":"Hi"}]}`
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/proxy/main/v1/chat/completions", strings.NewReader(request)))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the fallback to serve the request, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if primaryBody["max_tokens"] != float64(10) || primaryBody["user"] != "main-team" {
+		t.Errorf("Expected the primary to get its own clamps and tag, got %v", primaryBody)
+	}
+	if fallbackBody["max_tokens"] != float64(20) || fallbackBody["user"] != "backup-team" || fallbackBody["top_p"] != 0.5 {
+		t.Errorf("Expected the fallback group's clamps, overrides and tag, got %v", fallbackBody)
+	}
+	if _, ok := fallbackBody["temperature"]; ok {
+		t.Errorf("Expected none of the primary group's overrides in the fallback request, got %v", fallbackBody)
+	}
+}
+
+func TestOpenAIToGeminiResponse(t *testing.T) {
+	body := []byte(`{"model":"gpt-4o-mini","choices":[{"message":{"role":"assistant","content":"Hi!"},"finish_reason":"length"}],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`)
+
+	translated, ok := openAIToGeminiResponse(body)
+	if !ok {
+		t.Fatal("Expected chat completion to be translated")
+	}
+	expected := `{"candidates":[{"content":{"parts":[{"text":"Hi!"}],"role":"model"},"finishReason":"MAX_TOKENS","index":0}],"modelVersion":"gpt-4o-mini","usageMetadata":{"candidatesTokenCount":2,"promptTokenCount":3,"totalTokenCount":5}}`
+	if string(translated) != expected {
+		t.Errorf("Expected %s, got %s", expected, translated)
+	}
+
+	if _, ok := openAIToGeminiResponse([]byte(`{"error":{"message":"bad"}}`)); ok {
+		t.Error("Expected non-completion bodies to be left untranslated")
+	}
+}
@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gpt-load/internal/streaming"
+
+	"github.com/gin-gonic/gin"
+)
+
+// geminiToOpenAIRequest translates a Gemini generateContent request into an OpenAI chat
+// completion request. Only text parts are carried over.
+func geminiToOpenAIRequest(body []byte, model string, isStream bool) ([]byte, error) {
+	var request map[string]interface{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("failed to parse gemini request: %w", err)
+	}
+
+	var messages []map[string]interface{}
+	for _, key := range []string{"systemInstruction", "system_instruction"} {
+		if instruction, ok := request[key].(map[string]interface{}); ok {
+			if text := geminiPartsText(instruction); text != "" {
+				messages = append(messages, map[string]interface{}{"role": "system", "content": text})
+			}
+		}
+	}
+
+	contents, _ := request["contents"].([]interface{})
+	for _, item := range contents {
+		content, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		role := "user"
+		if r, _ := content["role"].(string); r == "model" {
+			role = "assistant"
+		}
+		messages = append(messages, map[string]interface{}{"role": role, "content": geminiPartsText(content)})
+	}
+
+	translated := map[string]interface{}{
+		"model":    model,
+		"messages": messages,
+	}
+	if isStream {
+		translated["stream"] = true
+	}
+
+	generationConfigFields := map[string]string{
+		"maxOutputTokens": "max_tokens",
+		"temperature":     "temperature",
+		"topP":            "top_p",
+		"stopSequences":   "stop",
+	}
+	if config, ok := request["generationConfig"].(map[string]interface{}); ok {
+		for geminiField, openAIField := range generationConfigFields {
+			if value, ok := config[geminiField]; ok {
+				translated[openAIField] = value
+			}
+		}
+	}
+
+	return json.Marshal(translated)
+}
+
+// geminiPartsText concatenates the text parts of a Gemini content object.
+func geminiPartsText(content map[string]interface{}) string {
+	parts, _ := content["parts"].([]interface{})
+	var text strings.Builder
+	for _, p := range parts {
+		if part, ok := p.(map[string]interface{}); ok {
+			if s, ok := part["text"].(string); ok {
+				text.WriteString(s)
+			}
+		}
+	}
+	return text.String()
+}
+
+// replaceModel sets the model of a JSON request body.
+func replaceModel(body []byte, model string) ([]byte, error) {
+	var request map[string]interface{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("failed to parse request: %w", err)
+	}
+	request["model"] = model
+	return json.Marshal(request)
+}
+
+// geminiFinishReasons maps normalized finish reasons back onto Gemini's vocabulary.
+var geminiFinishReasons = map[streaming.FinishReason]string{
+	streaming.FinishReasonStop:          "STOP",
+	streaming.FinishReasonToolCalls:     "STOP",
+	streaming.FinishReasonLength:        "MAX_TOKENS",
+	streaming.FinishReasonContentFilter: "SAFETY",
+	streaming.FinishReasonOther:         "OTHER",
+}
+
+// geminiResponseWriter rewrites an OpenAI chat completion response from a fallback group
+// into the Gemini format the client asked for. Streams are translated line by line as they
+// pass through; regular responses are buffered and translated by finish. Anything that is
+// not a chat completion, such as an error response, is passed through unchanged.
+type geminiResponseWriter struct {
+	gin.ResponseWriter
+	stream  bool
+	pending bytes.Buffer
+}
+
+func newGeminiResponseWriter(w gin.ResponseWriter, stream bool) *geminiResponseWriter {
+	return &geminiResponseWriter{ResponseWriter: w, stream: stream}
+}
+
+func (w *geminiResponseWriter) Write(p []byte) (int, error) {
+	w.pending.Write(p)
+	if !w.stream {
+		return len(p), nil
+	}
+
+	for {
+		line, err := w.pending.ReadString('\n')
+		if err != nil {
+			// Keep the incomplete line for the next write
+			w.pending.Reset()
+			w.pending.WriteString(line)
+			return len(p), nil
+		}
+		if _, err := w.ResponseWriter.WriteString(translateOpenAIStreamLine(line)); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (w *geminiResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// finish writes whatever is still buffered once the fallback request has completed.
+func (w *geminiResponseWriter) finish() {
+	if w.pending.Len() == 0 {
+		return
+	}
+
+	body := w.pending.Bytes()
+	if w.stream {
+		w.ResponseWriter.WriteString(translateOpenAIStreamLine(string(body)))
+		return
+	}
+
+	if w.Status() < http.StatusBadRequest {
+		if translated, ok := openAIToGeminiResponse(body); ok {
+			body = translated
+		}
+	}
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.Write(body)
+}
+
+// translateOpenAIStreamLine rewrites one OpenAI SSE line as a Gemini SSE line. The [DONE]
+// marker has no Gemini counterpart and is dropped.
+func translateOpenAIStreamLine(line string) string {
+	content := strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(content, "data: ") {
+		return line
+	}
+	data := strings.TrimPrefix(content, "data: ")
+	if data == "[DONE]" {
+		return ""
+	}
+
+	var chunk struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Delta struct {
+				Content string `json:"content"`
+			} `json:"delta"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage map[string]interface{} `json:"usage"`
+	}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil || (len(chunk.Choices) == 0 && chunk.Usage == nil) {
+		return line
+	}
+
+	var text, finishReason string
+	if len(chunk.Choices) > 0 {
+		text, finishReason = chunk.Choices[0].Delta.Content, chunk.Choices[0].FinishReason
+	}
+	payload, _ := json.Marshal(geminiResponse(text, finishReason, chunk.Model, chunk.Usage))
+	return "data: " + string(payload) + "\n"
+}
+
+// openAIToGeminiResponse rewrites a non-streaming chat completion as a Gemini response.
+func openAIToGeminiResponse(body []byte) ([]byte, bool) {
+	var completion struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage map[string]interface{} `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &completion); err != nil || len(completion.Choices) == 0 {
+		return nil, false
+	}
+
+	choice := completion.Choices[0]
+	translated, err := json.Marshal(geminiResponse(choice.Message.Content, choice.FinishReason, completion.Model, completion.Usage))
+	if err != nil {
+		return nil, false
+	}
+	return translated, true
+}
+
+// geminiResponse builds a Gemini response object with a single text candidate.
+func geminiResponse(text, finishReason, model string, usage map[string]interface{}) map[string]interface{} {
+	candidate := map[string]interface{}{
+		"index": 0,
+		"content": map[string]interface{}{
+			"role":  "model",
+			"parts": []interface{}{map[string]interface{}{"text": text}},
+		},
+	}
+	if finishReason != "" {
+		candidate["finishReason"] = geminiFinishReasons[streaming.NormalizeFinishReason("openai", finishReason)]
+	}
+
+	response := map[string]interface{}{"candidates": []interface{}{candidate}}
+	if model != "" {
+		response["modelVersion"] = model
+	}
+	if usage != nil {
+		response["usageMetadata"] = map[string]interface{}{
+			"promptTokenCount":     usage["prompt_tokens"],
+			"candidatesTokenCount": usage["completion_tokens"],
+			"totalTokenCount":      usage["total_tokens"],
+		}
+	}
+	return response
+}
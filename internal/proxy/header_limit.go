@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"net/http"
+
+	"gpt-load/internal/models"
+	"gpt-load/internal/utils"
+)
+
+// copyBoundedHeaders sets the upstream header values on the client response, replacing any
+// the response already has, and drops every value whose line would exceed the group's
+// max_forwarded_header_bytes. Clients and proxies in front of them reject a response with an
+// oversized header outright, so one huge upstream header would cost the whole response. A cut
+// value could be misread, so it is dropped rather than truncated.
+func copyBoundedHeaders(dst, src http.Header, group *models.Group) {
+	limit := group.EffectiveConfig.MaxForwardedHeaderBytes
+	for key, values := range src {
+		var kept []string
+		for _, value := range values {
+			if limit > 0 && headerLineBytes(key, value) > limit {
+				utils.GroupLogger(group).Warnf("Dropping upstream header %s of %d bytes, which exceeds the limit of %d bytes", key, len(value), limit)
+				continue
+			}
+			kept = append(kept, value)
+		}
+		if len(kept) > 0 {
+			dst[http.CanonicalHeaderKey(key)] = kept
+		}
+	}
+}
+
+// headerLineBytes is the size of a header line as sent over HTTP/1.1, without the CRLF.
+func headerLineBytes(key, value string) int {
+	return len(key) + len(": ") + len(value)
+}
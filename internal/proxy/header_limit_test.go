@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"gpt-load/internal/models"
+)
+
+func TestOversizedUpstreamHeaderIsDropped(t *testing.T) {
+	group := &models.Group{Name: "headers"}
+	group.EffectiveConfig.MaxForwardedHeaderBytes = 1024
+
+	upstream := http.Header{}
+	upstream.Set("X-Request-Id", "abc")
+	upstream.Set("X-Debug-Trace", strings.Repeat("x", 4096))
+	upstream.Add("Set-Cookie", "a=1")
+	upstream.Add("Set-Cookie", "b="+strings.Repeat("y", 2048))
+
+	client := http.Header{}
+	client.Set("X-Debug-Trace", "from the proxy")
+	copyBoundedHeaders(client, upstream, group)
+
+	if client.Get("X-Request-Id") != "abc" {
+		t.Errorf("Expected a small header to be forwarded, got %q", client.Get("X-Request-Id"))
+	}
+	if got := client.Get("X-Debug-Trace"); got != "from the proxy" {
+		t.Errorf("Expected the oversized header to be dropped, got %d bytes", len(got))
+	}
+	if cookies := client.Values("Set-Cookie"); len(cookies) != 1 || cookies[0] != "a=1" {
+		t.Errorf("Expected only the oversized value of a multi-valued header to be dropped, got %d values", len(cookies))
+	}
+
+	group.EffectiveConfig.MaxForwardedHeaderBytes = 0
+	unlimited := http.Header{}
+	copyBoundedHeaders(unlimited, upstream, group)
+	if len(unlimited.Get("X-Debug-Trace")) != 4096 {
+		t.Error("Expected every header to be forwarded without a limit")
+	}
+}
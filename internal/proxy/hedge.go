@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gpt-load/internal/channel"
+	"gpt-load/internal/keypool"
+	"gpt-load/internal/models"
+	"gpt-load/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hedgedResult is the outcome of one of the requests raced by doHedged.
+type hedgedResult struct {
+	resp  *http.Response
+	err   error
+	index int
+}
+
+// hedgeFailure is a hedge that failed, with the key it was sent with.
+type hedgeFailure struct {
+	key *models.APIKey
+	err error
+}
+
+// hedgeSucceeded reports whether a result is final rather than retryable, matching the
+// retry rules of executeRequestWithRetry.
+func hedgeSucceeded(r hedgedResult) bool {
+	return r.err == nil && (r.resp.StatusCode < 400 || r.resp.StatusCode == http.StatusNotFound)
+}
+
+// doHedged sends the primary request, send(ctx, 0), and if it has not answered within delay
+// also a hedge, send(ctx, 1). The first successful answer wins and the other request is
+// canceled, its body closed if it had already answered. A primary that fails before the
+// delay is returned as is, leaving the retry to the caller. When both fail, the primary's
+// failure is returned. A hedge that failed before the race ended is reported as hedgeErr,
+// whichever request's answer is returned. Each request runs under its own child of ctx, so
+// the winner stays readable until ctx is canceled.
+func doHedged(ctx context.Context, delay time.Duration, send func(ctx context.Context, index int) (*http.Response, error)) (resp *http.Response, index int, hedgeErr error, err error) {
+	results := make(chan hedgedResult, 2)
+	var cancels []context.CancelFunc
+	launch := func(index int) {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, err := send(attemptCtx, index)
+			results <- hedgedResult{resp: resp, err: err, index: index}
+		}()
+	}
+
+	launch(0)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	hedgeAt := timer.C
+
+	pending := 1
+	var primaryFailure *hedgedResult
+	var hedgeFailed error
+	for {
+		select {
+		case <-hedgeAt:
+			hedgeAt = nil
+			launch(1)
+			pending++
+
+		case r := <-results:
+			pending--
+			if hedgeSucceeded(r) {
+				for i, cancel := range cancels {
+					if i != r.index {
+						cancel()
+					}
+				}
+				// The loser may still answer after being canceled
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if loser := <-results; loser.resp != nil {
+							loser.resp.Body.Close()
+						}
+					}
+				}(pending)
+				if primaryFailure != nil && primaryFailure.resp != nil {
+					primaryFailure.resp.Body.Close()
+				}
+				return r.resp, r.index, hedgeFailed, nil
+			}
+
+			if r.index == 0 {
+				if hedgeAt != nil {
+					// Failed before the hedge was due, so there is nothing to race
+					return r.resp, 0, nil, r.err
+				}
+				primaryFailure = &r
+			} else if r.err != nil {
+				hedgeFailed = r.err
+			} else {
+				hedgeFailed = fmt.Errorf("hedge failed with status %d", r.resp.StatusCode)
+				r.resp.Body.Close()
+			}
+			if pending == 0 {
+				return primaryFailure.resp, 0, hedgeFailed, primaryFailure.err
+			}
+		}
+	}
+}
+
+// sendHedged sends a streaming request and, if no response headers arrived within the group's
+// hedge delay, races a second request with another key against it. It returns the winning
+// response with the key and upstream URL that produced it, and the hedge's failure if the
+// hedge was sent and failed, so its key can be penalized. A losing request that was canceled
+// leaves its key untouched, since it did not fail.
+func (ps *ProxyServer) sendHedged(
+	ctx context.Context,
+	c *gin.Context,
+	channelHandler channel.ChannelProxy,
+	group *models.Group,
+	client *http.Client,
+	req *http.Request,
+	apiKey *models.APIKey,
+	upstreamURL string,
+	bodyBytes []byte,
+	timeout time.Duration,
+	budgeted bool,
+) (*http.Response, *models.APIKey, string, *hedgeFailure, error) {
+	log := utils.GroupLogger(group)
+	delay := time.Duration(group.EffectiveConfig.StreamHedgeDelayMs) * time.Millisecond
+
+	keys := []*models.APIKey{apiKey, nil}
+	urls := []string{upstreamURL, ""}
+	resp, index, hedgeErr, err := doHedged(ctx, delay, func(attemptCtx context.Context, index int) (*http.Response, error) {
+		if index == 0 {
+			return client.Do(req.WithContext(attemptCtx))
+		}
+
+		hedgeKey, err := ps.keyProvider.SelectKeyWithinQuota(group.ID, keypool.KeyQuotaOf(group))
+		if err != nil {
+			return nil, err
+		}
+		hedgeURL, err := channelHandler.BuildUpstreamURL(c.Request.URL, group)
+		if err != nil {
+			return nil, err
+		}
+		hedgeReq, err := ps.buildUpstreamRequest(attemptCtx, c, channelHandler, group, hedgeKey, hedgeURL, bodyBytes, true, timeout, budgeted)
+		if err != nil {
+			return nil, err
+		}
+		keys[1], urls[1] = hedgeKey, hedgeURL
+		log.Debugf("No response within %v, hedging with key %s", delay, utils.MaskAPIKey(hedgeKey.KeyValue))
+		return client.Do(hedgeReq)
+	})
+	if index == 1 {
+		log.Debugf("Hedged request with key %s won the race", utils.MaskAPIKey(keys[1].KeyValue))
+	}
+	// A hedge that never got a key or a request built did not fail on its key
+	var failure *hedgeFailure
+	if hedgeErr != nil && keys[1] != nil {
+		failure = &hedgeFailure{key: keys[1], err: hedgeErr}
+	}
+	return resp, keys[index], urls[index], failure, err
+}
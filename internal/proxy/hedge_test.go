@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gpt-load/internal/models"
+	"gpt-load/internal/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func okResponse(body string) *http.Response {
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+func TestSlowPrimaryTriggersHedgeThatWins(t *testing.T) {
+	primaryCanceled := make(chan struct{})
+	resp, index, _, err := doHedged(context.Background(), 20*time.Millisecond, func(ctx context.Context, index int) (*http.Response, error) {
+		if index == 0 {
+			<-ctx.Done()
+			close(primaryCanceled)
+			return nil, ctx.Err()
+		}
+		return okResponse("hedge"), nil
+	})
+	if err != nil || index != 1 {
+		t.Fatalf("Expected the hedge to win, got index %d, err %v", index, err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "hedge" {
+		t.Errorf("Expected the hedge's response, got %q", body)
+	}
+
+	select {
+	case <-primaryCanceled:
+	case <-time.After(time.Second):
+		t.Error("Expected the slow primary to be canceled")
+	}
+}
+
+func TestFastPrimaryIsNotHedged(t *testing.T) {
+	var hedges int32
+	_, index, _, err := doHedged(context.Background(), time.Second, func(ctx context.Context, index int) (*http.Response, error) {
+		if index == 1 {
+			atomic.AddInt32(&hedges, 1)
+		}
+		return okResponse("primary"), nil
+	})
+	if err != nil || index != 0 {
+		t.Fatalf("Expected the primary to win, got index %d, err %v", index, err)
+	}
+	if atomic.LoadInt32(&hedges) != 0 {
+		t.Error("Expected no hedge for a primary that answered in time")
+	}
+}
+
+func TestPrimaryFailureIsReturnedWhenBothFail(t *testing.T) {
+	primaryErr := errors.New("primary failed")
+	_, index, _, err := doHedged(context.Background(), 10*time.Millisecond, func(ctx context.Context, index int) (*http.Response, error) {
+		if index == 0 {
+			time.Sleep(50 * time.Millisecond)
+			return nil, primaryErr
+		}
+		return nil, errors.New("no key for the hedge")
+	})
+	if index != 0 || err != primaryErr {
+		t.Errorf("Expected the primary's failure, got index %d, err %v", index, err)
+	}
+}
+
+func TestHedgeFailureIsReportedWhenPrimaryWins(t *testing.T) {
+	resp, index, hedgeErr, err := doHedged(context.Background(), 10*time.Millisecond, func(ctx context.Context, index int) (*http.Response, error) {
+		if index == 0 {
+			time.Sleep(50 * time.Millisecond)
+			return okResponse("primary"), nil
+		}
+		return &http.Response{StatusCode: http.StatusTooManyRequests, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})
+	if err != nil || index != 0 {
+		t.Fatalf("Expected the primary to win, got index %d, err %v", index, err)
+	}
+	resp.Body.Close()
+	if hedgeErr == nil {
+		t.Error("Expected the hedge's failure to be reported")
+	}
+}
+
+func TestHedgedStreamUsesWinningRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		if atomic.AddInt32(&requests, 1) == 1 {
+			// The first upstream stalls until the proxy gives up on it
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "test", EffectiveConfig: types.SystemSettings{StreamHedgeDelayMs: 20}}
+	ps := &ProxyServer{keyProvider: newTestKeyProvider(group.ID)}
+	ch := &stubChannel{upstream: server.URL, channelType: "openai"}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+	req, err := ps.buildUpstreamRequest(context.Background(), c, ch, group, &models.APIKey{KeyValue: "sk-primary"}, server.URL+"/v1/chat/completions", []byte(`{}`), true, 0, false)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+
+	resp, key, _, _, err := ps.sendHedged(context.Background(), c, ch, group, http.DefaultClient, req, &models.APIKey{KeyValue: "sk-primary"}, server.URL, []byte(`{}`), 0, false)
+	if err != nil {
+		t.Fatalf("Expected the hedged request to succeed, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if key.KeyValue != "sk-test" {
+		t.Errorf("Expected the hedge's key to be reported for the winner, got %s", key.KeyValue)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("Expected the primary and one hedge, got %d requests", got)
+	}
+}
+
+func TestHedgedStreamReportsFailedHedgeKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		if atomic.AddInt32(&requests, 1) == 1 {
+			// The primary answers only after the hedge has failed
+			time.Sleep(100 * time.Millisecond)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "test", EffectiveConfig: types.SystemSettings{StreamHedgeDelayMs: 20}}
+	ps := &ProxyServer{keyProvider: newTestKeyProvider(group.ID)}
+	ch := &stubChannel{upstream: server.URL, channelType: "openai"}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+	primaryKey := &models.APIKey{KeyValue: "sk-primary"}
+	req, err := ps.buildUpstreamRequest(context.Background(), c, ch, group, primaryKey, server.URL+"/v1/chat/completions", []byte(`{}`), true, 0, false)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+
+	resp, key, _, failure, err := ps.sendHedged(context.Background(), c, ch, group, http.DefaultClient, req, primaryKey, server.URL, []byte(`{}`), 0, false)
+	if err != nil {
+		t.Fatalf("Expected the primary's error response, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if key != primaryKey || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected the primary's failure to be returned, got key %s and status %d", key.KeyValue, resp.StatusCode)
+	}
+	if failure == nil || failure.key.KeyValue != "sk-test" {
+		t.Fatalf("Expected the hedge's failure to be returned with its key, got %+v", failure)
+	}
+}
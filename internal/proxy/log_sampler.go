@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"math/rand"
+
+	"gpt-load/internal/models"
+)
+
+// maxLoggedBodyBytes caps the request body kept in a sampled request log.
+const maxLoggedBodyBytes = 64 * 1024
+
+// sampleRequestLog reports whether a successful request is recorded in the request log. A
+// group with a sample rate of N records about one in N of them, so busy groups still yield
+// representative logs without writing every request.
+func sampleRequestLog(group *models.Group) bool {
+	rate := group.EffectiveConfig.RequestLogSampleRate
+	if rate <= 1 {
+		return true
+	}
+	return rand.Float64() < 1/float64(rate)
+}
+
+// sampleRequestBody reports whether a recorded request log keeps the request body. Bodies may
+// carry user data, so none are kept unless the group asks for a percentage of them.
+func sampleRequestBody(group *models.Group) bool {
+	percent := group.EffectiveConfig.RequestBodyLogPercent
+	if percent <= 0 {
+		return false
+	}
+	return rand.Float64()*100 < float64(percent)
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"math"
+	"testing"
+
+	"gpt-load/internal/models"
+)
+
+func TestRequestLogSamplingFraction(t *testing.T) {
+	const requests = 100000
+
+	tests := []struct {
+		name     string
+		rate     int
+		percent  int
+		wantLogs float64
+		wantBody float64
+	}{
+		{"everything", 1, 0, 1, 0},
+		{"unset rate", 0, 0, 1, 0},
+		{"one in ten", 10, 0, 0.1, 0},
+		{"one in four with bodies", 4, 20, 0.25, 0.2},
+		{"all bodies", 1, 100, 1, 1},
+	}
+
+	for _, test := range tests {
+		group := &models.Group{}
+		group.EffectiveConfig.RequestLogSampleRate = test.rate
+		group.EffectiveConfig.RequestBodyLogPercent = test.percent
+
+		logged, bodies := 0, 0
+		for i := 0; i < requests; i++ {
+			if sampleRequestLog(group) {
+				logged++
+			}
+			if sampleRequestBody(group) {
+				bodies++
+			}
+		}
+
+		if got := float64(logged) / requests; math.Abs(got-test.wantLogs) > 0.01 {
+			t.Errorf("%s: expected about %.2f of requests to be logged, got %.4f", test.name, test.wantLogs, got)
+		}
+		if got := float64(bodies) / requests; math.Abs(got-test.wantBody) > 0.01 {
+			t.Errorf("%s: expected about %.2f of logs to keep the body, got %.4f", test.name, test.wantBody, got)
+		}
+	}
+}
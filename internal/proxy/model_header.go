@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gpt-load/internal/models"
+	"gpt-load/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ModelHeader overrides the model of a single request without the client editing its body.
+// It is consumed by the proxy and not sent upstream.
+const ModelHeader = "X-GPT-Load-Model"
+
+// headerModel returns the model the model header selects, or "" if there is none. The
+// group must list the model in its allowed_header_models setting; a group without the
+// setting ignores the header.
+func headerModel(c *gin.Context, group *models.Group) (string, error) {
+	model := strings.TrimSpace(c.GetHeader(ModelHeader))
+	allowed := utils.StringToSet(group.EffectiveConfig.AllowedHeaderModels, ",")
+	if model == "" || len(allowed) == 0 {
+		return "", nil
+	}
+	if _, ok := allowed[model]; !ok {
+		return "", fmt.Errorf("model '%s' is not allowed for group '%s'", model, group.Name)
+	}
+	return model, nil
+}
+
+// applyModelOverride switches a request to the given model by rewriting the model segment of
+// a models/{model}:action path, as native Gemini requests use, and the body's model field.
+func applyModelOverride(c *gin.Context, bodyBytes []byte, model string) ([]byte, error) {
+	if model == "" {
+		return bodyBytes, nil
+	}
+
+	segments := strings.Split(c.Request.URL.Path, "/")
+	for i := 0; i+1 < len(segments); i++ {
+		if segments[i] != "models" || segments[i+1] == "" {
+			continue
+		}
+		_, action, found := strings.Cut(segments[i+1], ":")
+		segments[i+1] = model
+		if found {
+			segments[i+1] += ":" + action
+		}
+		c.Request.URL.Path = strings.Join(segments, "/")
+		c.Request.URL.RawPath = ""
+		break
+	}
+
+	if len(bodyBytes) == 0 {
+		return bodyBytes, nil
+	}
+	var requestData map[string]any
+	if err := json.Unmarshal(bodyBytes, &requestData); err != nil {
+		logrus.Warnf("failed to unmarshal request body for model override, passing through: %v", err)
+		return bodyBytes, nil
+	}
+	if _, ok := requestData["model"]; !ok {
+		return bodyBytes, nil
+	}
+	requestData["model"] = model
+	return json.Marshal(requestData)
+}
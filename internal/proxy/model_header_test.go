@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gpt-load/internal/channel"
+	"gpt-load/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestModelHeaderOverridesUpstreamModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var gotPath, gotBody, gotHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotPath, gotBody, gotHeader = r.URL.Path, string(body), r.Header.Get(ModelHeader)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	newGroup := func(name string) *models.Group {
+		group := &models.Group{ID: 1, Name: name}
+		group.EffectiveConfig.RequestTimeout = 600
+		group.EffectiveConfig.AllowedHeaderModels = "gpt-4o-mini, gemini-2.5-flash"
+		return group
+	}
+	groups := &stubGroups{
+		groups: map[string]*models.Group{"openai": newGroup("openai"), "gemini": newGroup("gemini")},
+		channels: map[string]channel.ChannelProxy{
+			"openai": &stubChannel{upstream: server.URL, channelType: "openai"},
+			"gemini": &stubChannel{upstream: server.URL, channelType: "gemini"},
+		},
+	}
+	ps := &ProxyServer{keyProvider: newTestKeyProvider(1), groupManager: groups, channelFactory: groups, retrySlots: &retrySemaphore{}}
+
+	engine := gin.New()
+	engine.Any("/proxy/:group_name/*path", ps.HandleProxy)
+	send := func(path, body, model string) *httptest.ResponseRecorder {
+		gotPath, gotBody = "", ""
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(ModelHeader, model)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	send("/proxy/openai/v1/chat/completions", `{"model":"gpt-4o","messages":[]}`, "gpt-4o-mini")
+	if !strings.Contains(gotBody, `"model":"gpt-4o-mini"`) {
+		t.Errorf("Expected the body model to be overridden, got %s", gotBody)
+	}
+	if gotHeader != "" {
+		t.Errorf("Expected the model header not to be sent upstream, got %q", gotHeader)
+	}
+
+	send("/proxy/gemini/v1beta/models/gemini-2.0-flash:streamGenerateContent", `{"contents":[]}`, "gemini-2.5-flash")
+	if gotPath != "/proxy/gemini/v1beta/models/gemini-2.5-flash:streamGenerateContent" {
+		t.Errorf("Expected the Gemini path model to be overridden, got %s", gotPath)
+	}
+	if gotBody != `{"contents":[]}` {
+		t.Errorf("Expected a body without a model field to be left alone, got %s", gotBody)
+	}
+
+	if recorder := send("/proxy/openai/v1/chat/completions", `{"model":"gpt-4o"}`, "o3"); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected a model outside the allowed list to be rejected, got %d", recorder.Code)
+	}
+	if gotPath != "" {
+		t.Errorf("Expected a rejected model not to reach the upstream, got %s", gotPath)
+	}
+}
@@ -0,0 +1,262 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	app_errors "gpt-load/internal/errors"
+	"gpt-load/internal/models"
+	"gpt-load/internal/response"
+	"gpt-load/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ways to handle a streaming request for a model that cannot stream, selectable per group
+// with the non_streaming_mode setting.
+const (
+	NonStreamingModeBuffer = "buffer"
+	NonStreamingModeReject = "reject"
+)
+
+// bufferedStreamKey marks a streaming request that is sent upstream without streaming, so
+// its response is converted to SSE events before it is forwarded.
+const bufferedStreamKey = "bufferedStream"
+
+// isNonStreamingModel reports whether the group lists the model as unable to stream. The
+// "*" entry matches every model.
+func isNonStreamingModel(group *models.Group, model string) bool {
+	for _, name := range utils.SplitAndTrim(group.EffectiveConfig.NonStreamingModels, ",") {
+		if name == "*" || name == model {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferStreamRequest turns a streaming request into a non-streaming one by removing the
+// stream and stream_options fields, and for Gemini by switching to generateContent.
+func bufferStreamRequest(c *gin.Context, bodyBytes []byte) ([]byte, error) {
+	if path := c.Request.URL.Path; strings.HasSuffix(path, ":streamGenerateContent") {
+		c.Request.URL.Path = strings.TrimSuffix(path, ":streamGenerateContent") + ":generateContent"
+		c.Request.URL.RawPath = ""
+		q := c.Request.URL.Query()
+		if q.Get("alt") == "sse" {
+			q.Del("alt")
+			c.Request.URL.RawQuery = q.Encode()
+		}
+	}
+
+	if len(bodyBytes) == 0 {
+		return bodyBytes, nil
+	}
+	var requestData map[string]json.RawMessage
+	if err := json.Unmarshal(bodyBytes, &requestData); err != nil {
+		return bodyBytes, nil
+	}
+	if _, ok := requestData["stream"]; !ok {
+		return bodyBytes, nil
+	}
+	delete(requestData, "stream")
+	delete(requestData, "stream_options")
+	return json.Marshal(requestData)
+}
+
+// handleBufferedStreamResponse forwards the complete response to a buffered streaming
+// request as the SSE events the client expects.
+func (ps *ProxyServer) handleBufferedStreamResponse(c *gin.Context, resp *http.Response, group *models.Group, channelType string) {
+	log := utils.GroupLogger(group)
+
+	limit := int64(group.EffectiveConfig.MaxResponseBodyKB) * 1024
+	readLimit := limit
+	if limit > 0 {
+		readLimit++
+	}
+	body, err := readErrorBody(resp.Body, readLimit)
+	if err != nil {
+		logUpstreamError("reading buffered response body", err)
+		return
+	}
+
+	var events []byte
+	if limit > 0 && int64(len(body)) > limit {
+		err = fmt.Errorf("response body exceeds the limit of %d bytes", limit)
+	} else {
+		events, err = bufferedStreamEvents(channelType, body)
+	}
+	for _, key := range []string{"Content-Length", "Content-Encoding", "Content-Type"} {
+		c.Writer.Header().Del(key)
+	}
+	if err != nil {
+		log.Warnf("Failed to convert buffered response to a stream: %v", err)
+		response.Error(c, app_errors.NewAPIError(app_errors.ErrBadGateway, fmt.Sprintf("Failed to convert upstream response to a stream: %v", err)))
+		return
+	}
+
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	if _, err := c.Writer.Write(events); err != nil {
+		logUpstreamError("writing buffered stream", err)
+	}
+}
+
+// bufferedStreamEvents renders a complete response as the SSE events of the channel's
+// stream format. Gemini stream chunks have the same shape as a complete response, so its
+// response, like that of unknown channels, becomes a single event.
+func bufferedStreamEvents(channelType string, body []byte) ([]byte, error) {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("invalid JSON response: %w", err)
+	}
+
+	var events bytes.Buffer
+	writeEvent := func(name string, payload any) error {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if name != "" {
+			fmt.Fprintf(&events, "event: %s\n", name)
+		}
+		fmt.Fprintf(&events, "data: %s\n\n", encoded)
+		return nil
+	}
+
+	var err error
+	switch channelType {
+	case "openai":
+		err = writeOpenAIChunks(data, writeEvent)
+		events.WriteString("data: [DONE]\n\n")
+	case "anthropic":
+		err = writeAnthropicEvents(data, writeEvent)
+	default:
+		err = writeEvent("", data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return events.Bytes(), nil
+}
+
+// writeOpenAIChunks renders a chat completion as one chunk carrying each choice's message as
+// its delta, followed by a usage chunk as sent with stream_options.include_usage.
+func writeOpenAIChunks(data map[string]any, writeEvent func(string, any) error) error {
+	chunk := make(map[string]any)
+	for _, key := range []string{"id", "created", "model", "system_fingerprint", "service_tier"} {
+		if value, ok := data[key]; ok {
+			chunk[key] = value
+		}
+	}
+	chunk["object"] = "chat.completion.chunk"
+
+	choices, _ := data["choices"].([]any)
+	chunkChoices := make([]any, 0, len(choices))
+	for _, c := range choices {
+		choice, ok := c.(map[string]any)
+		if !ok {
+			continue
+		}
+		delta, _ := choice["message"].(map[string]any)
+		if toolCalls, ok := delta["tool_calls"].([]any); ok {
+			// Streamed tool calls are identified by their position
+			for i, call := range toolCalls {
+				if callMap, ok := call.(map[string]any); ok {
+					callMap["index"] = i
+				}
+			}
+		}
+		chunkChoices = append(chunkChoices, map[string]any{
+			"index":         choice["index"],
+			"delta":         delta,
+			"finish_reason": choice["finish_reason"],
+		})
+	}
+	chunk["choices"] = chunkChoices
+	if err := writeEvent("", chunk); err != nil {
+		return err
+	}
+
+	if usage, ok := data["usage"]; ok && usage != nil {
+		usageChunk := make(map[string]any, len(chunk))
+		for key, value := range chunk {
+			usageChunk[key] = value
+		}
+		usageChunk["choices"] = []any{}
+		usageChunk["usage"] = usage
+		return writeEvent("", usageChunk)
+	}
+	return nil
+}
+
+// writeAnthropicEvents renders a message as the Messages API event sequence: message_start,
+// a start, delta and stop event for each content block, message_delta and message_stop.
+func writeAnthropicEvents(data map[string]any, writeEvent func(string, any) error) error {
+	content, _ := data["content"].([]any)
+	usage, _ := data["usage"].(map[string]any)
+
+	message := make(map[string]any, len(data))
+	for key, value := range data {
+		message[key] = value
+	}
+	message["content"] = []any{}
+	message["stop_reason"] = nil
+	message["stop_sequence"] = nil
+	if err := writeEvent("message_start", map[string]any{"type": "message_start", "message": message}); err != nil {
+		return err
+	}
+
+	for i, b := range content {
+		block, ok := b.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		start := make(map[string]any, len(block))
+		for key, value := range block {
+			start[key] = value
+		}
+		var delta map[string]any
+		switch block["type"] {
+		case "text":
+			start["text"] = ""
+			delta = map[string]any{"type": "text_delta", "text": block["text"]}
+		case "thinking":
+			start["thinking"] = ""
+			delta = map[string]any{"type": "thinking_delta", "thinking": block["thinking"]}
+		case "tool_use":
+			input, err := json.Marshal(block["input"])
+			if err != nil {
+				return err
+			}
+			start["input"] = map[string]any{}
+			delta = map[string]any{"type": "input_json_delta", "partial_json": string(input)}
+		}
+
+		if err := writeEvent("content_block_start", map[string]any{"type": "content_block_start", "index": i, "content_block": start}); err != nil {
+			return err
+		}
+		if delta != nil {
+			if err := writeEvent("content_block_delta", map[string]any{"type": "content_block_delta", "index": i, "delta": delta}); err != nil {
+				return err
+			}
+		}
+		if err := writeEvent("content_block_stop", map[string]any{"type": "content_block_stop", "index": i}); err != nil {
+			return err
+		}
+	}
+
+	messageDelta := map[string]any{
+		"type":  "message_delta",
+		"delta": map[string]any{"stop_reason": data["stop_reason"], "stop_sequence": data["stop_sequence"]},
+	}
+	if outputTokens, ok := usage["output_tokens"]; ok {
+		messageDelta["usage"] = map[string]any{"output_tokens": outputTokens}
+	}
+	if err := writeEvent("message_delta", messageDelta); err != nil {
+		return err
+	}
+	return writeEvent("message_stop", map[string]any{"type": "message_stop"})
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gpt-load/internal/channel"
+	"gpt-load/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNonStreamingModelGetsBufferedThenChunkedResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var upstreamBody map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &upstreamBody)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"o1-mini","choices":[{"index":0,"message":{"role":"assistant","content":"Hello there"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`))
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "main", ChannelType: "openai"}
+	group.EffectiveConfig.NonStreamingModels = "*"
+	groups := &stubGroups{
+		groups:   map[string]*models.Group{"main": group},
+		channels: map[string]channel.ChannelProxy{"main": &stubChannel{upstream: server.URL, channelType: "openai"}},
+	}
+	ps := &ProxyServer{
+		keyProvider:    newTestKeyProvider(group.ID),
+		groupManager:   groups,
+		channelFactory: groups,
+		retrySlots:     &retrySemaphore{},
+	}
+	engine := gin.New()
+	engine.Any("/proxy/:group_name/*path", ps.HandleProxy)
+	send := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := `{"model":"o1-mini","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"Hi"}]}`
+		engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/proxy/main/v1/chat/completions", strings.NewReader(request)))
+		return recorder
+	}
+
+	recorder := send()
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the buffered request to succeed, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if _, ok := upstreamBody["stream"]; ok {
+		t.Errorf("Expected stream to be removed from the upstream request, got %v", upstreamBody)
+	}
+	if _, ok := upstreamBody["stream_options"]; ok {
+		t.Errorf("Expected stream_options to be removed from the upstream request, got %v", upstreamBody)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected an event stream, got content type %q", contentType)
+	}
+
+	events := strings.Split(strings.TrimSuffix(recorder.Body.String(), "\n\n"), "\n\n")
+	if len(events) != 3 || events[2] != "data: [DONE]" {
+		t.Fatalf("Expected a content chunk, a usage chunk and [DONE], got %q", recorder.Body.String())
+	}
+	var chunk struct {
+		Object  string `json:"object"`
+		Choices []struct {
+			Delta        map[string]any `json:"delta"`
+			FinishReason string         `json:"finish_reason"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(events[0], "data: ")), &chunk); err != nil {
+		t.Fatalf("Expected a JSON chunk, got %q", events[0])
+	}
+	if chunk.Object != "chat.completion.chunk" || len(chunk.Choices) != 1 || chunk.Choices[0].Delta["content"] != "Hello there" || chunk.Choices[0].FinishReason != "stop" {
+		t.Errorf("Unexpected content chunk %q", events[0])
+	}
+	if !strings.Contains(events[1], `"usage":{"completion_tokens":2`) {
+		t.Errorf("Expected the usage in its own chunk, got %q", events[1])
+	}
+
+	group.EffectiveConfig.NonStreamingMode = NonStreamingModeReject
+	if recorder := send(); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected the streaming request to be rejected, got %d", recorder.Code)
+	}
+}
+
+func TestBufferedStreamEventsForAnthropicAndGemini(t *testing.T) {
+	message := `{"id":"msg_1","type":"message","role":"assistant","model":"claude","content":[{"type":"text","text":"Hi"},{"type":"tool_use","id":"tu_1","name":"lookup","input":{"q":"x"}}],"stop_reason":"tool_use","stop_sequence":null,"usage":{"input_tokens":5,"output_tokens":7}}`
+	events, err := bufferedStreamEvents("anthropic", []byte(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, line := range strings.Split(string(events), "\n") {
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			names = append(names, name)
+		}
+	}
+	expected := "message_start,content_block_start,content_block_delta,content_block_stop,content_block_start,content_block_delta,content_block_stop,message_delta,message_stop"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("Expected events %s, got %v", expected, names)
+	}
+	for _, want := range []string{`{"text":"Hi","type":"text_delta"}`, `"partial_json":"{\"q\":\"x\"}"`, `"stop_reason":"tool_use"`, `"usage":{"output_tokens":7}`} {
+		if !strings.Contains(string(events), want) {
+			t.Errorf("Expected %s in the events, got %s", want, events)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/proxy/g/v1beta/models/gemini-pro:streamGenerateContent?alt=sse", strings.NewReader(`{}`))
+	if _, err := bufferStreamRequest(c, []byte(`{"contents":[]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if c.Request.URL.Path != "/proxy/g/v1beta/models/gemini-pro:generateContent" || c.Request.URL.RawQuery != "" {
+		t.Errorf("Expected a generateContent request without alt=sse, got %s", c.Request.URL)
+	}
+	events, _ = bufferedStreamEvents("gemini", []byte(`{"candidates":[{"content":{"parts":[{"text":"Hi"}]},"finishReason":"STOP"}]}`))
+	if string(events) != `data: {"candidates":[{"content":{"parts":[{"text":"Hi"}]},"finishReason":"STOP"}]}`+"\n\n" {
+		t.Errorf("Expected the Gemini response as a single event, got %q", events)
+	}
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var errGroupRateLimited = errors.New("group rate limit reached")
+
+// slidingWindowLimiter caps the number of requests each group may start within a rolling
+// window. Unlike fixed windows, it never admits a burst of twice the limit around a window
+// boundary, since every admitted request counts until exactly one window after it started.
+// Limits are read from settings on every call, so changes apply immediately.
+type slidingWindowLimiter struct {
+	mu      sync.Mutex
+	windows map[uint][]time.Time
+	now     func() time.Time
+}
+
+func newSlidingWindowLimiter() *slidingWindowLimiter {
+	return &slidingWindowLimiter{windows: make(map[uint][]time.Time), now: time.Now}
+}
+
+// allow admits a request for the group if fewer than limit requests were admitted within
+// the trailing window. Otherwise it returns how long until the oldest request in the window
+// expires and a slot frees up. A limit of 0 disables the check.
+func (l *slidingWindowLimiter) allow(groupID uint, limit int, window time.Duration) (bool, time.Duration) {
+	if l == nil || limit <= 0 || window <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	admitted := l.windows[groupID]
+
+	// Drop requests that have left the window
+	expired := 0
+	for expired < len(admitted) && !admitted[expired].After(now.Add(-window)) {
+		expired++
+	}
+	admitted = admitted[expired:]
+
+	// A lowered limit leaves more entries than needed; only the newest ones matter
+	if len(admitted) > limit {
+		admitted = admitted[len(admitted)-limit:]
+	}
+
+	if len(admitted) >= limit {
+		l.windows[groupID] = admitted
+		return false, admitted[0].Add(window).Sub(now)
+	}
+
+	l.windows[groupID] = append(admitted, now)
+	return true, 0
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowLimiterBoundaries(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	now := start
+	limiter := newSlidingWindowLimiter()
+	limiter.now = func() time.Time { return now }
+
+	window := 60 * time.Second
+
+	// Three requests spread over the window fill a limit of 3
+	for _, offset := range []time.Duration{0, 10 * time.Second, 20 * time.Second} {
+		now = start.Add(offset)
+		if allowed, _ := limiter.allow(1, 3, window); !allowed {
+			t.Fatalf("Expected request at +%v to be admitted", offset)
+		}
+	}
+
+	now = start.Add(59 * time.Second)
+	allowed, retryAfter := limiter.allow(1, 3, window)
+	if allowed {
+		t.Error("Expected fourth request inside the window to be rejected")
+	}
+	if retryAfter != time.Second {
+		t.Errorf("Expected Retry-After to point at the oldest request expiring in 1s, got %v", retryAfter)
+	}
+
+	// Exactly one window after the first request, its slot is free again
+	now = start.Add(window)
+	if allowed, _ := limiter.allow(1, 3, window); !allowed {
+		t.Error("Expected request to be admitted once the oldest request left the window")
+	}
+	now = start.Add(window + time.Second)
+	if allowed, retryAfter := limiter.allow(1, 3, window); allowed || retryAfter != 9*time.Second {
+		t.Errorf("Expected rejection until the +10s request expires, got allowed=%v retryAfter=%v", allowed, retryAfter)
+	}
+
+	// Rejected requests do not occupy slots, and groups are counted separately
+	if allowed, _ := limiter.allow(2, 3, window); !allowed {
+		t.Error("Expected another group to have its own window")
+	}
+	if allowed, _ := limiter.allow(1, 0, window); !allowed {
+		t.Error("Expected a limit of 0 to disable the check")
+	}
+}
@@ -3,12 +3,21 @@ package proxy
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	app_errors "gpt-load/internal/errors"
 	"gpt-load/internal/models"
+	"gpt-load/internal/utils"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
@@ -30,6 +39,154 @@ func (ps *ProxyServer) applyParamOverrides(bodyBytes []byte, group *models.Group
 	return json.Marshal(requestData)
 }
 
+// paramClamp defines the allowed numeric range of a single request body field.
+type paramClamp struct {
+	path []string
+	min  *float64
+	max  *float64
+}
+
+// parseParamClamps parses a spec like "max_tokens=:4096,temperature=0:1" into clamp rules.
+func parseParamClamps(spec string) []paramClamp {
+	var clamps []paramClamp
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		field, bounds, ok := strings.Cut(entry, "=")
+		minStr, maxStr, hasRange := strings.Cut(bounds, ":")
+		if !ok || !hasRange || strings.TrimSpace(field) == "" {
+			logrus.Warnf("Invalid param clamp entry '%s', expected field=min:max", entry)
+			continue
+		}
+
+		clamp := paramClamp{path: strings.Split(strings.TrimSpace(field), ".")}
+		var err error
+		if clamp.min, err = parseClampBound(minStr); err != nil {
+			logrus.Warnf("Invalid minimum in param clamp entry '%s': %v", entry, err)
+			continue
+		}
+		if clamp.max, err = parseClampBound(maxStr); err != nil {
+			logrus.Warnf("Invalid maximum in param clamp entry '%s': %v", entry, err)
+			continue
+		}
+		clamps = append(clamps, clamp)
+	}
+	return clamps
+}
+
+// parseClampBound parses an optional bound, where an empty string means unbounded.
+func parseClampBound(value string) (*float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	bound, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &bound, nil
+}
+
+// applyParamClamps clamps client-supplied numeric parameters that fall outside the group's configured ranges.
+func (ps *ProxyServer) applyParamClamps(bodyBytes []byte, group *models.Group) ([]byte, error) {
+	clamps := parseParamClamps(group.EffectiveConfig.ParamClamps)
+	if len(clamps) == 0 || len(bodyBytes) == 0 {
+		return bodyBytes, nil
+	}
+
+	var requestData map[string]any
+	if err := json.Unmarshal(bodyBytes, &requestData); err != nil {
+		logrus.Warnf("failed to unmarshal request body for param clamp, passing through: %v", err)
+		return bodyBytes, nil
+	}
+
+	modified := false
+	for _, clamp := range clamps {
+		parent := requestData
+		for _, key := range clamp.path[:len(clamp.path)-1] {
+			next, ok := parent[key].(map[string]any)
+			if !ok {
+				parent = nil
+				break
+			}
+			parent = next
+		}
+		if parent == nil {
+			continue
+		}
+
+		field := clamp.path[len(clamp.path)-1]
+		value, ok := parent[field].(float64)
+		if !ok {
+			continue
+		}
+
+		clamped := value
+		if clamp.min != nil && clamped < *clamp.min {
+			clamped = *clamp.min
+		}
+		if clamp.max != nil && clamped > *clamp.max {
+			clamped = *clamp.max
+		}
+		if clamped != value {
+			logrus.Debugf("Clamped parameter %s from %v to %v for group %s", strings.Join(clamp.path, "."), value, clamped, group.Name)
+			parent[field] = clamped
+			modified = true
+		}
+	}
+
+	if !modified {
+		return bodyBytes, nil
+	}
+	return json.Marshal(requestData)
+}
+
+// drainAndCloseBody discards up to limit bytes of an abandoned upstream body so the
+// connection can return to the pool, then closes it. Bodies larger than the limit are
+// simply closed, which makes the transport drop the connection instead of reusing it.
+func drainAndCloseBody(body io.ReadCloser, limit int64) {
+	if limit > 0 {
+		n, err := io.CopyN(io.Discard, body, limit)
+		if err == nil {
+			logrus.Debugf("Upstream body exceeded drain limit of %d bytes, closing connection", limit)
+		} else if err != io.EOF {
+			logrus.Debugf("Failed to drain upstream body after %d bytes: %v", n, err)
+		}
+	}
+	body.Close()
+}
+
+// readErrorBody reads an upstream error body for parsing, keeping at most limit bytes when a
+// limit is set so an unbounded error response cannot exhaust memory.
+func readErrorBody(body io.Reader, limit int64) ([]byte, error) {
+	if limit > 0 {
+		body = io.LimitReader(body, limit)
+	}
+	return io.ReadAll(body)
+}
+
+// isRetryableUpstreamError applies the group's retryable_error_messages to an upstream error
+// response. A message containing one of them, ignoring case, is retried whatever its status.
+// Once the list is set, other 5xx errors are returned right away, since only the listed ones
+// are known to be transient; 4xx errors keep rotating keys as before.
+func isRetryableUpstreamError(group *models.Group, statusCode int, parsedError string) bool {
+	messages := utils.SplitAndTrim(group.EffectiveConfig.RetryableErrorMessages, ",")
+	if len(messages) == 0 {
+		return true
+	}
+
+	parsedError = strings.ToLower(parsedError)
+	for _, message := range messages {
+		if strings.Contains(parsedError, strings.ToLower(message)) {
+			return true
+		}
+	}
+	return statusCode < http.StatusInternalServerError
+}
+
 // logUpstreamError provides a centralized way to log errors from upstream interactions.
 func logUpstreamError(context string, err error) {
 	if err == nil {
@@ -61,3 +218,139 @@ func handleGzipCompression(resp *http.Response, bodyBytes []byte) []byte {
 	}
 	return bodyBytes
 }
+
+// InjectDoneHeader lets a client opt out of the done-token prompt injection for a single
+// streaming request by sending "false". It is consumed by the proxy and not sent upstream.
+const InjectDoneHeader = "X-GPT-Load-Inject-Done"
+
+// injectDoneRequested reports whether the done-token prompt should be injected for a request.
+// Injection stays on unless the header holds a false boolean value.
+func injectDoneRequested(header http.Header) bool {
+	value := strings.TrimSpace(header.Get(InjectDoneHeader))
+	if value == "" {
+		return true
+	}
+	enabled, err := strconv.ParseBool(value)
+	return err != nil || enabled
+}
+
+// TokenBudgetHeader lets a client cap the output tokens of a single intelligent stream. A
+// group's stream_token_budget can only be lowered, never raised. It is consumed by the proxy
+// and not sent upstream.
+const TokenBudgetHeader = "X-GPT-Load-Token-Budget"
+
+// requestTokenBudget returns the token budget for a stream: the client's budget when it is
+// valid and lower than the group's, otherwise the group's. 0 means unlimited.
+func requestTokenBudget(header http.Header, groupBudget int) int {
+	budget, err := strconv.Atoi(strings.TrimSpace(header.Get(TokenBudgetHeader)))
+	if err != nil || budget <= 0 || (groupBudget > 0 && budget >= groupBudget) {
+		return groupBudget
+	}
+	return budget
+}
+
+// KeyIDHeader reports the ID of the key that served a request when the group enables it,
+// so operators can tell keys apart without the secret ever leaving the proxy.
+const KeyIDHeader = "X-GPT-Load-Key-ID"
+
+// redactedURL renders an upstream URL for logging with the API key in the query and any
+// password in the user info replaced.
+func redactedURL(u *url.URL) string {
+	redacted := *u
+	q := redacted.Query()
+	if _, ok := q["key"]; ok {
+		q.Set("key", "REDACTED")
+		redacted.RawQuery = q.Encode()
+	}
+	return redacted.Redacted()
+}
+
+// isBodylessRequest reports whether a request carries its parameters in the URL
+// rather than a body, as with SSE endpoints streamed over GET.
+func isBodylessRequest(method string, bodyBytes []byte) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	}
+	return len(bytes.TrimSpace(bodyBytes)) == 0
+}
+
+// prepareGroupBody runs a client request body through a group's parameter clamps, parameter
+// overrides and upstream user tag, in that order.
+func (ps *ProxyServer) prepareGroupBody(c *gin.Context, bodyBytes []byte, group *models.Group, channelType string) ([]byte, error) {
+	clampedBodyBytes, err := ps.applyParamClamps(bodyBytes, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply parameter clamps: %w", err)
+	}
+
+	overriddenBodyBytes, err := ps.applyParamOverrides(clampedBodyBytes, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply parameter overrides: %w", err)
+	}
+
+	taggedBodyBytes, err := ps.applyUpstreamUserTag(c, overriddenBodyBytes, group, channelType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply upstream user tag: %w", err)
+	}
+	return taggedBodyBytes, nil
+}
+
+// applyUpstreamUserTag injects the group's user tag into the request body using the
+// field each channel understands, so upstream usage can be attributed per group or client.
+// A value already supplied by the client is left untouched.
+func (ps *ProxyServer) applyUpstreamUserTag(c *gin.Context, bodyBytes []byte, group *models.Group, channelType string) ([]byte, error) {
+	tag := group.EffectiveConfig.UpstreamUserTag
+	if tag == "" || isBodylessRequest(c.Request.Method, bodyBytes) {
+		return bodyBytes, nil
+	}
+
+	var requestData map[string]any
+	if err := json.Unmarshal(bodyBytes, &requestData); err != nil {
+		logrus.Warnf("failed to unmarshal request body for user tag, passing through: %v", err)
+		return bodyBytes, nil
+	}
+
+	value := resolveUserTag(c, group, tag)
+	switch {
+	case channelType == "anthropic":
+		metadata, _ := requestData["metadata"].(map[string]any)
+		if metadata == nil {
+			metadata = make(map[string]any)
+		}
+		if _, exists := metadata["user_id"]; exists {
+			return bodyBytes, nil
+		}
+		metadata["user_id"] = value
+		requestData["metadata"] = metadata
+	case channelType == "gemini" && !strings.Contains(c.Request.URL.Path, "v1beta/openai"):
+		// The native Gemini API has no per-request user field.
+		return bodyBytes, nil
+	default:
+		if _, exists := requestData["user"]; exists {
+			return bodyBytes, nil
+		}
+		requestData["user"] = value
+	}
+
+	return json.Marshal(requestData)
+}
+
+// resolveUserTag expands the variables supported in a user tag. The client token is
+// only ever exposed as a short hash so proxy keys never reach the upstream.
+func resolveUserTag(c *gin.Context, group *models.Group, tag string) string {
+	if strings.Contains(tag, "${CLIENT_TOKEN_HASH}") {
+		sum := sha256.Sum256([]byte(c.GetString("proxyKey")))
+		tag = strings.ReplaceAll(tag, "${CLIENT_TOKEN_HASH}", hex.EncodeToString(sum[:])[:12])
+	}
+	return utils.ResolveHeaderVariables(tag, utils.NewHeaderVariableContextFromGin(c, group, nil))
+}
+
+// clearWriteDeadline lifts the server's WriteTimeout for the current response. The
+// timeout counts from the end of the request headers, so it would otherwise cut off any
+// stream that runs longer; the connection's IdleTimeout still applies once it returns
+// to keep-alive.
+func clearWriteDeadline(w http.ResponseWriter) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		logrus.Debugf("Failed to clear write deadline for streaming response: %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gpt-load/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestApplyParamClamps(t *testing.T) {
+	ps := &ProxyServer{}
+	group := &models.Group{Name: "test"}
+	group.EffectiveConfig.ParamClamps = "max_tokens=:4096, temperature=0:1, generationConfig.maxOutputTokens=:8192"
+
+	tests := []struct {
+		name     string
+		body     string
+		expected map[string]float64
+	}{
+		{"within range", `{"max_tokens":1024,"temperature":0.5}`, map[string]float64{"max_tokens": 1024, "temperature": 0.5}},
+		{"above max", `{"max_tokens":100000,"temperature":1.7}`, map[string]float64{"max_tokens": 4096, "temperature": 1}},
+		{"below min", `{"temperature":-0.3}`, map[string]float64{"temperature": 0}},
+	}
+
+	for _, test := range tests {
+		out, err := ps.applyParamClamps([]byte(test.body), group)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		var data map[string]any
+		if err := json.Unmarshal(out, &data); err != nil {
+			t.Fatalf("%s: invalid JSON output: %v", test.name, err)
+		}
+		for key, want := range test.expected {
+			if got := data[key]; got != want {
+				t.Errorf("%s: expected %s=%v, got %v", test.name, key, want, got)
+			}
+		}
+	}
+
+	nested := `{"generationConfig":{"maxOutputTokens":65536}}`
+	out, _ := ps.applyParamClamps([]byte(nested), group)
+	var data map[string]any
+	json.Unmarshal(out, &data)
+	if got := data["generationConfig"].(map[string]any)["maxOutputTokens"]; got != float64(8192) {
+		t.Errorf("Expected nested maxOutputTokens to be clamped to 8192, got %v", got)
+	}
+
+	unchanged := `{"max_tokens":10}`
+	if out, _ := ps.applyParamClamps([]byte(unchanged), group); string(out) != unchanged {
+		t.Errorf("Expected in-range body to pass through byte-for-byte, got %s", out)
+	}
+}
+
+func TestUpstreamUserTagPreservedOnRetry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		channelType string
+		tagOf       func(body map[string]any) any
+	}{
+		{"openai", func(body map[string]any) any { return body["user"] }},
+		{"custom", func(body map[string]any) any { return body["user"] }},
+		{"anthropic", func(body map[string]any) any {
+			metadata, _ := body["metadata"].(map[string]any)
+			return metadata["user_id"]
+		}},
+	}
+
+	for _, test := range tests {
+		var retryBody map[string]any
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&retryBody)
+		}))
+
+		group := &models.Group{ID: 1, Name: "team-a"}
+		group.EffectiveConfig.UpstreamUserTag = "${GROUP_NAME}"
+		ps := &ProxyServer{keyProvider: newTestKeyProvider(group.ID)}
+		ch := &stubChannel{upstream: server.URL, channelType: test.channelType}
+
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+		body, err := ps.applyUpstreamUserTag(c, []byte(`{"messages":[{"role":"user","content":"hi"}]}`), group, test.channelType)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.channelType, err)
+		}
+		var initial map[string]any
+		json.Unmarshal(body, &initial)
+		if got := test.tagOf(initial); got != "team-a" {
+			t.Errorf("%s: expected initial body tag team-a, got %v", test.channelType, got)
+		}
+
+		resp, err := ps.createRetryRequest(c, ch, group, body, "partial", time.Now())
+		if err != nil {
+			t.Fatalf("%s: retry failed: %v", test.channelType, err)
+		}
+		resp.Body.Close()
+		server.Close()
+
+		if got := test.tagOf(retryBody); got != "team-a" {
+			t.Errorf("%s: expected retry body tag team-a, got %v", test.channelType, got)
+		}
+	}
+}
+
+func TestUpstreamUserTagKeepsClientValue(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	c.Set("proxyKey", "sk-proxy")
+
+	group := &models.Group{Name: "team-a"}
+	group.EffectiveConfig.UpstreamUserTag = "${CLIENT_TOKEN_HASH}"
+	ps := &ProxyServer{}
+
+	body, _ := ps.applyUpstreamUserTag(c, []byte(`{"user":"alice"}`), group, "openai")
+	if string(body) != `{"user":"alice"}` {
+		t.Errorf("Expected client-supplied user to be kept, got %s", body)
+	}
+
+	body, _ = ps.applyUpstreamUserTag(c, []byte(`{}`), group, "openai")
+	var data map[string]any
+	json.Unmarshal(body, &data)
+	if user, _ := data["user"].(string); len(user) != 12 || strings.Contains(user, "sk-proxy") {
+		t.Errorf("Expected a short token hash, got %q", user)
+	}
+}
+
+func TestStreamingOutlivesServerWriteTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	engine := gin.New()
+	engine.GET("/stream", func(c *gin.Context) {
+		if c.Query("clear") == "true" {
+			clearWriteDeadline(c.Writer)
+		}
+		c.Header("Content-Type", "text/event-stream")
+		for i := 0; i < 6; i++ {
+			fmt.Fprintf(c.Writer, "data: %d\n\n", i)
+			c.Writer.Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+		fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+	})
+
+	server := httptest.NewUnstartedServer(engine)
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	read := func(query string) string {
+		resp, err := http.Get(server.URL + "/stream?" + query)
+		if err != nil {
+			return ""
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	if body := read("clear=true"); !strings.Contains(body, "[DONE]") {
+		t.Errorf("Expected stream to outlive the server write timeout, got %q", body)
+	}
+	if body := read("clear=false"); strings.Contains(body, "[DONE]") {
+		t.Error("Expected the write timeout to cut off the stream when it is not cleared")
+	}
+}
+
+func TestRequestTokenBudgetOnlyLowersGroupBudget(t *testing.T) {
+	tests := []struct {
+		header      string
+		groupBudget int
+		expected    int
+	}{
+		{"", 0, 0},
+		{"", 500, 500},
+		{"200", 0, 200},
+		{"200", 500, 200},
+		{"800", 500, 500},
+		{"-5", 500, 500},
+		{"lots", 0, 0},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.header != "" {
+			header.Set(TokenBudgetHeader, tt.header)
+		}
+		if got := requestTokenBudget(header, tt.groupBudget); got != tt.expected {
+			t.Errorf("requestTokenBudget(%q, %d) = %d, expected %d", tt.header, tt.groupBudget, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gpt-load/internal/channel"
+	"gpt-load/internal/models"
+	"gpt-load/internal/streaming"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResolveConfigHeader asks for the configuration a request resolves to instead of proxying
+// it, in groups that enable config_debug. Elsewhere it is ignored; it is never sent upstream.
+const ResolveConfigHeader = "X-GPT-Load-Resolve-Config"
+
+// Gin context keys recording which group a routing middleware took a request away from and
+// why, so the resolved configuration can explain the routing.
+const (
+	routedFromKey = "routedFrom"
+	routedByKey   = "routedBy"
+)
+
+// Routing decisions reported by the resolved configuration.
+const (
+	routedByDefaultGroup = "default_group"
+	routedByRouteHeader  = "route_header"
+)
+
+// resolvedRouting describes how a request reached its group.
+type resolvedRouting struct {
+	RequestedGroup string   `json:"requested_group"`
+	Group          string   `json:"group"`
+	RoutedBy       string   `json:"routed_by,omitempty"`
+	FallbackGroups []string `json:"fallback_groups,omitempty"`
+}
+
+// resolvedConfig is the configuration a request resolves to once channel defaults, group
+// overrides and per-request headers are merged.
+type resolvedConfig struct {
+	Routing        resolvedRouting `json:"routing"`
+	ChannelType    string          `json:"channel_type"`
+	Model          string          `json:"model"`
+	Stream         bool            `json:"stream"`
+	BufferedStream bool            `json:"buffered_stream"`
+	// Streaming is the streaming mode the request is served with, simple or intelligent.
+	Streaming    string                  `json:"streaming,omitempty"`
+	InjectDone   bool                    `json:"inject_done,omitempty"`
+	StreamConfig *streaming.StreamConfig `json:"stream_config,omitempty"`
+}
+
+// resolveConfigRequested reports whether the client asked for the resolved configuration and
+// the group allows it.
+func resolveConfigRequested(c *gin.Context, group *models.Group) bool {
+	if group.EffectiveConfig.ConfigDebug <= 0 {
+		return false
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(c.GetHeader(ResolveConfigHeader)))
+	return err == nil && enabled
+}
+
+// respondWithResolvedConfig answers a request with the configuration it resolves to, built
+// the same way the request would be served, without contacting the upstream.
+func (ps *ProxyServer) respondWithResolvedConfig(c *gin.Context, channelHandler channel.ChannelProxy, group *models.Group, model string, isStream bool) {
+	channelType := channelHandler.GetChannelType()
+	resolved := resolvedConfig{
+		Routing: resolvedRouting{
+			RequestedGroup: group.Name,
+			Group:          group.Name,
+			FallbackGroups: parseFallbackGroups(group.EffectiveConfig.FallbackGroups),
+		},
+		ChannelType:    channelType,
+		Model:          model,
+		Stream:         isStream,
+		BufferedStream: c.GetBool(bufferedStreamKey),
+	}
+	if from := c.GetString(routedFromKey); from != "" {
+		resolved.Routing.RequestedGroup = from
+		resolved.Routing.RoutedBy = c.GetString(routedByKey)
+	}
+
+	if isStream {
+		if usesSimpleStreaming(group, channelType) {
+			resolved.Streaming = StreamingModeSimple
+		} else {
+			resolved.Streaming = StreamingModeIntelligent
+			resolved.InjectDone = injectDoneRequested(c.Request.Header)
+			processor := ps.streamProcessorFactory.CreateProcessor(channelType, group)
+			processor.SetTokenBudget(requestTokenBudget(c.Request.Header, group.EffectiveConfig.StreamTokenBudget))
+			streamConfig := processor.GetStreamConfig()
+			resolved.StreamConfig = &streamConfig
+		}
+	}
+
+	c.JSON(http.StatusOK, resolved)
+}
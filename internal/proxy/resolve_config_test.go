@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gpt-load/internal/channel"
+	"gpt-load/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResolvedConfigMergesHeaderOverGroupAndChannel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer server.Close()
+
+	gateway := &models.Group{ID: 1, Name: "gateway", ChannelType: "openai"}
+	gateway.EffectiveConfig.RouteHeaderGroups = "gemini-group"
+	geminiGroup := &models.Group{ID: 2, Name: "gemini-group", ChannelType: "gemini"}
+	geminiGroup.EffectiveConfig.ConfigDebug = 1
+	geminiGroup.EffectiveConfig.StreamRetryDelayMs = 250
+	geminiGroup.EffectiveConfig.StreamTokenBudget = 1000
+	geminiGroup.EffectiveConfig.FallbackGroups = "gateway"
+	groups := &stubGroups{
+		groups: map[string]*models.Group{"gateway": gateway, "gemini-group": geminiGroup},
+		channels: map[string]channel.ChannelProxy{
+			"gateway":      &stubChannel{upstream: server.URL, channelType: "openai"},
+			"gemini-group": &stubChannel{upstream: server.URL, channelType: "gemini"},
+		},
+	}
+	ps := &ProxyServer{groupManager: groups, channelFactory: groups, retrySlots: &retrySemaphore{}}
+
+	engine := gin.New()
+	engine.Any("/proxy/:group_name/*path", ps.RouteHeaderRouting(), ps.HandleProxy)
+	req := httptest.NewRequest(http.MethodPost, "/proxy/gateway/v1beta/models/m:streamGenerateContent", strings.NewReader(`{}`))
+	req.Header.Set(RouteHeader, "gemini-group")
+	req.Header.Set(ResolveConfigHeader, "true")
+	req.Header.Set(TokenBudgetHeader, "200")
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the resolved configuration, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if hits != 0 {
+		t.Errorf("Expected the upstream not to be contacted, got %d requests", hits)
+	}
+
+	var resolved struct {
+		Routing struct {
+			RequestedGroup string   `json:"requested_group"`
+			Group          string   `json:"group"`
+			RoutedBy       string   `json:"routed_by"`
+			FallbackGroups []string `json:"fallback_groups"`
+		} `json:"routing"`
+		Streaming    string `json:"streaming"`
+		StreamConfig struct {
+			MaxRetries  int    `json:"max_retries"`
+			RetryDelay  string `json:"retry_delay"`
+			TokenBudget int    `json:"token_budget"`
+		} `json:"stream_config"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resolved); err != nil {
+		t.Fatalf("Expected a JSON response, got %s", recorder.Body.String())
+	}
+
+	routing := resolved.Routing
+	if routing.RequestedGroup != "gateway" || routing.Group != "gemini-group" || routing.RoutedBy != routedByRouteHeader {
+		t.Errorf("Expected the route header decision to be reported, got %+v", routing)
+	}
+	if len(routing.FallbackGroups) != 1 || routing.FallbackGroups[0] != "gateway" {
+		t.Errorf("Expected the group's fallback groups, got %v", routing.FallbackGroups)
+	}
+	if resolved.Streaming != StreamingModeIntelligent {
+		t.Errorf("Expected a Gemini stream to be served intelligently, got %q", resolved.Streaming)
+	}
+
+	streamConfig := resolved.StreamConfig
+	if streamConfig.MaxRetries != 5 {
+		t.Errorf("Expected the Gemini channel default of 5 retries, got %d", streamConfig.MaxRetries)
+	}
+	if streamConfig.RetryDelay != "250ms" {
+		t.Errorf("Expected the group's retry delay to override the channel default, got %q", streamConfig.RetryDelay)
+	}
+	if streamConfig.TokenBudget != 200 {
+		t.Errorf("Expected the header to lower the group's token budget, got %d", streamConfig.TokenBudget)
+	}
+}
@@ -4,47 +4,166 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"gpt-load/internal/channel"
+	app_errors "gpt-load/internal/errors"
+	"gpt-load/internal/keypool"
 	"gpt-load/internal/models"
+	"gpt-load/internal/response"
+	"gpt-load/internal/streaming"
 	"gpt-load/internal/utils"
 
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
+	"github.com/google/uuid"
 )
 
-func (ps *ProxyServer) handleStreamingResponse(c *gin.Context, resp *http.Response, channelHandler channel.ChannelProxy, group *models.Group, bodyBytes []byte) {
-	// Check if this channel type should use simple proxy mode
+func (ps *ProxyServer) handleStreamingResponse(c *gin.Context, resp *http.Response, channelHandler channel.ChannelProxy, group *models.Group, bodyBytes []byte, startTime time.Time) {
+	log := utils.GroupLogger(group)
+
 	channelType := channelHandler.GetChannelType()
-	
-	// For OpenAI and Anthropic, use simple proxy mode (direct streaming)
-	// Only Gemini uses intelligent streaming with retry logic
-	if channelType == "openai" || channelType == "anthropic" {
-		ps.handleSimpleStreamingResponse(c, resp)
+
+	var writer http.ResponseWriter = c.Writer
+	if tee := ps.newStreamTee(c.Writer, group); tee != nil {
+		defer tee.Close()
+		writer = tee
+	}
+
+	if usesSimpleStreaming(group, channelType) {
+		ps.handleSimpleStreamingResponse(c, writer, resp, group)
 		return
 	}
-	
-	// For Gemini and other channels, use intelligent streaming with retry logic
+
+	// Use intelligent streaming with retry logic
 	processor := ps.streamProcessorFactory.CreateProcessor(channelType, group)
+	processor.SetTokenBudget(requestTokenBudget(c.Request.Header, group.EffectiveConfig.StreamTokenBudget))
+	processor.SetContext(c.Request.Context())
 
 	// Create retry function that can make new requests with accumulated context
 	retryFunc := func(accumulatedText string) (*http.Response, error) {
-		return ps.createRetryRequest(c, channelHandler, group, bodyBytes, accumulatedText)
+		return ps.createRetryRequest(c, channelHandler, group, bodyBytes, accumulatedText, startTime)
 	}
 
+	// Structured responses that fail validation are repaired with a fresh request
+	processor.SetRepairFunc(func(invalidText, problem string) (*http.Response, error) {
+		return ps.createRepairRequest(c, channelHandler, group, bodyBytes, invalidText, problem, startTime)
+	})
+
 	// Handle the streaming response with retry logic
-	err := processor.HandleStreamingResponse(resp, c.Writer, group, channelType, bodyBytes, retryFunc)
+	body := &readTrackingBody{ReadCloser: resp.Body}
+	resp.Body = body
+	err := processor.HandleStreamingResponse(resp, writer, group, channelType, bodyBytes, retryFunc)
 	if err != nil {
-		logrus.Errorf("Intelligent streaming response handling failed: %v", err)
-		// If intelligent streaming fails, try to fall back to simple streaming
-		ps.handleSimpleStreamingResponse(c, resp)
+		log.Errorf("Intelligent streaming response handling failed: %v", err)
+		ps.handleIntelligentStreamError(c, writer, resp, group, body.touched, err)
+	}
+}
+
+// Streaming modes selectable per group with the streaming_mode setting.
+const (
+	StreamingModeAuto        = "auto"
+	StreamingModeSimple      = "simple"
+	StreamingModeIntelligent = "intelligent"
+)
+
+// usesSimpleStreaming reports whether a stream is passed through directly instead of being
+// handled by the intelligent streaming path with its retry logic. In auto mode, OpenAI and
+// Anthropic streams are passed through, since they reliably signal completion, while Gemini
+// and other channels use intelligent streaming. Unknown modes behave like auto.
+func usesSimpleStreaming(group *models.Group, channelType string) bool {
+	switch group.EffectiveConfig.StreamingMode {
+	case StreamingModeSimple:
+		return true
+	case StreamingModeIntelligent:
+		return false
+	}
+	return channelType == "openai" || channelType == "anthropic"
+}
+
+// handleIntelligentStreamError reports a failed intelligent stream to the client. Falling
+// back to simple streaming is only possible while the upstream body is untouched; once it
+// has been read, replaying it would yield an empty or corrupted stream, so a clean error is
+// sent instead, as a JSON response if nothing was written yet or as an SSE error event.
+func (ps *ProxyServer) handleIntelligentStreamError(c *gin.Context, writer http.ResponseWriter, resp *http.Response, group *models.Group, bodyTouched bool, err error) {
+	log := utils.GroupLogger(group)
+
+	if errors.Is(err, streaming.ErrRetryLimitExceeded) || c.Request.Context().Err() != nil {
+		// Already reported, or nobody is left to report to
+		return
+	}
+
+	if !bodyTouched && !c.Writer.Written() {
+		log.Warn("Upstream body untouched, falling back to simple streaming")
+		ps.handleSimpleStreamingResponse(c, writer, resp, group)
+		return
+	}
+	resp.Body.Close()
+
+	message := fmt.Sprintf("Streaming interrupted: %v", err)
+	if !c.Writer.Written() {
+		c.Writer.Header().Del("Content-Type")
+		response.Error(c, app_errors.NewAPIError(app_errors.ErrBadGateway, message))
+		return
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    app_errors.ErrBadGateway.Code,
+			"message": message,
+		},
+	})
+	if _, writeErr := fmt.Fprintf(writer, "event: error\ndata: %s\n\n", payload); writeErr != nil {
+		log.Debugf("Failed to write stream error event: %v", writeErr)
+		return
+	}
+	if flusher, ok := writer.(http.Flusher); ok {
+		flusher.Flush()
 	}
 }
 
+// readTrackingBody records whether anything has tried to read the upstream body.
+type readTrackingBody struct {
+	io.ReadCloser
+	touched bool
+}
+
+func (b *readTrackingBody) Read(p []byte) (int, error) {
+	b.touched = true
+	return b.ReadCloser.Read(p)
+}
+
+// newStreamTee opens a per-request archive file in the group's tee directory and wraps
+// the writer so the forwarded stream is copied into it. It returns nil when disabled.
+func (ps *ProxyServer) newStreamTee(writer http.ResponseWriter, group *models.Group) *streaming.TeeWriter {
+	log := utils.GroupLogger(group)
+
+	dir := group.EffectiveConfig.StreamTeeDir
+	if dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Warnf("Failed to create stream tee directory %s: %v", dir, err)
+		return nil
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.sse", group.Name, time.Now().Format("20060102-150405"), uuid.NewString()[:8])
+	file, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		log.Warnf("Failed to create stream tee file: %v", err)
+		return nil
+	}
+
+	return streaming.NewTeeWriter(writer, file, 0)
+}
+
 // createRetryRequest creates a new request for retry with accumulated context
 func (ps *ProxyServer) createRetryRequest(
 	c *gin.Context,
@@ -52,24 +171,89 @@ func (ps *ProxyServer) createRetryRequest(
 	group *models.Group,
 	originalBodyBytes []byte,
 	accumulatedText string,
+	startTime time.Time,
 ) (*http.Response, error) {
-	// Parse original request body
+	remaining, budgeted := remainingBudget(group, startTime)
+	if budgeted && remaining <= 0 {
+		return nil, fmt.Errorf("retry not attempted: %w", app_errors.ErrBudgetExhausted)
+	}
+
+	// Requests without a body (e.g. SSE over GET) carry their parameters in the URL,
+	// so they are replayed as-is instead of rebuilding a body with retry context. So is
+	// a request that has not produced any text yet, as there is nothing to continue from.
+	var retryBodyBytes []byte
+	hasBody := !isBodylessRequest(c.Request.Method, originalBodyBytes)
+	if hasBody && accumulatedText == "" {
+		retryBodyBytes = originalBodyBytes
+	} else if hasBody {
+		// Parse original request body
+		var originalBody map[string]interface{}
+		if err := json.Unmarshal(originalBodyBytes, &originalBody); err != nil {
+			return nil, fmt.Errorf("failed to parse original request body: %w", err)
+		}
+
+		// Build retry request body with accumulated context
+		contextText := accumulatedText
+		if group.EffectiveConfig.NormalizeRetryContext > 0 {
+			contextText = normalizeRetryContext(contextText)
+		}
+		retryBody := ps.buildRetryRequestBody(originalBody, contextText, channelHandler.GetChannelType(), group.EffectiveConfig.ContinuationMarker)
+
+		// Marshal retry body
+		var err error
+		retryBodyBytes, err = json.Marshal(retryBody)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal retry body: %w", err)
+		}
+	}
+
+	return ps.sendRetryRequest(c, channelHandler, group, retryBodyBytes, hasBody, remaining, budgeted)
+}
+
+// createRepairRequest asks the upstream to correct a structured response that failed
+// validation. The original conversation is replayed with the invalid answer and the
+// validation problem appended, so the model returns a complete corrected document.
+func (ps *ProxyServer) createRepairRequest(
+	c *gin.Context,
+	channelHandler channel.ChannelProxy,
+	group *models.Group,
+	originalBodyBytes []byte,
+	invalidText string,
+	problem string,
+	startTime time.Time,
+) (*http.Response, error) {
+	remaining, budgeted := remainingBudget(group, startTime)
+	if budgeted && remaining <= 0 {
+		return nil, fmt.Errorf("repair not attempted: %w", app_errors.ErrBudgetExhausted)
+	}
+
 	var originalBody map[string]interface{}
 	if err := json.Unmarshal(originalBodyBytes, &originalBody); err != nil {
 		return nil, fmt.Errorf("failed to parse original request body: %w", err)
 	}
 
-	// Build retry request body with accumulated context
-	retryBody := ps.buildRetryRequestBody(originalBody, accumulatedText, channelHandler.GetChannelType())
-
-	// Marshal retry body
-	retryBodyBytes, err := json.Marshal(retryBody)
+	repairBody := ps.buildRepairRequestBody(originalBody, invalidText, problem)
+	repairBodyBytes, err := json.Marshal(repairBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal retry body: %w", err)
+		return nil, fmt.Errorf("failed to marshal repair body: %w", err)
 	}
 
+	return ps.sendRetryRequest(c, channelHandler, group, repairBodyBytes, true, remaining, budgeted)
+}
+
+// sendRetryRequest sends a follow-up streaming request with a new key, bounded by whatever
+// is left of the request budget.
+func (ps *ProxyServer) sendRetryRequest(
+	c *gin.Context,
+	channelHandler channel.ChannelProxy,
+	group *models.Group,
+	retryBodyBytes []byte,
+	hasBody bool,
+	remaining time.Duration,
+	budgeted bool,
+) (*http.Response, error) {
 	// Get API key for retry
-	apiKey, err := ps.keyProvider.SelectKey(group.ID)
+	apiKey, err := ps.keyProvider.SelectKeyWithinQuota(group.ID, keypool.KeyQuotaOf(group))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get API key for retry: %w", err)
 	}
@@ -80,51 +264,133 @@ func (ps *ProxyServer) createRetryRequest(
 		return nil, fmt.Errorf("failed to build upstream URL: %w", err)
 	}
 
-	// Create retry request
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
-	defer cancel()
+	// Create retry request. The context lives as long as the retry stream, so it is only
+	// cancelled once the response body is closed or the request could not be made.
+	timeout := attemptTimeout(0, remaining, budgeted)
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(c.Request.Context(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(c.Request.Context())
+	}
 
-	req, err := http.NewRequestWithContext(ctx, c.Request.Method, upstreamURL, bytes.NewReader(retryBodyBytes))
+	var body io.Reader
+	if hasBody {
+		body = bytes.NewReader(retryBodyBytes)
+	}
+	req, err := http.NewRequestWithContext(ctx, c.Request.Method, upstreamURL, body)
 	if err != nil {
+		cancel()
 		return nil, fmt.Errorf("failed to create retry request: %w", err)
 	}
 
 	req.ContentLength = int64(len(retryBodyBytes))
 	req.Header = c.Request.Header.Clone()
+	if !hasBody {
+		req.Header.Del("Content-Type")
+		req.Header.Del("Content-Length")
+	}
 
 	// Clean up client auth keys
 	req.Header.Del("Authorization")
 	req.Header.Del("X-Api-Key")
 	req.Header.Del("X-Goog-Api-Key")
 
+	injectDone := injectDoneRequested(req.Header)
+	req.Header.Del(InjectDoneHeader)
+	req.Header.Del(TokenBudgetHeader)
+	req.Header.Del(ResolveConfigHeader)
+	req.Header.Del(ModelHeader)
+	q := req.URL.Query()
+	q.Del("key")
+	req.URL.RawQuery = q.Encode()
+
+	if budgeted {
+		setRequestTimeoutHeader(req, timeout)
+	}
+
 	// Apply custom header rules
 	if len(group.HeaderRuleList) > 0 {
 		headerCtx := utils.NewHeaderVariableContextFromGin(c, group, apiKey)
 		utils.ApplyHeaderRules(req, group.HeaderRuleList, headerCtx)
 	}
+	applyRetryNonce(req, group)
 
 	// Apply channel-specific modifications
 	channelHandler.ModifyRequest(req, apiKey, group)
+	channel.ApplyMetadataHeaders(channelHandler, req, group)
+	utils.GroupLogger(group).Debugf("Upstream retry request: %s %s", req.Method, redactedURL(req.URL))
 
 	// Get appropriate client
 	client := channelHandler.GetStreamClient()
-	channelHandler.ReshapeStreamReqBody(req)
+	if hasBody {
+		channelHandler.ReshapeStreamReqBody(req, injectDone)
+	}
 	req.Header.Set("X-Accel-Buffering", "no")
 
+	// Hold a process-wide retry slot for as long as the retry response is open
+	releaseSlot, err := ps.retrySlots.acquire(ctx, group.EffectiveConfig.MaxConcurrentRetries, retrySlotWait)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("retry not attempted: %w", err)
+	}
+	release := func() {
+		cancel()
+		releaseSlot()
+	}
+
 	// Make the request
 	resp, err := client.Do(req)
 	if err != nil {
+		release()
 		return nil, fmt.Errorf("retry request failed: %w", err)
 	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer release()
+		defer resp.Body.Close()
+		return nil, ps.retryStatusError(resp, apiKey, group)
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+
+	// Retries are always read as text by the stream handler
+	if err := decodeUpstreamBody(resp, "", true); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
 
 	return resp, nil
 }
 
-// buildRetryRequestBody builds a retry request body with accumulated context
+// retryStatusError reports a retry the upstream answered with an error status instead of a
+// stream. As with the first request, the key's failure is recorded and the error is retried
+// with another key unless it is a 404 or the group's retryable_error_messages exclude it.
+func (ps *ProxyServer) retryStatusError(resp *http.Response, apiKey *models.APIKey, group *models.Group) error {
+	ps.keyProvider.UpdateStatus(apiKey, group, false)
+
+	errorBody, err := readErrorBody(resp.Body, int64(group.EffectiveConfig.MaxResponseBodyKB)*1024)
+	if err != nil {
+		utils.GroupLogger(group).Errorf("Failed to read retry error body: %v", err)
+		errorBody = []byte("Failed to read error body")
+	}
+	errorBody = handleGzipCompression(resp, errorBody)
+	parsedError := app_errors.ParseUpstreamError(errorBody)
+
+	return &streaming.RetryStatusError{
+		StatusCode: resp.StatusCode,
+		Message:    parsedError,
+		Retryable:  resp.StatusCode != http.StatusNotFound && isRetryableUpstreamError(group, resp.StatusCode, parsedError),
+	}
+}
+
+// buildRetryRequestBody builds a retry request body with accumulated context. A non-empty
+// marker asks OpenAI and Gemini continuations to open with it, so the stream handler can
+// strip it and tell the continuation apart from a restarted answer.
 func (ps *ProxyServer) buildRetryRequestBody(
 	originalBody map[string]interface{},
 	accumulatedText string,
 	channelType string,
+	marker string,
 ) map[string]interface{} {
 	retryBody := make(map[string]interface{})
 
@@ -136,9 +402,9 @@ func (ps *ProxyServer) buildRetryRequestBody(
 	// Add retry context based on channel type
 	switch channelType {
 	case "openai":
-		ps.addOpenAIRetryContext(retryBody, accumulatedText)
+		ps.addOpenAIRetryContext(retryBody, accumulatedText, marker)
 	case "gemini":
-		ps.addGeminiRetryContext(retryBody, accumulatedText)
+		ps.addGeminiRetryContext(retryBody, accumulatedText, marker)
 	case "anthropic":
 		ps.addAnthropicRetryContext(retryBody, accumulatedText)
 	default:
@@ -148,8 +414,38 @@ func (ps *ProxyServer) buildRetryRequestBody(
 	return retryBody
 }
 
+// buildRepairRequestBody builds a request asking the model to correct an invalid
+// structured response, appending the invalid answer and the validation problem to the
+// Gemini contents or chat messages of the original request.
+func (ps *ProxyServer) buildRepairRequestBody(
+	originalBody map[string]interface{},
+	invalidText string,
+	problem string,
+) map[string]interface{} {
+	repairBody := make(map[string]interface{}, len(originalBody))
+	for k, v := range originalBody {
+		repairBody[k] = v
+	}
+
+	instruction := fmt.Sprintf("Your previous response is not valid for the requested JSON output: %s. Reply with only the complete corrected JSON document.", problem)
+	if contents, ok := repairBody["contents"].([]interface{}); ok {
+		repairBody["contents"] = append(append([]interface{}{}, contents...),
+			map[string]interface{}{"role": "model", "parts": []interface{}{map[string]interface{}{"text": invalidText}}},
+			map[string]interface{}{"role": "user", "parts": []interface{}{map[string]interface{}{"text": instruction}}},
+		)
+		return repairBody
+	}
+	if messages, ok := repairBody["messages"].([]interface{}); ok {
+		repairBody["messages"] = append(append([]interface{}{}, messages...),
+			map[string]interface{}{"role": "assistant", "content": invalidText},
+			map[string]interface{}{"role": "user", "content": instruction},
+		)
+	}
+	return repairBody
+}
+
 // addOpenAIRetryContext adds retry context for OpenAI requests
-func (ps *ProxyServer) addOpenAIRetryContext(body map[string]interface{}, accumulatedText string) {
+func (ps *ProxyServer) addOpenAIRetryContext(body map[string]interface{}, accumulatedText string, marker string) {
 	messages, ok := body["messages"].([]interface{})
 	if !ok {
 		return
@@ -157,8 +453,8 @@ func (ps *ProxyServer) addOpenAIRetryContext(body map[string]interface{}, accumu
 
 	// Add a system message with context
 	systemMessage := map[string]interface{}{
-		"role": "system",
-		"content": fmt.Sprintf("Continue from where you left off. Previous response: %s\n\nContinue generating the response without repetition.", accumulatedText),
+		"role":    "system",
+		"content": fmt.Sprintf("Continue from where you left off. Previous response: %s\n\nContinue generating the response without repetition.", accumulatedText) + continuationMarkerInstruction(marker),
 	}
 
 	// Insert at the beginning
@@ -166,8 +462,17 @@ func (ps *ProxyServer) addOpenAIRetryContext(body map[string]interface{}, accumu
 	body["messages"] = newMessages
 }
 
+// continuationMarkerInstruction asks the model to open its continuation with the marker,
+// or returns nothing when no marker is configured.
+func continuationMarkerInstruction(marker string) string {
+	if marker == "" {
+		return ""
+	}
+	return fmt.Sprintf(" Start your reply with the marker %s immediately followed by the continuation.", marker)
+}
+
 // addGeminiRetryContext adds retry context for Gemini requests
-func (ps *ProxyServer) addGeminiRetryContext(body map[string]interface{}, accumulatedText string) {
+func (ps *ProxyServer) addGeminiRetryContext(body map[string]interface{}, accumulatedText string, marker string) {
 	contents, ok := body["contents"].([]interface{})
 	if !ok {
 		return
@@ -195,7 +500,7 @@ func (ps *ProxyServer) addGeminiRetryContext(body map[string]interface{}, accumu
 		map[string]interface{}{
 			"role": "user",
 			"parts": []interface{}{
-				map[string]interface{}{"text": "Continue exactly where you left off without any preamble or repetition. Remember to include [done] at the end."},
+				map[string]interface{}{"text": "Continue exactly where you left off without any preamble or repetition. Remember to include [done] at the end." + continuationMarkerInstruction(marker)},
 			},
 		},
 	}
@@ -212,21 +517,60 @@ func (ps *ProxyServer) addGeminiRetryContext(body map[string]interface{}, accumu
 	}
 }
 
-// addAnthropicRetryContext adds retry context for Anthropic requests
+// addAnthropicRetryContext adds retry context for Anthropic requests.
+// The top-level system prompt is left untouched; the continuation instruction re-states
+// that it still applies, and the partial answer is replayed as an assistant prefill so
+// the model resumes its own turn instead of answering a new one.
 func (ps *ProxyServer) addAnthropicRetryContext(body map[string]interface{}, accumulatedText string) {
-	// For Anthropic, we typically need to modify the messages array
 	messages, ok := body["messages"].([]interface{})
 	if !ok {
 		return
 	}
 
-	// Add context as a user message
-	contextMessage := map[string]interface{}{
-		"role": "user",
-		"content": fmt.Sprintf("Continue from where you left off. Previous response: %s\n\nContinue without repetition.", accumulatedText),
+	instruction := "Continue exactly where you left off without any preamble or repetition."
+	if _, hasSystem := body["system"]; hasSystem {
+		instruction += " Keep following every format and constraint from the system prompt."
+	}
+
+	newMessages := make([]interface{}, len(messages), len(messages)+2)
+	copy(newMessages, messages)
+
+	// Roles must alternate, so the instruction joins a trailing user turn when there is one
+	var lastMessage map[string]interface{}
+	if len(newMessages) > 0 {
+		lastMessage, _ = newMessages[len(newMessages)-1].(map[string]interface{})
+	}
+	if lastMessage != nil && lastMessage["role"] == "user" {
+		newMessages[len(newMessages)-1] = appendAnthropicText(lastMessage, instruction)
+	} else {
+		newMessages = append(newMessages, map[string]interface{}{"role": "user", "content": instruction})
+	}
+
+	// Anthropic rejects a final assistant turn that ends with whitespace
+	if prefill := strings.TrimRight(accumulatedText, " \t\r\n"); prefill != "" {
+		newMessages = append(newMessages, map[string]interface{}{"role": "assistant", "content": prefill})
 	}
 
-	body["messages"] = append(messages, contextMessage)
+	body["messages"] = newMessages
+}
+
+// appendAnthropicText returns a copy of message with text added as an extra content block.
+func appendAnthropicText(message map[string]interface{}, text string) map[string]interface{} {
+	var blocks []interface{}
+	switch content := message["content"].(type) {
+	case string:
+		blocks = []interface{}{map[string]interface{}{"type": "text", "text": content}}
+	case []interface{}:
+		blocks = append(blocks, content...)
+	}
+	blocks = append(blocks, map[string]interface{}{"type": "text", "text": text})
+
+	updated := make(map[string]interface{}, len(message))
+	for k, v := range message {
+		updated[k] = v
+	}
+	updated["content"] = blocks
+	return updated
 }
 
 // addGenericRetryContext adds retry context for generic requests
@@ -234,7 +578,7 @@ func (ps *ProxyServer) addGenericRetryContext(body map[string]interface{}, accum
 	// Generic fallback - try to add context to messages if available
 	if messages, ok := body["messages"].([]interface{}); ok {
 		contextMessage := map[string]interface{}{
-			"role": "user",
+			"role":    "user",
 			"content": fmt.Sprintf("Continue from where you left off. Previous response: %s\n\nContinue without repetition.", accumulatedText),
 		}
 		body["messages"] = append(messages, contextMessage)
@@ -242,25 +586,37 @@ func (ps *ProxyServer) addGenericRetryContext(body map[string]interface{}, accum
 }
 
 // handleSimpleStreamingResponse handles streaming response with simple proxy mode (direct streaming)
-func (ps *ProxyServer) handleSimpleStreamingResponse(c *gin.Context, resp *http.Response) {
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
-	c.Header("X-Accel-Buffering", "no")
+func (ps *ProxyServer) handleSimpleStreamingResponse(c *gin.Context, writer http.ResponseWriter, resp *http.Response, group *models.Group) {
+	log := utils.GroupLogger(group)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	writer.Header().Set("X-Accel-Buffering", "no")
+	if group.EffectiveConfig.ForwardUpstreamTrailers > 0 {
+		declareTrailers(writer, resp)
+	}
 
-	flusher, ok := c.Writer.(http.Flusher)
+	flusher, ok := writer.(http.Flusher)
 	if !ok {
-		logrus.Error("Streaming unsupported by the writer, falling back to normal response")
-		ps.handleNormalResponse(c, resp)
+		log.Error("Streaming unsupported by the writer, falling back to normal response")
+		ps.handleNormalResponse(c, resp, group)
 		return
 	}
 
 	buf := make([]byte, 4*1024)
 	for {
+		if c.Request.Context().Err() != nil {
+			log.Debug("Client disconnected, closing the upstream stream")
+			resp.Body.Close()
+			return
+		}
 		n, err := resp.Body.Read(buf)
 		if n > 0 {
-			if _, writeErr := c.Writer.Write(buf[:n]); writeErr != nil {
+			if _, writeErr := writer.Write(buf[:n]); writeErr != nil {
 				logUpstreamError("writing stream to client", writeErr)
+				// A live stream may never end, so close it instead of draining.
+				resp.Body.Close()
 				return
 			}
 			flusher.Flush()
@@ -273,10 +629,77 @@ func (ps *ProxyServer) handleSimpleStreamingResponse(c *gin.Context, resp *http.
 			return
 		}
 	}
+
+	if group.EffectiveConfig.ForwardUpstreamTrailers > 0 {
+		forwardTrailers(writer, resp, group)
+	}
 }
 
-func (ps *ProxyServer) handleNormalResponse(c *gin.Context, resp *http.Response) {
-	if _, err := io.Copy(c.Writer, resp.Body); err != nil {
+func (ps *ProxyServer) handleNormalResponse(c *gin.Context, resp *http.Response, group *models.Group) {
+	if group.EffectiveConfig.ForwardUpstreamTrailers > 0 {
+		declareTrailers(c.Writer, resp)
+	}
+
+	if limit := int64(group.EffectiveConfig.MaxResponseBodyKB) * 1024; limit > 0 {
+		if !ps.copyBoundedBody(c, resp, group, limit) {
+			return
+		}
+	} else if _, err := io.Copy(c.Writer, resp.Body); err != nil {
 		logUpstreamError("copying response body", err)
+		drainAndCloseBody(resp.Body, int64(group.EffectiveConfig.UpstreamDrainLimitKB)*1024)
+		return
 	}
+
+	if group.EffectiveConfig.ForwardUpstreamTrailers > 0 {
+		forwardTrailers(c.Writer, resp, group)
+	}
+}
+
+// copyBoundedBody forwards a non-streamed upstream body of at most limit bytes. The body is
+// buffered up to the limit so that an oversized response can still be rejected with a clean
+// 502 instead of a truncated body; it returns false if the body was not forwarded.
+func (ps *ProxyServer) copyBoundedBody(c *gin.Context, resp *http.Response, group *models.Group, limit int64) bool {
+	log := utils.GroupLogger(group)
+	drainLimit := int64(group.EffectiveConfig.UpstreamDrainLimitKB) * 1024
+
+	var body []byte
+	var err error
+	if resp.ContentLength <= limit {
+		body, err = io.ReadAll(io.LimitReader(resp.Body, limit+1))
+		if err != nil {
+			logUpstreamError("reading response body", err)
+			drainAndCloseBody(resp.Body, drainLimit)
+			return false
+		}
+	}
+	if resp.ContentLength > limit || int64(len(body)) > limit {
+		log.Warnf("Upstream response body exceeds the limit of %d bytes, rejecting it", limit)
+		resp.Body.Close()
+		for _, key := range []string{"Content-Length", "Content-Encoding", "Content-Type", "Trailer"} {
+			c.Writer.Header().Del(key)
+		}
+		response.Error(c, app_errors.NewAPIError(app_errors.ErrBadGateway, fmt.Sprintf("Upstream response body exceeds the limit of %d bytes", limit)))
+		return false
+	}
+
+	if _, err := c.Writer.Write(body); err != nil {
+		logUpstreamError("copying response body", err)
+		return false
+	}
+	return true
+}
+
+// declareTrailers announces the upstream trailer keys before the body is written. Declared
+// trailers make net/http use chunked encoding even for short bodies, which could otherwise be
+// sent with a fixed Content-Length that cannot carry trailers.
+func declareTrailers(w http.ResponseWriter, resp *http.Response) {
+	for key := range resp.Trailer {
+		w.Header().Add("Trailer", key)
+	}
+}
+
+// forwardTrailers copies the upstream trailer values, which are only known once the body has
+// been read to EOF, onto the client response.
+func forwardTrailers(w http.ResponseWriter, resp *http.Response, group *models.Group) {
+	copyBoundedHeaders(w.Header(), resp.Trailer, group)
 }
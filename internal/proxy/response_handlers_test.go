@@ -0,0 +1,649 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gpt-load/internal/keypool"
+	"gpt-load/internal/models"
+	"gpt-load/internal/store"
+	"gpt-load/internal/streaming"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// trackingBody records how much of an upstream body was consumed and whether it was closed.
+type trackingBody struct {
+	reader *strings.Reader
+	read   int
+	closed bool
+}
+
+func (b *trackingBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	b.read += n
+	return n, err
+}
+
+func (b *trackingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+// failingWriter simulates a client that disconnected mid-response.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write: broken pipe")
+}
+
+func TestHandleNormalResponseDrainsOnClientAbort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name      string
+		bodySize  int
+		limitKB   int
+		wantDrain bool
+	}{
+		{"remainder within limit is drained", 48 * 1024, 64, true},
+		{"oversized body is only closed", 256 * 1024, 64, false},
+		{"drain disabled", 48 * 1024, 0, false},
+	}
+
+	for _, test := range tests {
+		c, _ := gin.CreateTestContext(&failingWriter{httptest.NewRecorder()})
+		body := &trackingBody{reader: strings.NewReader(strings.Repeat("x", test.bodySize))}
+		resp := &http.Response{StatusCode: http.StatusOK, Body: body}
+		group := &models.Group{}
+		group.EffectiveConfig.UpstreamDrainLimitKB = test.limitKB
+
+		(&ProxyServer{}).handleNormalResponse(c, resp, group)
+
+		if !body.closed {
+			t.Errorf("%s: expected upstream body to be closed", test.name)
+		}
+		if drained := body.read == test.bodySize; drained != test.wantDrain {
+			t.Errorf("%s: expected drained=%v, read %d of %d bytes", test.name, test.wantDrain, body.read, test.bodySize)
+		}
+	}
+}
+
+// stubChannel is a minimal ChannelProxy that forwards to a fixed upstream.
+type stubChannel struct {
+	upstream    string
+	channelType string
+	reshaped    bool
+	injectDone  bool
+	client      *http.Client
+}
+
+func (s *stubChannel) BuildUpstreamURL(originalURL *url.URL, group *models.Group) (string, error) {
+	u, _ := url.Parse(s.upstream)
+	u.Path = originalURL.Path
+	u.RawQuery = originalURL.RawQuery
+	return u.String(), nil
+}
+func (s *stubChannel) IsConfigStale(group *models.Group) bool { return false }
+func (s *stubChannel) GetHTTPClient() *http.Client {
+	if s.client != nil {
+		return s.client
+	}
+	return http.DefaultClient
+}
+func (s *stubChannel) GetStreamClient() *http.Client { return http.DefaultClient }
+func (s *stubChannel) ModifyRequest(req *http.Request, apiKey *models.APIKey, group *models.Group) {
+	req.Header.Set("Authorization", "Bearer "+apiKey.KeyValue)
+}
+func (s *stubChannel) IsStreamRequest(c *gin.Context, bodyBytes []byte) bool { return true }
+func (s *stubChannel) ExtractModel(c *gin.Context, bodyBytes []byte) string  { return "" }
+func (s *stubChannel) ValidateKey(ctx context.Context, apiKey *models.APIKey, group *models.Group) (bool, error) {
+	return true, nil
+}
+func (s *stubChannel) ReshapeStreamReqBody(req *http.Request, injectDone bool) {
+	s.reshaped, s.injectDone = true, injectDone
+}
+func (s *stubChannel) GetChannelType() string { return s.channelType }
+
+// newTestKeyProvider returns a key provider backed by an in-memory store holding one active key.
+func newTestKeyProvider(groupID uint) *keypool.KeyProvider {
+	memStore := store.NewMemoryStore()
+	memStore.HSet("key:1", map[string]any{"key_string": "sk-test", "status": models.KeyStatusActive})
+	memStore.LPush(fmt.Sprintf("group:%d:active_keys", groupID), "1")
+	return keypool.NewProvider(nil, memStore, nil)
+}
+
+func TestCreateRetryRequestForGetStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var gotMethod, gotQuery, gotBody, gotContentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotMethod, gotQuery, gotBody = r.Method, r.URL.RawQuery, string(body)
+		gotContentType = r.Header.Get("Content-Type")
+		w.Header().Set("Content-Type", "text/event-stream")
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "test"}
+	ps := &ProxyServer{keyProvider: newTestKeyProvider(group.ID)}
+	ch := &stubChannel{upstream: server.URL, channelType: "openai"}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/v1/events?model=m1&stream=true&key=client", nil)
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	resp, err := ps.createRetryRequest(c, ch, group, nil, "partial text", time.Now())
+	if err != nil {
+		t.Fatalf("Expected GET retry to succeed, got %v", err)
+	}
+	resp.Body.Close()
+
+	if gotMethod != http.MethodGet {
+		t.Errorf("Expected retry to use GET, got %s", gotMethod)
+	}
+	if gotBody != "" {
+		t.Errorf("Expected GET retry to carry no body, got %q", gotBody)
+	}
+	if gotContentType != "" {
+		t.Errorf("Expected no Content-Type on bodyless retry, got %q", gotContentType)
+	}
+	if gotQuery != "model=m1&stream=true" {
+		t.Errorf("Expected query params to be preserved without client key, got %q", gotQuery)
+	}
+	if ch.reshaped {
+		t.Error("Expected bodyless retry to skip body reshaping")
+	}
+}
+
+func TestInjectDoneHeaderDisablesPromptInjection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var forwarded string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(InjectDoneHeader)
+		w.Header().Set("Content-Type", "text/event-stream")
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "test"}
+	ps := &ProxyServer{keyProvider: newTestKeyProvider(group.ID)}
+	ch := &stubChannel{upstream: server.URL, channelType: "gemini"}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/m:streamGenerateContent", strings.NewReader("{}"))
+	c.Request.Header.Set(InjectDoneHeader, "false")
+
+	resp, err := ps.createRetryRequest(c, ch, group, []byte(`{"contents":[]}`), "partial text", time.Now())
+	if err != nil {
+		t.Fatalf("Expected retry to succeed, got %v", err)
+	}
+	resp.Body.Close()
+
+	if !ch.reshaped || ch.injectDone {
+		t.Errorf("Expected reshape to run with injection disabled, got reshaped=%v injectDone=%v", ch.reshaped, ch.injectDone)
+	}
+	if forwarded != "" {
+		t.Errorf("Expected %s not to be forwarded upstream, got %q", InjectDoneHeader, forwarded)
+	}
+}
+
+func TestRetryWithoutTextReplaysOriginalBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		w.Header().Set("Content-Type", "text/event-stream")
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "test"}
+	ps := &ProxyServer{keyProvider: newTestKeyProvider(group.ID)}
+	ch := &stubChannel{upstream: server.URL, channelType: "gemini"}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/m:streamGenerateContent", nil)
+
+	original := `{"contents":[{"role":"user","parts":[{"text":"hi"}]}]}`
+	resp, err := ps.createRetryRequest(c, ch, group, []byte(original), "", time.Now())
+	if err != nil {
+		t.Fatalf("Expected retry to succeed, got %v", err)
+	}
+	resp.Body.Close()
+
+	if gotBody != original {
+		t.Errorf("Expected the original body to be replayed unchanged, got %s", gotBody)
+	}
+}
+
+func TestStreamingModeSelectsPath(t *testing.T) {
+	tests := []struct {
+		mode        string
+		channelType string
+		simple      bool
+	}{
+		{StreamingModeAuto, "openai", true},
+		{StreamingModeAuto, "anthropic", true},
+		{StreamingModeAuto, "gemini", false},
+		{"", "gemini", false},
+		{StreamingModeSimple, "gemini", true},
+		{StreamingModeSimple, "custom", true},
+		{StreamingModeIntelligent, "openai", false},
+		{StreamingModeIntelligent, "anthropic", false},
+	}
+
+	for _, tt := range tests {
+		group := &models.Group{}
+		group.EffectiveConfig.StreamingMode = tt.mode
+		if got := usesSimpleStreaming(group, tt.channelType); got != tt.simple {
+			t.Errorf("usesSimpleStreaming(%q, %q) = %v, expected %v", tt.mode, tt.channelType, got, tt.simple)
+		}
+	}
+}
+
+// keyQueryChannel passes the API key in the query string, like the Gemini channel.
+type keyQueryChannel struct {
+	*stubChannel
+}
+
+func (k keyQueryChannel) ModifyRequest(req *http.Request, apiKey *models.APIKey, group *models.Group) {
+	q := req.URL.Query()
+	q.Set("key", apiKey.KeyValue)
+	req.URL.RawQuery = q.Encode()
+}
+
+func TestRetryUpstreamURLIsLoggedWithKeyRedacted(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var output strings.Builder
+	std := logrus.StandardLogger()
+	originalOut := std.Out
+	std.SetOutput(&output)
+	defer std.SetOutput(originalOut)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "test"}
+	group.EffectiveConfig.LogLevel = "debug"
+	ps := &ProxyServer{keyProvider: newTestKeyProvider(group.ID)}
+	ch := keyQueryChannel{&stubChannel{upstream: server.URL, channelType: "gemini"}}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/m:streamGenerateContent?alt=sse", nil)
+
+	resp, err := ps.createRetryRequest(c, ch, group, []byte(`{"contents":[]}`), "partial", time.Now())
+	if err != nil {
+		t.Fatalf("Expected retry to succeed, got %v", err)
+	}
+	resp.Body.Close()
+
+	logs := output.String()
+	if !strings.Contains(logs, "key=REDACTED") || !strings.Contains(logs, ":streamGenerateContent?alt=sse") {
+		t.Errorf("Expected the upstream URL to be logged with the key redacted, got %q", logs)
+	}
+	if strings.Contains(logs, "sk-test") {
+		t.Errorf("Expected the API key not to be logged, got %q", logs)
+	}
+}
+
+func TestAnthropicRetryContextKeepsSystemAndPrefills(t *testing.T) {
+	ps := &ProxyServer{}
+	original := map[string]interface{}{
+		"system": "Answer only in JSON.",
+		"messages": []interface{}{
+			map[string]interface{}{"role": "user", "content": "List three colors."},
+		},
+	}
+
+	body := ps.buildRetryRequestBody(original, `{"colors": ["red", `, "anthropic", "")
+
+	if body["system"] != "Answer only in JSON." {
+		t.Errorf("Expected top-level system to be preserved, got %v", body["system"])
+	}
+
+	messages := body["messages"].([]interface{})
+	if len(messages) != 2 {
+		t.Fatalf("Expected user turn followed by assistant prefill, got %d messages", len(messages))
+	}
+
+	user := messages[0].(map[string]interface{})
+	blocks, ok := user["content"].([]interface{})
+	if user["role"] != "user" || !ok || len(blocks) != 2 {
+		t.Fatalf("Expected continuation instruction to join the user turn, got %v", user)
+	}
+	instruction := blocks[1].(map[string]interface{})["text"].(string)
+	if !strings.Contains(instruction, "system prompt") {
+		t.Errorf("Expected instruction to re-state the system prompt constraints, got %q", instruction)
+	}
+
+	prefill := messages[1].(map[string]interface{})
+	if prefill["role"] != "assistant" || prefill["content"] != `{"colors": ["red",` {
+		t.Errorf("Expected trimmed assistant prefill, got %v", prefill)
+	}
+
+	if original["messages"].([]interface{})[0].(map[string]interface{})["content"] != "List three colors." {
+		t.Error("Expected original request messages to be left unmodified")
+	}
+}
+
+func TestRetryContextRequestsContinuationMarker(t *testing.T) {
+	ps := &ProxyServer{}
+
+	gemini := map[string]interface{}{
+		"contents": []interface{}{
+			map[string]interface{}{"role": "user", "parts": []interface{}{map[string]interface{}{"text": "Tell a story"}}},
+		},
+	}
+	body := ps.buildRetryRequestBody(gemini, "Once upon", "gemini", "@@CONTINUE@@")
+	contents := body["contents"].([]interface{})
+	last := contents[len(contents)-1].(map[string]interface{})
+	instruction := last["parts"].([]interface{})[0].(map[string]interface{})["text"].(string)
+	if !strings.Contains(instruction, "@@CONTINUE@@") {
+		t.Errorf("Expected Gemini continuation to request the marker, got %q", instruction)
+	}
+
+	openai := map[string]interface{}{
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": "Tell a story"}},
+	}
+	body = ps.buildRetryRequestBody(openai, "Once upon", "openai", "@@CONTINUE@@")
+	system := body["messages"].([]interface{})[0].(map[string]interface{})["content"].(string)
+	if !strings.Contains(system, "@@CONTINUE@@") {
+		t.Errorf("Expected OpenAI continuation to request the marker, got %q", system)
+	}
+
+	body = ps.buildRetryRequestBody(openai, "Once upon", "openai", "")
+	system = body["messages"].([]interface{})[0].(map[string]interface{})["content"].(string)
+	if strings.Contains(system, "marker") {
+		t.Errorf("Expected no marker instruction without a configured marker, got %q", system)
+	}
+}
+
+func TestIntelligentStreamFailureReturnsCleanError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Retries go to an upstream that is no longer reachable.
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	tests := []struct {
+		name       string
+		upstream   string
+		wantStatus int
+		wantBody   string
+	}{
+		{"error after partial stream", `data: {"candidates":[{"content":{"parts":[{"text":"partial"}]}}]}` + "\n\n", http.StatusOK, "event: error"},
+		{"error before any output", "", http.StatusBadGateway, `"code":"BAD_GATEWAY"`},
+	}
+
+	for _, test := range tests {
+		group := &models.Group{ID: 1, Name: "test"}
+		ps := &ProxyServer{keyProvider: newTestKeyProvider(group.ID), streamProcessorFactory: streaming.NewStreamProcessorFactory()}
+		ch := &stubChannel{upstream: dead.URL, channelType: "gemini"}
+
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/m:streamGenerateContent", nil)
+		resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(test.upstream))}
+
+		ps.handleStreamingResponse(c, resp, ch, group, []byte(`{"contents":[]}`), time.Now())
+
+		if recorder.Code != test.wantStatus {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.wantStatus, recorder.Code)
+		}
+		if !strings.Contains(recorder.Body.String(), test.wantBody) {
+			t.Errorf("%s: expected body to contain %q, got %q", test.name, test.wantBody, recorder.Body.String())
+		}
+	}
+}
+
+func TestUpstreamTrailersForwarded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte(`{"ok":true}`))
+		w.(http.Flusher).Flush()
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	defer upstream.Close()
+
+	for _, enabled := range []int{1, 0} {
+		group := &models.Group{}
+		group.EffectiveConfig.ForwardUpstreamTrailers = enabled
+		ps := &ProxyServer{}
+
+		engine := gin.New()
+		engine.GET("/", func(c *gin.Context) {
+			resp, err := http.Get(upstream.URL)
+			if err != nil {
+				t.Fatalf("Upstream request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			c.Status(resp.StatusCode)
+			ps.handleNormalResponse(c, resp, group)
+		})
+		proxy := httptest.NewServer(engine)
+
+		resp, err := http.Get(proxy.URL)
+		if err != nil {
+			t.Fatalf("Proxy request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		proxy.Close()
+
+		if string(body) != `{"ok":true}` {
+			t.Errorf("Expected body to be forwarded, got %q", body)
+		}
+		got := resp.Trailer.Get("Grpc-Status")
+		if enabled == 1 && got != "0" {
+			t.Errorf("Expected Grpc-Status trailer to reach the client, got %q", got)
+		}
+		if enabled == 0 && got != "" {
+			t.Errorf("Expected no trailer when forwarding is disabled, got %q", got)
+		}
+	}
+}
+
+func TestOversizedNonStreamResponseIsRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	payload := `{"text":"` + strings.Repeat("a", 64*1024) + `"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("chunked") != "" {
+			// Without a Content-Length the size is only known while reading
+			w.Write([]byte(payload[:1024]))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(payload[1024:]))
+			return
+		}
+		w.Write([]byte(payload))
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "test"}
+	group.EffectiveConfig.MaxResponseBodyKB = 16
+	ps := &ProxyServer{keyProvider: newTestKeyProvider(group.ID)}
+	ch := &stubChannel{upstream: server.URL, channelType: "openai"}
+
+	for _, target := range []string{"/v1/chat/completions", "/v1/chat/completions?chunked=1"} {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{}`))
+		ps.executeRequestWithRetry(c, ch, group, []byte(`{}`), false, time.Now(), 0, nil)
+
+		if recorder.Code != http.StatusBadGateway {
+			t.Errorf("%s: expected status 502 for an oversized body, got %d", target, recorder.Code)
+		}
+		if recorder.Body.Len() >= 16*1024 || strings.Contains(recorder.Body.String(), "aaaa") {
+			t.Errorf("%s: expected the oversized body not to be forwarded, got %d bytes", target, recorder.Body.Len())
+		}
+	}
+
+	group.EffectiveConfig.MaxResponseBodyKB = 128
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+	ps.executeRequestWithRetry(c, ch, group, []byte(`{}`), false, time.Now(), 0, nil)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != payload {
+		t.Errorf("Expected a body within the limit to be forwarded unchanged, got status %d and %d bytes", recorder.Code, recorder.Body.Len())
+	}
+}
+
+// metadataChannel is a stub channel declaring a metadata header computed from the request path.
+type metadataChannel struct {
+	*stubChannel
+}
+
+func (m *metadataChannel) MetadataHeaders(req *http.Request, group *models.Group) http.Header {
+	return http.Header{"X-Goog-Request-Params": {"model=" + url.QueryEscape(strings.TrimPrefix(req.URL.Path, "/"))}}
+}
+
+func TestMetadataHeadersAreSetOnRetries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var gotParams string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotParams = r.Header.Get("X-Goog-Request-Params")
+		w.Header().Set("Content-Type", "text/event-stream")
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "test"}
+	ps := &ProxyServer{keyProvider: newTestKeyProvider(group.ID)}
+	ch := &metadataChannel{&stubChannel{upstream: server.URL, channelType: "gemini"}}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/models/gemini-pro:streamGenerateContent", strings.NewReader(`{}`))
+	resp, err := ps.createRetryRequest(c, ch, group, []byte(`{"contents":[]}`), "partial", time.Now())
+	if err != nil {
+		t.Fatalf("Expected retry to succeed, got %v", err)
+	}
+	resp.Body.Close()
+
+	if gotParams != "model=models%2Fgemini-pro%3AstreamGenerateContent" {
+		t.Errorf("Expected the retry to carry the channel's metadata header, got %q", gotParams)
+	}
+}
+
+func TestKeyIDHeaderCarriesIDNotSecret(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "test"}
+	ps := &ProxyServer{keyProvider: newTestKeyProvider(group.ID)}
+	ch := &stubChannel{upstream: server.URL, channelType: "openai"}
+
+	run := func() http.Header {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+		ps.executeRequestWithRetry(c, ch, group, []byte(`{}`), false, time.Now(), 0, nil)
+		return recorder.Header()
+	}
+
+	if got := run().Get(KeyIDHeader); got != "" {
+		t.Errorf("Expected no key ID header unless enabled, got %q", got)
+	}
+
+	group.EffectiveConfig.KeyIDHeader = 1
+	header := run()
+	if got := header.Get(KeyIDHeader); got != "1" {
+		t.Errorf("Expected the key ID in the header, got %q", got)
+	}
+	for name, values := range header {
+		for _, value := range values {
+			if strings.Contains(value, "sk-test") {
+				t.Errorf("Expected the key value never to be exposed, found it in %s", name)
+			}
+		}
+	}
+}
+
+func TestRetryErrorStatusIsReportedInsteadOfStreamed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	statusCode, message := http.StatusTooManyRequests, "Rate limit reached"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		fmt.Fprintf(w, `{"error":{"message":%q}}`, message)
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "test"}
+	group.EffectiveConfig.RetryableErrorMessages = "overloaded"
+	// The key is already marked invalid, so its failure doesn't touch the database
+	memStore := store.NewMemoryStore()
+	memStore.HSet("key:1", map[string]any{"key_string": "sk-test", "status": models.KeyStatusInvalid})
+	memStore.LPush("group:1:active_keys", "1")
+	ps := &ProxyServer{keyProvider: keypool.NewProvider(nil, memStore, nil)}
+	ch := &stubChannel{upstream: server.URL, channelType: "openai"}
+
+	retry := func() *streaming.RetryStatusError {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"messages":[]}`))
+		resp, err := ps.createRetryRequest(c, ch, group, []byte(`{"messages":[]}`), "partial", time.Now())
+		if resp != nil {
+			resp.Body.Close()
+			t.Fatal("Expected an error status not to be returned as a stream")
+		}
+		var statusErr *streaming.RetryStatusError
+		if !errors.As(err, &statusErr) {
+			t.Fatalf("Expected a retry status error, got %v", err)
+		}
+		return statusErr
+	}
+
+	if statusErr := retry(); !statusErr.Retryable || statusErr.StatusCode != http.StatusTooManyRequests || statusErr.Message != message {
+		t.Errorf("Expected a retryable 429 with the upstream message, got %+v", statusErr)
+	}
+
+	statusCode, message = http.StatusInternalServerError, "Something else broke"
+	if statusErr := retry(); statusErr.Retryable {
+		t.Errorf("Expected an unlisted 5xx error not to be retryable, got %+v", statusErr)
+	}
+}
+
+func TestSimpleStreamingStopsWhenClientDisconnects(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil).WithContext(ctx)
+
+	body := &trackingBody{reader: strings.NewReader("data: {\"choices\":[]}\n\n")}
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: body}
+
+	(&ProxyServer{}).handleSimpleStreamingResponse(c, c.Writer, resp, &models.Group{})
+
+	if !body.closed {
+		t.Error("Expected the upstream body to be closed once the client is gone")
+	}
+	if body.read != 0 || recorder.Body.Len() != 0 {
+		t.Errorf("Expected nothing to be forwarded to a disconnected client, read %d bytes and wrote %q", body.read, recorder.Body.String())
+	}
+}
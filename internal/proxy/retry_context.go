@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// excessNewlines matches three or more line breaks, optionally separated by blank lines that
+// hold only spaces.
+var excessNewlines = regexp.MustCompile(`\n(?:[ \t]*\n){2,}`)
+
+// normalizeRetryContext tidies accumulated text before it is sent back as continuation context:
+// line endings become \n, control characters other than tabs and newlines are removed, trailing
+// whitespace is trimmed from each line and runs of blank lines collapse into one. Indentation
+// and spacing inside lines are kept, as they carry meaning in code and tables. Only the retry
+// request sees the result; the client always receives the text as it was streamed.
+func normalizeRetryContext(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	text = strings.Map(func(r rune) rune {
+		if r != '\n' && r != '\t' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return excessNewlines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gpt-load/internal/models"
+	"gpt-load/internal/streaming"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNormalizeRetryContext(t *testing.T) {
+	raw := "Line one  \r\nLine\x00 two\x07\r\n\r\n\r\n\r\n    indented  code\tkept\n"
+	want := "Line one\nLine two\n\n    indented  code\tkept\n"
+	if got := normalizeRetryContext(raw); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestRetryContextIsNormalizedButClientOutputIsRaw(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var retryBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		retryBody = string(body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"candidates":[{"content":{"parts":[{"text":" done. [done]"}]}}]}` + "\n\n"))
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "test"}
+	group.EffectiveConfig.NormalizeRetryContext = 1
+	group.EffectiveConfig.StreamRetryDelayMs = 1
+	ps := &ProxyServer{keyProvider: newTestKeyProvider(group.ID), streamProcessorFactory: streaming.NewStreamProcessorFactory()}
+	ch := &stubChannel{upstream: server.URL, channelType: "gemini"}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/m:streamGenerateContent", nil)
+	upstream := `data: {"candidates":[{"content":{"parts":[{"text":"First  \r\n\r\n\r\n\r\nsecond\u0007"}]}}]}` + "\n\n"
+	resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(upstream))}
+
+	ps.handleStreamingResponse(c, resp, ch, group, []byte(`{"contents":[]}`), time.Now())
+
+	if !strings.Contains(retryBody, `"text":"First\n\nsecond"`) {
+		t.Errorf("Expected the retry context to be normalized, got %s", retryBody)
+	}
+	if !strings.Contains(recorder.Body.String(), `First  \r\n\r\n\r\n\r\nsecond\u0007`) {
+		t.Errorf("Expected the client to receive the text as streamed, got %q", recorder.Body.String())
+	}
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// retrySlotWait is how long a retry waits for a free slot before giving up.
+const retrySlotWait = 2 * time.Second
+
+var errRetrySlotsExhausted = errors.New("too many concurrent retries in progress")
+
+// retrySemaphore caps the number of in-flight retry requests across the whole process.
+// The limit is read from settings on every acquire, so changes apply to new retries
+// while retries holding a slot of the previous size drain normally.
+type retrySemaphore struct {
+	mu    sync.Mutex
+	limit int
+	slots chan struct{}
+}
+
+// acquire reserves a retry slot, waiting at most wait for one to free up.
+// A limit of 0 disables the cap. The returned release function must be called once.
+func (s *retrySemaphore) acquire(ctx context.Context, limit int, wait time.Duration) (func(), error) {
+	if s == nil || limit <= 0 {
+		return func() {}, nil
+	}
+
+	s.mu.Lock()
+	if s.slots == nil || s.limit != limit {
+		s.slots = make(chan struct{}, limit)
+		s.limit = limit
+	}
+	slots := s.slots
+	s.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case slots <- struct{}{}:
+		var once sync.Once
+		return func() { once.Do(func() { <-slots }) }, nil
+	case <-timer.C:
+		return nil, errRetrySlotsExhausted
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// releasingBody releases a retry slot when the response body is closed, so the slot is
+// held for as long as the retry's connection is in use.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gpt-load/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGlobalRetryCapBoundsConcurrentRetries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ps := &ProxyServer{retrySlots: &retrySemaphore{}}
+
+	// Retries for different groups share the same process-wide cap. Open retry responses
+	// are counted on the client side, since the upstream only notices a closed connection
+	// some time after the slot has been handed on.
+	var active, peak int32
+	var wg sync.WaitGroup
+	var failures int32
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			group := &models.Group{ID: uint(i%2 + 1), Name: "test"}
+			group.EffectiveConfig.MaxConcurrentRetries = 2
+			ps := &ProxyServer{keyProvider: newTestKeyProvider(group.ID), retrySlots: ps.retrySlots}
+			// The stub records the reshaped body, so each retry gets its own
+			ch := &stubChannel{upstream: server.URL, channelType: "custom"}
+
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+			resp, err := ps.createRetryRequest(c, ch, group, []byte(`{"messages":[]}`), "partial", time.Now())
+			if err != nil {
+				atomic.AddInt32(&failures, 1)
+				return
+			}
+			n := atomic.AddInt32(&active, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(30 * time.Millisecond)
+			atomic.AddInt32(&active, -1)
+			resp.Body.Close()
+		}(i)
+	}
+	wg.Wait()
+
+	if failures != 0 {
+		t.Errorf("Expected all retries to get a slot eventually, %d failed", failures)
+	}
+	if peak > 2 {
+		t.Errorf("Expected at most 2 concurrent retries, observed %d", peak)
+	}
+}
+
+func TestRetrySemaphoreFailsFastWhenSaturated(t *testing.T) {
+	sem := &retrySemaphore{}
+	release, err := sem.acquire(context.Background(), 1, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Expected first slot to be granted, got %v", err)
+	}
+
+	if _, err := sem.acquire(context.Background(), 1, 10*time.Millisecond); !errors.Is(err, errRetrySlotsExhausted) {
+		t.Errorf("Expected saturation error, got %v", err)
+	}
+
+	release()
+	if _, err := sem.acquire(context.Background(), 1, time.Millisecond); err != nil {
+		t.Errorf("Expected slot to be reusable after release, got %v", err)
+	}
+}
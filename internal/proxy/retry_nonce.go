@@ -0,0 +1,30 @@
+package proxy
+
+import (
+	"net/http"
+
+	"gpt-load/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// applyRetryNonce marks a retry or repair request with a fresh nonce in the header and query
+// parameter the group configures, so an upstream, or a cache in front of it, that keys
+// responses by request does not serve the same truncated response again. The first request
+// of a stream is never marked.
+func applyRetryNonce(req *http.Request, group *models.Group) {
+	header, param := group.EffectiveConfig.RetryNonceHeader, group.EffectiveConfig.RetryNonceParam
+	if header == "" && param == "" {
+		return
+	}
+
+	nonce := uuid.NewString()
+	if header != "" {
+		req.Header.Set(header, nonce)
+	}
+	if param != "" {
+		q := req.URL.Query()
+		q.Set(param, nonce)
+		req.URL.RawQuery = q.Encode()
+	}
+}
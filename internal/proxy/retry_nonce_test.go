@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gpt-load/internal/models"
+	"gpt-load/internal/streaming"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRetryNonceIsSentOnRetriesOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var headers, params []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Get("X-Retry-Nonce"))
+		params = append(params, r.URL.Query().Get("nonce"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		if len(headers) == 1 {
+			// Truncated before the answer is complete
+			w.Write([]byte(`data: {"candidates":[{"content":{"parts":[{"text":"Half of"}]}}]}` + "\n\n"))
+			return
+		}
+		w.Write([]byte(`data: {"candidates":[{"content":{"parts":[{"text":" the rest. [done]"}]},"finishReason":"STOP"}]}` + "\n\n"))
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "nonce"}
+	group.EffectiveConfig.MaxRetries = 1
+	group.EffectiveConfig.StreamRetryDelayMs = 1
+	group.EffectiveConfig.RetryNonceHeader = "X-Retry-Nonce"
+	group.EffectiveConfig.RetryNonceParam = "nonce"
+	ps := &ProxyServer{
+		keyProvider:            newTestKeyProvider(group.ID),
+		streamProcessorFactory: streaming.NewStreamProcessorFactory(),
+		retrySlots:             &retrySemaphore{},
+	}
+	ch := &stubChannel{upstream: server.URL, channelType: "gemini"}
+
+	requestBody := `{"contents":[{"role":"user","parts":[{"text":"Hi"}]}]}`
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/gemini:streamGenerateContent", strings.NewReader(requestBody))
+	ps.executeRequestWithRetry(c, ch, group, []byte(requestBody), true, time.Now(), 0, nil)
+
+	if len(headers) != 2 {
+		t.Fatalf("Expected the truncated stream to be retried once, got %d requests: %q", len(headers), recorder.Body.String())
+	}
+	if headers[0] != "" || params[0] != "" {
+		t.Errorf("Expected no nonce on the first request, got header %q and param %q", headers[0], params[0])
+	}
+	if headers[1] == "" || params[1] == "" {
+		t.Errorf("Expected a nonce on the retry, got header %q and param %q", headers[1], params[1])
+	}
+}
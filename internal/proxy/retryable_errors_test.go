@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gpt-load/internal/httpclient"
+	"gpt-load/internal/keypool"
+	"gpt-load/internal/models"
+	"gpt-load/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRetryableErrorMessages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	send := func(statusCode int, message string, retryable string) (int, *httptest.ResponseRecorder) {
+		hits := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits++
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(statusCode)
+			fmt.Fprintf(w, `{"error":{"code":%d,"message":%q}}`, statusCode, message)
+		}))
+		defer server.Close()
+
+		group := &models.Group{ID: 1, Name: "retryable"}
+		group.EffectiveConfig.RequestTimeout = 600
+		group.EffectiveConfig.MaxRetries = 2
+		group.EffectiveConfig.RetryableErrorMessages = retryable
+		// The key is already marked invalid, so its failures don't touch the database
+		memStore := store.NewMemoryStore()
+		memStore.HSet("key:1", map[string]any{"key_string": "gm-key", "status": models.KeyStatusInvalid})
+		memStore.LPush("group:1:active_keys", "1")
+		ps := &ProxyServer{keyProvider: keypool.NewProvider(nil, memStore, nil), retrySlots: &retrySemaphore{}}
+		ch := &stubChannel{upstream: server.URL, channelType: "gemini"}
+
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/m:generateContent", strings.NewReader(`{}`))
+		ps.executeRequestWithRetry(c, ch, group, []byte(`{}`), false, time.Now(), 0, nil)
+		return hits, recorder
+	}
+
+	if hits, _ := send(http.StatusInternalServerError, "Something else broke", ""); hits != 3 {
+		t.Errorf("Expected every error to be retried without a list, got %d requests", hits)
+	}
+	if hits, _ := send(http.StatusServiceUnavailable, "The model is Overloaded", "overloaded, internal error"); hits != 3 {
+		t.Errorf("Expected a listed message to be retried, got %d requests", hits)
+	}
+
+	hits, recorder := send(http.StatusInternalServerError, "Something else broke", "overloaded")
+	if hits != 1 {
+		t.Errorf("Expected an unlisted 5xx error not to be retried, got %d requests", hits)
+	}
+	if recorder.Code != http.StatusInternalServerError || !strings.Contains(recorder.Body.String(), "Something else broke") {
+		t.Errorf("Expected the upstream error to be returned, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	if hits, _ := send(http.StatusTooManyRequests, "Quota exceeded", "overloaded"); hits != 3 {
+		t.Errorf("Expected 4xx errors to keep rotating keys, got %d requests", hits)
+	}
+}
+
+func TestRefusedRedirectIsNotRetried(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the unlisted host not to be contacted")
+	}))
+	defer elsewhere.Close()
+
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		http.Redirect(w, r, elsewhere.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "redirected"}
+	group.EffectiveConfig.RequestTimeout = 600
+	group.EffectiveConfig.MaxRetries = 2
+	// The key is already marked invalid, so its failures don't touch the database
+	memStore := store.NewMemoryStore()
+	memStore.HSet("key:1", map[string]any{"key_string": "sk-key", "status": models.KeyStatusInvalid})
+	memStore.LPush("group:1:active_keys", "1")
+	ps := &ProxyServer{keyProvider: keypool.NewProvider(nil, memStore, nil), retrySlots: &retrySemaphore{}}
+	client := httpclient.NewHTTPClientManager().GetClient(&httpclient.Config{MaxRedirects: 10})
+	ch := &stubChannel{upstream: server.URL, channelType: "openai", client: client}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+	ps.executeRequestWithRetry(c, ch, group, []byte(`{}`), false, time.Now(), 0, nil)
+
+	if hits != 1 {
+		t.Errorf("Expected a refused redirect not to be retried, got %d requests", hits)
+	}
+	if recorder.Code != http.StatusBadGateway || !strings.Contains(recorder.Body.String(), "not in the allowed hosts") {
+		t.Errorf("Expected the refused redirect to be returned as a bad gateway, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
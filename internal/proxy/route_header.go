@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+
+	app_errors "gpt-load/internal/errors"
+	"gpt-load/internal/response"
+	"gpt-load/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// RouteHeader selects the group that serves a request, overriding the group in the path. It
+// is consumed by the proxy and not sent upstream.
+const RouteHeader = "X-GPT-Load-Route"
+
+// RouteHeaderRouting routes proxy requests carrying the route header to the group it names.
+// The path group must list that group in its route_header_groups setting; a group without
+// the setting ignores the header. The rewrite happens before authentication, so the client
+// token must also be valid for the selected group.
+func (ps *ProxyServer) RouteHeaderRouting() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := strings.TrimSpace(c.GetHeader(RouteHeader))
+		c.Request.Header.Del(RouteHeader)
+		groupName := c.Param("group_name")
+		if route == "" || route == groupName {
+			c.Next()
+			return
+		}
+
+		group, err := ps.groupManager.GetGroupByName(groupName)
+		if err != nil {
+			c.Next()
+			return
+		}
+		allowed := utils.StringToSet(group.EffectiveConfig.RouteHeaderGroups, ",")
+		if len(allowed) == 0 {
+			c.Next()
+			return
+		}
+
+		if _, ok := allowed[route]; !ok {
+			response.Error(c, app_errors.NewAPIError(app_errors.ErrForbidden, fmt.Sprintf("Route '%s' is not allowed for group '%s'", route, groupName)))
+			c.Abort()
+			return
+		}
+		if _, err := ps.groupManager.GetGroupByName(route); err != nil {
+			response.Error(c, app_errors.NewAPIError(app_errors.ErrResourceNotFound, fmt.Sprintf("Route group '%s' not found", route)))
+			c.Abort()
+			return
+		}
+
+		logrus.Debugf("Routing request for group '%s' to group '%s' by header", groupName, route)
+		c.Set(routedFromKey, groupName)
+		c.Set(routedByKey, routedByRouteHeader)
+		for i := range c.Params {
+			if c.Params[i].Key == "group_name" {
+				c.Params[i].Value = route
+			}
+		}
+		c.Request.URL.Path = "/proxy/" + route + c.Param("path")
+		c.Request.URL.RawPath = ""
+		c.Next()
+	}
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gpt-load/internal/channel"
+	"gpt-load/internal/keypool"
+	"gpt-load/internal/models"
+	"gpt-load/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRouteHeaderSelectsGroup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newUpstream := func(hits *int, routeHeader *string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*hits++
+			*routeHeader = r.Header.Get(RouteHeader)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"ok":true}`))
+		}))
+	}
+	var openaiHits, geminiHits int
+	var forwardedRoute string
+	openaiServer := newUpstream(&openaiHits, &forwardedRoute)
+	defer openaiServer.Close()
+	geminiServer := newUpstream(&geminiHits, &forwardedRoute)
+	defer geminiServer.Close()
+
+	memStore := store.NewMemoryStore()
+	memStore.HSet("key:1", map[string]any{"key_string": "sk-openai", "status": models.KeyStatusActive})
+	memStore.LPush("group:1:active_keys", "1")
+	memStore.HSet("key:2", map[string]any{"key_string": "gm-key", "status": models.KeyStatusActive})
+	memStore.LPush("group:2:active_keys", "2")
+
+	gateway := &models.Group{ID: 1, Name: "gateway", ChannelType: "openai"}
+	gateway.EffectiveConfig.RouteHeaderGroups = "gemini-group, missing"
+	geminiGroup := &models.Group{ID: 2, Name: "gemini-group", ChannelType: "gemini"}
+	otherGroup := &models.Group{ID: 3, Name: "other", ChannelType: "openai"}
+	groups := &stubGroups{
+		groups: map[string]*models.Group{"gateway": gateway, "gemini-group": geminiGroup, "other": otherGroup},
+		channels: map[string]channel.ChannelProxy{
+			"gateway":      &stubChannel{upstream: openaiServer.URL, channelType: "openai"},
+			"gemini-group": &stubChannel{upstream: geminiServer.URL, channelType: "gemini"},
+		},
+	}
+	ps := &ProxyServer{
+		keyProvider:    keypool.NewProvider(nil, memStore, nil),
+		groupManager:   groups,
+		channelFactory: groups,
+		retrySlots:     &retrySemaphore{},
+	}
+
+	engine := gin.New()
+	engine.Any("/proxy/:group_name/*path", ps.RouteHeaderRouting(), ps.HandleProxy)
+	send := func(route string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/proxy/gateway/v1/chat/completions", strings.NewReader(`{"model":"m"}`))
+		if route != "" {
+			req.Header.Set(RouteHeader, route)
+		}
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if recorder := send(""); recorder.Code != http.StatusOK || openaiHits != 1 {
+		t.Errorf("Expected path-based routing without the header, got %d with %d openai requests", recorder.Code, openaiHits)
+	}
+
+	if recorder := send("gemini-group"); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the routed request to succeed, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if geminiHits != 1 || openaiHits != 1 {
+		t.Errorf("Expected the header to select the gemini channel, got %d gemini and %d openai requests", geminiHits, openaiHits)
+	}
+	if forwardedRoute != "" {
+		t.Errorf("Expected the route header not to be sent upstream, got %q", forwardedRoute)
+	}
+
+	if recorder := send("other"); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected a route outside the allowed list to be rejected, got %d", recorder.Code)
+	}
+	if recorder := send("missing"); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected a route to an unknown group to be rejected, got %d", recorder.Code)
+	}
+	if geminiHits != 1 || openaiHits != 1 {
+		t.Errorf("Expected rejected routes not to reach any upstream, got %d gemini and %d openai requests", geminiHits, openaiHits)
+	}
+}
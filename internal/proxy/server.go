@@ -8,12 +8,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"gpt-load/internal/channel"
 	"gpt-load/internal/config"
 	app_errors "gpt-load/internal/errors"
+	"gpt-load/internal/httpclient"
 	"gpt-load/internal/keypool"
 	"gpt-load/internal/models"
 	"gpt-load/internal/response"
@@ -26,14 +29,27 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// groupLookup resolves groups by name, as services.GroupManager does.
+type groupLookup interface {
+	GetGroupByName(name string) (*models.Group, error)
+}
+
+// channelProvider returns the channel proxy of a group, as channel.Factory does.
+type channelProvider interface {
+	GetChannel(group *models.Group) (channel.ChannelProxy, error)
+}
+
 // ProxyServer represents the proxy server
 type ProxyServer struct {
-	keyProvider           *keypool.KeyProvider
-	groupManager          *services.GroupManager
-	settingsManager       *config.SystemSettingsManager
-	channelFactory        *channel.Factory
-	requestLogService     *services.RequestLogService
+	keyProvider            *keypool.KeyProvider
+	groupManager           groupLookup
+	settingsManager        settingsProvider
+	channelFactory         channelProvider
+	requestLogService      *services.RequestLogService
 	streamProcessorFactory *streaming.StreamProcessorFactory
+	retrySlots             *retrySemaphore
+	rateLimiter            *slidingWindowLimiter
+	dispatchSlots          *dispatchQueue
 }
 
 // NewProxyServer creates a new proxy server
@@ -45,12 +61,15 @@ func NewProxyServer(
 	requestLogService *services.RequestLogService,
 ) (*ProxyServer, error) {
 	return &ProxyServer{
-		keyProvider:           keyProvider,
-		groupManager:          groupManager,
-		settingsManager:       settingsManager,
-		channelFactory:        channelFactory,
-		requestLogService:     requestLogService,
+		keyProvider:            keyProvider,
+		groupManager:           groupManager,
+		settingsManager:        settingsManager,
+		channelFactory:         channelFactory,
+		requestLogService:      requestLogService,
 		streamProcessorFactory: streaming.NewStreamProcessorFactory(),
+		retrySlots:             &retrySemaphore{},
+		rateLimiter:            newSlidingWindowLimiter(),
+		dispatchSlots:          &dispatchQueue{},
 	}, nil
 }
 
@@ -65,12 +84,40 @@ func (ps *ProxyServer) HandleProxy(c *gin.Context) {
 		return
 	}
 
+	releaseSlot, retryAfter, err := ps.admitRequest(c, group)
+	if errors.Is(err, errGroupRateLimited) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		response.Error(c, app_errors.ErrRateLimited)
+		return
+	}
+	if err != nil {
+		response.Error(c, app_errors.NewAPIError(app_errors.ErrQueueTimeout, fmt.Sprintf("Request not dispatched: %v", err)))
+		return
+	}
+	defer releaseSlot()
+
 	channelHandler, err := ps.channelFactory.GetChannel(group)
 	if err != nil {
 		response.Error(c, app_errors.NewAPIError(app_errors.ErrInternalServer, fmt.Sprintf("Failed to get channel for group '%s': %v", groupName, err)))
 		return
 	}
 
+	overrideModel, err := headerModel(c, group)
+	if err != nil {
+		response.Error(c, app_errors.NewAPIError(app_errors.ErrForbidden, err.Error()))
+		return
+	}
+
+	if forwardsUnbuffered(c, channelHandler, group) {
+		c.Set(unbufferedBodyKey, true)
+		isStream := channelHandler.IsStreamRequest(c, nil)
+		if isStream {
+			clearWriteDeadline(c.Writer)
+		}
+		ps.executeRequestWithRetry(c, channelHandler, group, nil, isStream, startTime, 0, nil)
+		return
+	}
+
 	bodyBytes, err := io.ReadAll(c.Request.Body)
 	if err != nil {
 		logrus.Errorf("Failed to read request body: %v", err)
@@ -78,18 +125,68 @@ func (ps *ProxyServer) HandleProxy(c *gin.Context) {
 		return
 	}
 	c.Request.Body.Close()
+	c.Set(clientBodyKey, bodyBytes)
 
-	finalBodyBytes, err := ps.applyParamOverrides(bodyBytes, group)
+	bodyBytes, err = applyModelOverride(c, bodyBytes, overrideModel)
 	if err != nil {
-		response.Error(c, app_errors.NewAPIError(app_errors.ErrInternalServer, fmt.Sprintf("Failed to apply parameter overrides: %v", err)))
+		response.Error(c, app_errors.NewAPIError(app_errors.ErrInternalServer, fmt.Sprintf("Failed to apply model override: %v", err)))
+		return
+	}
+
+	finalBodyBytes, err := ps.prepareGroupBody(c, bodyBytes, group, channelHandler.GetChannelType())
+	if err != nil {
+		response.Error(c, app_errors.NewAPIError(app_errors.ErrInternalServer, fmt.Sprintf("Failed to prepare request body: %v", err)))
 		return
 	}
 	isStream := channelHandler.IsStreamRequest(c, bodyBytes)
+	model := channelHandler.ExtractModel(c, finalBodyBytes)
+	if isStream && isNonStreamingModel(group, model) {
+		if group.EffectiveConfig.NonStreamingMode == NonStreamingModeReject {
+			response.Error(c, app_errors.NewAPIError(app_errors.ErrBadRequest, fmt.Sprintf("Model '%s' does not support streaming", model)))
+			return
+		}
+		// The model can't stream, so the complete response is converted to events instead
+		finalBodyBytes, err = bufferStreamRequest(c, finalBodyBytes)
+		if err != nil {
+			response.Error(c, app_errors.NewAPIError(app_errors.ErrInternalServer, fmt.Sprintf("Failed to remove streaming parameters: %v", err)))
+			return
+		}
+		c.Set(bufferedStreamKey, true)
+		isStream = false
+	}
+	if resolveConfigRequested(c, group) {
+		ps.respondWithResolvedConfig(c, channelHandler, group, model, isStream)
+		return
+	}
+	if isStream {
+		clearWriteDeadline(c.Writer)
+	}
 
 	ps.executeRequestWithRetry(c, channelHandler, group, finalBodyBytes, isStream, startTime, 0, nil)
 }
 
+// admitRequest applies the group's rate limit and then waits for an upstream slot, admitting
+// higher-priority groups first under load. A rate-limited request gets errGroupRateLimited
+// and how long until it would be admitted. The slot's release function is also kept in the
+// context, so a fallback can hand the slot on before it is admitted to its own group.
+func (ps *ProxyServer) admitRequest(c *gin.Context, group *models.Group) (func(), time.Duration, error) {
+	rateWindow := time.Duration(group.EffectiveConfig.RateLimitWindow) * time.Second
+	if allowed, retryAfter := ps.rateLimiter.allow(group.ID, group.EffectiveConfig.RateLimitRequests, rateWindow); !allowed {
+		return nil, retryAfter, errGroupRateLimited
+	}
+
+	releaseSlot, err := ps.dispatchSlots.acquire(c.Request.Context(), group.EffectiveConfig.MaxConcurrentUpstream, group.EffectiveConfig.RequestPriority, dispatchQueueWait)
+	if err != nil {
+		return nil, 0, err
+	}
+	c.Set(dispatchSlotKey, releaseSlot)
+	return releaseSlot, 0, nil
+}
+
 // executeRequestWithRetry is the core recursive function for handling requests and retries.
+// Streaming requests go through it too until a stream is established: a first request that
+// fails to connect or is answered with an error status is retried with the original body and
+// the next key. Only an established stream is continued by the streaming retry loop.
 func (ps *ProxyServer) executeRequestWithRetry(
 	c *gin.Context,
 	channelHandler channel.ChannelProxy,
@@ -101,33 +198,37 @@ func (ps *ProxyServer) executeRequestWithRetry(
 	retryErrors []types.RetryError,
 ) {
 	cfg := group.EffectiveConfig
+	log := utils.GroupLogger(group)
 	if retryCount > cfg.MaxRetries {
+		if ps.tryFallback(c, group, channelHandler, bodyBytes, isStream, startTime) {
+			return
+		}
 		if len(retryErrors) > 0 {
 			lastError := retryErrors[len(retryErrors)-1]
-			var errorJSON map[string]any
-			if err := json.Unmarshal([]byte(lastError.ErrorMessage), &errorJSON); err == nil {
-				c.JSON(lastError.StatusCode, errorJSON)
-			} else {
-				response.Error(c, app_errors.NewAPIErrorWithUpstream(lastError.StatusCode, "UPSTREAM_ERROR", lastError.ErrorMessage))
-			}
-			logMessage := lastError.ParsedErrorMessage
-			if logMessage == "" {
-				logMessage = lastError.ErrorMessage
-			}
-			logrus.Debugf("Max retries exceeded for group %s after %d attempts. Parsed Error: %s", group.Name, retryCount, logMessage)
-
-			ps.logRequest(c, group, &models.APIKey{KeyValue: lastError.KeyValue}, startTime, lastError.StatusCode, retryCount, errors.New(logMessage), isStream, lastError.UpstreamAddr, channelHandler, bodyBytes)
+			log.Debugf("Max retries exceeded for group %s after %d attempts. Parsed Error: %s", group.Name, retryCount, lastError.LogMessage())
+			ps.respondWithUpstreamError(c, channelHandler, group, bodyBytes, isStream, startTime, retryCount, lastError)
 		} else {
 			response.Error(c, app_errors.ErrMaxRetriesExceeded)
-			logrus.Debugf("Max retries exceeded for group %s after %d attempts.", group.Name, retryCount)
+			log.Debugf("Max retries exceeded for group %s after %d attempts.", group.Name, retryCount)
 			ps.logRequest(c, group, nil, startTime, http.StatusServiceUnavailable, retryCount, app_errors.ErrMaxRetriesExceeded, isStream, "", channelHandler, bodyBytes)
 		}
 		return
 	}
 
-	apiKey, err := ps.keyProvider.SelectKey(group.ID)
+	remaining, budgeted := remainingBudget(group, startTime)
+	if budgeted && remaining <= 0 {
+		log.Debugf("Request budget of %ds exhausted for group %s after %d attempts", cfg.RequestBudget, group.Name, retryCount)
+		response.Error(c, app_errors.ErrBudgetExhausted)
+		ps.logRequest(c, group, nil, startTime, http.StatusGatewayTimeout, retryCount, app_errors.ErrBudgetExhausted, isStream, "", channelHandler, bodyBytes)
+		return
+	}
+
+	apiKey, err := ps.keyProvider.SelectKeyWithinQuota(group.ID, keypool.KeyQuotaOf(group))
 	if err != nil {
-		logrus.Errorf("Failed to select a key for group %s on attempt %d: %v", group.Name, retryCount+1, err)
+		log.Errorf("Failed to select a key for group %s on attempt %d: %v", group.Name, retryCount+1, err)
+		if ps.tryFallback(c, group, channelHandler, bodyBytes, isStream, startTime) {
+			return
+		}
 		response.Error(c, app_errors.NewAPIError(app_errors.ErrNoKeysAvailable, err.Error()))
 		ps.logRequest(c, group, nil, startTime, http.StatusServiceUnavailable, retryCount, err, isStream, "", channelHandler, bodyBytes)
 		return
@@ -139,52 +240,47 @@ func (ps *ProxyServer) executeRequestWithRetry(
 		return
 	}
 
+	var timeout time.Duration
+	if !isStream {
+		timeout = time.Duration(cfg.RequestTimeout) * time.Second
+	}
+	timeout = attemptTimeout(timeout, remaining, budgeted)
+
 	var ctx context.Context
 	var cancel context.CancelFunc
-	if isStream {
-		ctx, cancel = context.WithCancel(c.Request.Context())
-	} else {
-		timeout := time.Duration(cfg.RequestTimeout) * time.Second
+	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(c.Request.Context(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(c.Request.Context())
 	}
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, c.Request.Method, upstreamURL, bytes.NewReader(bodyBytes))
+	req, err := ps.buildUpstreamRequest(ctx, c, channelHandler, group, apiKey, upstreamURL, bodyBytes, isStream, timeout, budgeted)
 	if err != nil {
-		logrus.Errorf("Failed to create upstream request: %v", err)
+		log.Errorf("Failed to create upstream request: %v", err)
 		response.Error(c, app_errors.ErrInternalServer)
 		return
 	}
-	req.ContentLength = int64(len(bodyBytes))
-
-	req.Header = c.Request.Header.Clone()
-
-	// Clean up client auth key
-	req.Header.Del("Authorization")
-	req.Header.Del("X-Api-Key")
-	req.Header.Del("X-Goog-Api-Key")
-	q := req.URL.Query()
-	q.Del("key")
-	req.URL.RawQuery = q.Encode()
-
-	// Apply custom header rules
-	if len(group.HeaderRuleList) > 0 {
-		headerCtx := utils.NewHeaderVariableContextFromGin(c, group, apiKey)
-		utils.ApplyHeaderRules(req, group.HeaderRuleList, headerCtx)
-	}
-
-	channelHandler.ModifyRequest(req, apiKey, group)
+	log.Debugf("Upstream request (attempt %d): %s %s", retryCount+1, req.Method, redactedURL(req.URL))
 
 	var client *http.Client
 	if isStream {
 		client = channelHandler.GetStreamClient()
-		channelHandler.ReshapeStreamReqBody(req)
-		req.Header.Set("X-Accel-Buffering", "no")
 	} else {
 		client = channelHandler.GetHTTPClient()
 	}
 
-	resp, err := client.Do(req)
+	var resp *http.Response
+	if isStream && cfg.StreamHedgeDelayMs > 0 && !c.GetBool(unbufferedBodyKey) {
+		var hedgeFailed *hedgeFailure
+		resp, apiKey, upstreamURL, hedgeFailed, err = ps.sendHedged(ctx, c, channelHandler, group, client, req, apiKey, upstreamURL, bodyBytes, timeout, budgeted)
+		if hedgeFailed != nil && !app_errors.IsIgnorableError(hedgeFailed.err) && !errors.Is(hedgeFailed.err, httpclient.ErrRedirectRefused) {
+			log.Debugf("Hedged request failed for key %s: %v", utils.MaskAPIKey(hedgeFailed.key.KeyValue), hedgeFailed.err)
+			ps.keyProvider.UpdateStatus(hedgeFailed.key, group, false)
+		}
+	} else {
+		resp, err = client.Do(req)
+	}
 	if resp != nil {
 		defer resp.Body.Close()
 	}
@@ -193,10 +289,22 @@ func (ps *ProxyServer) executeRequestWithRetry(
 	// Exclude 404 from being a retryable error.
 	if err != nil || (resp != nil && resp.StatusCode >= 400 && resp.StatusCode != http.StatusNotFound) {
 		if err != nil && app_errors.IsIgnorableError(err) {
-			logrus.Debugf("Client-side ignorable error for key %s, aborting retries: %v", utils.MaskAPIKey(apiKey.KeyValue), err)
+			log.Debugf("Client-side ignorable error for key %s, aborting retries: %v", utils.MaskAPIKey(apiKey.KeyValue), err)
 			ps.logRequest(c, group, apiKey, startTime, 499, retryCount+1, err, isStream, upstreamURL, channelHandler, bodyBytes)
 			return
 		}
+		if errors.Is(err, httpclient.ErrRedirectRefused) {
+			// The group's redirect settings refused it, which no key or retry can change
+			log.Warnf("Upstream redirect refused for group %s, check its upstream_max_redirects and upstream_redirect_hosts: %v", group.Name, err)
+			ps.respondWithUpstreamError(c, channelHandler, group, bodyBytes, isStream, startTime, retryCount+1, types.RetryError{
+				StatusCode:   http.StatusBadGateway,
+				ErrorMessage: err.Error(),
+				KeyValue:     apiKey.KeyValue,
+				Attempt:      retryCount + 1,
+				UpstreamAddr: upstreamURL,
+			})
+			return
+		}
 
 		ps.keyProvider.UpdateStatus(apiKey, group, false)
 
@@ -207,52 +315,167 @@ func (ps *ProxyServer) executeRequestWithRetry(
 		if err != nil {
 			statusCode = 500
 			errorMessage = err.Error()
-			logrus.Debugf("Request failed (attempt %d/%d) for key %s: %v", retryCount+1, cfg.MaxRetries, utils.MaskAPIKey(apiKey.KeyValue), err)
+			log.Debugf("Request failed (attempt %d/%d) for key %s: %v", retryCount+1, cfg.MaxRetries, utils.MaskAPIKey(apiKey.KeyValue), err)
 		} else {
 			// HTTP-level error (status >= 400)
 			statusCode = resp.StatusCode
-			errorBody, readErr := io.ReadAll(resp.Body)
+			errorBody, readErr := readErrorBody(resp.Body, int64(cfg.MaxResponseBodyKB)*1024)
 			if readErr != nil {
-				logrus.Errorf("Failed to read error body: %v", readErr)
+				log.Errorf("Failed to read error body: %v", readErr)
 				errorBody = []byte("Failed to read error body")
 			}
 
 			errorBody = handleGzipCompression(resp, errorBody)
 			errorMessage = string(errorBody)
 			parsedError = app_errors.ParseUpstreamError(errorBody)
-			logrus.Debugf("Request failed with status %d (attempt %d/%d) for key %s. Parsed Error: %s", statusCode, retryCount+1, cfg.MaxRetries, utils.MaskAPIKey(apiKey.KeyValue), parsedError)
+			log.Debugf("Request failed with status %d (attempt %d/%d) for key %s. Parsed Error: %s", statusCode, retryCount+1, cfg.MaxRetries, utils.MaskAPIKey(apiKey.KeyValue), parsedError)
 		}
 
-		newRetryErrors := append(retryErrors, types.RetryError{
+		retryError := types.RetryError{
 			StatusCode:         statusCode,
 			ErrorMessage:       errorMessage,
 			ParsedErrorMessage: parsedError,
 			KeyValue:           apiKey.KeyValue,
 			Attempt:            retryCount + 1,
 			UpstreamAddr:       upstreamURL,
-		})
+		}
+		if c.GetBool(unbufferedBodyKey) {
+			log.Debugf("Request with an unbuffered body failed with status %d and cannot be retried", statusCode)
+			ps.respondWithUpstreamError(c, channelHandler, group, bodyBytes, isStream, startTime, retryCount+1, retryError)
+			return
+		}
+		if err == nil && !isRetryableUpstreamError(group, statusCode, parsedError) {
+			log.Debugf("Error with status %d is not retryable for group %s: %s", statusCode, group.Name, parsedError)
+			ps.respondWithUpstreamError(c, channelHandler, group, bodyBytes, isStream, startTime, retryCount+1, retryError)
+			return
+		}
+
+		newRetryErrors := append(retryErrors, retryError)
 		ps.executeRequestWithRetry(c, channelHandler, group, bodyBytes, isStream, startTime, retryCount+1, newRetryErrors)
 		return
 	}
 
 	// ps.keyProvider.UpdateStatus(apiKey, group, true) // 请求成功不再重置成功次数，减少IO消耗
-	logrus.Debugf("Request for group %s succeeded on attempt %d with key %s", group.Name, retryCount+1, utils.MaskAPIKey(apiKey.KeyValue))
+	log.Debugf("Request for group %s succeeded on attempt %d with key %s", group.Name, retryCount+1, utils.MaskAPIKey(apiKey.KeyValue))
 	ps.logRequest(c, group, apiKey, startTime, resp.StatusCode, retryCount+1, nil, isStream, upstreamURL, channelHandler, bodyBytes)
 
-	for key, values := range resp.Header {
-		for _, value := range values {
-			c.Header(key, value)
-		}
+	// The intelligent streaming path and buffered streams read the body, so they always need it decoded
+	buffered := c.GetBool(bufferedStreamKey)
+	mustDecode := (isStream && !usesSimpleStreaming(group, channelHandler.GetChannelType())) || buffered
+	if err := decodeUpstreamBody(resp, c.GetHeader("Accept-Encoding"), mustDecode); err != nil {
+		log.Warnf("Forwarding upstream response as received: %v", err)
+	}
+
+	copyBoundedHeaders(c.Writer.Header(), resp.Header, group)
+	c.Header(streaming.AttemptsHeader, strconv.Itoa(retryCount+1))
+	if cfg.KeyIDHeader > 0 {
+		c.Header(KeyIDHeader, strconv.FormatUint(uint64(apiKey.ID), 10))
 	}
 	c.Status(resp.StatusCode)
 
 	if isStream {
-		ps.handleStreamingResponse(c, resp, channelHandler, group, bodyBytes)
+		ps.handleStreamingResponse(c, resp, channelHandler, group, bodyBytes, startTime)
+	} else if buffered {
+		ps.handleBufferedStreamResponse(c, resp, group, channelHandler.GetChannelType())
+	} else if c.GetBool(unbufferedBodyKey) && isEventStream(resp) {
+		// A stream requested in the unread body is passed through as it arrives
+		ps.handleSimpleStreamingResponse(c, c.Writer, resp, group)
 	} else {
-		ps.handleNormalResponse(c, resp)
+		ps.handleNormalResponse(c, resp, group)
 	}
 }
 
+// respondWithUpstreamError sends the client the upstream error that ended a request, as the
+// upstream's JSON, as the standardized envelope or wrapped in an API error, and logs it.
+func (ps *ProxyServer) respondWithUpstreamError(
+	c *gin.Context,
+	channelHandler channel.ChannelProxy,
+	group *models.Group,
+	bodyBytes []byte,
+	isStream bool,
+	startTime time.Time,
+	attempts int,
+	lastError types.RetryError,
+) {
+	cfg := group.EffectiveConfig
+	var errorJSON map[string]any
+	if cfg.StandardErrorEnvelope > 0 {
+		mapping := app_errors.ParseErrorCodeMapping(cfg.ErrorCodeMapping)
+		c.JSON(lastError.StatusCode, app_errors.NewUpstreamErrorEnvelope(lastError.StatusCode, []byte(lastError.ErrorMessage), mapping))
+	} else if err := json.Unmarshal([]byte(lastError.ErrorMessage), &errorJSON); err == nil {
+		c.JSON(lastError.StatusCode, errorJSON)
+	} else {
+		response.Error(c, app_errors.NewAPIErrorWithUpstream(lastError.StatusCode, "UPSTREAM_ERROR", lastError.ErrorMessage))
+	}
+
+	ps.logRequest(c, group, &models.APIKey{KeyValue: lastError.KeyValue}, startTime, lastError.StatusCode, attempts, errors.New(lastError.LogMessage()), isStream, lastError.UpstreamAddr, channelHandler, bodyBytes)
+}
+
+// buildUpstreamRequest creates the request for one upstream attempt with the given key,
+// carrying the client's headers without its credentials and the group's header rules.
+func (ps *ProxyServer) buildUpstreamRequest(
+	ctx context.Context,
+	c *gin.Context,
+	channelHandler channel.ChannelProxy,
+	group *models.Group,
+	apiKey *models.APIKey,
+	upstreamURL string,
+	bodyBytes []byte,
+	isStream bool,
+	timeout time.Duration,
+	budgeted bool,
+) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, c.Request.Method, upstreamURL, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(bodyBytes))
+	if c.GetBool(unbufferedBodyKey) {
+		// The client's body is handed over as it arrives, with its declared length
+		req.Body = c.Request.Body
+		req.ContentLength = c.Request.ContentLength
+		req.GetBody = nil
+	}
+
+	req.Header = c.Request.Header.Clone()
+
+	// Clean up client auth key
+	req.Header.Del("Authorization")
+	req.Header.Del("X-Api-Key")
+	req.Header.Del("X-Goog-Api-Key")
+
+	// Passed-through streams have nobody to strip the done token from the response
+	injectDone := injectDoneRequested(req.Header) && !usesSimpleStreaming(group, channelHandler.GetChannelType())
+	req.Header.Del(InjectDoneHeader)
+	req.Header.Del(TokenBudgetHeader)
+	req.Header.Del(ResolveConfigHeader)
+	req.Header.Del(ModelHeader)
+	q := req.URL.Query()
+	q.Del("key")
+	req.URL.RawQuery = q.Encode()
+
+	if budgeted {
+		setRequestTimeoutHeader(req, timeout)
+	}
+
+	// Apply custom header rules
+	if len(group.HeaderRuleList) > 0 {
+		headerCtx := utils.NewHeaderVariableContextFromGin(c, group, apiKey)
+		utils.ApplyHeaderRules(req, group.HeaderRuleList, headerCtx)
+	}
+
+	channelHandler.ModifyRequest(req, apiKey, group)
+	channel.ApplyMetadataHeaders(channelHandler, req, group)
+
+	if isStream {
+		if !isBodylessRequest(req.Method, bodyBytes) {
+			channelHandler.ReshapeStreamReqBody(req, injectDone)
+		}
+		req.Header.Set("X-Accel-Buffering", "no")
+	}
+	return req, nil
+}
+
 // logRequest is a helper function to create and record a request log.
 func (ps *ProxyServer) logRequest(
 	c *gin.Context,
@@ -271,12 +494,18 @@ func (ps *ProxyServer) logRequest(
 		return
 	}
 
+	// Failures are rare and always worth keeping, so only successful requests are sampled
+	isSuccess := finalError == nil && statusCode < 400
+	if isSuccess && !sampleRequestLog(group) {
+		return
+	}
+
 	duration := time.Since(startTime).Milliseconds()
 
 	logEntry := &models.RequestLog{
 		GroupID:      group.ID,
 		GroupName:    group.Name,
-		IsSuccess:    finalError == nil && statusCode < 400,
+		IsSuccess:    isSuccess,
 		SourceIP:     c.ClientIP(),
 		StatusCode:   statusCode,
 		RequestPath:  utils.TruncateString(c.Request.URL.String(), 500),
@@ -291,6 +520,10 @@ func (ps *ProxyServer) logRequest(
 		logEntry.Model = channelHandler.ExtractModel(c, bodyBytes)
 	}
 
+	if len(bodyBytes) > 0 && sampleRequestBody(group) {
+		logEntry.RequestBody = utils.TruncateString(string(bodyBytes), maxLoggedBodyBytes)
+	}
+
 	if apiKey != nil {
 		logEntry.KeyValue = apiKey.KeyValue
 	}
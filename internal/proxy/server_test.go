@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gpt-load/internal/keypool"
+	"gpt-load/internal/models"
+	"gpt-load/internal/store"
+	"gpt-load/internal/streaming"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unreachableFirstChannel sends its first request to an address that refuses connections.
+type unreachableFirstChannel struct {
+	*stubChannel
+	deadAddr string
+	keys     []string
+}
+
+func (u *unreachableFirstChannel) ModifyRequest(req *http.Request, apiKey *models.APIKey, group *models.Group) {
+	u.stubChannel.ModifyRequest(req, apiKey, group)
+	u.keys = append(u.keys, apiKey.KeyValue)
+	if len(u.keys) == 1 {
+		req.URL.Host = u.deadAddr
+	}
+}
+
+func TestFirstStreamRequestConnectFailureRetriesWithAnotherKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var receivedBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receivedBody = string(body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	// A listener that is closed again leaves an address nothing accepts connections on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve an address: %v", err)
+	}
+	deadAddr := listener.Addr().String()
+	listener.Close()
+
+	group := &models.Group{ID: 1, Name: "first-request"}
+	group.EffectiveConfig.MaxRetries = 2
+	group.EffectiveConfig.StreamingMode = StreamingModeSimple
+	// The keys are already marked invalid, so their failures don't touch the database
+	memStore := store.NewMemoryStore()
+	for i, key := range []string{"sk-one", "sk-two"} {
+		memStore.HSet(fmt.Sprintf("key:%d", i+1), map[string]any{"key_string": key, "status": models.KeyStatusInvalid})
+		memStore.LPush("group:1:active_keys", fmt.Sprint(i+1))
+	}
+	ps := &ProxyServer{keyProvider: keypool.NewProvider(nil, memStore, nil), retrySlots: &retrySemaphore{}}
+	ch := &unreachableFirstChannel{stubChannel: &stubChannel{upstream: server.URL, channelType: "openai"}, deadAddr: deadAddr}
+
+	requestBody := `{"stream":true,"messages":[{"role":"user","content":"Hi"}]}`
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(requestBody))
+	ps.executeRequestWithRetry(c, ch, group, []byte(requestBody), true, time.Now(), 0, nil)
+
+	if len(ch.keys) != 2 || ch.keys[0] == ch.keys[1] {
+		t.Fatalf("Expected the failed connection to be retried once with another key, got keys %v", ch.keys)
+	}
+	if receivedBody != requestBody {
+		t.Errorf("Expected the retry to send the original body, got %q", receivedBody)
+	}
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"content":"Hi"`) {
+		t.Errorf("Expected the retried stream to reach the client, got %d %q", recorder.Code, recorder.Body.String())
+	}
+	if got := recorder.Header().Get(streaming.AttemptsHeader); got != "2" {
+		t.Errorf("Expected the attempts header to count the failed connection, got %q", got)
+	}
+}
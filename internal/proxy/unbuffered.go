@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"gpt-load/internal/channel"
+	"gpt-load/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unbufferedBodyKey marks a request whose body is sent upstream as it arrives instead of
+// being read into memory first. Such a request gets a single attempt, since its body can
+// only be read once.
+const unbufferedBodyKey = "unbufferedBody"
+
+// forwardsUnbuffered reports whether a request body should be sent upstream without being
+// buffered: it declares a length of at least the group's unbuffered_body_kb, and the group
+// needs nothing that reads or rewrites the body. That rules out parameter clamps and
+// overrides, the upstream user tag, stream_options injection, non-streaming models,
+// fallback groups, model header overrides and intelligent streaming with its done-token
+// injection and retries.
+// Streams requested only in the body cannot be recognized and are passed through.
+func forwardsUnbuffered(c *gin.Context, channelHandler channel.ChannelProxy, group *models.Group) bool {
+	cfg := group.EffectiveConfig
+	threshold := int64(cfg.UnbufferedBodyKB) * 1024
+	if threshold <= 0 || c.Request.ContentLength < threshold {
+		return false
+	}
+	if len(group.ParamOverrides) > 0 || len(parseParamClamps(cfg.ParamClamps)) > 0 || cfg.UpstreamUserTag != "" ||
+		cfg.StreamIncludeUsage > 0 || cfg.NonStreamingModels != "" || cfg.FallbackGroups != "" ||
+		(cfg.AllowedHeaderModels != "" && c.GetHeader(ModelHeader) != "") {
+		return false
+	}
+	if resolveConfigRequested(c, group) {
+		return false
+	}
+	return !channelHandler.IsStreamRequest(c, nil) || usesSimpleStreaming(group, channelHandler.GetChannelType())
+}
+
+// isEventStream reports whether an upstream response is a server-sent event stream.
+func isEventStream(resp *http.Response) bool {
+	return strings.HasPrefix(strings.ToLower(resp.Header.Get("Content-Type")), "text/event-stream")
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gpt-load/internal/channel"
+	"gpt-load/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLargeBodyIsForwardedUnbuffered(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const half = 64 * 1024
+	firstHalf := make(chan struct{})
+	var received int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadFull(r.Body, make([]byte, half)); err == nil {
+			close(firstHalf)
+		}
+		rest, _ := io.ReadAll(r.Body)
+		received = half + len(rest)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	group := &models.Group{ID: 1, Name: "large"}
+	group.EffectiveConfig.RequestTimeout = 600
+	group.EffectiveConfig.UnbufferedBodyKB = 100
+	groups := &stubGroups{
+		groups:   map[string]*models.Group{"large": group},
+		channels: map[string]channel.ChannelProxy{"large": &stubChannel{upstream: server.URL, channelType: "openai"}},
+	}
+	ps := &ProxyServer{keyProvider: newTestKeyProvider(1), groupManager: groups, channelFactory: groups, retrySlots: &retrySemaphore{}}
+
+	// The second half is only sent once the upstream has the first, which never happens if
+	// the proxy waits for the whole body before contacting it
+	body, bodyWriter := io.Pipe()
+	streamed := make(chan bool, 1)
+	go func() {
+		bodyWriter.Write(bytes.Repeat([]byte("a"), half))
+		select {
+		case <-firstHalf:
+			streamed <- true
+		case <-time.After(2 * time.Second):
+			streamed <- false
+		}
+		bodyWriter.Write(bytes.Repeat([]byte("b"), half))
+		bodyWriter.Close()
+	}()
+
+	engine := gin.New()
+	engine.Any("/proxy/:group_name/*path", ps.HandleProxy)
+	req := httptest.NewRequest(http.MethodPost, "/proxy/large/v1/files", body)
+	req.ContentLength = 2 * half
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, req)
+
+	if !<-streamed {
+		t.Error("Expected the body to reach the upstream before the client finished sending it")
+	}
+	if recorder.Code != http.StatusOK || received != 2*half {
+		t.Errorf("Expected the whole body to be forwarded, got status %d with %d bytes", recorder.Code, received)
+	}
+}
+
+func TestForwardsUnbufferedOnlyWithoutBodyRewrites(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	check := func(contentLength int64, configure func(group *models.Group)) bool {
+		group := &models.Group{Name: "large"}
+		group.EffectiveConfig.UnbufferedBodyKB = 1
+		configure(group)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		c.Request.ContentLength = contentLength
+		return forwardsUnbuffered(c, &stubChannel{channelType: "openai"}, group)
+	}
+
+	if !check(2048, func(group *models.Group) {}) {
+		t.Error("Expected a large body to be forwarded unbuffered")
+	}
+	if check(512, func(group *models.Group) {}) {
+		t.Error("Expected a body below the threshold to be buffered")
+	}
+	if check(-1, func(group *models.Group) {}) {
+		t.Error("Expected a body of unknown length to be buffered")
+	}
+	if check(2048, func(group *models.Group) { group.ParamOverrides = map[string]any{"temperature": 0.5} }) {
+		t.Error("Expected parameter overrides to require buffering")
+	}
+	if check(2048, func(group *models.Group) { group.EffectiveConfig.StreamingMode = StreamingModeIntelligent }) {
+		t.Error("Expected intelligent streaming to require buffering")
+	}
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	app_errors "gpt-load/internal/errors"
+	"gpt-load/internal/response"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// groupLister lists the names of all groups, as services.GroupManager does.
+type groupLister interface {
+	GroupNames() []string
+}
+
+// UnknownGroupHandling answers requests for a group that does not exist with a 404 before
+// authentication, which could only fail on the missing group. By default the 404 is generic;
+// with unknown_group_routes enabled it lists the available proxy routes, which helps while
+// integrating but reveals the group names. It runs after the default group routing, so a
+// configured default group still serves such requests.
+func (ps *ProxyServer) UnknownGroupHandling() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		groupName := c.Param("group_name")
+		if _, err := ps.groupManager.GetGroupByName(groupName); !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.Next()
+			return
+		}
+
+		apiErr := app_errors.ErrResourceNotFound
+		if ps.settingsManager != nil && ps.settingsManager.GetSettings().UnknownGroupRoutes > 0 {
+			apiErr = app_errors.NewAPIError(app_errors.ErrResourceNotFound, fmt.Sprintf("Group '%s' not found. Available routes: %s", groupName, ps.availableRoutes()))
+		}
+		response.Error(c, apiErr)
+		c.Abort()
+	}
+}
+
+// availableRoutes lists the proxy route of every group.
+func (ps *ProxyServer) availableRoutes() string {
+	lister, ok := ps.groupManager.(groupLister)
+	if !ok {
+		return "unknown"
+	}
+	names := lister.GroupNames()
+	if len(names) == 0 {
+		return "none"
+	}
+	routes := make([]string, len(names))
+	for i, name := range names {
+		routes[i] = "/proxy/" + name
+	}
+	return strings.Join(routes, ", ")
+}
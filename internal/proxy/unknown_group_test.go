@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gpt-load/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUnknownGroupReturnsNotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	groups := &stubGroups{groups: map[string]*models.Group{
+		"main":   {ID: 1, Name: "main"},
+		"backup": {ID: 2, Name: "backup"},
+	}}
+	settings := &stubSettings{}
+	ps := &ProxyServer{groupManager: groups, settingsManager: settings}
+
+	reached := false
+	engine := gin.New()
+	engine.Any("/proxy/:group_name/*path", ps.UnknownGroupHandling(), func(c *gin.Context) {
+		reached = true
+		c.Status(http.StatusOK)
+	})
+	send := func(group string) (int, string) {
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/proxy/"+group+"/v1/chat/completions", strings.NewReader(`{}`)))
+		var body struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(recorder.Body.Bytes(), &body)
+		return recorder.Code, body.Message
+	}
+
+	code, message := send("unknown")
+	if code != http.StatusNotFound {
+		t.Fatalf("Expected an unknown group to return 404, got %d", code)
+	}
+	if message != "Resource not found" {
+		t.Errorf("Expected a generic message by default, got %q", message)
+	}
+
+	settings.settings.UnknownGroupRoutes = 1
+	code, message = send("unknown")
+	if code != http.StatusNotFound {
+		t.Fatalf("Expected an unknown group to return 404, got %d", code)
+	}
+	if !strings.Contains(message, "'unknown'") || !strings.Contains(message, "/proxy/backup, /proxy/main") {
+		t.Errorf("Expected the message to name the group and list the routes, got %q", message)
+	}
+
+	if code, _ := send("main"); code != http.StatusOK || !reached {
+		t.Errorf("Expected a known group to pass through, got %d", code)
+	}
+}
@@ -163,7 +163,7 @@ func registerProxyRoutes(
 ) {
 	proxyGroup := router.Group("/proxy")
 
-	proxyGroup.Use(middleware.ProxyAuth(groupManager))
+	proxyGroup.Use(proxyServer.DefaultGroupRouting(), proxyServer.RouteHeaderRouting(), proxyServer.UnknownGroupHandling(), middleware.ProxyAuth(groupManager))
 
 	proxyGroup.Any("/:group_name/*path", proxyServer.HandleProxy)
 }
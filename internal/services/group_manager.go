@@ -4,11 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"gpt-load/internal/channel"
 	"gpt-load/internal/config"
 	"gpt-load/internal/models"
 	"gpt-load/internal/store"
 	"gpt-load/internal/syncer"
 	"gpt-load/internal/utils"
+	"sort"
 
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
@@ -22,6 +24,7 @@ type GroupManager struct {
 	db              *gorm.DB
 	store           store.Store
 	settingsManager *config.SystemSettingsManager
+	channelFactory  *channel.Factory
 }
 
 // NewGroupManager creates a new, uninitialized GroupManager.
@@ -29,11 +32,13 @@ func NewGroupManager(
 	db *gorm.DB,
 	store store.Store,
 	settingsManager *config.SystemSettingsManager,
+	channelFactory *channel.Factory,
 ) *GroupManager {
 	return &GroupManager{
 		db:              db,
 		store:           store,
 		settingsManager: settingsManager,
+		channelFactory:  channelFactory,
 	}
 }
 
@@ -72,12 +77,21 @@ func (gm *GroupManager) Initialize() error {
 		return groupMap, nil
 	}
 
+	// Release the channels and warm-up loops of groups that were deleted
+	afterLoader := func(groups map[string]*models.Group) {
+		groupIDs := make([]uint, 0, len(groups))
+		for _, group := range groups {
+			groupIDs = append(groupIDs, group.ID)
+		}
+		gm.channelFactory.RetainGroups(groupIDs)
+	}
+
 	syncer, err := syncer.NewCacheSyncer(
 		loader,
 		gm.store,
 		GroupUpdateChannel,
 		logrus.WithField("syncer", "groups"),
-		nil,
+		afterLoader,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create group syncer: %w", err)
@@ -100,6 +114,21 @@ func (gm *GroupManager) GetGroupByName(name string) (*models.Group, error) {
 	return group, nil
 }
 
+// GroupNames returns the names of all cached groups, sorted.
+func (gm *GroupManager) GroupNames() []string {
+	if gm.syncer == nil {
+		return nil
+	}
+
+	groups := gm.syncer.Get()
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Invalidate triggers a cache reload across all instances.
 func (gm *GroupManager) Invalidate() error {
 	if gm.syncer == nil {
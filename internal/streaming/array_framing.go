@@ -0,0 +1,115 @@
+package streaming
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// peekFirstByte returns the first non-space byte of a body without consuming it, or 0 if
+// the body ends first. Gemini's :streamGenerateContent without ?alt=sse frames its stream as
+// a JSON array, so a leading '[' distinguishes it from SSE.
+func peekFirstByte(body *bufio.Reader) byte {
+	for n := 1; ; n++ {
+		peeked, err := body.Peek(n)
+		if len(peeked) < n {
+			return 0
+		}
+		switch c := peeked[n-1]; c {
+		case ' ', '\t', '\r', '\n':
+			if err != nil {
+				return 0
+			}
+		default:
+			return c
+		}
+	}
+}
+
+// peekableBody is an upstream body whose first bytes can be inspected before it is read.
+type peekableBody struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// newPeekableBody wraps a body for peeking, reusing it if it already is peekable.
+func newPeekableBody(body io.ReadCloser) *peekableBody {
+	if peekable, ok := body.(*peekableBody); ok {
+		return peekable
+	}
+	return &peekableBody{Reader: bufio.NewReader(body), Closer: body}
+}
+
+// jsonArrayReader turns an incrementally arriving JSON array of objects into SSE data lines,
+// one per element, so array-framed streams go through the same parsing, completion checks
+// and retries as SSE streams. Each element is emitted as soon as it has been received. An
+// array that ends before its closing bracket fails with io.ErrUnexpectedEOF, like a dropped
+// connection.
+type jsonArrayReader struct {
+	decoder *json.Decoder
+	pending []byte
+	started bool
+	err     error
+}
+
+func newJSONArrayReader(body io.Reader) *jsonArrayReader {
+	return &jsonArrayReader{decoder: json.NewDecoder(body)}
+}
+
+func (r *jsonArrayReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.next()
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// next decodes the next array element into pending, or records why there is none.
+func (r *jsonArrayReader) next() {
+	if !r.started {
+		if _, err := r.decoder.Token(); err != nil {
+			r.err = unexpectedEOF(err)
+			return
+		}
+		r.started = true
+	}
+
+	if !r.decoder.More() {
+		// Consume the closing bracket, which a truncated stream never sends
+		if _, err := r.decoder.Token(); err != nil {
+			r.err = unexpectedEOF(err)
+			return
+		}
+		r.err = io.EOF
+		return
+	}
+
+	var element json.RawMessage
+	if err := r.decoder.Decode(&element); err != nil {
+		r.err = unexpectedEOF(err)
+		return
+	}
+
+	var line bytes.Buffer
+	line.WriteString("data: ")
+	if err := json.Compact(&line, element); err != nil {
+		r.err = err
+		return
+	}
+	line.WriteString("\n\n")
+	r.pending = line.Bytes()
+}
+
+// unexpectedEOF reports the end of input inside the array as a truncation.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,26 @@
+package streaming
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// DefaultMaxRetryDelay caps a growing retry delay when no other cap is configured.
+const DefaultMaxRetryDelay = 30 * time.Second
+
+// retryBackoffJitter is the largest random share of the delay added to a backed-off retry.
+const retryBackoffJitter = 0.1
+
+// retryDelayFor returns the wait before the given retry, 1 for the first. Without a backoff
+// factor above 1 it is always the retry delay. Otherwise the delay grows by the factor with
+// each retry up to the maximum, and a random jitter of up to a tenth of it is added, so
+// streams cut off by the same rate-limited upstream don't all come back at once.
+func (sh *StreamHandler) retryDelayFor(retry int) time.Duration {
+	if sh.retryBackoffFactor <= 1 {
+		return sh.retryDelay
+	}
+	delay := float64(sh.retryDelay) * math.Pow(sh.retryBackoffFactor, float64(retry-1))
+	delay = math.Min(delay, float64(sh.maxRetryDelay))
+	return time.Duration(delay + rand.Float64()*retryBackoffJitter*delay)
+}
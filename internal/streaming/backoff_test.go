@@ -0,0 +1,24 @@
+package streaming
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelayBackoff(t *testing.T) {
+	fixed := NewStreamHandler(StreamConfig{RetryDelay: 100 * time.Millisecond})
+	for retry := 1; retry <= 4; retry++ {
+		if got := fixed.retryDelayFor(retry); got != 100*time.Millisecond {
+			t.Errorf("Expected a fixed delay by default, got %v for retry %d", got, retry)
+		}
+	}
+
+	backoff := NewStreamHandler(StreamConfig{RetryDelay: 100 * time.Millisecond, RetryBackoffFactor: 2, MaxRetryDelay: 500 * time.Millisecond})
+	for retry, base := range map[int]time.Duration{1: 100, 2: 200, 3: 400, 4: 500, 10: 500} {
+		base *= time.Millisecond
+		got := backoff.retryDelayFor(retry)
+		if got < base || got > base+base/10 {
+			t.Errorf("Expected retry %d to wait %v plus up to 10%% jitter, got %v", retry, base, got)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package streaming
+
+import "strings"
+
+// CompletionCodeFences means the stream stalled with every code fence closed.
+const CompletionCodeFences CompletionReason = "code_fences"
+
+// codeFencesBalanced reports whether text holds at least one fenced code block and closes
+// every fence it opens. Code rarely ends with sentence punctuation, so for code-generation
+// workloads balanced fences are a better sign that a stalled stream is complete.
+func codeFencesBalanced(text string) bool {
+	fences := 0
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimLeft(line, " \t"), "```") {
+			fences++
+		}
+	}
+	return fences > 0 && fences%2 == 0
+}
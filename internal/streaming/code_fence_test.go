@@ -0,0 +1,49 @@
+package streaming
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCodeFencesBalanced(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected bool
+	}{
+		{"Here you go:\n```go\nfmt.Println(1)\n```", true},
+		{"```\na\n```\nThen:\n  ```python\nb\n  ```\n", true},
+		{"Here you go:\n```go\nfmt.Println(1)\n", false},
+		{"```\na\n```\n```\nb", false},
+		{"No code at all", false},
+		{"Inline ``` in a sentence", false},
+	}
+	for _, test := range tests {
+		if got := codeFencesBalanced(test.text); got != test.expected {
+			t.Errorf("%q: expected %v, got %v", test.text, test.expected, got)
+		}
+	}
+}
+
+func TestCodeFenceCompletion(t *testing.T) {
+	run := func(first string) int {
+		handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, CodeFenceCompletion: true})
+		retries := 0
+		retryFunc := func(accumulatedText string) (*http.Response, error) {
+			retries++
+			return newStreamResponse(geminiChunk("[done]")), nil
+		}
+		if err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk(first)), httptest.NewRecorder(), "gemini", nil, retryFunc); err != nil {
+			t.Fatalf("Expected stream to complete, got %v", err)
+		}
+		return retries
+	}
+
+	if retries := run("```go\\nfunc main() {}\\n```"); retries != 0 {
+		t.Errorf("Expected balanced fences to complete the stream, got %d retries", retries)
+	}
+	if retries := run("```go\\nfunc main() {"); retries != 1 {
+		t.Errorf("Expected an open fence to be retried, got %d retries", retries)
+	}
+}
@@ -0,0 +1,93 @@
+package streaming
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ParseContinuationFillers splits the continuation_filler_phrases setting. Phrases are
+// separated by "|", since they usually contain commas, and the longest is tried first.
+func ParseContinuationFillers(value string) []string {
+	var phrases []string
+	for _, phrase := range strings.Split(value, "|") {
+		if phrase = strings.TrimSpace(phrase); phrase != "" {
+			phrases = append(phrases, phrase)
+		}
+	}
+	sort.SliceStable(phrases, func(i, j int) bool { return len(phrases[i]) > len(phrases[j]) })
+	return phrases
+}
+
+// continuationFiller strips a filler phrase, such as "Sure, continuing:", that a model
+// opens a continuation with despite being asked to carry on seamlessly. Phrases match
+// ignoring case, and one ending in a letter or digit only at the end of a word. Text is held
+// back while it could still become a phrase; once that is decided the filter is done, so
+// the same phrase later in the text is never touched.
+type continuationFiller struct {
+	phrases []string
+	pending string
+	done    bool
+}
+
+func (f *continuationFiller) filter(text string) (string, bool) {
+	if f.done {
+		return text, false
+	}
+
+	f.pending += text
+	trimmed := strings.TrimLeft(f.pending, " \t\r\n")
+	leading := f.pending[:len(f.pending)-len(trimmed)]
+	for _, phrase := range f.phrases {
+		if hasPrefixFold(phrase, trimmed) && (len(trimmed) < len(phrase) || endsInWord(phrase)) {
+			return "", true
+		}
+	}
+
+	out := f.pending
+	for _, phrase := range f.phrases {
+		if !hasPrefixFold(trimmed, phrase) {
+			continue
+		}
+		rest := trimmed[len(phrase):]
+		if next, _ := utf8.DecodeRuneInString(rest); endsInWord(phrase) && isWordRune(next) {
+			continue
+		}
+		if leading != "" {
+			rest = strings.TrimLeft(rest, " \t")
+		}
+		out = leading + rest
+		break
+	}
+	f.done = true
+	f.pending = ""
+	return out, false
+}
+
+func (f *continuationFiller) release() string {
+	out := f.pending
+	f.done = true
+	f.pending = ""
+	return out
+}
+
+func (f *continuationFiller) finished() bool {
+	return f.done
+}
+
+// hasPrefixFold reports whether s begins with prefix, ignoring case.
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// endsInWord reports whether a phrase ends in a letter or digit, so it only matches a
+// whole word.
+func endsInWord(phrase string) bool {
+	last, _ := utf8.DecodeLastRuneInString(phrase)
+	return isWordRune(last)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,65 @@
+package streaming
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContinuationFillerIsStripped(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{
+		MaxRetries:          2,
+		RetryDelay:          time.Millisecond,
+		ContinuationFillers: ParseContinuationFillers("Sure, | Okay,"),
+	})
+
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		// The filler arrives split across chunks, and the phrase shows up again later
+		return newStreamResponse(geminiChunk("Su") + geminiChunk("re, fox jumps.") + geminiChunk(" Sure, it does. [done]")), nil
+	}
+
+	recorder := httptest.NewRecorder()
+	err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk("Sure, the quick brown")), recorder, "gemini", nil, retryFunc)
+	if err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, `"text":"Sure, the quick brown"`) {
+		t.Errorf("Expected the first attempt to be forwarded untouched, got body %q", body)
+	}
+	if !strings.Contains(body, `"text":" fox jumps."`) {
+		t.Errorf("Expected the filler to be stripped from the continuation, got body %q", body)
+	}
+	if !strings.Contains(body, `"text":" Sure, it does."`) {
+		t.Errorf("Expected the phrase later in the continuation to be untouched, got body %q", body)
+	}
+}
+
+func TestContinuationFillerMatchesWholeWords(t *testing.T) {
+	phrases := ParseContinuationFillers("Okay|Sure, continuing:|Sure,")
+	if phrases[0] != "Sure, continuing:" {
+		t.Errorf("Expected the longest phrase to be tried first, got %q", phrases)
+	}
+
+	filter := func(chunks ...string) string {
+		f := &continuationFiller{phrases: phrases}
+		var out string
+		for _, chunk := range chunks {
+			text, _ := f.filter(chunk)
+			out += text
+		}
+		return out
+	}
+
+	if got := filter("Okay", "ama is a city"); got != "Okayama is a city" {
+		t.Errorf("Expected a phrase inside a longer word to be kept, got %q", got)
+	}
+	if got := filter("Sure, cont", "inuing: the rest"); got != " the rest" {
+		t.Errorf("Expected the longest matching phrase to be stripped, got %q", got)
+	}
+	if got := filter("sure, the rest"); got != " the rest" {
+		t.Errorf("Expected phrases to match ignoring case, got %q", got)
+	}
+}
@@ -0,0 +1,105 @@
+package streaming
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// continuationFilter rewrites the opening text of a continuation. It may hold text back
+// until it can tell how the continuation starts.
+type continuationFilter interface {
+	// filter returns the text to forward for a chunk, and whether the chunk is held back.
+	filter(text string) (string, bool)
+	// release gives up and returns whatever text was held back.
+	release() string
+	// finished reports whether the filter no longer changes any text.
+	finished() bool
+}
+
+// continuationMarker strips the marker a continuation was asked to open with. Text is held
+// back while it could still be the start of the marker, so a marker split across chunks
+// never reaches the client; once the text is known to start with the marker or not, the
+// held text is released with the marker removed.
+type continuationMarker struct {
+	marker  string
+	pending string
+	done    bool
+}
+
+func (m *continuationMarker) filter(text string) (string, bool) {
+	if m.done {
+		return text, false
+	}
+
+	m.pending += text
+	trimmed := strings.TrimLeft(m.pending, " \t\r\n")
+	switch {
+	case strings.HasPrefix(trimmed, m.marker):
+		m.done = true
+		m.pending = ""
+		return strings.TrimPrefix(trimmed, m.marker), false
+	case strings.HasPrefix(m.marker, trimmed):
+		return "", true
+	default:
+		// The model skipped the marker, so everything held belongs to the answer
+		out := m.pending
+		m.done = true
+		m.pending = ""
+		return out, false
+	}
+}
+
+func (m *continuationMarker) release() string {
+	out := m.pending
+	m.done = true
+	m.pending = ""
+	return out
+}
+
+func (m *continuationMarker) finished() bool {
+	return m.done
+}
+
+// applyContinuationFilter applies a continuation filter to an SSE data line, rewriting the
+// event's text when the filter or held text changes it. It reports whether the line is
+// held back entirely. Events without rewritable text pass through untouched.
+func (sh *StreamHandler) applyContinuationFilter(f continuationFilter, line string, channelType string) (string, bool) {
+	if f.finished() || !strings.HasPrefix(line, "data: ") || isDoneSignal(line) {
+		return line, false
+	}
+	dataContent := strings.TrimPrefix(line, "data: ")
+	if !utf8.ValidString(line) {
+		// Re-encoding would mangle the bytes, so the text is left in place
+		f.release()
+		return line, false
+	}
+
+	event, err := decodeEvent(dataContent)
+	if err != nil {
+		return line, false
+	}
+	text, ok := chunkText(event, channelType)
+	if !ok || text == "" {
+		return line, false
+	}
+
+	out, held := f.filter(text)
+	if held {
+		if !sh.hasProtocolSignal(event, channelType) {
+			return "", true
+		}
+		// The stream ends here, so the held text is delivered as it is
+		out = f.release()
+	}
+	if out == text {
+		return line, false
+	}
+
+	setChunkText(event, channelType, out)
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return line, false
+	}
+	return "data: " + string(payload), false
+}
@@ -0,0 +1,62 @@
+package streaming
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContinuationMarkerIsStripped(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{
+		MaxRetries:         2,
+		RetryDelay:         time.Millisecond,
+		ContinuationMarker: "@@CONTINUE@@",
+	})
+
+	var retryContext string
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retryContext = accumulatedText
+		// The marker arrives split across two chunks
+		return newStreamResponse(geminiChunk(" @@CONT") + geminiChunk("INUE@@ fox jumps.") + geminiChunk("[done]")), nil
+	}
+
+	recorder := httptest.NewRecorder()
+	err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk("The quick brown")), recorder, "gemini", nil, retryFunc)
+	if err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	if retryContext != "The quick brown" {
+		t.Errorf("Expected retry to continue from the first attempt, got %q", retryContext)
+	}
+	body := recorder.Body.String()
+	if strings.Contains(body, "CONT") {
+		t.Errorf("Expected the marker to be stripped, got body %q", body)
+	}
+	if !strings.Contains(body, `"text":" fox jumps."`) {
+		t.Errorf("Expected the continuation text after the marker, got body %q", body)
+	}
+}
+
+func TestContinuationWithoutMarkerIsForwarded(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{
+		MaxRetries:         2,
+		RetryDelay:         time.Millisecond,
+		ContinuationMarker: "@@CONTINUE@@",
+	})
+
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		return newStreamResponse(openAIDeltaEvent("@") + openAIDeltaEvent("@ fox") + "data: [DONE]\n\n"), nil
+	}
+
+	recorder := httptest.NewRecorder()
+	err := handler.HandleStreamingResponse(newStreamResponse(openAIDeltaEvent("The quick")), recorder, "openai", nil, retryFunc)
+	if err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, `"content":"@@ fox"`) {
+		t.Errorf("Expected the held text to be released with the next chunk, got body %q", body)
+	}
+}
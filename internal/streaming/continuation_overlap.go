@@ -0,0 +1,91 @@
+package streaming
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultGeminiOverlapWindow is the number of bytes at the end of the accumulated text that a
+// Gemini continuation is compared against.
+const DefaultGeminiOverlapWindow = 512
+
+// minOverlapBytes is the shortest repeat that is suppressed. Shorter matches, such as a
+// single word, are as likely to be a coincidence as a repeat.
+const minOverlapBytes = 16
+
+// continuationOverlap suppresses text a continuation repeats from the answer so far. Asked to
+// continue exactly where it left off, a model often restates the last sentence or two, or
+// starts the whole answer over. The opening of the continuation is held back while it could
+// still be such a repeat: text that starts at a word within the window at the end of the
+// accumulated text and runs to its end, or the accumulated text as a whole. Once the
+// continuation covers one completely, the longest is dropped. If it turns out to be new text,
+// everything held is released as it is.
+type continuationOverlap struct {
+	accumulated string
+	window      int
+	candidates  []string
+	pending     string
+	done        bool
+}
+
+func newContinuationOverlap(accumulated string, window int) *continuationOverlap {
+	o := &continuationOverlap{accumulated: accumulated, window: window}
+	if whole := strings.TrimLeftFunc(accumulated, unicode.IsSpace); len(whole) >= minOverlapBytes {
+		o.candidates = append(o.candidates, whole)
+	}
+	for i := max(1, len(accumulated)-window); i <= len(accumulated)-minOverlapBytes; i++ {
+		if startsWord(accumulated, i) {
+			o.candidates = append(o.candidates, accumulated[i:])
+		}
+	}
+	return o
+}
+
+// startsWord reports whether a word starts at byte i of s.
+func startsWord(s string, i int) bool {
+	if !utf8.RuneStart(s[i]) {
+		return false
+	}
+	current, _ := utf8.DecodeRuneInString(s[i:])
+	previous, _ := utf8.DecodeLastRuneInString(s[:i])
+	return !unicode.IsSpace(current) && unicode.IsSpace(previous)
+}
+
+func (o *continuationOverlap) filter(text string) (string, bool) {
+	if o.done {
+		return text, false
+	}
+
+	o.pending += text
+	trimmed := strings.TrimLeftFunc(o.pending, unicode.IsSpace)
+	repeated := ""
+	for _, candidate := range o.candidates {
+		switch {
+		case strings.HasPrefix(candidate, trimmed) && len(candidate) > len(trimmed):
+			// It could still become this repeat, or a longer one
+			return "", true
+		case strings.HasPrefix(trimmed, candidate) && len(candidate) > len(repeated):
+			repeated = candidate
+		}
+	}
+
+	out := o.pending
+	if repeated != "" {
+		out = trimmed[len(repeated):]
+	}
+	o.done = true
+	o.pending = ""
+	return out, false
+}
+
+func (o *continuationOverlap) release() string {
+	out := o.pending
+	o.done = true
+	o.pending = ""
+	return out
+}
+
+func (o *continuationOverlap) finished() bool {
+	return o.done
+}
@@ -0,0 +1,72 @@
+package streaming
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContinuationOverlapIsSuppressed(t *testing.T) {
+	first := "The quick brown fox jumps over the lazy dog. It was a bright and sunny"
+
+	tests := []struct {
+		name     string
+		window   int
+		retry    []string
+		wantText string
+	}{
+		{"repeated last sentence", 512, []string{"It was a bright and sun", "ny day. The end. [done]"}, `"text":" day. The end."`},
+		{"restarted answer beyond the window", 24, []string{"The quick brown fox jumps over the lazy dog.", " It was a bright and sunny", " day. [done]"}, `"text":" day."`},
+		{"new text", 512, []string{" day, and the fox slept. [done]"}, `"text":" day, and the fox slept."`},
+		{"short coincidence", 512, []string{"sunny and warm. [done]"}, `"text":"sunny and warm."`},
+		{"disabled", 0, []string{"It was a bright and sunny day. [done]"}, `"text":"It was a bright and sunny day."`},
+	}
+
+	for _, test := range tests {
+		handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]"}, OverlapWindow: test.window})
+		var chunks strings.Builder
+		for _, chunk := range test.retry {
+			chunks.WriteString(geminiChunk(chunk))
+		}
+		var contexts []string
+		retryFunc := func(accumulatedText string) (*http.Response, error) {
+			contexts = append(contexts, accumulatedText)
+			if len(contexts) > 1 {
+				return newStreamResponse(geminiChunk("[done]")), nil
+			}
+			return newStreamResponse(chunks.String()), nil
+		}
+
+		recorder := httptest.NewRecorder()
+		if err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk(first)), recorder, "gemini", nil, retryFunc); err != nil {
+			t.Fatalf("%s: expected stream to complete, got %v", test.name, err)
+		}
+		if body := recorder.Body.String(); !strings.Contains(body, test.wantText) {
+			t.Errorf("%s: expected %s to be forwarded, got %q", test.name, test.wantText, body)
+		}
+		if len(contexts) != 1 {
+			t.Errorf("%s: expected the continuation to complete the answer, got retries from %q", test.name, contexts)
+		}
+	}
+}
+
+func TestContinuationOverlapHoldsPossibleRepeat(t *testing.T) {
+	o := newContinuationOverlap("First sentence here. Second sentence here.", 512)
+	if out, held := o.filter(" Second sent"); !held || out != "" {
+		t.Errorf("Expected the start of a possible repeat to be held, got %q, %v", out, held)
+	}
+	if out, held := o.filter("ence here. Third"); held || out != " Third" {
+		t.Errorf("Expected the repeat to be dropped once complete, got %q, %v", out, held)
+	}
+	if out, held := o.filter(" sentence here."); held || out != " sentence here." {
+		t.Errorf("Expected later text to pass untouched, got %q, %v", out, held)
+	}
+
+	o = newContinuationOverlap("First sentence here. Second sentence here.", 512)
+	o.filter("Second sent")
+	if out, held := o.filter("ry duty"); held || out != "Second sentry duty" {
+		t.Errorf("Expected held text to be released when it diverges, got %q, %v", out, held)
+	}
+}
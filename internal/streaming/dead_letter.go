@@ -0,0 +1,158 @@
+package streaming
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redactedValue replaces secret values in dead-letter records.
+const redactedValue = "[REDACTED]"
+
+// secretFieldNames lists request body fields, matched case-insensitively, whose values are
+// never written to a dead-letter sink.
+var secretFieldNames = []string{"key", "api_key", "apikey", "authorization", "token", "access_token", "secret", "password"}
+
+// DeadLetterRecord captures a stream that exhausted its retries for later analysis.
+type DeadLetterRecord struct {
+	Time            time.Time       `json:"time"`
+	ChannelType     string          `json:"channel_type"`
+	Request         interface{}     `json:"request,omitempty"`
+	AccumulatedText string          `json:"accumulated_text"`
+	Attempts        int             `json:"attempts"`
+	AttemptHistory  []AttemptRecord `json:"attempt_history"`
+}
+
+// AttemptRecord summarizes a single upstream attempt of a failed stream. With snapshots
+// enabled it also holds the accumulated text at the start and end of the attempt, which
+// shows where a continuation duplicated or lost text.
+type AttemptRecord struct {
+	Attempt       int    `json:"attempt"`
+	ReceivedChars int    `json:"received_chars"`
+	DurationMs    int64  `json:"duration_ms"`
+	TextBefore    string `json:"text_before,omitempty"`
+	TextAfter     string `json:"text_after,omitempty"`
+}
+
+// DeadLetterSink receives the records of streams that exhausted their retries.
+type DeadLetterSink interface {
+	WriteDeadLetter(record DeadLetterRecord) error
+}
+
+// DeadLetterFunc adapts a callback to a DeadLetterSink.
+type DeadLetterFunc func(record DeadLetterRecord) error
+
+// WriteDeadLetter implements DeadLetterSink.
+func (f DeadLetterFunc) WriteDeadLetter(record DeadLetterRecord) error {
+	return f(record)
+}
+
+// FileDeadLetterSink appends records as JSON lines to a daily file in a directory.
+type FileDeadLetterSink struct {
+	dir    string
+	prefix string
+	mu     sync.Mutex
+}
+
+// NewFileDeadLetterSink creates a sink writing to files named <prefix>-deadletter-<date>.jsonl.
+func NewFileDeadLetterSink(dir, prefix string) *FileDeadLetterSink {
+	return &FileDeadLetterSink{dir: dir, prefix: prefix}
+}
+
+// WriteDeadLetter implements DeadLetterSink. The file is opened per record, since failed
+// streams are rare and the sink should not hold a descriptor for the lifetime of the group.
+func (s *FileDeadLetterSink) WriteDeadLetter(record DeadLetterRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead-letter record: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create dead-letter directory: %w", err)
+	}
+	name := fmt.Sprintf("%s-deadletter-%s.jsonl", s.prefix, record.Time.Format("20060102"))
+	file, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open dead-letter file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write dead-letter record: %w", err)
+	}
+	return nil
+}
+
+// recordDeadLetter hands an exhausted stream to the configured dead-letter sink, if any.
+func (sh *StreamHandler) recordDeadLetter(channelType string, originalRequest interface{}, accumulatedText string, history []AttemptRecord) {
+	if sh.deadLetter == nil {
+		return
+	}
+
+	record := DeadLetterRecord{
+		Time:            time.Now(),
+		ChannelType:     channelType,
+		Request:         redactRequest(originalRequest),
+		AccumulatedText: accumulatedText,
+		Attempts:        len(history),
+		AttemptHistory:  history,
+	}
+	if err := sh.deadLetter.WriteDeadLetter(record); err != nil {
+		sh.log.Warnf("Failed to write dead-letter record: %v", err)
+	}
+}
+
+// redactRequest decodes the original request for a dead-letter record with secret fields
+// replaced. Bodies that are not JSON objects are left out, since they cannot be redacted.
+func redactRequest(originalRequest interface{}) interface{} {
+	var body []byte
+	switch req := originalRequest.(type) {
+	case []byte:
+		body = req
+	case string:
+		body = []byte(req)
+	default:
+		return nil
+	}
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil
+	}
+	return redactSecrets(parsed)
+}
+
+// redactSecrets replaces the values of secret fields anywhere in a decoded JSON value.
+func redactSecrets(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, inner := range v {
+			if isSecretField(key) {
+				v[key] = redactedValue
+			} else {
+				v[key] = redactSecrets(inner)
+			}
+		}
+	case []interface{}:
+		for i, inner := range v {
+			v[i] = redactSecrets(inner)
+		}
+	}
+	return value
+}
+
+func isSecretField(name string) bool {
+	for _, secret := range secretFieldNames {
+		if strings.EqualFold(name, secret) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package streaming
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExhaustedStreamWritesDeadLetter(t *testing.T) {
+	dir := t.TempDir()
+	handler := NewStreamHandler(StreamConfig{
+		MaxRetries: 1,
+		RetryDelay: time.Millisecond,
+		DeadLetter: NewFileDeadLetterSink(dir, "test"),
+	})
+
+	request := []byte(`{"api_key":"sk-secret","contents":[{"parts":[{"text":"Tell me a story"}]}],"metadata":{"Authorization":"Bearer sk-secret"}}`)
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		return newStreamResponse(geminiChunk(" and then")), nil
+	}
+
+	err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk("Once upon a time")), httptest.NewRecorder(), "gemini", request, retryFunc)
+	if err != ErrRetryLimitExceeded {
+		t.Fatalf("Expected retry limit error, got %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "test-deadletter-*.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one dead-letter file, got %v", files)
+	}
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("Failed to read dead-letter file: %v", err)
+	}
+	if strings.Contains(string(content), "sk-secret") {
+		t.Errorf("Expected secrets to be redacted, got %s", content)
+	}
+
+	var record DeadLetterRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		t.Fatalf("Expected a single JSON record, got %s: %v", content, err)
+	}
+	if record.AccumulatedText != "Once upon a time and then" {
+		t.Errorf("Expected accumulated text to be recorded, got %q", record.AccumulatedText)
+	}
+	if record.Attempts != 2 || len(record.AttemptHistory) != 2 {
+		t.Errorf("Expected 2 attempts in the record, got %d with history %v", record.Attempts, record.AttemptHistory)
+	}
+	if len(record.AttemptHistory) == 2 && record.AttemptHistory[1].ReceivedChars != len(" and then") {
+		t.Errorf("Expected second attempt to report %d chars, got %d", len(" and then"), record.AttemptHistory[1].ReceivedChars)
+	}
+	if !strings.Contains(string(content), "Tell me a story") {
+		t.Errorf("Expected the redacted request to be recorded, got %s", content)
+	}
+}
+
+func TestDeadLetterRecordsAttemptSnapshots(t *testing.T) {
+	var record DeadLetterRecord
+	handler := NewStreamHandler(StreamConfig{
+		MaxRetries:      2,
+		RetryDelay:      time.Millisecond,
+		RecordSnapshots: true,
+		DeadLetter: DeadLetterFunc(func(r DeadLetterRecord) error {
+			record = r
+			return nil
+		}),
+	})
+
+	chunks := []string{" upon", " a time"}
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		chunk := chunks[0]
+		chunks = chunks[1:]
+		return newStreamResponse(geminiChunk(chunk)), nil
+	}
+
+	err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk("Once")), httptest.NewRecorder(), "gemini", nil, retryFunc)
+	if err != ErrRetryLimitExceeded {
+		t.Fatalf("Expected retry limit error, got %v", err)
+	}
+
+	expected := []AttemptRecord{
+		{Attempt: 1, TextBefore: "", TextAfter: "Once"},
+		{Attempt: 2, TextBefore: "Once", TextAfter: "Once upon"},
+		{Attempt: 3, TextBefore: "Once upon", TextAfter: "Once upon a time"},
+	}
+	if len(record.AttemptHistory) != len(expected) {
+		t.Fatalf("Expected %d attempts, got %v", len(expected), record.AttemptHistory)
+	}
+	for i, want := range expected {
+		got := record.AttemptHistory[i]
+		if got.Attempt != want.Attempt || got.TextBefore != want.TextBefore || got.TextAfter != want.TextAfter {
+			t.Errorf("Attempt %d: expected snapshots %q -> %q, got %q -> %q", want.Attempt, want.TextBefore, want.TextAfter, got.TextBefore, got.TextAfter)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package streaming
+
+import "strings"
+
+// isDuplicateChunk reports whether a text chunk should be dropped because it exactly repeats
+// the previous text chunk. Only whole chunks are compared, so words repeated within or across
+// different chunks are kept, and whitespace-only chunks such as repeated newlines are never
+// dropped. Events that also carry a finish reason or reasoning are always forwarded.
+func (sh *StreamHandler) isDuplicateChunk(textChunk, previousChunk string, data map[string]interface{}, channelType string) bool {
+	if !sh.dedupeChunks || textChunk != previousChunk || strings.TrimSpace(textChunk) == "" {
+		return false
+	}
+	// Tool-call arguments legitimately repeat short fragments such as quotes
+	if sh.extractFinishReason(data, channelType) != "" || sh.extractReasoningText(data, channelType) != "" || hasToolCall(data, channelType) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,41 @@
+package streaming
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openAIDeltaEvent(text string) string {
+	return fmt.Sprintf("data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", text)
+}
+
+func TestDuplicateConsecutiveChunkIsDropped(t *testing.T) {
+	stream := openAIDeltaEvent("The answer is") + openAIDeltaEvent("The answer is") + openAIDeltaEvent(" no") + openAIDeltaEvent(" no no") + openAIDeltaEvent("\n") + openAIDeltaEvent("\n") + "data: [DONE]\n\n"
+
+	run := func(dedupe bool) string {
+		handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, DedupeChunks: dedupe})
+		recorder := httptest.NewRecorder()
+		if err := handler.HandleStreamingResponse(newStreamResponse(stream), recorder, "openai", nil, nil); err != nil {
+			t.Fatalf("Expected stream to complete, got %v", err)
+		}
+		return recorder.Body.String()
+	}
+
+	body := run(true)
+	if count := strings.Count(body, `"The answer is"`); count != 1 {
+		t.Errorf("Expected the duplicated chunk to be forwarded once, got %d times in %q", count, body)
+	}
+	if !strings.Contains(body, `" no"`) || !strings.Contains(body, `" no no"`) {
+		t.Errorf("Expected chunks that merely repeat a word to be kept, got %q", body)
+	}
+	if count := strings.Count(body, `"\n"`); count != 2 {
+		t.Errorf("Expected whitespace-only chunks to be kept, got %d in %q", count, body)
+	}
+
+	if count := strings.Count(run(false), `"The answer is"`); count != 2 {
+		t.Errorf("Expected chunks to be forwarded unchanged when disabled, got %d", count)
+	}
+}
@@ -0,0 +1,72 @@
+package streaming
+
+import (
+	"net/http"
+	"strings"
+)
+
+// EmptyStreamHeader explains, as an SSE comment, why a stream that completed cleanly
+// carried no text.
+const EmptyStreamHeader = "X-GPT-Load-Empty-Reason"
+
+// Reasons reported for streams that completed without text.
+const (
+	EmptyReasonContentFiltered = "content_filtered"
+	EmptyReasonToolCallsOnly   = "tool_calls_only"
+	EmptyReasonMaxTokens       = "max_tokens"
+	EmptyReasonNoContent       = "no_content"
+)
+
+// emptyStreamReason derives why a cleanly completed stream produced no text from its
+// normalized finish reason and its last event.
+func emptyStreamReason(finishReason FinishReason, lastEvent map[string]interface{}, channelType string) string {
+	switch {
+	case finishReason == FinishReasonContentFilter || hasPromptBlock(lastEvent):
+		return EmptyReasonContentFiltered
+	case finishReason == FinishReasonToolCalls || hasToolCall(lastEvent, channelType):
+		return EmptyReasonToolCallsOnly
+	case finishReason == FinishReasonLength:
+		return EmptyReasonMaxTokens
+	}
+	return EmptyReasonNoContent
+}
+
+// hasPromptBlock reports whether a Gemini event says the prompt itself was blocked.
+func hasPromptBlock(event map[string]interface{}) bool {
+	feedback, ok := event["promptFeedback"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	reason, _ := feedback["blockReason"].(string)
+	return reason != ""
+}
+
+// hasToolCall reports whether an event carries a tool or function call.
+func hasToolCall(event map[string]interface{}, channelType string) bool {
+	if event == nil {
+		return false
+	}
+	if channelType == "gemini" {
+		for _, p := range firstGeminiParts(event) {
+			if part, ok := p.(map[string]interface{}); ok && part["functionCall"] != nil {
+				return true
+			}
+		}
+		return false
+	}
+	if delta := firstChoiceDelta(event); delta != nil {
+		return delta["tool_calls"] != nil || delta["function_call"] != nil
+	}
+	return false
+}
+
+// writeEmptyStreamDiagnostic explains a cleanly completed stream that carried no text, so
+// the client does not receive an empty stream without a reason.
+func (sh *StreamHandler) writeEmptyStreamDiagnostic(writer http.ResponseWriter, accumulatedText string, finishReason FinishReason, lastEvent map[string]interface{}, channelType string) {
+	if strings.TrimSpace(sh.RemoveDoneTokensFromText(accumulatedText)) != "" {
+		return
+	}
+	reason := emptyStreamReason(finishReason, lastEvent, channelType)
+	sh.log.Infof("Stream completed without text: %s", reason)
+	sh.writeTrailerComment(writer, EmptyStreamHeader, reason)
+}
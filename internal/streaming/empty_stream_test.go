@@ -0,0 +1,55 @@
+package streaming
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmptyStreamReportsReason(t *testing.T) {
+	cases := []struct {
+		name   string
+		stream string
+		reason string
+	}{
+		{
+			name:   "filtered",
+			stream: "data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"content_filter\"}]}\n\ndata: [DONE]\n\n",
+			reason: EmptyReasonContentFiltered,
+		},
+		{
+			name:   "tool calls only",
+			stream: "data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"name\":\"lookup\"}}]},\"finish_reason\":\"tool_calls\"}]}\n\ndata: [DONE]\n\n",
+			reason: EmptyReasonToolCallsOnly,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, EmptyStreamDiagnostic: true})
+			retryFunc := func(accumulatedText string) (*http.Response, error) {
+				t.Fatal("Expected a clean empty stream not to be retried")
+				return nil, nil
+			}
+
+			recorder := httptest.NewRecorder()
+			if err := handler.HandleStreamingResponse(newStreamResponse(tc.stream), recorder, "openai", nil, retryFunc); err != nil {
+				t.Fatalf("Expected stream to complete, got %v", err)
+			}
+			if !strings.Contains(recorder.Body.String(), ": "+EmptyStreamHeader+": "+tc.reason+"\n\n") {
+				t.Errorf("Expected empty stream reason %q, got body %q", tc.reason, recorder.Body.String())
+			}
+		})
+	}
+
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond})
+	recorder := httptest.NewRecorder()
+	if err := handler.HandleStreamingResponse(newStreamResponse(cases[0].stream), recorder, "openai", nil, nil); err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	if strings.Contains(recorder.Body.String(), EmptyStreamHeader) {
+		t.Errorf("Expected no diagnostic when disabled, got body %q", recorder.Body.String())
+	}
+}
@@ -0,0 +1,75 @@
+package streaming
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// startTrackingWriter records whether the response has started, i.e. whether the status line
+// may already have been sent so it can no longer be changed.
+type startTrackingWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *startTrackingWriter) Write(p []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *startTrackingWriter) WriteHeader(statusCode int) {
+	w.started = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *startTrackingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.started = true
+		flusher.Flush()
+	}
+}
+
+// writeRetryError reports a stream whose retries are exhausted. Before anything was sent it
+// is a regular 504 JSON response. Once the stream has started the status can no longer
+// change, so the error is sent as an SSE error event followed by the channel's end-of-stream
+// marker, letting clients tell the failure apart from a complete answer.
+func (sh *StreamHandler) writeRetryError(writer http.ResponseWriter, channelType string, started bool) error {
+	message := fmt.Sprintf("Retry limit (%d) exceeded after stream interruption", sh.maxRetries)
+	exhausted := ErrRetryLimitExceeded
+	if sh.strictCompletion {
+		message = fmt.Sprintf("Response truncated: no explicit completion signal after %d retries", sh.maxRetries)
+		exhausted = ErrStreamTruncated
+	}
+	errorPayload := map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    http.StatusGatewayTimeout,
+			"status":  "DEADLINE_EXCEEDED",
+			"message": message,
+		},
+	}
+	errorBytes, _ := json.Marshal(errorPayload)
+
+	if !started {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Del("Cache-Control")
+		writer.Header().Del("X-Accel-Buffering")
+		writer.WriteHeader(http.StatusGatewayTimeout)
+		if _, err := writer.Write(errorBytes); err != nil {
+			return fmt.Errorf("failed to write error response: %w", err)
+		}
+		return exhausted
+	}
+
+	event := fmt.Sprintf("event: error\ndata: %s\n\n", errorBytes)
+	if channelType == "openai" {
+		event += "data: [DONE]\n\n"
+	}
+	if _, err := fmt.Fprint(writer, event); err != nil {
+		return fmt.Errorf("failed to write error event: %w", err)
+	}
+	if flusher, ok := writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return exhausted
+}
@@ -0,0 +1,124 @@
+package streaming
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExhaustionBeforeStreamStartReturnsHTTPError(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond})
+
+	dropped := func() *http.Response {
+		resp := newStreamResponse("")
+		resp.Body = &failingBody{data: strings.NewReader("")}
+		return resp
+	}
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		return dropped(), nil
+	}
+
+	recorder := httptest.NewRecorder()
+	if err := handler.HandleStreamingResponse(dropped(), recorder, "openai", nil, retryFunc); err != ErrRetryLimitExceeded {
+		t.Fatalf("Expected retry limit error, got %v", err)
+	}
+	if recorder.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected status 504, got %d", recorder.Code)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected a JSON error response, got Content-Type %q", contentType)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &payload); err != nil || payload["error"] == nil {
+		t.Errorf("Expected a JSON error body, got %q", recorder.Body.String())
+	}
+}
+
+func TestExhaustionMidStreamEmitsErrorEvent(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond})
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		return newStreamResponse("data: {\"choices\":[{\"delta\":{\"content\":\" and then\"}}]}\n\n"), nil
+	}
+
+	recorder := httptest.NewRecorder()
+	first := newStreamResponse("data: {\"choices\":[{\"delta\":{\"content\":\"Once upon a time\"}}]}\n\n")
+	if err := handler.HandleStreamingResponse(first, recorder, "openai", nil, retryFunc); err != ErrRetryLimitExceeded {
+		t.Fatalf("Expected retry limit error, got %v", err)
+	}
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected the started stream to keep status 200, got %d", recorder.Code)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, "Once upon a time") {
+		t.Errorf("Expected the partial text to be delivered, got %q", body)
+	}
+	if !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Errorf("Expected the stream to end with the terminator, got %q", body)
+	}
+	errorEvent := strings.Index(body, "event: error\ndata: {\"error\":")
+	if errorEvent < 0 || errorEvent < strings.LastIndex(body, "and then") {
+		t.Errorf("Expected an SSE error event after the partial text, got %q", body)
+	}
+}
+
+func TestStrictCompletionIgnoresHeuristics(t *testing.T) {
+	complete := "This answer looks complete and ends with a full stop, so heuristics accept it."
+
+	run := func(strict bool) (int, string, error) {
+		handler := NewStreamHandler(StreamConfig{
+			MaxRetries:                 2,
+			RetryDelay:                 time.Millisecond,
+			EnablePunctuationHeuristic: true,
+			StrictCompletion:           strict,
+		})
+		retries := 0
+		retryFunc := func(accumulatedText string) (*http.Response, error) {
+			retries++
+			return newStreamResponse(geminiChunk(" Still no signal.")), nil
+		}
+		recorder := httptest.NewRecorder()
+		err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk(complete)), recorder, "gemini", nil, retryFunc)
+		return retries, recorder.Body.String(), err
+	}
+
+	if retries, _, err := run(false); err != nil || retries != 0 {
+		t.Fatalf("Expected heuristics to complete the stream without strict mode, got %v after %d retries", err, retries)
+	}
+
+	retries, body, err := run(true)
+	if !errors.Is(err, ErrStreamTruncated) || !errors.Is(err, ErrRetryLimitExceeded) {
+		t.Errorf("Expected a truncation error, got %v", err)
+	}
+	if retries != 2 {
+		t.Errorf("Expected retries to run to exhaustion, got %d", retries)
+	}
+	if !strings.Contains(body, "Response truncated") {
+		t.Errorf("Expected the client to be told the response was truncated, got %q", body)
+	}
+}
+
+func TestStrictCompletionAcceptsGeminiCandidateFinishReason(t *testing.T) {
+	for _, reason := range []string{"STOP", "MAX_TOKENS"} {
+		handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Millisecond, StrictCompletion: true})
+		retryFunc := func(accumulatedText string) (*http.Response, error) {
+			t.Errorf("%s: expected no retry for a stream with a terminal finish reason", reason)
+			return newStreamResponse(geminiChunk("retried")), nil
+		}
+
+		// The shape Gemini actually streams: the finish reason sits on the last candidate,
+		// next to its content, with usage metadata alongside
+		stream := `data: {"candidates":[{"content":{"parts":[{"text":"The answer"}],"role":"model"},"index":0}],"usageMetadata":{"promptTokenCount":4,"candidatesTokenCount":2,"totalTokenCount":6},"modelVersion":"gemini-2.5-flash"}` + "\n\n" +
+			`data: {"candidates":[{"content":{"parts":[{"text":" is 42"}],"role":"model"},"finishReason":"` + reason + `","index":0}],"usageMetadata":{"promptTokenCount":4,"candidatesTokenCount":5,"totalTokenCount":9},"modelVersion":"gemini-2.5-flash"}` + "\n\n"
+		recorder := httptest.NewRecorder()
+		if err := handler.HandleStreamingResponse(newStreamResponse(stream), recorder, "gemini", nil, retryFunc); err != nil {
+			t.Errorf("%s: expected the stream to complete, got %v", reason, err)
+		}
+		if body := recorder.Body.String(); !strings.Contains(body, " is 42") || strings.Contains(body, "truncated") {
+			t.Errorf("%s: expected the answer without a truncation error, got %q", reason, body)
+		}
+	}
+}
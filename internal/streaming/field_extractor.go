@@ -0,0 +1,267 @@
+package streaming
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// DefaultLargeEventBytes is the event size above which events are parsed with the
+// field extractor instead of being fully unmarshaled.
+const DefaultLargeEventBytes = 256 * 1024
+
+// hotFieldPaths lists the string fields the stream handler reads from events.
+// "*" matches any array index.
+var hotFieldPaths = [][]string{
+	{"choices", "*", "delta", "content"},
+	{"choices", "*", "delta", "content", "*", "type"},
+	{"choices", "*", "delta", "content", "*", "text"},
+	{"choices", "*", "delta", "reasoning_content"},
+	{"choices", "*", "delta", "reasoning"},
+	{"choices", "*", "finish_reason"},
+	{"candidates", "*", "content", "parts", "*", "text"},
+	{"candidates", "*", "finishReason"},
+	{"metadata", "finishReason"},
+	{"type"},
+	{"delta", "type"},
+	{"delta", "text"},
+	{"text"},
+	{"content"},
+	{"finish_reason"},
+}
+
+var errMalformedJSON = errors.New("malformed JSON event")
+
+// extractHotFields walks a JSON event without building the full document and returns
+// a sparse map holding only the hot fields, shaped like the original event so the
+// regular text extraction and completion checks work on it unchanged. Large values
+// outside the hot paths, such as tool-call arguments, are skipped without allocation.
+func extractHotFields(event []byte) (map[string]interface{}, error) {
+	fe := &fieldExtractor{data: event}
+	fe.skipSpace()
+	if fe.pos >= len(fe.data) || fe.data[fe.pos] != '{' {
+		return nil, errMalformedJSON
+	}
+	if err := fe.value(nil); err != nil {
+		return nil, err
+	}
+	if len(fe.fields) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	return buildSparse(fe.fields, 0).(map[string]interface{}), nil
+}
+
+// pathSegment is a single step in the path to the value being scanned.
+type pathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+type fieldExtractor struct {
+	data   []byte
+	pos    int
+	fields []hotField
+}
+
+func (fe *fieldExtractor) skipSpace() {
+	for fe.pos < len(fe.data) {
+		switch fe.data[fe.pos] {
+		case ' ', '\t', '\n', '\r':
+			fe.pos++
+		default:
+			return
+		}
+	}
+}
+
+// value scans the value at the current position, recording it if path is a hot field.
+func (fe *fieldExtractor) value(path []pathSegment) error {
+	fe.skipSpace()
+	if fe.pos >= len(fe.data) {
+		return errMalformedJSON
+	}
+
+	switch fe.data[fe.pos] {
+	case '{':
+		return fe.object(path)
+	case '[':
+		return fe.array(path)
+	case '"':
+		start := fe.pos
+		if err := fe.skipString(); err != nil {
+			return err
+		}
+		if isHotPath(path) {
+			var s string
+			if err := json.Unmarshal(fe.data[start:fe.pos], &s); err != nil {
+				return err
+			}
+			fe.fields = append(fe.fields, hotField{path: append([]pathSegment(nil), path...), value: s})
+		}
+		return nil
+	default:
+		return fe.skipLiteral()
+	}
+}
+
+func (fe *fieldExtractor) object(path []pathSegment) error {
+	fe.pos++ // '{'
+	for {
+		fe.skipSpace()
+		if fe.pos >= len(fe.data) {
+			return errMalformedJSON
+		}
+		if fe.data[fe.pos] == '}' {
+			fe.pos++
+			return nil
+		}
+		if fe.data[fe.pos] == ',' {
+			fe.pos++
+			fe.skipSpace()
+		}
+
+		start := fe.pos
+		if err := fe.skipString(); err != nil {
+			return err
+		}
+		var key string
+		if err := json.Unmarshal(fe.data[start:fe.pos], &key); err != nil {
+			return errMalformedJSON
+		}
+
+		fe.skipSpace()
+		if fe.pos >= len(fe.data) || fe.data[fe.pos] != ':' {
+			return errMalformedJSON
+		}
+		fe.pos++
+
+		if err := fe.value(append(path, pathSegment{key: key})); err != nil {
+			return err
+		}
+	}
+}
+
+func (fe *fieldExtractor) array(path []pathSegment) error {
+	fe.pos++ // '['
+	for index := 0; ; index++ {
+		fe.skipSpace()
+		if fe.pos >= len(fe.data) {
+			return errMalformedJSON
+		}
+		if fe.data[fe.pos] == ']' {
+			fe.pos++
+			return nil
+		}
+		if fe.data[fe.pos] == ',' {
+			fe.pos++
+		}
+
+		if err := fe.value(append(path, pathSegment{index: index, isIndex: true})); err != nil {
+			return err
+		}
+	}
+}
+
+// skipString advances past a quoted string, honouring escapes.
+func (fe *fieldExtractor) skipString() error {
+	if fe.pos >= len(fe.data) || fe.data[fe.pos] != '"' {
+		return errMalformedJSON
+	}
+	for i := fe.pos + 1; i < len(fe.data); i++ {
+		switch fe.data[i] {
+		case '\\':
+			i++
+		case '"':
+			fe.pos = i + 1
+			return nil
+		}
+	}
+	return errMalformedJSON
+}
+
+// skipLiteral advances past a number, true, false or null.
+func (fe *fieldExtractor) skipLiteral() error {
+	start := fe.pos
+	for fe.pos < len(fe.data) {
+		switch fe.data[fe.pos] {
+		case ',', '}', ']', ' ', '\t', '\n', '\r':
+			if fe.pos == start {
+				return errMalformedJSON
+			}
+			return nil
+		}
+		fe.pos++
+	}
+	return errMalformedJSON
+}
+
+// hotField is a hot value found during the scan together with its location.
+type hotField struct {
+	path  []pathSegment
+	value string
+}
+
+// buildSparse assembles the found fields into nested maps and slices mirroring the event.
+func buildSparse(fields []hotField, depth int) interface{} {
+	if len(fields[0].path) == depth {
+		// A repeated key keeps its last value, as json.Unmarshal would.
+		return fields[len(fields)-1].value
+	}
+
+	if fields[0].path[depth].isIndex {
+		size := 0
+		for _, f := range fields {
+			if f.path[depth].index >= size {
+				size = f.path[depth].index + 1
+			}
+		}
+		arr := make([]interface{}, size)
+		for i := range arr {
+			var group []hotField
+			for _, f := range fields {
+				if f.path[depth].index == i {
+					group = append(group, f)
+				}
+			}
+			if len(group) > 0 {
+				arr[i] = buildSparse(group, depth+1)
+			}
+		}
+		return arr
+	}
+
+	obj := make(map[string]interface{})
+	groups := make(map[string][]hotField)
+	for _, f := range fields {
+		groups[f.path[depth].key] = append(groups[f.path[depth].key], f)
+	}
+	for key, group := range groups {
+		obj[key] = buildSparse(group, depth+1)
+	}
+	return obj
+}
+
+// isHotPath reports whether path matches one of the hot field paths.
+func isHotPath(path []pathSegment) bool {
+	for _, hot := range hotFieldPaths {
+		if len(hot) != len(path) {
+			continue
+		}
+		match := true
+		for i, want := range hot {
+			if want == "*" {
+				if !path[i].isIndex {
+					match = false
+					break
+				}
+			} else if path[i].isIndex || path[i].key != want {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
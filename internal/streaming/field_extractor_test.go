@@ -0,0 +1,100 @@
+package streaming
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// largeToolCallEvent builds an OpenAI event carrying a multi-MB tool-call argument.
+func largeToolCallEvent(size int) string {
+	args := strings.Repeat(`{\"k\":\"v\"},`, size/12)
+	return `{"id":"x","choices":[{"index":0,"delta":{"content":"tail text","tool_calls":[{"function":{"name":"f","arguments":"` + args + `"}}]},"finish_reason":"stop"}],"usage":{"total_tokens":12}}`
+}
+
+func TestExtractHotFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   string
+		channel string
+		text    string
+	}{
+		{"openai", `{"choices":[{"delta":{"content":"hi \"there\"\n"},"finish_reason":null}]}`, "openai", "hi \"there\"\n"},
+		{"openai multi-part", `{"choices":[{"delta":{"content":[{"type":"text","text":"see "},{"type":"image_url","image_url":{"url":"x"}},{"type":"text","text":"above"}]}}]}`, "openai", "see above"},
+		{"gemini", `{"candidates":[{"content":{"parts":[{"text":"你好"}],"role":"model"},"finishReason":"STOP"}],"usageMetadata":{"totalTokenCount":3}}`, "gemini", "你好"},
+		{"anthropic", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"ok"}}`, "anthropic", "ok"},
+		{"generic", `{"text":"plain","extra":[1,2,{"a":[true,false,null]}]}`, "custom", "plain"},
+	}
+
+	handler := NewStreamHandler(StreamConfig{})
+	for _, test := range tests {
+		data, err := extractHotFields([]byte(test.event))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if got := handler.extractTextFromData(data, test.channel); got != test.text {
+			t.Errorf("%s: expected text %q, got %q", test.name, test.text, got)
+		}
+
+		var full map[string]interface{}
+		json.Unmarshal([]byte(test.event), &full)
+		if handler.isStreamComplete(data, test.channel, "") != handler.isStreamComplete(full, test.channel, "") {
+			t.Errorf("%s: completion check differs between sparse and full parse", test.name)
+		}
+	}
+
+	if _, err := extractHotFields([]byte(`{"choices":[{"delta":`)); err == nil {
+		t.Error("Expected error for truncated event")
+	}
+}
+
+func TestLargeEventUsesFieldExtractor(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond})
+	// Above the field extractor's threshold, but within the default line limit
+	event := largeToolCallEvent(768 * 1024)
+	if len(event) <= DefaultLargeEventBytes {
+		t.Fatalf("Expected test event to exceed the threshold, got %d bytes", len(event))
+	}
+
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		t.Error("Expected the finish_reason in the large event to complete the stream")
+		return newStreamResponse(""), nil
+	}
+
+	recorder := httptest.NewRecorder()
+	resp := newStreamResponse("data: " + event + "\n\n")
+	if err := handler.HandleStreamingResponse(resp, recorder, "openai", nil, retryFunc); err != nil {
+		t.Fatalf("Expected large event to be processed, got %v", err)
+	}
+	if !strings.Contains(recorder.Body.String(), "tail text") {
+		t.Error("Expected the large event to be forwarded to the client")
+	}
+}
+
+func BenchmarkExtractHotFields(b *testing.B) {
+	event := []byte(largeToolCallEvent(4 * 1024 * 1024))
+	b.SetBytes(int64(len(event)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := extractHotFields(event); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalLargeEvent(b *testing.B) {
+	event := []byte(largeToolCallEvent(4 * 1024 * 1024))
+	b.SetBytes(int64(len(event)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var data map[string]interface{}
+		if err := json.Unmarshal(event, &data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package streaming
+
+import "strings"
+
+// FinishReasonHeader reports the normalized reason the upstream gave for ending a stream.
+const FinishReasonHeader = "X-GPT-Load-Finish-Reason"
+
+// FinishReason is a provider-independent terminal reason.
+type FinishReason string
+
+const (
+	FinishReasonNone          FinishReason = ""
+	FinishReasonStop          FinishReason = "stop"
+	FinishReasonLength        FinishReason = "length"
+	FinishReasonContentFilter FinishReason = "content_filter"
+	FinishReasonToolCalls     FinishReason = "tool_calls"
+	FinishReasonOther         FinishReason = "other"
+)
+
+// finishReasonsByChannel maps each provider's terminal reasons onto the normalized set.
+// Reasons that are not listed normalize to FinishReasonOther.
+var finishReasonsByChannel = map[string]map[string]FinishReason{
+	"openai": {
+		"stop":           FinishReasonStop,
+		"length":         FinishReasonLength,
+		"content_filter": FinishReasonContentFilter,
+		"tool_calls":     FinishReasonToolCalls,
+		"function_call":  FinishReasonToolCalls,
+	},
+	"gemini": {
+		"STOP":                    FinishReasonStop,
+		"MAX_TOKENS":              FinishReasonLength,
+		"SAFETY":                  FinishReasonContentFilter,
+		"RECITATION":              FinishReasonContentFilter,
+		"BLOCKLIST":               FinishReasonContentFilter,
+		"PROHIBITED_CONTENT":      FinishReasonContentFilter,
+		"SPII":                    FinishReasonContentFilter,
+		"IMAGE_SAFETY":            FinishReasonContentFilter,
+		"MALFORMED_FUNCTION_CALL": FinishReasonOther,
+	},
+	"anthropic": {
+		"end_turn":      FinishReasonStop,
+		"stop_sequence": FinishReasonStop,
+		"max_tokens":    FinishReasonLength,
+		"refusal":       FinishReasonContentFilter,
+		"tool_use":      FinishReasonToolCalls,
+	},
+}
+
+// DefaultOpenAITerminalReasons are the OpenAI finish_reason values that complete a stream
+// when no other set is configured.
+var DefaultOpenAITerminalReasons = []string{"stop", "length"}
+
+// DefaultGeminiTerminalReasons are the Gemini candidate finishReason values that complete a
+// stream when no other set is configured.
+var DefaultGeminiTerminalReasons = []string{"STOP", "MAX_TOKENS"}
+
+// ParseTerminalFinishReasons splits a comma-separated terminal finish reasons setting.
+func ParseTerminalFinishReasons(value string) []string {
+	return splitCommaList(value)
+}
+
+// NormalizeFinishReason maps a channel-specific terminal reason, such as OpenAI
+// "content_filter", Gemini "SAFETY" or Anthropic "max_tokens", onto the normalized set.
+// Unknown channels are matched against every provider's vocabulary.
+func NormalizeFinishReason(channelType, reason string) FinishReason {
+	if reason == "" {
+		return FinishReasonNone
+	}
+
+	if reasons, ok := finishReasonsByChannel[channelType]; ok {
+		if normalized, ok := reasons[reason]; ok {
+			return normalized
+		}
+		return FinishReasonOther
+	}
+
+	for _, reasons := range finishReasonsByChannel {
+		for raw, normalized := range reasons {
+			if strings.EqualFold(raw, reason) {
+				return normalized
+			}
+		}
+	}
+	return FinishReasonOther
+}
+
+// extractFinishReason returns the raw terminal reason carried by an event, if any.
+func (sh *StreamHandler) extractFinishReason(data map[string]interface{}, channelType string) string {
+	switch channelType {
+	case "openai":
+		if choices, ok := data["choices"].([]interface{}); ok && len(choices) > 0 {
+			if choice, ok := choices[0].(map[string]interface{}); ok {
+				reason, _ := choice["finish_reason"].(string)
+				return reason
+			}
+		}
+	case "gemini":
+		if candidate := firstGeminiCandidate(data); candidate != nil {
+			if reason, ok := candidate["finishReason"].(string); ok {
+				return reason
+			}
+		}
+		if metadata, ok := data["metadata"].(map[string]interface{}); ok {
+			reason, _ := metadata["finishReason"].(string)
+			return reason
+		}
+	case "anthropic":
+		if typ, _ := data["type"].(string); typ == "message_delta" {
+			if delta, ok := data["delta"].(map[string]interface{}); ok {
+				reason, _ := delta["stop_reason"].(string)
+				return reason
+			}
+		}
+	default:
+		reason, _ := data["finish_reason"].(string)
+		return reason
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+package streaming
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeFinishReason(t *testing.T) {
+	tests := []struct {
+		channelType string
+		reason      string
+		expected    FinishReason
+	}{
+		{"openai", "stop", FinishReasonStop},
+		{"openai", "length", FinishReasonLength},
+		{"openai", "content_filter", FinishReasonContentFilter},
+		{"openai", "tool_calls", FinishReasonToolCalls},
+		{"gemini", "STOP", FinishReasonStop},
+		{"gemini", "MAX_TOKENS", FinishReasonLength},
+		{"gemini", "SAFETY", FinishReasonContentFilter},
+		{"gemini", "RECITATION", FinishReasonContentFilter},
+		{"gemini", "OTHER", FinishReasonOther},
+		{"anthropic", "end_turn", FinishReasonStop},
+		{"anthropic", "max_tokens", FinishReasonLength},
+		{"anthropic", "refusal", FinishReasonContentFilter},
+		{"anthropic", "tool_use", FinishReasonToolCalls},
+		{"custom", "safety", FinishReasonContentFilter},
+		{"custom", "stop", FinishReasonStop},
+		{"custom", "", FinishReasonNone},
+	}
+
+	for _, test := range tests {
+		if got := NormalizeFinishReason(test.channelType, test.reason); got != test.expected {
+			t.Errorf("%s %q: expected %q, got %q", test.channelType, test.reason, test.expected, got)
+		}
+	}
+}
+
+func TestExtractFinishReason(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{})
+
+	tests := []struct {
+		channelType string
+		data        map[string]interface{}
+		expected    string
+	}{
+		{"openai", map[string]interface{}{"choices": []interface{}{map[string]interface{}{"finish_reason": "content_filter"}}}, "content_filter"},
+		{"gemini", map[string]interface{}{"candidates": []interface{}{map[string]interface{}{"finishReason": "SAFETY"}}}, "SAFETY"},
+		{"gemini", map[string]interface{}{"metadata": map[string]interface{}{"finishReason": "STOP"}}, "STOP"},
+		{"anthropic", map[string]interface{}{"type": "message_delta", "delta": map[string]interface{}{"stop_reason": "max_tokens"}}, "max_tokens"},
+		{"custom", map[string]interface{}{"finish_reason": "length"}, "length"},
+		{"openai", map[string]interface{}{"choices": []interface{}{map[string]interface{}{"finish_reason": nil}}}, ""},
+	}
+
+	for _, test := range tests {
+		if got := handler.extractFinishReason(test.data, test.channelType); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.channelType, test.expected, got)
+		}
+	}
+}
+
+func TestFinishReasonReportedToClient(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{})
+	recorder := httptest.NewRecorder()
+	stream := `data: {"text":"Cut off here","finish_reason":"length"}` + "\n\n"
+
+	if err := handler.HandleStreamingResponse(newStreamResponse(stream), recorder, "custom", nil, nil); err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, ": "+FinishReasonHeader+": length\n\n") {
+		t.Errorf("Expected normalized finish reason comment, got %q", body)
+	}
+}
+
+func TestOpenAICompletesOnlyOnTerminalFinishReasons(t *testing.T) {
+	chunk := func(reason interface{}) map[string]interface{} {
+		return map[string]interface{}{"choices": []interface{}{map[string]interface{}{"delta": map[string]interface{}{}, "finish_reason": reason}}}
+	}
+	missing := map[string]interface{}{"choices": []interface{}{map[string]interface{}{"delta": map[string]interface{}{}}}}
+
+	handler := NewStreamHandler(StreamConfig{})
+	tests := []struct {
+		name     string
+		data     map[string]interface{}
+		expected bool
+	}{
+		{"null", chunk(nil), false},
+		{"empty", chunk(""), false},
+		{"blank", chunk("  "), false},
+		{"missing", missing, false},
+		{"non-string", chunk(float64(1)), false},
+		{"unknown", chunk("paused"), false},
+		{"stop", chunk("stop"), true},
+		{"length", chunk("length"), true},
+		{"tool_calls", chunk("tool_calls"), true},
+		{"function_call", chunk("function_call"), true},
+	}
+	for _, test := range tests {
+		if got := handler.isOpenAIComplete(test.data); got != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+
+	configured := NewStreamHandler(StreamConfig{OpenAITerminalReasons: ParseTerminalFinishReasons("stop")})
+	if !configured.isOpenAIComplete(chunk("stop")) {
+		t.Error("Expected a configured terminal reason to complete the stream")
+	}
+	if !configured.isOpenAIComplete(chunk("tool_calls")) || !configured.isOpenAIComplete(chunk("function_call")) {
+		t.Error("Expected a finished tool call to complete the stream whatever the configured set")
+	}
+	if configured.isOpenAIComplete(chunk("length")) {
+		t.Error("Expected a reason outside the configured set not to complete the stream")
+	}
+}
@@ -0,0 +1,15 @@
+package streaming
+
+import "strings"
+
+// isGoAwayError reports whether a read error comes from the upstream shutting down its HTTP/2
+// connection, which aborts every stream on it that the server did not finish. net/http keeps
+// its HTTP/2 error types unexported, so they are recognized by their messages: GOAWAY itself,
+// and REFUSED_STREAM for streams the server never started.
+func isGoAwayError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "GOAWAY") || strings.Contains(msg, "REFUSED_STREAM")
+}
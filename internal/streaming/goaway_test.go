@@ -0,0 +1,63 @@
+package streaming
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// goAwayBody yields its data and then fails like a stream aborted by an HTTP/2 GOAWAY.
+type goAwayBody struct {
+	data *strings.Reader
+}
+
+func (b *goAwayBody) Read(p []byte) (int, error) {
+	if b.data.Len() == 0 {
+		return 0, errors.New(`http2: server sent GOAWAY and closed the connection; LastStreamID=1, ErrCode=NO_ERROR, debug=""`)
+	}
+	return b.data.Read(p)
+}
+
+func (b *goAwayBody) Close() error { return nil }
+
+func TestGoAwayMidStreamReconnectsWithContext(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Second, DoneTokenPatterns: []string{"[done]"}, ReconnectOnGoAway: true})
+
+	var retriedWith []string
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retriedWith = append(retriedWith, accumulatedText)
+		return newStreamResponse(geminiChunk(" the rest. [done]")), nil
+	}
+
+	resp := newStreamResponse("")
+	resp.Body = &goAwayBody{data: strings.NewReader(geminiChunk("Half of"))}
+
+	start := time.Now()
+	if err := handler.HandleStreamingResponse(resp, httptest.NewRecorder(), "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete after reconnect, got %v", err)
+	}
+	if len(retriedWith) != 1 || retriedWith[0] != "Half of" {
+		t.Errorf("Expected one reconnect continuing from the accumulated text, got %q", retriedWith)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("Expected the reconnect not to wait for the retry delay, took %v", elapsed)
+	}
+}
+
+func TestIsGoAwayError(t *testing.T) {
+	cases := map[error]bool{
+		errors.New("http2: Transport received Server's graceful shutdown GOAWAY"):   true,
+		errors.New("stream error: stream ID 3; REFUSED_STREAM; received from peer"): true,
+		io.ErrUnexpectedEOF: false,
+		nil:                 false,
+	}
+	for err, want := range cases {
+		if got := isGoAwayError(err); got != want {
+			t.Errorf("isGoAwayError(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package streaming
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// idleTimer abandons an attempt whose upstream keeps the connection open but sends nothing
+// for longer than the idle timeout. A read blocked on such a stream never returns on its own,
+// so the timer closes the body, which ends the read with an error.
+type idleTimer struct {
+	timer   *time.Timer
+	timeout time.Duration
+	fired   atomic.Bool
+}
+
+// newIdleTimer starts a timer that closes body after timeout without a reset, or returns nil
+// if the timeout is disabled.
+func newIdleTimer(timeout time.Duration, body io.Closer) *idleTimer {
+	if timeout <= 0 {
+		return nil
+	}
+	t := &idleTimer{timeout: timeout}
+	t.timer = time.AfterFunc(timeout, func() {
+		t.fired.Store(true)
+		body.Close()
+	})
+	return t
+}
+
+// reset restarts the timeout after the upstream sent a line.
+func (t *idleTimer) reset() {
+	if t == nil {
+		return
+	}
+	t.timer.Reset(t.timeout)
+}
+
+// stop releases the timer at the end of the attempt.
+func (t *idleTimer) stop() {
+	if t == nil {
+		return
+	}
+	t.timer.Stop()
+}
+
+// expired reports whether the timer closed the body.
+func (t *idleTimer) expired() bool {
+	return t != nil && t.fired.Load()
+}
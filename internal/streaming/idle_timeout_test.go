@@ -0,0 +1,101 @@
+package streaming
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stalledResponse streams the given events and then keeps the connection open without
+// sending anything, until the body is closed.
+func stalledResponse(events ...string) *http.Response {
+	reader, writer := io.Pipe()
+	go func() {
+		for _, event := range events {
+			if _, err := writer.Write([]byte(event)); err != nil {
+				return
+			}
+		}
+	}()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       reader,
+	}
+}
+
+func TestIdleUpstreamIsAbandonedAndRetried(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]"}, IdleTimeout: 50 * time.Millisecond})
+
+	var resumedFrom string
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		resumedFrom = accumulatedText
+		return newStreamResponse(geminiChunk(" the rest. [done]")), nil
+	}
+
+	recorder := httptest.NewRecorder()
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.HandleStreamingResponse(stalledResponse(geminiChunk("Half of")), recorder, "gemini", nil, retryFunc)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected the retry to complete the stream, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the idle timeout to abandon the stalled attempt")
+	}
+	if resumedFrom != "Half of" {
+		t.Errorf("Expected the retry to continue from the received text, got %q", resumedFrom)
+	}
+	if !strings.Contains(recorder.Body.String(), "X-GPT-Load-Attempts: 2") {
+		t.Errorf("Expected two attempts, got %q", recorder.Body.String())
+	}
+}
+
+func TestIdleUpstreamOnLastAttemptFails(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]"}, IdleTimeout: 50 * time.Millisecond})
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		return stalledResponse(geminiChunk(" more")), nil
+	}
+
+	err := handler.HandleStreamingResponse(stalledResponse(geminiChunk("Half of")), httptest.NewRecorder(), "gemini", nil, retryFunc)
+	if err == nil {
+		t.Fatal("Expected an error once the last attempt stalled")
+	}
+}
+
+func TestHeartbeatsKeepAttemptAlive(t *testing.T) {
+	ping := anthropicEvent("ping", `{"type": "ping"}`)
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, IdleTimeout: 100 * time.Millisecond})
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.Write([]byte(anthropicEvent("message_start", `{"type":"message_start","message":{"role":"assistant"}}`)))
+		for i := 0; i < 5; i++ {
+			time.Sleep(40 * time.Millisecond)
+			writer.Write([]byte(ping))
+		}
+		writer.Write([]byte(anthropicEvent("message_stop", `{"type":"message_stop"}`)))
+		writer.Close()
+	}()
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": []string{"text/event-stream"}}, Body: reader}
+
+	retries := 0
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retries++
+		return newStreamResponse(anthropicEvent("message_stop", `{"type":"message_stop"}`)), nil
+	}
+
+	if err := handler.HandleStreamingResponse(resp, httptest.NewRecorder(), "anthropic", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	if retries != 0 {
+		t.Errorf("Expected heartbeats to keep the attempt alive, got %d retries", retries)
+	}
+}
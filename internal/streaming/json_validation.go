@@ -0,0 +1,289 @@
+package streaming
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+const (
+	// JSONRepairHeader reports, as an SSE comment after the response, why the structured
+	// response was repaired. The client only receives the repaired document.
+	JSONRepairHeader = "X-GPT-Load-JSON-Repair"
+	// JSONInvalidHeader reports, as an SSE comment after the response, that the structured
+	// response still failed validation once all repair attempts were used up.
+	JSONInvalidHeader = "X-GPT-Load-JSON-Invalid"
+)
+
+// heldWriter holds back a structured response until it has been validated, so that a repair
+// replaces an invalid document instead of being appended to it. Once released it writes through.
+type heldWriter struct {
+	http.ResponseWriter
+	buf      bytes.Buffer
+	status   int
+	released bool
+}
+
+func (w *heldWriter) Write(p []byte) (int, error) {
+	if w.released {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+func (w *heldWriter) WriteHeader(statusCode int) {
+	if w.released {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+func (w *heldWriter) Flush() {
+	if !w.released {
+		return
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// discard drops everything held so far.
+func (w *heldWriter) discard() {
+	w.buf.Reset()
+	w.status = 0
+}
+
+// release sends what is held and lets further writes through.
+func (w *heldWriter) release() error {
+	if w.released {
+		return nil
+	}
+	w.released = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if w.buf.Len() > 0 {
+		if _, err := w.ResponseWriter.Write(w.buf.Bytes()); err != nil {
+			return err
+		}
+		w.buf.Reset()
+	}
+	w.Flush()
+	return nil
+}
+
+// jsonOutputSpec describes the structured output a request asked for.
+type jsonOutputSpec struct {
+	schema map[string]interface{}
+}
+
+// detectJSONOutput reports whether the request enables JSON mode, either through Gemini's
+// responseMimeType or an OpenAI-style response_format, along with the schema it provided.
+func detectJSONOutput(originalRequest interface{}) (*jsonOutputSpec, bool) {
+	var body []byte
+	switch req := originalRequest.(type) {
+	case []byte:
+		body = req
+	case string:
+		body = []byte(req)
+	default:
+		return nil, false
+	}
+
+	var request map[string]interface{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, false
+	}
+
+	for _, configKey := range []string{"generationConfig", "generation_config"} {
+		config, ok := request[configKey].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		mimeType, _ := firstField(config, "responseMimeType", "response_mime_type").(string)
+		if mimeType != "application/json" {
+			continue
+		}
+		schema, _ := firstField(config, "responseSchema", "response_schema", "responseJsonSchema").(map[string]interface{})
+		return &jsonOutputSpec{schema: schema}, true
+	}
+
+	if format, ok := request["response_format"].(map[string]interface{}); ok {
+		switch format["type"] {
+		case "json_object":
+			return &jsonOutputSpec{}, true
+		case "json_schema":
+			spec := &jsonOutputSpec{}
+			if jsonSchema, ok := format["json_schema"].(map[string]interface{}); ok {
+				spec.schema, _ = jsonSchema["schema"].(map[string]interface{})
+			}
+			return spec, true
+		}
+	}
+
+	return nil, false
+}
+
+// firstField returns the value of the first key present in m.
+func firstField(m map[string]interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		if value, ok := m[key]; ok {
+			return value
+		}
+	}
+	return nil
+}
+
+// validate parses the assembled response text and checks it against the schema, if any.
+// A surrounding markdown code fence is tolerated, since models often add one.
+func (spec *jsonOutputSpec) validate(text string) error {
+	document := strings.TrimSpace(text)
+	if strings.HasPrefix(document, "```") && strings.HasSuffix(document, "```") && len(document) >= 6 {
+		document = strings.TrimSuffix(document, "```")
+		if newline := strings.IndexByte(document, '\n'); newline >= 0 {
+			document = document[newline+1:]
+		} else {
+			document = strings.TrimPrefix(document, "```")
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(document)))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	if decoder.More() {
+		return fmt.Errorf("invalid JSON: unexpected data after the top-level value")
+	}
+
+	if spec.schema == nil {
+		return nil
+	}
+	return validateSchema(value, spec.schema, "$")
+}
+
+// validateSchema checks a decoded JSON value against the commonly used subset of JSON
+// Schema and Gemini's OpenAPI-style schemas: type, nullable, enum, properties, required,
+// additionalProperties and items. Other keywords are ignored.
+func validateSchema(value interface{}, schema map[string]interface{}, path string) error {
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return nil
+		}
+	}
+
+	if typ, ok := schema["type"]; ok && !matchesSchemaType(value, typ) {
+		return fmt.Errorf("%s: expected type %v, got %s", path, typ, jsonTypeName(value))
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if jsonEqual(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value %v is not one of %v", path, value, enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if key, ok := name.(string); ok {
+					if _, present := v[key]; !present {
+						return fmt.Errorf("%s: missing required property %q", path, key)
+					}
+				}
+			}
+		}
+		for key, inner := range v {
+			propertySchema, ok := properties[key].(map[string]interface{})
+			if !ok {
+				if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+					return fmt.Errorf("%s: unexpected property %q", path, key)
+				}
+				continue
+			}
+			if err := validateSchema(inner, propertySchema, path+"."+key); err != nil {
+				return err
+			}
+		}
+
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, inner := range v {
+				if err := validateSchema(inner, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// matchesSchemaType compares a value with a schema type, which may be a single name or a
+// list of names. Names are matched case-insensitively, as Gemini uses upper case.
+func matchesSchemaType(value interface{}, typ interface{}) bool {
+	switch t := typ.(type) {
+	case string:
+		name := strings.ToLower(t)
+		actual := jsonTypeName(value)
+		if name == "number" && actual == "integer" {
+			return true
+		}
+		return name == actual
+	case []interface{}:
+		for _, inner := range t {
+			if matchesSchemaType(value, inner) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// jsonTypeName returns the JSON Schema type name of a value decoded with UseNumber.
+func jsonTypeName(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+// jsonEqual compares a decoded value with an enum entry, treating numbers by value.
+func jsonEqual(a, b interface{}) bool {
+	if n, ok := a.(json.Number); ok {
+		af, _ := n.Float64()
+		bf, ok := b.(float64)
+		return ok && af == bf
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,107 @@
+package streaming
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// textEvent renders a generic SSE event carrying text and a stop finish reason.
+func textEvent(text string) string {
+	payload, _ := json.Marshal(map[string]interface{}{"text": text, "finish_reason": "stop"})
+	return "data: " + string(payload) + "\n\n"
+}
+
+func TestJSONOutputValidation(t *testing.T) {
+	request := []byte(`{"contents":[],"generationConfig":{"responseMimeType":"application/json","responseSchema":{"type":"OBJECT","properties":{"name":{"type":"STRING"},"tags":{"type":"ARRAY","items":{"type":"STRING"}}},"required":["name"]}}}`)
+	spec, ok := detectJSONOutput(request)
+	if !ok {
+		t.Fatal("Expected JSON mode to be detected from responseMimeType")
+	}
+
+	tests := []struct {
+		text  string
+		valid bool
+	}{
+		{`{"name":"a","tags":["x"]}`, true},
+		{"```json\n{\"name\":\"a\"}\n```", true},
+		{`{"name":"a","tags":[1]}`, false},
+		{`{"tags":[]}`, false},
+		{`{"name":"a"`, false},
+	}
+	for _, tt := range tests {
+		if err := spec.validate(tt.text); (err == nil) != tt.valid {
+			t.Errorf("validate(%q) = %v, expected valid=%v", tt.text, err, tt.valid)
+		}
+	}
+
+	if _, ok := detectJSONOutput([]byte(`{"contents":[]}`)); ok {
+		t.Error("Expected no JSON mode without responseMimeType or response_format")
+	}
+}
+
+func TestInvalidJSONTriggersOneRepair(t *testing.T) {
+	request := []byte(`{"messages":[],"response_format":{"type":"json_schema","json_schema":{"schema":{"type":"object","required":["answer"]}}}}`)
+
+	run := func(first string) (int, string) {
+		handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, JSONRepairAttempts: 1})
+		repairs := 0
+		handler.SetRepairFunc(func(invalidText, problem string) (*http.Response, error) {
+			repairs++
+			return newStreamResponse(textEvent(`{"answer":42}`)), nil
+		})
+		retryFunc := func(accumulatedText string) (*http.Response, error) {
+			t.Error("Expected no continuation retry for a completed stream")
+			return nil, nil
+		}
+
+		recorder := httptest.NewRecorder()
+		if err := handler.HandleStreamingResponse(newStreamResponse(textEvent(first)), recorder, "custom", request, retryFunc); err != nil {
+			t.Errorf("Expected stream to complete, got %v", err)
+		}
+		return repairs, recorder.Body.String()
+	}
+
+	if repairs, body := run(`{"answer":1}`); repairs != 0 || strings.Contains(body, JSONRepairHeader) {
+		t.Errorf("Expected valid JSON to pass without repair, got %d repairs", repairs)
+	}
+
+	repairs, body := run(`{"result":1}`)
+	if repairs != 1 {
+		t.Errorf("Expected invalid JSON to trigger exactly one repair, got %d", repairs)
+	}
+	if !strings.Contains(body, ": "+JSONRepairHeader+":") {
+		t.Errorf("Expected repair to be announced to the client, got %q", body)
+	}
+	if !strings.Contains(body, `{\"answer\":42}`) {
+		t.Errorf("Expected repaired response to be streamed, got %q", body)
+	}
+	if strings.Contains(body, `{\"result\":1}`) {
+		t.Errorf("Expected the invalid document to be held back, got %q", body)
+	}
+}
+
+func TestJSONStillInvalidAfterRepairsIsDeliveredOnce(t *testing.T) {
+	request := []byte(`{"messages":[],"response_format":{"type":"json_object"}}`)
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, JSONRepairAttempts: 1})
+	handler.SetRepairFunc(func(invalidText, problem string) (*http.Response, error) {
+		return newStreamResponse(textEvent(`{"answer":`)), nil
+	})
+
+	recorder := httptest.NewRecorder()
+	err := handler.HandleStreamingResponse(newStreamResponse(textEvent(`not json`)), recorder, "custom", request, nil)
+	if err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+
+	body := recorder.Body.String()
+	if strings.Contains(body, "not json") || strings.Count(body, "data: ") != 1 {
+		t.Errorf("Expected only the last document to be delivered, got %q", body)
+	}
+	if !strings.Contains(body, ": "+JSONInvalidHeader+":") {
+		t.Errorf("Expected the remaining problem to be reported, got %q", body)
+	}
+}
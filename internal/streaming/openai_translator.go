@@ -0,0 +1,207 @@
+package streaming
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// openAIChunk mirrors the field layout of an OpenAI chat.completion.chunk event.
+type openAIChunk struct {
+	ID                string         `json:"id"`
+	Object            string         `json:"object"`
+	Created           int64          `json:"created"`
+	Model             string         `json:"model"`
+	SystemFingerprint *string        `json:"system_fingerprint"`
+	Choices           []openAIChoice `json:"choices"`
+	Usage             *openAIUsage   `json:"usage,omitempty"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type openAIChoice struct {
+	Index        int         `json:"index"`
+	Delta        openAIDelta `json:"delta"`
+	Logprobs     *struct{}   `json:"logprobs"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+type openAIDelta struct {
+	Role    string  `json:"role,omitempty"`
+	Content *string `json:"content,omitempty"`
+}
+
+// GeminiToOpenAITranslator rewrites a Gemini stream as an OpenAI chat completion stream.
+// It emits the conventional role chunk first, then one chunk per text delta, then a
+// chunk carrying only the finish_reason, the usage chunk if requested, and finally [DONE].
+type GeminiToOpenAITranslator struct {
+	id           string
+	model        string
+	created      int64
+	roleSent     bool
+	finished     bool
+	includeUsage bool
+	usage        *openAIUsage
+}
+
+// NewGeminiToOpenAITranslator creates a translator for a single response stream.
+func NewGeminiToOpenAITranslator(model string) *GeminiToOpenAITranslator {
+	return &GeminiToOpenAITranslator{
+		id:      "chatcmpl-" + uuid.NewString(),
+		model:   model,
+		created: time.Now().Unix(),
+	}
+}
+
+// SetIncludeUsage makes the stream end with a usage chunk, as OpenAI sends for requests with
+// stream_options.include_usage.
+func (t *GeminiToOpenAITranslator) SetIncludeUsage(include bool) {
+	t.includeUsage = include
+}
+
+// Translate converts one parsed Gemini event into zero or more OpenAI SSE lines.
+func (t *GeminiToOpenAITranslator) Translate(data map[string]interface{}) []string {
+	// Gemini reports cumulative usage, often on the last event or after the finish reason
+	if usage := openAIUsageFromGemini(data); usage != nil {
+		t.usage = usage
+	}
+	if t.finished {
+		return nil
+	}
+
+	var lines []string
+	if !t.roleSent {
+		empty := ""
+		lines = append(lines, t.line(openAIDelta{Role: "assistant", Content: &empty}, nil))
+		t.roleSent = true
+	}
+
+	candidate := firstGeminiCandidate(data)
+	if candidate == nil {
+		return lines
+	}
+
+	if text := geminiCandidateText(candidate); text != "" {
+		lines = append(lines, t.line(openAIDelta{Content: &text}, nil))
+	}
+
+	if reason, ok := candidate["finishReason"].(string); ok && reason != "" {
+		finishReason := openAIFinishReason(reason)
+		lines = append(lines, t.line(openAIDelta{}, &finishReason))
+		t.finished = true
+	}
+
+	return lines
+}
+
+// Finish returns the closing lines of the stream. A stop chunk is synthesized when the
+// upstream never reported a finish reason.
+func (t *GeminiToOpenAITranslator) Finish() []string {
+	var lines []string
+	if !t.finished {
+		if !t.roleSent {
+			empty := ""
+			lines = append(lines, t.line(openAIDelta{Role: "assistant", Content: &empty}, nil))
+			t.roleSent = true
+		}
+		stop := "stop"
+		lines = append(lines, t.line(openAIDelta{}, &stop))
+		t.finished = true
+	}
+	if t.includeUsage && t.usage != nil {
+		lines = append(lines, t.usageLine())
+	}
+	return append(lines, "data: [DONE]")
+}
+
+// line renders a single chunk as an SSE data line.
+func (t *GeminiToOpenAITranslator) line(delta openAIDelta, finishReason *string) string {
+	chunk := openAIChunk{
+		ID:      t.id,
+		Object:  "chat.completion.chunk",
+		Created: t.created,
+		Model:   t.model,
+		Choices: []openAIChoice{{Index: 0, Delta: delta, FinishReason: finishReason}},
+	}
+	// The chunk only holds strings and numbers, so marshaling cannot fail
+	payload, _ := json.Marshal(chunk)
+	return "data: " + string(payload)
+}
+
+// usageLine renders the usage chunk, which like OpenAI's has no choices.
+func (t *GeminiToOpenAITranslator) usageLine() string {
+	chunk := openAIChunk{
+		ID:      t.id,
+		Object:  "chat.completion.chunk",
+		Created: t.created,
+		Model:   t.model,
+		Choices: []openAIChoice{},
+		Usage:   t.usage,
+	}
+	payload, _ := json.Marshal(chunk)
+	return "data: " + string(payload)
+}
+
+// openAIUsageFromGemini maps an event's usageMetadata onto OpenAI usage fields:
+// promptTokenCount to prompt_tokens, candidatesTokenCount to completion_tokens and
+// totalTokenCount to total_tokens.
+func openAIUsageFromGemini(data map[string]interface{}) *openAIUsage {
+	metadata, ok := data["usageMetadata"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	prompt, _ := metadata["promptTokenCount"].(float64)
+	candidates, _ := metadata["candidatesTokenCount"].(float64)
+	total, ok := metadata["totalTokenCount"].(float64)
+	if !ok {
+		total = prompt + candidates
+	}
+	return &openAIUsage{PromptTokens: int(prompt), CompletionTokens: int(candidates), TotalTokens: int(total)}
+}
+
+// firstGeminiCandidate returns the first candidate of a Gemini event, if any.
+func firstGeminiCandidate(data map[string]interface{}) map[string]interface{} {
+	candidates, ok := data["candidates"].([]interface{})
+	if !ok || len(candidates) == 0 {
+		return nil
+	}
+	candidate, _ := candidates[0].(map[string]interface{})
+	return candidate
+}
+
+// geminiCandidateText concatenates the text parts of a candidate.
+func geminiCandidateText(candidate map[string]interface{}) string {
+	content, ok := candidate["content"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	parts, ok := content["parts"].([]interface{})
+	if !ok {
+		return ""
+	}
+
+	var text string
+	for _, p := range parts {
+		if part, ok := p.(map[string]interface{}); ok {
+			if s, ok := part["text"].(string); ok {
+				text += s
+			}
+		}
+	}
+	return text
+}
+
+// openAIFinishReason maps a Gemini finish reason onto the OpenAI vocabulary.
+func openAIFinishReason(reason string) string {
+	switch normalized := NormalizeFinishReason("gemini", reason); normalized {
+	case FinishReasonLength, FinishReasonContentFilter:
+		return string(normalized)
+	default:
+		return "stop"
+	}
+}
@@ -0,0 +1,134 @@
+package streaming
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// referenceOpenAIStream follows the exact layout of an OpenAI chat completions stream.
+const referenceOpenAIStream = `data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","system_fingerprint":null,"choices":[{"index":0,"delta":{"role":"assistant","content":""},"logprobs":null,"finish_reason":null}]}
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","system_fingerprint":null,"choices":[{"index":0,"delta":{"content":"Hello"},"logprobs":null,"finish_reason":null}]}
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","system_fingerprint":null,"choices":[{"index":0,"delta":{"content":" world"},"logprobs":null,"finish_reason":null}]}
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","system_fingerprint":null,"choices":[{"index":0,"delta":{},"logprobs":null,"finish_reason":"stop"}]}
+data: [DONE]`
+
+// chunkShape reduces an SSE line to its structure: top-level keys, delta keys and finish reason.
+func chunkShape(t *testing.T, line string) string {
+	payload := strings.TrimPrefix(line, "data: ")
+	if payload == "[DONE]" {
+		return payload
+	}
+
+	var chunk map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+		t.Fatalf("Invalid chunk %q: %v", line, err)
+	}
+	choice := chunk["choices"].([]interface{})[0].(map[string]interface{})
+	delta := choice["delta"].(map[string]interface{})
+
+	keys := func(m map[string]interface{}) []string {
+		var out []string
+		for k := range m {
+			out = append(out, k)
+		}
+		sort.Strings(out)
+		return out
+	}
+	shape := map[string]interface{}{
+		"top":    keys(chunk),
+		"choice": keys(choice),
+		"delta":  keys(delta),
+		"role":   delta["role"],
+		"finish": choice["finish_reason"],
+	}
+	out, _ := json.Marshal(shape)
+	return string(out)
+}
+
+func TestGeminiToOpenAITranslationMatchesReference(t *testing.T) {
+	translator := NewGeminiToOpenAITranslator("gpt-4o")
+
+	events := []string{
+		`{"candidates":[{"content":{"parts":[{"text":"Hello"}],"role":"model"}}]}`,
+		`{"candidates":[{"content":{"parts":[{"text":" world"}],"role":"model"},"finishReason":"STOP"}]}`,
+	}
+
+	var translated []string
+	for _, event := range events {
+		var data map[string]interface{}
+		json.Unmarshal([]byte(event), &data)
+		translated = append(translated, translator.Translate(data)...)
+	}
+	translated = append(translated, translator.Finish()...)
+
+	reference := strings.Split(referenceOpenAIStream, "\n")
+	if len(translated) != len(reference) {
+		t.Fatalf("Expected %d lines, got %d:\n%s", len(reference), len(translated), strings.Join(translated, "\n"))
+	}
+
+	var content string
+	for i := range reference {
+		if got, want := chunkShape(t, translated[i]), chunkShape(t, reference[i]); !reflect.DeepEqual(got, want) {
+			t.Errorf("Line %d structure mismatch\n got: %s\nwant: %s", i, got, want)
+		}
+		if i > 0 && i < len(reference)-2 {
+			var chunk openAIChunk
+			json.Unmarshal([]byte(strings.TrimPrefix(translated[i], "data: ")), &chunk)
+			content += *chunk.Choices[0].Delta.Content
+		}
+	}
+	if content != "Hello world" {
+		t.Errorf("Expected translated content %q, got %q", "Hello world", content)
+	}
+}
+
+func TestGeminiToOpenAITranslationSynthesizesStop(t *testing.T) {
+	translator := NewGeminiToOpenAITranslator("m")
+	lines := translator.Finish()
+	if len(lines) != 3 || lines[2] != "data: [DONE]" || !strings.Contains(lines[1], `"finish_reason":"stop"`) {
+		t.Errorf("Expected role, stop and [DONE] lines, got %v", lines)
+	}
+}
+
+func TestGeminiUsageMetadataTranslatesToUsageChunk(t *testing.T) {
+	events := []string{
+		`{"candidates":[{"content":{"parts":[{"text":"Hello"}],"role":"model"}}],"usageMetadata":{"promptTokenCount":12,"candidatesTokenCount":1,"totalTokenCount":13}}`,
+		`{"candidates":[{"content":{"parts":[{"text":" world"}],"role":"model"},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":12,"candidatesTokenCount":2,"totalTokenCount":14}}`,
+	}
+	translate := func(includeUsage bool) []string {
+		translator := NewGeminiToOpenAITranslator("gpt-4o")
+		translator.SetIncludeUsage(includeUsage)
+		var lines []string
+		for _, event := range events {
+			var data map[string]interface{}
+			json.Unmarshal([]byte(event), &data)
+			lines = append(lines, translator.Translate(data)...)
+		}
+		return append(lines, translator.Finish()...)
+	}
+
+	lines := translate(true)
+	if len(lines) < 2 || lines[len(lines)-1] != "data: [DONE]" {
+		t.Fatalf("Expected the stream to end with [DONE], got %v", lines)
+	}
+	var chunk map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[len(lines)-2], "data: ")), &chunk); err != nil {
+		t.Fatalf("Invalid usage chunk: %v", err)
+	}
+	if choices, ok := chunk["choices"].([]interface{}); !ok || len(choices) != 0 {
+		t.Errorf("Expected the usage chunk to have empty choices, got %v", chunk["choices"])
+	}
+	want := map[string]interface{}{"prompt_tokens": 12.0, "completion_tokens": 2.0, "total_tokens": 14.0}
+	if !reflect.DeepEqual(chunk["usage"], want) {
+		t.Errorf("Expected usage %v from the last usageMetadata, got %v", want, chunk["usage"])
+	}
+
+	for _, line := range translate(false) {
+		if strings.Contains(line, `"usage"`) {
+			t.Errorf("Expected no usage chunk unless requested, got %s", line)
+		}
+	}
+}
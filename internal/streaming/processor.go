@@ -1,14 +1,37 @@
 package streaming
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"time"
 
 	"gpt-load/internal/models"
+	"gpt-load/internal/utils"
 )
 
-// ChannelRetryFunc defines the function signature for retry requests
+// ChannelRetryFunc defines the function signature for retry requests. An empty
+// accumulatedText means nothing was received yet, so the original request is replayed
+// unchanged instead of asking the model to continue from an empty answer.
 type ChannelRetryFunc func(accumulatedText string) (*http.Response, error)
 
+// RetryStatusError is returned by a ChannelRetryFunc when the upstream answers a retry with
+// an error status instead of a stream. A retryable error uses up an attempt and the request
+// is retried; any other error ends the stream.
+type RetryStatusError struct {
+	StatusCode int
+	Message    string
+	Retryable  bool
+}
+
+func (e *RetryStatusError) Error() string {
+	return fmt.Sprintf("retry request failed with status %d: %s", e.StatusCode, e.Message)
+}
+
+// ChannelRepairFunc defines the function signature for requests that ask the upstream to
+// correct a structured response which failed validation
+type ChannelRepairFunc func(invalidText, problem string) (*http.Response, error)
+
 // StreamProcessor defines the interface for stream processing
 type StreamProcessor interface {
 	// HandleStreamingResponse handles streaming response with retry logic
@@ -21,6 +44,15 @@ type StreamProcessor interface {
 		retryFunc ChannelRetryFunc,
 	) error
 
+	// SetRepairFunc sets the request used to repair invalid structured responses
+	SetRepairFunc(repairFunc ChannelRepairFunc)
+
+	// SetTokenBudget sets the output token budget of the stream
+	SetTokenBudget(tokens int)
+
+	// SetContext sets the context of the client request, which ends the stream when done
+	SetContext(ctx context.Context)
+
 	// GetStreamConfig returns the stream configuration for this processor
 	GetStreamConfig() StreamConfig
 }
@@ -51,11 +83,35 @@ func (p *DefaultStreamProcessor) HandleStreamingResponse(
 	return p.handler.HandleStreamingResponse(resp, writer, channelType, originalRequest, retryFunc)
 }
 
+// SetRepairFunc implements StreamProcessor interface
+func (p *DefaultStreamProcessor) SetRepairFunc(repairFunc ChannelRepairFunc) {
+	p.handler.SetRepairFunc(repairFunc)
+}
+
+// SetTokenBudget implements StreamProcessor interface
+func (p *DefaultStreamProcessor) SetTokenBudget(tokens int) {
+	p.handler.SetTokenBudget(tokens)
+	p.config.TokenBudget = tokens
+}
+
+// SetContext implements StreamProcessor interface
+func (p *DefaultStreamProcessor) SetContext(ctx context.Context) {
+	p.handler.SetContext(ctx)
+}
+
 // GetStreamConfig implements StreamProcessor interface
 func (p *DefaultStreamProcessor) GetStreamConfig() StreamConfig {
 	return p.config
 }
 
+// Values of the stream_punctuation_heuristic group setting. Auto, like an empty value, keeps
+// the channel default.
+const (
+	PunctuationHeuristicAuto = "auto"
+	PunctuationHeuristicOn   = "on"
+	PunctuationHeuristicOff  = "off"
+)
+
 // StreamProcessorFactory creates stream processors for different channels
 type StreamProcessorFactory struct{}
 
@@ -68,35 +124,91 @@ func NewStreamProcessorFactory() *StreamProcessorFactory {
 func (f *StreamProcessorFactory) CreateProcessor(channelType string, group *models.Group) StreamProcessor {
 	// Base configuration
 	config := StreamConfig{
-		MaxRetries:                3,
-		RetryDelay:                1 * 1000 * 1000 * 1000, // 1 second in nanoseconds
+		Logger:                     utils.GroupLogger(group),
+		MaxRetries:                 3,
+		RetryDelay:                 time.Second,
 		EnablePunctuationHeuristic: true,
-		DoneTokenPatterns:         []string{"[done]", "[DONE]", "done", "DONE"},
+		DoneTokenPatterns:          []string{"[done]", "[DONE]", "done", "DONE"},
 	}
 
 	// Channel-specific configurations
 	switch channelType {
 	case "gemini":
-		config.MaxRetries = 5 // Gemini is more prone to forgetting [done]
+		config.MaxRetries = 5                       // Gemini is more prone to forgetting [done]
+		config.RetryDelay = 1500 * time.Millisecond // Gemini needs longer to recover from truncation
 		config.DoneTokenPatterns = []string{"[done]", "[DONE]", "done", "DONE"}
 		config.EnablePunctuationHeuristic = true
-		
+		config.OverlapWindow = DefaultGeminiOverlapWindow // Gemini tends to repeat itself when continuing
+
 	case "openai":
 		config.MaxRetries = 2 // OpenAI is more reliable
+		config.RetryDelay = 500 * time.Millisecond
 		config.DoneTokenPatterns = []string{} // OpenAI uses [DONE] signal
 		config.EnablePunctuationHeuristic = false
-		
+
 	case "anthropic":
 		config.MaxRetries = 2
+		config.RetryDelay = 750 * time.Millisecond
 		config.DoneTokenPatterns = []string{} // Anthropic uses message_stop signal
 		config.EnablePunctuationHeuristic = false
-		
+
 	default:
 		// Generic configuration for unknown channels
 		config.MaxRetries = 3
+		config.RetryDelay = time.Second
 		config.DoneTokenPatterns = []string{"[done]", "[DONE]", "done", "DONE"}
 		config.EnablePunctuationHeuristic = true
 	}
 
+	if group != nil {
+		if retries := group.EffectiveConfig.StreamMaxRetries; retries > 0 {
+			config.MaxRetries = retries
+		}
+		if tokens := ParseDoneTokens(group.EffectiveConfig.StreamDoneTokens); len(tokens) > 0 {
+			config.DoneTokenPatterns = tokens
+		}
+		switch group.EffectiveConfig.StreamPunctuationHeuristic {
+		case PunctuationHeuristicOn:
+			config.EnablePunctuationHeuristic = true
+		case PunctuationHeuristicOff:
+			config.EnablePunctuationHeuristic = false
+		}
+		if delay := group.EffectiveConfig.StreamRetryDelayMs; delay > 0 {
+			config.RetryDelay = time.Duration(delay) * time.Millisecond
+		}
+		config.RetryBackoffFactor = float64(group.EffectiveConfig.StreamRetryBackoffPercent) / 100
+		config.MaxRetryDelay = time.Duration(group.EffectiveConfig.StreamMaxRetryDelayMs) * time.Millisecond
+		config.ReconnectOnGoAway = group.EffectiveConfig.StreamGoAwayReconnect > 0
+		if name := group.EffectiveConfig.StreamRetryDecider; name != "" {
+			if decider, ok := lookupRetryDecider(name); ok {
+				config.RetryDecider = decider
+			} else {
+				config.Logger.Warnf("Unknown stream retry decider %q, using the default", name)
+			}
+		}
+		config.PunctuationOnFirstAttempt = config.EnablePunctuationHeuristic && group.EffectiveConfig.FirstAttemptPunctuation > 0
+		config.MaxChunkChars = group.EffectiveConfig.MaxChunkChars
+		config.JSONRepairAttempts = group.EffectiveConfig.JSONRepairAttempts
+		config.SingleJSONAsJSON = group.EffectiveConfig.StreamJSONResponse == "json"
+		config.EmptyStreamDiagnostic = group.EffectiveConfig.EmptyStreamDiagnostic > 0
+		config.StopRetryPhrases = ParseStopRetryPhrases(group.EffectiveConfig.StopRetryPhrases)
+		config.DedupeChunks = group.EffectiveConfig.DedupeStreamChunks > 0
+		config.ContentAnalysisMinChars = group.EffectiveConfig.ContentAnalysisMinChars
+		config.ContinuationMarker = group.EffectiveConfig.ContinuationMarker
+		config.ContinuationFillers = ParseContinuationFillers(group.EffectiveConfig.ContinuationFillerPhrases)
+		config.OpenAITerminalReasons = ParseTerminalFinishReasons(group.EffectiveConfig.OpenAITerminalFinishReasons)
+		config.GeminiTerminalReasons = ParseTerminalFinishReasons(group.EffectiveConfig.GeminiTerminalFinishReasons)
+		config.WriteTimeout = time.Duration(group.EffectiveConfig.ClientWriteTimeout) * time.Second
+		config.IdleTimeout = time.Duration(group.EffectiveConfig.StreamIdleTimeout) * time.Second
+		config.RecordSnapshots = group.EffectiveConfig.DeadLetterSnapshots > 0
+		config.CodeFenceCompletion = group.EffectiveConfig.CodeFenceCompletion > 0
+		config.StrictCompletion = group.EffectiveConfig.StrictCompletion > 0
+		config.TokenBudget = group.EffectiveConfig.StreamTokenBudget
+		config.IncludeUsage = group.EffectiveConfig.StreamIncludeUsage > 0
+		if dir := group.EffectiveConfig.DeadLetterDir; dir != "" {
+			config.DeadLetter = NewFileDeadLetterSink(dir, group.Name)
+		}
+	}
+
 	return NewDefaultStreamProcessor(config)
-}
\ No newline at end of file
+}
@@ -0,0 +1,179 @@
+package streaming
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// rechunkLine splits an SSE data line whose text is longer than maxChunkChars into several
+// lines of the same channel format, each carrying at most maxChunkChars characters. Finish
+// markers and usage stay on the last sub-chunk so clients see them after all the text.
+// Lines that cannot be split are returned unchanged.
+func (sh *StreamHandler) rechunkLine(line string, channelType string) []string {
+	if sh.maxChunkChars <= 0 || !strings.HasPrefix(line, "data: ") || !utf8.ValidString(line) {
+		return []string{line}
+	}
+
+	dataContent := strings.TrimPrefix(line, "data: ")
+	event, err := decodeEvent(dataContent)
+	if err != nil {
+		return []string{line}
+	}
+	text, ok := chunkText(event, channelType)
+	if !ok || utf8.RuneCountInString(text) <= sh.maxChunkChars {
+		return []string{line}
+	}
+
+	pieces := splitRunes(text, sh.maxChunkChars)
+	lines := make([]string, 0, len(pieces))
+	for i, piece := range pieces {
+		// Each sub-chunk gets its own copy of the event so the edits don't leak between them
+		subEvent, err := decodeEvent(dataContent)
+		if err != nil {
+			return []string{line}
+		}
+		setChunkText(subEvent, channelType, piece)
+		if i < len(pieces)-1 {
+			clearFinishMarkers(subEvent, channelType)
+		}
+
+		payload, err := json.Marshal(subEvent)
+		if err != nil {
+			return []string{line}
+		}
+		lines = append(lines, "data: "+string(payload))
+	}
+	return lines
+}
+
+// decodeEvent parses an event, keeping numbers as written so large integers survive re-marshaling.
+func decodeEvent(content string) (map[string]interface{}, error) {
+	var event map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// chunkText returns the text field of an event that rechunking knows how to rewrite.
+// Gemini events are only split when the text sits in a single part.
+func chunkText(event map[string]interface{}, channelType string) (string, bool) {
+	switch channelType {
+	case "openai":
+		if delta := firstChoiceDelta(event); delta != nil {
+			text, ok := delta["content"].(string)
+			return text, ok
+		}
+	case "gemini":
+		if parts := firstGeminiParts(event); len(parts) == 1 {
+			if part, ok := parts[0].(map[string]interface{}); ok {
+				text, ok := part["text"].(string)
+				return text, ok
+			}
+		}
+	case "anthropic":
+		if typ, _ := event["type"].(string); typ == "content_block_delta" {
+			if delta, ok := event["delta"].(map[string]interface{}); ok {
+				text, ok := delta["text"].(string)
+				return text, ok
+			}
+		}
+	default:
+		if text, ok := event["text"].(string); ok {
+			return text, true
+		}
+		if content, ok := event["content"].(string); ok {
+			return content, true
+		}
+	}
+	return "", false
+}
+
+// setChunkText writes text into the field chunkText read it from.
+func setChunkText(event map[string]interface{}, channelType string, text string) {
+	switch channelType {
+	case "openai":
+		firstChoiceDelta(event)["content"] = text
+	case "gemini":
+		firstGeminiParts(event)[0].(map[string]interface{})["text"] = text
+	case "anthropic":
+		event["delta"].(map[string]interface{})["text"] = text
+	default:
+		if _, ok := event["text"].(string); ok {
+			event["text"] = text
+		} else {
+			event["content"] = text
+		}
+	}
+}
+
+// clearFinishMarkers removes the completion signals and usage from a sub-chunk that is not last.
+func clearFinishMarkers(event map[string]interface{}, channelType string) {
+	switch channelType {
+	case "openai":
+		if choices, ok := event["choices"].([]interface{}); ok && len(choices) > 0 {
+			if choice, ok := choices[0].(map[string]interface{}); ok {
+				if _, ok := choice["finish_reason"]; ok {
+					choice["finish_reason"] = nil
+				}
+			}
+		}
+		delete(event, "usage")
+	case "gemini":
+		if candidate := firstGeminiCandidate(event); candidate != nil {
+			delete(candidate, "finishReason")
+		}
+		delete(event, "usageMetadata")
+	case "anthropic":
+		// Text deltas never carry a stop reason
+	default:
+		delete(event, "finish_reason")
+	}
+}
+
+// firstChoiceDelta returns choices[0].delta of an OpenAI event, if any.
+func firstChoiceDelta(event map[string]interface{}) map[string]interface{} {
+	choices, ok := event["choices"].([]interface{})
+	if !ok || len(choices) == 0 {
+		return nil
+	}
+	choice, ok := choices[0].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	delta, _ := choice["delta"].(map[string]interface{})
+	return delta
+}
+
+// firstGeminiParts returns the content parts of the first candidate of a Gemini event.
+func firstGeminiParts(event map[string]interface{}) []interface{} {
+	candidate := firstGeminiCandidate(event)
+	if candidate == nil {
+		return nil
+	}
+	content, ok := candidate["content"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	parts, _ := content["parts"].([]interface{})
+	return parts
+}
+
+// splitRunes cuts text into pieces of at most size characters, never splitting a character.
+func splitRunes(text string, size int) []string {
+	var pieces []string
+	for text != "" {
+		end, count := 0, 0
+		for end < len(text) && count < size {
+			_, width := utf8.DecodeRuneInString(text[end:])
+			end += width
+			count++
+		}
+		pieces = append(pieces, text[:end])
+		text = text[end:]
+	}
+	return pieces
+}
@@ -0,0 +1,124 @@
+package streaming
+
+import (
+	"errors"
+	"sync"
+)
+
+// RetryAction is what the handler does after an attempt.
+type RetryAction int
+
+const (
+	// RetryWithContext waits for the retry delay and continues from the accumulated text.
+	RetryWithContext RetryAction = iota
+	// RetryOriginal sends the original request again right away, without the accumulated
+	// text. It suits streams that received nothing yet, where replaying repeats nothing.
+	RetryOriginal
+	// RetryComplete ends the stream with what was received.
+	RetryComplete
+	// RetryFail gives up and reports the retries as exhausted.
+	RetryFail
+)
+
+// RetryContext describes the attempt that just ended.
+type RetryContext struct {
+	// Attempt counts the attempts so far, 1 for the first request.
+	Attempt    int
+	MaxRetries int
+	Outcome    AttemptOutcome
+	// StatusCode and Err are set when a retry request was answered with an error status.
+	StatusCode int
+	Err        error
+	// AccumulatedText is everything received so far, ReceivedChars the characters this
+	// attempt added to it and to the arguments of tool calls.
+	AccumulatedText string
+	ReceivedChars   int
+	// ToolCallForwarded reports that part of a tool call already reached the client. Neither
+	// a replay nor a continuation can extend a call, so such a stream is never retried.
+	ToolCallForwarded bool
+	// Completion is the signal that completed the attempt, if any.
+	Completion CompletionReason
+	// StopRetryPhrase is the configured phrase found in the text of an incomplete attempt.
+	StopRetryPhrase string
+}
+
+// RetryDecider decides how a stream goes on after each attempt. Whatever it decides, no
+// retry is made once MaxRetries retries have been spent.
+type RetryDecider interface {
+	Decide(ctx RetryContext) RetryAction
+}
+
+// RetryDeciderFunc adapts a function to a RetryDecider.
+type RetryDeciderFunc func(ctx RetryContext) RetryAction
+
+// Decide implements RetryDecider.
+func (f RetryDeciderFunc) Decide(ctx RetryContext) RetryAction {
+	return f(ctx)
+}
+
+// DefaultRetryDecider completes attempts that signaled completion or hit a stop-retry phrase,
+// replays the original request right away after a network error before any text, fails on error statuses
+// the retry policy does not cover, and otherwise continues from the accumulated text.
+type DefaultRetryDecider struct{}
+
+// Decide implements RetryDecider.
+func (DefaultRetryDecider) Decide(ctx RetryContext) RetryAction {
+	switch {
+	case ctx.Outcome == AttemptComplete:
+		return RetryComplete
+	case ctx.Outcome == AttemptErrorStatus:
+		var statusErr *RetryStatusError
+		if !errors.As(ctx.Err, &statusErr) || !statusErr.Retryable {
+			return RetryFail
+		}
+	case ctx.StopRetryPhrase != "":
+		return RetryComplete
+	}
+
+	if ctx.Attempt > ctx.MaxRetries {
+		return RetryFail
+	}
+	if ctx.Outcome == AttemptNetworkError && ctx.AccumulatedText == "" {
+		return RetryOriginal
+	}
+	return RetryWithContext
+}
+
+var (
+	retryDecidersMu sync.RWMutex
+	retryDeciders   = map[string]RetryDecider{}
+)
+
+// RegisterRetryDecider makes a decider available to groups that name it in their
+// stream_retry_decider setting.
+func RegisterRetryDecider(name string, decider RetryDecider) {
+	retryDecidersMu.Lock()
+	defer retryDecidersMu.Unlock()
+	retryDeciders[name] = decider
+}
+
+// lookupRetryDecider returns the decider registered under name.
+func lookupRetryDecider(name string) (RetryDecider, bool) {
+	retryDecidersMu.RLock()
+	defer retryDecidersMu.RUnlock()
+	decider, ok := retryDeciders[name]
+	return decider, ok
+}
+
+// decide asks the decider how to go on, holding it to the retry limit and to never retrying
+// a stream whose tool call reached the client.
+func (sh *StreamHandler) decide(ctx RetryContext) RetryAction {
+	ctx.MaxRetries = sh.maxRetries
+	action := sh.retryDecider.Decide(ctx)
+	if action != RetryWithContext && action != RetryOriginal {
+		return action
+	}
+	if ctx.ToolCallForwarded {
+		sh.log.Warn("Stream ended inside a tool call the client already received, not retrying")
+		return RetryFail
+	}
+	if ctx.Attempt > sh.maxRetries {
+		return RetryFail
+	}
+	return action
+}
@@ -0,0 +1,129 @@
+package streaming
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gpt-load/internal/models"
+)
+
+func TestDefaultRetryDecider(t *testing.T) {
+	tests := []struct {
+		name     string
+		ctx      RetryContext
+		expected RetryAction
+	}{
+		{"completed stream", RetryContext{Attempt: 1, MaxRetries: 2, Outcome: AttemptComplete, Completion: CompletionProtocolSignal}, RetryComplete},
+		{"completed on the last attempt", RetryContext{Attempt: 3, MaxRetries: 2, Outcome: AttemptComplete, Completion: CompletionDoneToken}, RetryComplete},
+		{"incomplete answer", RetryContext{Attempt: 1, MaxRetries: 2, Outcome: AttemptIncomplete, AccumulatedText: "Half of", ReceivedChars: 7}, RetryWithContext},
+		{"incomplete without text", RetryContext{Attempt: 1, MaxRetries: 2, Outcome: AttemptIncomplete}, RetryWithContext},
+		{"network error before any text", RetryContext{Attempt: 1, MaxRetries: 2, Outcome: AttemptNetworkError}, RetryOriginal},
+		{"network error after text on an earlier attempt", RetryContext{Attempt: 2, MaxRetries: 2, Outcome: AttemptNetworkError, AccumulatedText: "Half"}, RetryWithContext},
+		{"network error after text", RetryContext{Attempt: 2, MaxRetries: 2, Outcome: AttemptNetworkError, AccumulatedText: "Half", ReceivedChars: 4}, RetryWithContext},
+		{"connection went away", RetryContext{Attempt: 1, MaxRetries: 2, Outcome: AttemptGoAway, AccumulatedText: "Half", ReceivedChars: 4}, RetryWithContext},
+		{"stop-retry phrase", RetryContext{Attempt: 1, MaxRetries: 2, Outcome: AttemptIncomplete, StopRetryPhrase: "I cannot continue"}, RetryComplete},
+		{"retries exhausted", RetryContext{Attempt: 3, MaxRetries: 2, Outcome: AttemptIncomplete}, RetryFail},
+		{"retryable error status", RetryContext{Attempt: 2, MaxRetries: 2, Outcome: AttemptErrorStatus, StatusCode: 429, Err: &RetryStatusError{StatusCode: 429, Retryable: true}}, RetryWithContext},
+		{"retryable error status without retries left", RetryContext{Attempt: 3, MaxRetries: 2, Outcome: AttemptErrorStatus, StatusCode: 429, Err: &RetryStatusError{StatusCode: 429, Retryable: true}}, RetryFail},
+		{"non-retryable error status", RetryContext{Attempt: 2, MaxRetries: 2, Outcome: AttemptErrorStatus, StatusCode: 400, Err: &RetryStatusError{StatusCode: 400}}, RetryFail},
+	}
+
+	for _, test := range tests {
+		if got := (DefaultRetryDecider{}).Decide(test.ctx); got != test.expected {
+			t.Errorf("%s: expected action %d, got %d", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestCustomRetryDecider(t *testing.T) {
+	// Accept whatever the first attempt delivered, as long as it has some text
+	decider := RetryDeciderFunc(func(ctx RetryContext) RetryAction {
+		if ctx.AccumulatedText != "" {
+			return RetryComplete
+		}
+		return RetryWithContext
+	})
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]"}, RetryDecider: decider})
+
+	retries := 0
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retries++
+		return newStreamResponse(geminiChunk(" the rest. [done]")), nil
+	}
+
+	recorder := httptest.NewRecorder()
+	if err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk("Half of")), recorder, "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to end without error, got %v", err)
+	}
+	if retries != 0 {
+		t.Errorf("Expected the decider to complete the stream without retrying, got %d retries", retries)
+	}
+	if !strings.Contains(recorder.Body.String(), "X-GPT-Load-Attempts: 1") {
+		t.Errorf("Expected the attempts trailer, got %q", recorder.Body.String())
+	}
+}
+
+func TestRetryDeciderIsHeldToRetryLimit(t *testing.T) {
+	always := RetryDeciderFunc(func(ctx RetryContext) RetryAction { return RetryOriginal })
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Millisecond, RetryDecider: always})
+
+	retries := 0
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retries++
+		return newStreamResponse(""), nil
+	}
+
+	handler.HandleStreamingResponse(newStreamResponse(""), httptest.NewRecorder(), "gemini", nil, retryFunc)
+	if retries != 2 {
+		t.Errorf("Expected the retry limit to stop the decider after 2 retries, got %d", retries)
+	}
+}
+
+func TestGroupSelectsRegisteredRetryDecider(t *testing.T) {
+	decider := RetryDeciderFunc(func(ctx RetryContext) RetryAction { return RetryFail })
+	RegisterRetryDecider("test-fail-fast", decider)
+
+	group := &models.Group{Name: "test"}
+	group.EffectiveConfig.StreamRetryDecider = "test-fail-fast"
+	config := NewStreamProcessorFactory().CreateProcessor("gemini", group).GetStreamConfig()
+	if _, ok := config.RetryDecider.(RetryDeciderFunc); !ok {
+		t.Errorf("Expected the group to use the registered decider, got %T", config.RetryDecider)
+	}
+
+	group.EffectiveConfig.StreamRetryDecider = "unknown"
+	config = NewStreamProcessorFactory().CreateProcessor("gemini", group).GetStreamConfig()
+	if config.RetryDecider != nil {
+		t.Errorf("Expected an unknown decider to fall back to the default, got %T", config.RetryDecider)
+	}
+}
+
+func TestRetryOriginalReplaysOriginalRequest(t *testing.T) {
+	// Restart from scratch whatever was received, as a decider for idempotent streams might
+	decider := RetryDeciderFunc(func(ctx RetryContext) RetryAction {
+		if ctx.Outcome == AttemptComplete {
+			return RetryComplete
+		}
+		return RetryOriginal
+	})
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Second, DoneTokenPatterns: []string{"[done]"}, RetryDecider: decider})
+
+	var retriedWith []string
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retriedWith = append(retriedWith, accumulatedText)
+		return newStreamResponse(geminiChunk("Full answer. [done]")), nil
+	}
+
+	start := time.Now()
+	if err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk("Half of")), httptest.NewRecorder(), "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete after the replay, got %v", err)
+	}
+	if len(retriedWith) != 1 || retriedWith[0] != "" {
+		t.Errorf("Expected one retry replaying the original request, got %q", retriedWith)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("Expected the replay to skip the retry delay, took %v", elapsed)
+	}
+}
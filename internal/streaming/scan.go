@@ -0,0 +1,182 @@
+package streaming
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// lineSplitter splits an SSE stream into lines at LF, CRLF or a lone CR, the three line
+// endings the SSE spec allows, and remembers whether the last line it returned was cut off by
+// the end of the stream rather than terminated. bufio.Scanner hands such a partial line over
+// like any other, so without this a truncated stream looks clean.
+type lineSplitter struct {
+	partial      bool
+	partialBytes int
+}
+
+// Split implements bufio.SplitFunc.
+func (ls *lineSplitter) Split(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		switch {
+		case data[i] == '\n':
+			return ls.line(i+1, data[:i], false)
+		case i+1 < len(data) && data[i+1] == '\n':
+			return ls.line(i+2, data[:i], false)
+		case i+1 < len(data) || atEOF:
+			return ls.line(i+1, data[:i], false)
+		default:
+			// A CR at the end of the buffer may be the start of a CRLF
+			return 0, nil, nil
+		}
+	}
+	if atEOF {
+		return ls.line(len(data), data, true)
+	}
+	return 0, nil, nil
+}
+
+func (ls *lineSplitter) line(advance int, token []byte, partial bool) (int, []byte, error) {
+	ls.partial = partial
+	ls.partialBytes = 0
+	if partial {
+		ls.partialBytes = len(token)
+	}
+	return advance, token, nil
+}
+
+// dataLineJoiner returns the lines of an SSE stream, joining the data lines of an event that
+// spreads its JSON payload over several of them, as the SSE spec allows. The joined payload
+// is compacted into a single data line, so it is processed and forwarded like any other
+// event. Data lines that don't form one JSON value together are returned one by one.
+type dataLineJoiner struct {
+	scanner  *bufio.Scanner
+	maxBytes int
+	queue    []string
+	line     string
+}
+
+// Scan advances to the next line, like bufio.Scanner.Scan.
+func (j *dataLineJoiner) Scan() bool {
+	if len(j.queue) == 0 && !j.fill() {
+		return false
+	}
+	j.line, j.queue = j.queue[0], j.queue[1:]
+	return true
+}
+
+// Text returns the current line.
+func (j *dataLineJoiner) Text() string {
+	return j.line
+}
+
+// fill queues the lines up to and including the end of the next run of data lines.
+func (j *dataLineJoiner) fill() bool {
+	if !j.scanner.Scan() {
+		return false
+	}
+	first := j.scanner.Text()
+	if !isDataLine(first) {
+		j.queue = append(j.queue, first)
+		return true
+	}
+
+	group := []string{first}
+	size := len(first)
+	var next string
+	more := false
+	for size <= j.maxBytes && j.scanner.Scan() {
+		line := j.scanner.Text()
+		if !isDataLine(line) {
+			next, more = line, true
+			break
+		}
+		group = append(group, line)
+		size += len(line)
+	}
+
+	if joined, ok := joinDataLines(group); ok {
+		j.queue = append(j.queue, joined)
+	} else {
+		j.queue = append(j.queue, group...)
+	}
+	if more {
+		j.queue = append(j.queue, next)
+	}
+	return true
+}
+
+// joinDataLines joins the payloads of several data lines with newlines, as the SSE spec
+// does, and returns them as one data line if the result is a single JSON value.
+func joinDataLines(group []string) (string, bool) {
+	if len(group) < 2 {
+		return "", false
+	}
+	payloads := make([]string, len(group))
+	for i, line := range group {
+		payloads[i] = strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(strings.Join(payloads, "\n"))); err != nil {
+		return "", false
+	}
+	return "data: " + compact.String(), true
+}
+
+func isDataLine(line string) bool {
+	return strings.HasPrefix(line, "data:")
+}
+
+// isDoneSignal reports whether an SSE line is the [DONE] terminator, also when the server
+// omits the space after data: or pads the line with whitespace.
+func isDoneSignal(line string) bool {
+	payload, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+	return ok && strings.TrimSpace(payload) == "[DONE]"
+}
+
+// DefaultMaxGarbageLines is the number of consecutive binary lines after which an attempt
+// is abandoned.
+const DefaultMaxGarbageLines = 8
+
+// garbageDetector counts consecutive lines that are neither valid events nor text. A broken
+// proxy in front of the upstream can turn a stream into binary noise that never parses, and
+// scanning it to the end only delays the retry.
+type garbageDetector struct {
+	limit  int
+	streak int
+}
+
+// Observe records a line that failed to parse and reports whether the limit was reached.
+// Lines that are merely malformed text don't count, only binary ones.
+func (g *garbageDetector) Observe(line string) bool {
+	if !isBinaryGarbage(line) {
+		g.streak = 0
+		return false
+	}
+	g.streak++
+	return g.streak >= g.limit
+}
+
+// Reset clears the streak after a valid event.
+func (g *garbageDetector) Reset() {
+	g.streak = 0
+}
+
+// isBinaryGarbage reports whether s holds control characters or invalid UTF-8, which no
+// text event stream contains.
+func isBinaryGarbage(s string) bool {
+	if !utf8.ValidString(s) {
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < 0x20 && c != '\t') || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package streaming
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// isSingleJSONResponse reports whether the upstream answered a streaming request with one
+// complete JSON object instead of a stream. JSON-array framed streams are not affected.
+func (sh *StreamHandler) isSingleJSONResponse(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return false
+	}
+
+	body := newPeekableBody(resp.Body)
+	resp.Body = body
+	return peekFirstByte(body.Reader) == '{'
+}
+
+// forwardJSONResponse completes a streaming request that the upstream answered with a
+// complete non-streamed JSON response. The response is forwarded as a single SSE event, or
+// as a regular JSON response when asJSON is set. There is nothing left to continue, so it
+// is never retried unless it cannot be read.
+func (sh *StreamHandler) forwardJSONResponse(
+	resp *http.Response,
+	writer http.ResponseWriter,
+	channelType string,
+	accumulatedText *string,
+	finishReason *FinishReason,
+	lastEvent *map[string]interface{},
+	asJSON bool,
+) (AttemptOutcome, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(sh.maxLineBytes)+1))
+	if err != nil {
+		sh.log.Errorf("Failed to read JSON response: %v", err)
+		return AttemptNetworkError, nil // Trigger retry
+	}
+
+	var compact bytes.Buffer
+	var data map[string]interface{}
+	if len(body) > sh.maxLineBytes || json.Compact(&compact, body) != nil || json.Unmarshal(body, &data) != nil {
+		sh.log.Warn("Upstream returned an unreadable JSON response to a streaming request")
+		return AttemptIncomplete, nil // Trigger retry
+	}
+	sh.log.Debug("Upstream returned a complete JSON response to a streaming request")
+	*lastEvent = data
+
+	*accumulatedText += sh.extractTextFromData(data, channelType)
+	if reason := sh.extractFinishReason(data, channelType); reason != "" {
+		*finishReason = NormalizeFinishReason(channelType, reason)
+	}
+
+	line := "data: " + compact.String()
+	if channelType == "gemini" {
+		line = sh.removeDoneTokensFromLine(line, data)
+	}
+
+	if asJSON {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Del("Content-Length")
+		if _, err := io.WriteString(writer, strings.TrimPrefix(line, "data: ")); err != nil {
+			return AttemptIncomplete, fmt.Errorf("failed to write to client: %w", err)
+		}
+		return AttemptComplete, nil
+	}
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Del("Content-Length")
+	if _, err := fmt.Fprintf(writer, "%s\n\n", line); err != nil {
+		return AttemptIncomplete, fmt.Errorf("failed to write to client: %w", err)
+	}
+	if flusher, ok := writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return AttemptComplete, nil
+}
@@ -0,0 +1,39 @@
+package streaming
+
+import "strings"
+
+// StopRetryHeader reports, as an SSE comment, the phrase that ended retries for a stream
+// that stopped incomplete.
+const StopRetryHeader = "X-GPT-Load-Stop-Retry"
+
+// ParseStopRetryPhrases splits the comma-separated stop_retry_phrases setting.
+func ParseStopRetryPhrases(value string) []string {
+	return splitCommaList(value)
+}
+
+// splitCommaList splits a comma-separated setting, dropping blank entries.
+func splitCommaList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// matchStopRetryPhrase returns the first configured phrase found in the accumulated text,
+// ignoring case, or "" if there is none. A model that refuses or reports an error keeps
+// doing so when asked to continue, so retrying such a stream only wastes requests.
+func (sh *StreamHandler) matchStopRetryPhrase(accumulatedText string) string {
+	if len(sh.stopRetryPhrases) == 0 {
+		return ""
+	}
+	text := strings.ToLower(accumulatedText)
+	for _, phrase := range sh.stopRetryPhrases {
+		if strings.Contains(text, strings.ToLower(phrase)) {
+			return phrase
+		}
+	}
+	return ""
+}
@@ -0,0 +1,39 @@
+package streaming
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStopRetryPhraseHaltsRetries(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{
+		MaxRetries:       3,
+		RetryDelay:       time.Millisecond,
+		StopRetryPhrases: ParseStopRetryPhrases(" I cannot continue , ,As an AI"),
+	})
+
+	retries := 0
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retries++
+		return newStreamResponse(geminiChunk("Sorry, I cannot continue with")), nil
+	}
+
+	recorder := httptest.NewRecorder()
+	err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk("Let me see")), recorder, "gemini", nil, retryFunc)
+	if err != nil {
+		t.Fatalf("Expected the received content to be delivered, got %v", err)
+	}
+	if retries != 1 {
+		t.Errorf("Expected retries to stop once the phrase appeared, got %d retries", retries)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, ": "+StopRetryHeader+": I cannot continue\n\n") {
+		t.Errorf("Expected the stop-retry trailer, got body %q", body)
+	}
+	if !strings.Contains(body, ": "+AttemptsHeader+": 2\n\n") {
+		t.Errorf("Expected attempts trailer with 2 attempts, got body %q", body)
+	}
+}
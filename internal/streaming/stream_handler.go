@@ -2,29 +2,214 @@ package streaming
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/sirupsen/logrus"
 )
 
+// AttemptsHeader reports how many upstream attempts were needed to serve a response.
+const AttemptsHeader = "X-GPT-Load-Attempts"
+
+// DefaultMaxLineBytes bounds the size of a single SSE line the scanner will buffer when no
+// other limit is configured. Gemini events with inline images easily exceed bufio's 64KB,
+// while 1MB still keeps a runaway line from holding much memory per stream.
+const DefaultMaxLineBytes = 1024 * 1024
+
+// ErrRetryLimitExceeded is returned once the retry budget is spent; the error has
+// already been reported to the client when it is returned.
+var ErrRetryLimitExceeded = errors.New("retry limit exceeded")
+
+// ErrStreamTruncated is returned in strict completion mode when the retries are spent
+// without an explicit completion signal. It wraps ErrRetryLimitExceeded.
+var ErrStreamTruncated = fmt.Errorf("%w: no explicit completion signal", ErrRetryLimitExceeded)
+
+// DefaultSentencePunctuation is the set of runes treated as sentence-ending punctuation.
+const DefaultSentencePunctuation = "。？！.!?…\"'\"'"
+
 // StreamHandler handles streaming responses with intelligent retry logic
 type StreamHandler struct {
-	maxRetries           int
-	retryDelay           time.Duration
+	maxRetries                 int
+	retryDelay                 time.Duration
+	retryBackoffFactor         float64
+	maxRetryDelay              time.Duration
 	enablePunctuationHeuristic bool
-	doneTokenPatterns    []string
+	punctuationOnFirstAttempt  bool
+	doneTokenPatterns          []string
+	sentencePunctuation        string
+	largeEventBytes            int
+	dropReasoning              bool
+	accumulateReasoning        bool
+	maxChunkChars              int
+	maxGarbageLines            int
+	maxLineBytes               int
+	reconnectOnGoAway          bool
+	retryDecider               RetryDecider
+	deadLetter                 DeadLetterSink
+	jsonRepairAttempts         int
+	repairFunc                 ChannelRepairFunc
+	singleJSONAsJSON           bool
+	emptyStreamDiagnostic      bool
+	stopRetryPhrases           []string
+	dedupeChunks               bool
+	contentAnalysisMinChars    int
+	continuationMarker         string
+	continuationFillers        []string
+	overlapWindow              int
+	openAITerminalReasons      []string
+	geminiTerminalReasons      []string
+	writeTimeout               time.Duration
+	idleTimeout                time.Duration
+	recordSnapshots            bool
+	codeFenceCompletion        bool
+	strictCompletion           bool
+	tokenBudget                int
+	includeUsage               bool
+	usage                      *usageTally
+	toolCallForwarded          bool
+	toolArgumentChars          int
+	ctx                        context.Context
+	log                        logrus.FieldLogger
 }
 
 // StreamConfig configures the streaming handler
 type StreamConfig struct {
-	MaxRetries                int
-	RetryDelay                time.Duration
-	EnablePunctuationHeuristic bool
-	DoneTokenPatterns         []string
+	MaxRetries                 int           `json:"max_retries"`
+	RetryDelay                 time.Duration `json:"retry_delay"`
+	EnablePunctuationHeuristic bool          `json:"enable_punctuation_heuristic"`
+	// RetryBackoffFactor multiplies the retry delay with each further retry, so a rate-limited
+	// upstream is given more time. A random jitter is added to backed-off delays. Defaults to
+	// 1, which keeps the delay fixed.
+	RetryBackoffFactor float64 `json:"retry_backoff_factor"`
+	// MaxRetryDelay caps a backed-off retry delay before jitter. Defaults to DefaultMaxRetryDelay.
+	MaxRetryDelay time.Duration `json:"max_retry_delay"`
+	// PunctuationOnFirstAttempt also applies the punctuation heuristic to the first attempt,
+	// for upstreams that send neither a done token nor a finish reason.
+	PunctuationOnFirstAttempt bool     `json:"punctuation_on_first_attempt"`
+	DoneTokenPatterns         []string `json:"done_token_patterns"`
+	SentencePunctuation       string   `json:"sentence_punctuation"`
+	// LargeEventBytes is the event size above which only the fields the handler needs
+	// are extracted instead of unmarshaling the whole event.
+	LargeEventBytes int `json:"large_event_bytes"`
+	// DropReasoning stops reasoning-only chunks (delta.reasoning_content) from being
+	// forwarded to the client. Reasoning is not added to the retry context either way, unless
+	// AccumulateReasoning is set.
+	DropReasoning bool `json:"drop_reasoning"`
+	// AccumulateReasoning counts OpenAI reasoning tokens (delta.reasoning_content) as received
+	// text, so the completion heuristics see everything that was streamed. The reasoning then
+	// also becomes part of the retry context. Forwarded lines are unchanged.
+	AccumulateReasoning bool `json:"accumulate_reasoning"`
+	// MaxChunkChars splits events whose text is longer than this many characters into
+	// several events of the same format before they are forwarded. 0 disables rechunking.
+	MaxChunkChars int `json:"max_chunk_chars"`
+	// MaxGarbageLines abandons an attempt after this many consecutive lines of binary data.
+	// Defaults to DefaultMaxGarbageLines.
+	MaxGarbageLines int `json:"max_garbage_lines"`
+	// MaxLineBytes is the longest SSE line an attempt reads; a longer one abandons the attempt.
+	// Defaults to DefaultMaxLineBytes.
+	MaxLineBytes int `json:"max_line_bytes"`
+	// DeadLetter receives streams that exhausted their retries, with secrets redacted.
+	DeadLetter DeadLetterSink `json:"-"`
+	// JSONRepairAttempts bounds how many times a completed JSON-mode response that fails
+	// validation is re-requested with a repair prompt. 0 disables validation. While validation
+	// is on, the response is held back until it validates.
+	JSONRepairAttempts int `json:"json_repair_attempts"`
+	// SingleJSONAsJSON forwards an upstream that answers a streaming request with one complete
+	// JSON response as a regular JSON response instead of a single SSE event.
+	SingleJSONAsJSON bool `json:"single_json_as_json"`
+	// EmptyStreamDiagnostic explains streams that complete without any text, such as fully
+	// filtered or tool-call only responses, with an SSE comment.
+	EmptyStreamDiagnostic bool `json:"empty_stream_diagnostic"`
+	// StopRetryPhrases ends retries for an incomplete stream whose text contains one of
+	// these phrases, delivering what was received instead of asking to continue.
+	StopRetryPhrases []string `json:"stop_retry_phrases"`
+	// DedupeChunks drops a text chunk that exactly repeats the previous one, as buggy
+	// upstreams and continuation overlaps sometimes produce.
+	DedupeChunks bool `json:"dedupe_chunks"`
+	// ContentAnalysisMinChars, when set, only lets the content analysis complete a stream once
+	// at least this many characters have accumulated and at least one retry has happened, so
+	// a short opening sentence is not mistaken for a complete answer.
+	ContentAnalysisMinChars int `json:"content_analysis_min_chars"`
+	// ContinuationMarker is the marker OpenAI and Gemini continuations are asked to open with.
+	// It is stripped from the start of each continuation before forwarding.
+	ContinuationMarker string `json:"continuation_marker"`
+	// ContinuationFillers are filler phrases, such as "Sure, continuing:", stripped from the
+	// start of a continuation so it joins the earlier text cleanly. The rest of the text is
+	// never touched.
+	ContinuationFillers []string `json:"continuation_fillers"`
+	// OverlapWindow is the number of bytes at the end of the accumulated text that the opening
+	// of a continuation is compared against, so text the model repeats is not forwarded twice.
+	// 0 disables the comparison.
+	OverlapWindow int `json:"overlap_window"`
+	// OpenAITerminalReasons are the finish_reason values that complete an OpenAI stream.
+	// Null, empty and unlisted values never do. Defaults to DefaultOpenAITerminalReasons.
+	OpenAITerminalReasons []string `json:"openai_terminal_reasons"`
+	// GeminiTerminalReasons are the candidate finishReason values that complete a Gemini
+	// stream in strict completion mode. Defaults to DefaultGeminiTerminalReasons.
+	GeminiTerminalReasons []string `json:"gemini_terminal_reasons"`
+	// WriteTimeout aborts the stream when a single write or flush to the client takes longer,
+	// freeing the upstream instead of blocking on a stuck client. 0 disables the timeout.
+	WriteTimeout time.Duration `json:"write_timeout"`
+	// IdleTimeout abandons an attempt when the upstream sends no line for this long, retrying
+	// like a dropped connection, so a stalled upstream that keeps the connection open does not
+	// hang the client. Every line, including heartbeats, restarts it. 0 disables the timeout.
+	IdleTimeout time.Duration `json:"idle_timeout"`
+	// RecordSnapshots adds the accumulated text at the start and end of each attempt to the
+	// attempt history handed to the dead-letter sink.
+	RecordSnapshots bool `json:"record_snapshots"`
+	// CodeFenceCompletion completes a stream that ended without a signal once it holds at
+	// least one code block and all of its code fences are closed.
+	CodeFenceCompletion bool `json:"code_fence_completion"`
+	// StrictCompletion lets only explicit signals complete a stream: [DONE], a finish reason,
+	// message_stop or the injected done token. The punctuation, code-fence and content analysis
+	// heuristics are disabled, and exhausted retries are reported as a truncated response.
+	StrictCompletion bool `json:"strict_completion"`
+	// TokenBudget cuts a stream off with a length-limited terminal event once it has produced
+	// more output tokens than this, estimated from the text or taken from reported usage,
+	// without retrying. 0 disables the budget.
+	TokenBudget int `json:"token_budget"`
+	// IncludeUsage means the upstream was asked for OpenAI's final usage chunk, which follows
+	// the chunk with the finish reason, so the stream is read on up to [DONE] to forward it.
+	IncludeUsage bool `json:"include_usage"`
+	// ReconnectOnGoAway reconnects right away, continuing from the accumulated text, when the
+	// upstream's HTTP/2 connection goes away mid-stream, instead of waiting out the retry delay
+	// like for an incomplete answer.
+	ReconnectOnGoAway bool `json:"reconnect_on_goaway"`
+	// RetryDecider decides after each attempt whether to complete, retry or give up.
+	// Defaults to DefaultRetryDecider.
+	RetryDecider RetryDecider `json:"-"`
+	// Logger scopes the handler's logs, e.g. to a group's log level. Defaults to the global logger.
+	Logger logrus.FieldLogger `json:"-"`
+}
+
+// MarshalJSON renders the configuration for inspection, with durations as strings such as
+// "1.5s" and the dead-letter sink reduced to whether one is set.
+func (c StreamConfig) MarshalJSON() ([]byte, error) {
+	type plain StreamConfig
+	return json.Marshal(struct {
+		plain
+		RetryDelay    string `json:"retry_delay"`
+		MaxRetryDelay string `json:"max_retry_delay"`
+		WriteTimeout  string `json:"write_timeout"`
+		IdleTimeout   string `json:"idle_timeout"`
+		DeadLetter    bool   `json:"dead_letter"`
+	}{
+		plain:         plain(c),
+		RetryDelay:    c.RetryDelay.String(),
+		MaxRetryDelay: c.MaxRetryDelay.String(),
+		WriteTimeout:  c.WriteTimeout.String(),
+		IdleTimeout:   c.IdleTimeout.String(),
+		DeadLetter:    c.DeadLetter != nil,
+	})
 }
 
 // NewStreamHandler creates a new streaming handler
@@ -35,18 +220,105 @@ func NewStreamHandler(config StreamConfig) *StreamHandler {
 	if config.RetryDelay <= 0 {
 		config.RetryDelay = 1 * time.Second
 	}
+	if config.RetryBackoffFactor <= 0 {
+		config.RetryBackoffFactor = 1
+	}
+	if config.MaxRetryDelay <= 0 {
+		config.MaxRetryDelay = DefaultMaxRetryDelay
+	}
 	if len(config.DoneTokenPatterns) == 0 {
 		config.DoneTokenPatterns = []string{"[done]", "[DONE]", "done", "DONE"}
 	}
+	if config.SentencePunctuation == "" {
+		config.SentencePunctuation = DefaultSentencePunctuation
+	}
+	if config.LargeEventBytes <= 0 {
+		config.LargeEventBytes = DefaultLargeEventBytes
+	}
+	if config.MaxGarbageLines <= 0 {
+		config.MaxGarbageLines = DefaultMaxGarbageLines
+	}
+	if config.MaxLineBytes <= 0 {
+		config.MaxLineBytes = DefaultMaxLineBytes
+	}
+	if len(config.OpenAITerminalReasons) == 0 {
+		config.OpenAITerminalReasons = DefaultOpenAITerminalReasons
+	}
+	if len(config.GeminiTerminalReasons) == 0 {
+		config.GeminiTerminalReasons = DefaultGeminiTerminalReasons
+	}
+	if config.RetryDecider == nil {
+		config.RetryDecider = DefaultRetryDecider{}
+	}
+	if config.Logger == nil {
+		config.Logger = logrus.StandardLogger()
+	}
 
 	return &StreamHandler{
-		maxRetries:           config.MaxRetries,
-		retryDelay:           config.RetryDelay,
+		maxRetries:                 config.MaxRetries,
+		retryDelay:                 config.RetryDelay,
+		retryBackoffFactor:         config.RetryBackoffFactor,
+		maxRetryDelay:              config.MaxRetryDelay,
 		enablePunctuationHeuristic: config.EnablePunctuationHeuristic,
-		doneTokenPatterns:    config.DoneTokenPatterns,
+		punctuationOnFirstAttempt:  config.PunctuationOnFirstAttempt,
+		doneTokenPatterns:          config.DoneTokenPatterns,
+		sentencePunctuation:        config.SentencePunctuation,
+		largeEventBytes:            config.LargeEventBytes,
+		dropReasoning:              config.DropReasoning,
+		accumulateReasoning:        config.AccumulateReasoning,
+		maxChunkChars:              config.MaxChunkChars,
+		maxGarbageLines:            config.MaxGarbageLines,
+		maxLineBytes:               config.MaxLineBytes,
+		deadLetter:                 config.DeadLetter,
+		jsonRepairAttempts:         config.JSONRepairAttempts,
+		singleJSONAsJSON:           config.SingleJSONAsJSON,
+		emptyStreamDiagnostic:      config.EmptyStreamDiagnostic,
+		stopRetryPhrases:           config.StopRetryPhrases,
+		dedupeChunks:               config.DedupeChunks,
+		contentAnalysisMinChars:    config.ContentAnalysisMinChars,
+		continuationMarker:         config.ContinuationMarker,
+		continuationFillers:        config.ContinuationFillers,
+		overlapWindow:              config.OverlapWindow,
+		openAITerminalReasons:      config.OpenAITerminalReasons,
+		geminiTerminalReasons:      config.GeminiTerminalReasons,
+		writeTimeout:               config.WriteTimeout,
+		idleTimeout:                config.IdleTimeout,
+		recordSnapshots:            config.RecordSnapshots,
+		codeFenceCompletion:        config.CodeFenceCompletion,
+		strictCompletion:           config.StrictCompletion,
+		tokenBudget:                config.TokenBudget,
+		includeUsage:               config.IncludeUsage,
+		reconnectOnGoAway:          config.ReconnectOnGoAway,
+		retryDecider:               config.RetryDecider,
+		ctx:                        context.Background(),
+		log:                        config.Logger,
 	}
 }
 
+// SetRepairFunc sets the request used to repair invalid structured responses.
+func (sh *StreamHandler) SetRepairFunc(repairFunc ChannelRepairFunc) {
+	sh.repairFunc = repairFunc
+}
+
+// SetTokenBudget sets the output token budget of the stream, overriding the configured one.
+func (sh *StreamHandler) SetTokenBudget(tokens int) {
+	sh.tokenBudget = tokens
+}
+
+// SetContext sets the context of the client request. Once it is done the client is gone, and
+// the upstream stream is abandoned without retrying.
+func (sh *StreamHandler) SetContext(ctx context.Context) {
+	sh.ctx = ctx
+}
+
+// clientGone returns an error once the client request is done.
+func (sh *StreamHandler) clientGone() error {
+	if err := sh.ctx.Err(); err != nil {
+		return fmt.Errorf("client disconnected: %w", err)
+	}
+	return nil
+}
+
 // HandleStreamingResponse handles streaming response with retry logic
 func (sh *StreamHandler) HandleStreamingResponse(
 	resp *http.Response,
@@ -56,43 +328,243 @@ func (sh *StreamHandler) HandleStreamingResponse(
 	retryRequestFunc func(accumulatedText string) (*http.Response, error),
 ) error {
 	var accumulatedText string
+	var finishReason FinishReason
+	var lastEvent map[string]interface{}
+	var previousChunk string
+	var history []AttemptRecord
 	consecutiveRetryCount := 0
 	resumePunctStreak := 0
+	meter := newTokenMeter(sh.tokenBudget)
+	sh.usage = &usageTally{includeUsage: channelType == "openai" && (sh.includeUsage || requestsUsage(originalRequest))}
+	sh.toolCallForwarded, sh.toolArgumentChars = false, 0
 
-	for {
-		logrus.Debugf("=== Starting stream attempt %d/%d ===", consecutiveRetryCount+1, sh.maxRetries+1)
+	if sh.writeTimeout > 0 {
+		writer = &timeoutWriter{ResponseWriter: writer, timeout: sh.writeTimeout}
+	}
 
-		cleanExit, err := sh.processStreamAttempt(
-			resp, writer, channelType, &accumulatedText,
-			&resumePunctStreak, consecutiveRetryCount,
-		)
+	// Track whether anything reached the client, which decides how exhaustion is reported
+	tracked := &startTrackingWriter{ResponseWriter: writer}
+	writer = tracked
+
+	var jsonOutput *jsonOutputSpec
+	var held *heldWriter
+	repairs := 0
+	repairProblem := ""
+	if sh.jsonRepairAttempts > 0 && sh.repairFunc != nil {
+		jsonOutput, _ = detectJSONOutput(originalRequest)
+	}
+	if jsonOutput != nil {
+		// The client already holding an invalid document could not tell it from its repair
+		held = &heldWriter{ResponseWriter: writer}
+		writer = held
+		defer held.release()
+	}
+
+	for {
+		sh.log.Debugf("=== Starting stream attempt %d/%d ===", consecutiveRetryCount+1, sh.maxRetries+1)
+		attemptStart := time.Now()
+		receivedBefore := len(accumulatedText)
+		argumentsBefore := sh.toolArgumentChars
+		meter.startAttempt(accumulatedText)
+		sh.usage.startAttempt()
+
+		var outcome AttemptOutcome
+		var completion CompletionReason
+		var err error
+		if sh.isSingleJSONResponse(resp) {
+			// A regular JSON response is only possible while nothing has been sent yet
+			asJSON := sh.singleJSONAsJSON && consecutiveRetryCount == 0 && repairs == 0
+			outcome, err = sh.forwardJSONResponse(resp, writer, channelType, &accumulatedText, &finishReason, &lastEvent, asJSON)
+			if outcome == AttemptComplete {
+				completion = CompletionProtocolSignal
+			}
+			if err == nil && outcome == AttemptComplete && asJSON {
+				// SSE trailers would corrupt the JSON body
+				sh.log.Info("=== STREAM COMPLETED SUCCESSFULLY ===")
+				return nil
+			}
+		} else {
+			outcome, err = sh.processStreamAttempt(
+				resp, writer, channelType, &accumulatedText,
+				&resumePunctStreak, &finishReason, &completion, &lastEvent, &previousChunk, meter, consecutiveRetryCount,
+			)
+		}
 
 		if err != nil {
+			// The client is gone or unwritable; abandon the upstream stream rather than leaking it.
+			resp.Body.Close()
 			return err
 		}
 
+		if outcome == AttemptBudgetExceeded {
+			sh.log.Warnf("Stream exceeded its token budget of %d, cutting it off", sh.tokenBudget)
+			resp.Body.Close()
+			if err := sh.writeTokenBudgetCutoff(writer, channelType); err != nil {
+				return err
+			}
+			sh.writeAttemptsTrailer(writer, consecutiveRetryCount+repairs+1)
+			sh.writeTrailerComment(writer, FinishReasonHeader, string(FinishReasonLength))
+			return nil
+		}
+
+		// Tool-call arguments are progress the accumulated text does not hold
+		receivedChars := utf8.RuneCountInString(accumulatedText[receivedBefore:]) + sh.toolArgumentChars - argumentsBefore
+		var phrase string
+		if outcome != AttemptComplete {
+			record := AttemptRecord{
+				Attempt:       consecutiveRetryCount + 1,
+				ReceivedChars: receivedChars,
+				DurationMs:    time.Since(attemptStart).Milliseconds(),
+			}
+			if sh.recordSnapshots {
+				record.TextBefore = accumulatedText[:receivedBefore]
+				record.TextAfter = accumulatedText
+			}
+			history = append(history, record)
+			phrase = sh.matchStopRetryPhrase(accumulatedText)
+		}
+
+		action := sh.decide(RetryContext{
+			Attempt:           consecutiveRetryCount + 1,
+			Outcome:           outcome,
+			AccumulatedText:   accumulatedText,
+			ReceivedChars:     receivedChars,
+			ToolCallForwarded: sh.toolCallForwarded,
+			Completion:        completion,
+			StopRetryPhrase:   phrase,
+		})
+
+		cleanExit := action == RetryComplete && outcome == AttemptComplete
+		if cleanExit && jsonOutput != nil {
+			if problem := jsonOutput.validate(sh.RemoveDoneTokensFromText(accumulatedText)); problem != nil {
+				if repairs < sh.jsonRepairAttempts {
+					repairs++
+					sh.log.Warnf("Structured response failed validation, requesting repair %d/%d: %v", repairs, sh.jsonRepairAttempts, problem)
+					repairProblem = problem.Error()
+					held.discard()
+					resp.Body.Close()
+
+					newResp, err := sh.repairFunc(accumulatedText, problem.Error())
+					if err != nil {
+						sh.log.Errorf("Repair request failed: %v", err)
+						return err
+					}
+
+					// The repair is a complete new document, not a continuation
+					resp = newResp
+					accumulatedText = ""
+					sh.toolCallForwarded, sh.toolArgumentChars = false, 0
+					finishReason = FinishReasonNone
+					resumePunctStreak = 0
+					continue
+				}
+				sh.log.Warnf("Structured response still invalid after %d repair attempts: %v", repairs, problem)
+				sh.writeTrailerComment(writer, JSONInvalidHeader, problem.Error())
+			} else if repairs > 0 {
+				sh.writeTrailerComment(writer, JSONRepairHeader, repairProblem)
+			}
+		}
+
 		if cleanExit {
-			logrus.Info("=== STREAM COMPLETED SUCCESSFULLY ===")
+			sh.log.Info("=== STREAM COMPLETED SUCCESSFULLY ===")
+			sh.writeMergedUsage(writer, channelType)
+			if sh.emptyStreamDiagnostic {
+				sh.writeEmptyStreamDiagnostic(writer, accumulatedText, finishReason, lastEvent, channelType)
+			}
+			sh.writeAttemptsTrailer(writer, consecutiveRetryCount+repairs+1)
+			if finishReason != FinishReasonNone {
+				sh.log.Infof("Stream finish reason: %s", finishReason)
+				sh.writeTrailerComment(writer, FinishReasonHeader, string(finishReason))
+			}
 			return nil
 		}
 
-		// Check if we've exceeded max retries
-		if consecutiveRetryCount >= sh.maxRetries {
-			return sh.writeRetryError(writer, consecutiveRetryCount)
+		switch action {
+		case RetryComplete:
+			resp.Body.Close()
+			if phrase != "" {
+				sh.log.Warnf("Stream ended incomplete with stop-retry phrase %q, delivering received content", phrase)
+				sh.writeTrailerComment(writer, StopRetryHeader, phrase)
+			} else {
+				sh.log.Warn("Stream ended incomplete, delivering received content")
+			}
+			sh.writeMergedUsage(writer, channelType)
+			sh.writeAttemptsTrailer(writer, consecutiveRetryCount+repairs+1)
+			return nil
+		case RetryFail:
+			sh.recordDeadLetter(channelType, originalRequest, accumulatedText, history)
+			if held != nil {
+				// Nothing was sent yet, so the failure is reported as a plain error response
+				held.discard()
+			}
+			return sh.writeRetryError(writer, channelType, tracked.started)
 		}
 
 		// Prepare for retry
 		consecutiveRetryCount++
-		logrus.Infof("=== STARTING RETRY %d/%d ===", consecutiveRetryCount, sh.maxRetries)
+		sh.log.Infof("=== STARTING RETRY %d/%d ===", consecutiveRetryCount, sh.maxRetries)
 
 		// Close current response body
 		resp.Body.Close()
 
-		// Make retry request
-		time.Sleep(sh.retryDelay)
-		newResp, err := retryRequestFunc(accumulatedText)
+		// Only an incomplete answer needs to wait before it continues from context. A replay of
+		// the original request goes out right away, as does a continuation after the upstream
+		// shut the connection down, which says nothing about the answer.
+		if action == RetryOriginal {
+			sh.log.Info("Nothing was received, reconnecting with the same request")
+		} else if outcome == AttemptGoAway && sh.reconnectOnGoAway {
+			sh.log.Info("Upstream connection went away mid-stream, reconnecting with the accumulated text")
+		} else {
+			time.Sleep(sh.retryDelayFor(consecutiveRetryCount))
+		}
+		if err := sh.clientGone(); err != nil {
+			sh.log.Info("Client disconnected, not retrying")
+			return err
+		}
+		newResp, err := retryRequestFunc(retryContextFor(action, accumulatedText))
+		var statusErr *RetryStatusError
+		for errors.As(err, &statusErr) {
+			// An error response is not a stream, so it only uses up an attempt
+			action := sh.decide(RetryContext{
+				Attempt:           consecutiveRetryCount + 1,
+				Outcome:           AttemptErrorStatus,
+				StatusCode:        statusErr.StatusCode,
+				Err:               err,
+				AccumulatedText:   accumulatedText,
+				ToolCallForwarded: sh.toolCallForwarded,
+			})
+			if action == RetryComplete {
+				sh.log.Warnf("Retry answered with status %d, delivering received content", statusErr.StatusCode)
+				sh.writeMergedUsage(writer, channelType)
+				sh.writeAttemptsTrailer(writer, consecutiveRetryCount+repairs+1)
+				return nil
+			}
+			history = append(history, AttemptRecord{Attempt: consecutiveRetryCount + 1})
+			if action == RetryFail {
+				if statusErr.Retryable {
+					sh.log.Warnf("Retry answered with status %d and no retries are left", statusErr.StatusCode)
+				} else {
+					sh.log.Warnf("Retry answered with status %d, which is not retried", statusErr.StatusCode)
+				}
+				sh.recordDeadLetter(channelType, originalRequest, accumulatedText, history)
+				if held != nil {
+					held.discard()
+				}
+				return sh.writeRetryError(writer, channelType, tracked.started)
+			}
+			consecutiveRetryCount++
+			sh.log.Warnf("Retry answered with status %d, starting retry %d/%d", statusErr.StatusCode, consecutiveRetryCount, sh.maxRetries)
+			if action == RetryWithContext {
+				time.Sleep(sh.retryDelayFor(consecutiveRetryCount))
+			}
+			if err := sh.clientGone(); err != nil {
+				sh.log.Info("Client disconnected, not retrying")
+				return err
+			}
+			newResp, err = retryRequestFunc(retryContextFor(action, accumulatedText))
+		}
 		if err != nil {
-			logrus.Errorf("Retry request failed: %v", err)
+			sh.log.Errorf("Retry request failed: %v", err)
 			return err
 		}
 
@@ -100,6 +572,33 @@ func (sh *StreamHandler) HandleStreamingResponse(
 	}
 }
 
+// retryContextFor returns the text a retry continues from. The retry function replays the
+// original request for an empty text, which is what RetryOriginal asks for.
+func retryContextFor(action RetryAction, accumulatedText string) string {
+	if action == RetryOriginal {
+		return ""
+	}
+	return accumulatedText
+}
+
+// AttemptOutcome describes how a single attempt ended.
+type AttemptOutcome int
+
+const (
+	// AttemptIncomplete means the stream ended without signaling completion
+	AttemptIncomplete AttemptOutcome = iota
+	// AttemptComplete means the stream is complete
+	AttemptComplete
+	// AttemptNetworkError means reading the upstream stream failed or the connection dropped
+	AttemptNetworkError
+	// AttemptGoAway means the upstream shut down its HTTP/2 connection in the middle of the stream
+	AttemptGoAway
+	// AttemptBudgetExceeded means the stream produced more tokens than its budget allows
+	AttemptBudgetExceeded
+	// AttemptErrorStatus means a retry request was answered with an error status instead of a stream
+	AttemptErrorStatus
+)
+
 // processStreamAttempt processes a single stream attempt
 func (sh *StreamHandler) processStreamAttempt(
 	resp *http.Response,
@@ -107,8 +606,13 @@ func (sh *StreamHandler) processStreamAttempt(
 	channelType string,
 	accumulatedText *string,
 	resumePunctStreak *int,
+	finishReason *FinishReason,
+	completion *CompletionReason,
+	lastEvent *map[string]interface{},
+	previousChunk *string,
+	meter *tokenMeter,
 	attempt int,
-) (bool, error) {
+) (AttemptOutcome, error) {
 	// Set streaming headers
 	writer.Header().Set("Content-Type", "text/event-stream")
 	writer.Header().Set("Cache-Control", "no-cache")
@@ -117,103 +621,324 @@ func (sh *StreamHandler) processStreamAttempt(
 
 	flusher, ok := writer.(http.Flusher)
 	if !ok {
-		return false, fmt.Errorf("streaming not supported")
+		return AttemptIncomplete, fmt.Errorf("streaming not supported")
 	}
 
-	scanner := bufio.NewScanner(resp.Body)
+	// A read blocked on a stalled upstream would not notice the client leaving
+	stop := context.AfterFunc(sh.ctx, func() { resp.Body.Close() })
+	defer stop()
+	idle := newIdleTimer(sh.idleTimeout, resp.Body)
+	defer idle.stop()
+
+	body := newPeekableBody(resp.Body)
+	var source io.Reader = body
+	if peekFirstByte(body.Reader) == '[' {
+		sh.log.Debug("Upstream uses JSON-array framing, converting elements to SSE events")
+		source = newJSONArrayReader(source)
+	}
+
+	scanner := bufio.NewScanner(source)
+	scanner.Buffer(make([]byte, 0, min(64*1024, sh.maxLineBytes)), sh.maxLineBytes)
+	var lines lineSplitter
+	scanner.Split(lines.Split)
 	var lastTextChunk string
 	var textInThisStream string
+	var reasoningInThisStream bool
+	var finishReasonInThisStream bool
+	var carry runeCarry
+	garbage := garbageDetector{limit: sh.maxGarbageLines}
+
+	// A Gemini chunk ending in a bare done word is held back until the next chunk or the end
+	// of the stream shows whether the word ended the answer, in which case it is stripped
+	var heldLine string
+	flushHeld := func(strip bool) error {
+		if heldLine == "" {
+			return nil
+		}
+		line := heldLine
+		heldLine = ""
+		if strip {
+			line = sh.removeDoneTokensFromLine(line, nil)
+		}
+		for _, outLine := range sh.rechunkLine(line, channelType) {
+			if _, err := fmt.Fprintf(writer, "%s\n\n", outLine); err != nil {
+				return fmt.Errorf("failed to write to client: %w", err)
+			}
+		}
+		flusher.Flush()
+		return nil
+	}
 
-	for scanner.Scan() {
-		line := scanner.Text()
+	// Only a continuation built from accumulated text was asked to open with the marker, and
+	// only its opening is checked for filler and then for repeated text, after the marker
+	var filters []continuationFilter
+	if attempt > 0 && *accumulatedText != "" {
+		if sh.continuationMarker != "" && (channelType == "openai" || channelType == "gemini") {
+			filters = append(filters, &continuationMarker{marker: sh.continuationMarker})
+		}
+		if len(sh.continuationFillers) > 0 {
+			filters = append(filters, &continuationFiller{phrases: sh.continuationFillers})
+		}
+		if sh.overlapWindow > 0 {
+			filters = append(filters, newContinuationOverlap(*accumulatedText, sh.overlapWindow))
+		}
+	}
+
+	events := &dataLineJoiner{scanner: scanner, maxBytes: sh.maxLineBytes}
+	for events.Scan() {
+		idle.reset()
+		if err := sh.clientGone(); err != nil {
+			sh.log.Info("Client disconnected mid-stream, abandoning the upstream stream")
+			return AttemptIncomplete, err
+		}
+		line := events.Text()
 		if line == "" {
 			continue
 		}
 
+		held := false
+		for _, f := range filters {
+			if line, held = sh.applyContinuationFilter(f, line, channelType); held {
+				break
+			}
+		}
+		if held {
+			continue
+		}
+
+		// OpenAI style end, recognized whatever the done-token patterns
+		if isDoneSignal(line) {
+			sh.log.Debug("Received [DONE] signal")
+			if err := flushHeld(true); err != nil {
+				return AttemptIncomplete, err
+			}
+			*completion = CompletionProtocolSignal
+			return AttemptComplete, nil
+		}
+
 		// Parse SSE line
 		if strings.HasPrefix(line, "data: ") {
 			dataContent := strings.TrimPrefix(line, "data: ")
-			if dataContent == "[DONE]" {
-				// OpenAI style end
-				logrus.Debug("Received [DONE] signal")
-				return true, nil
+
+			// Parse JSON data, keeping bytes of characters split across events intact
+			protectedContent := protectRawBytes(dataContent)
+			data, err := sh.parseEvent(protectedContent)
+			if err != nil {
+				sh.log.Debugf("Failed to parse JSON data: %v", err)
+				if garbage.Observe(dataContent) {
+					sh.log.Warnf("Aborting attempt after %d consecutive lines of binary data", garbage.streak)
+					return AttemptIncomplete, flushHeld(false) // Trigger retry
+				}
+				continue
 			}
+			garbage.Reset()
 
-			// Parse JSON data
-			var data map[string]interface{}
-			if err := json.Unmarshal([]byte(dataContent), &data); err != nil {
-				logrus.Debugf("Failed to parse JSON data: %v", err)
+			// A heartbeat shows the upstream is alive, but it carries no content and completes
+			// nothing, so it is forwarded without touching the stream state
+			if channelType == "anthropic" && isAnthropicPing(data) {
+				if _, err := fmt.Fprintf(writer, "%s\n\n", line); err != nil {
+					return AttemptIncomplete, fmt.Errorf("failed to write to client: %w", err)
+				}
+				flusher.Flush()
 				continue
 			}
 
+			*lastEvent = data
+			meter.observe(data, channelType)
+			sh.usage.observe(data, channelType)
+
 			// Extract text based on channel type
 			textChunk := sh.extractTextFromData(data, channelType)
+			if protectedContent != dataContent {
+				textChunk = restoreRawBytes(textChunk)
+			}
+			textChunk = carry.Append(textChunk)
+			if sh.isDuplicateChunk(textChunk, *previousChunk, data, channelType) {
+				sh.log.Debugf("Dropping chunk that repeats the previous one (%d bytes)", len(textChunk))
+				if reason := sh.chunkCompletionReason(data, channelType, *accumulatedText); reason != CompletionNone {
+					sh.log.Debugf("Stream completed by %s", reason)
+					*completion = reason
+					return AttemptComplete, flushHeld(sh.stripsDoneToken(reason, channelType, *accumulatedText))
+				}
+				continue
+			}
 			if textChunk != "" {
+				*previousChunk = textChunk
 				lastTextChunk = textChunk
 				*accumulatedText += textChunk
 				textInThisStream += textChunk
 			}
 
-			// Forward the line to client, but remove [done] tokens for Gemini
+			if reason := sh.extractFinishReason(data, channelType); reason != "" {
+				*finishReason = NormalizeFinishReason(channelType, reason)
+				finishReasonInThisStream = true
+			}
+
+			// Reasoning is progress for the client but only part of the retry context when it is
+			// accumulated
+			reasoningOnly := false
+			if reasoning := sh.extractReasoningText(data, channelType); reasoning != "" {
+				reasoningInThisStream = true
+				reasoningOnly = textChunk == "" || (sh.accumulateReasoning && textChunk == reasoning)
+			}
+
+			// Forward the line to client, but remove the [done] token from the Gemini chunk that
+			// completes the stream. Earlier chunks may legitimately end in the same word.
+			reason := sh.chunkCompletionReason(data, channelType, *accumulatedText)
+			stripDone := sh.stripsDoneToken(reason, channelType, *accumulatedText)
 			processedLine := line
-			if channelType == "gemini" {
+			if stripDone {
 				processedLine = sh.removeDoneTokensFromLine(line, data)
 			}
-			
-			if _, err := fmt.Fprintf(writer, "%s\n\n", processedLine); err != nil {
-				return false, fmt.Errorf("failed to write to client: %w", err)
+			// The held chunk's done word ended the answer only if no text followed it
+			if err := flushHeld(stripDone && textChunk == ""); err != nil {
+				return AttemptIncomplete, err
+			}
+
+			// After a retry the usage of all attempts is sent once, summed, at the end
+			forward := !(reasoningOnly && sh.dropReasoning)
+			if forward && sh.usage.merging(channelType) {
+				processedLine, forward = stripUsage(processedLine, channelType)
+			}
+			if forward && reason == CompletionNone && channelType == "gemini" && textChunk != "" &&
+				!hasToolCall(data, channelType) && sh.containsDoneToken(*accumulatedText) {
+				heldLine = processedLine
+				forward = false
+			}
+
+			if forward {
+				for _, outLine := range sh.rechunkLine(processedLine, channelType) {
+					if _, err := fmt.Fprintf(writer, "%s\n\n", outLine); err != nil {
+						return AttemptIncomplete, fmt.Errorf("failed to write to client: %w", err)
+					}
+				}
+				flusher.Flush()
+				if hasToolCall(data, channelType) {
+					sh.toolCallForwarded = true
+					sh.toolArgumentChars += utf8.RuneCountInString(sh.extractOpenAIToolArguments(data))
+				}
 			}
-			flusher.Flush()
 
 			// Check for completion
-			if sh.isStreamComplete(data, channelType, *accumulatedText) {
-				return true, nil
+			if reason != CompletionNone {
+				sh.log.Debugf("Stream completed by %s", reason)
+				*completion = reason
+				if sh.usage.includeUsage {
+					sh.forwardUsageTrailer(events, writer, flusher, channelType)
+				}
+				return AttemptComplete, nil
+			}
+			if meter.exceeded(*accumulatedText) {
+				return AttemptBudgetExceeded, flushHeld(false)
 			}
 		} else {
-			// Forward non-data lines as-is
+			if garbage.Observe(line) {
+				sh.log.Warnf("Aborting attempt after %d consecutive lines of binary data", garbage.streak)
+				return AttemptIncomplete, flushHeld(false) // Trigger retry
+			}
+			if garbage.streak > 0 {
+				// Binary noise would corrupt the client's event stream
+				continue
+			}
+
+			// Forward non-data lines as-is, after any held chunk they followed
+			if err := flushHeld(false); err != nil {
+				return AttemptIncomplete, err
+			}
 			if _, err := fmt.Fprintf(writer, "%s\n\n", line); err != nil {
-				return false, fmt.Errorf("failed to write to client: %w", err)
+				return AttemptIncomplete, fmt.Errorf("failed to write to client: %w", err)
 			}
 			flusher.Flush()
 		}
 	}
 
+	idle.stop()
+	if err := sh.clientGone(); err != nil {
+		sh.log.Info("Client disconnected mid-stream, abandoning the upstream stream")
+		return AttemptIncomplete, err
+	}
+	if idle.expired() {
+		sh.log.Warnf("Upstream sent nothing for %s, abandoning the attempt", sh.idleTimeout)
+		return AttemptNetworkError, flushHeld(false) // Trigger retry
+	}
+
 	// Check for stream completion without explicit end signal
-	if err := scanner.Err(); err != nil {
-		logrus.Errorf("Stream error: %v", err)
-		return false, nil // Trigger retry
+	if err := scanner.Err(); errors.Is(err, bufio.ErrTooLong) {
+		// The connection is fine, so the same request would only hit the same line again
+		sh.log.Warnf("Stream line exceeds the limit of %d bytes, abandoning the attempt", sh.maxLineBytes)
+		return AttemptIncomplete, flushHeld(false) // Trigger retry
+	} else if isGoAwayError(err) {
+		sh.log.Warnf("Upstream connection went away: %v", err)
+		return AttemptGoAway, flushHeld(false) // Trigger retry
+	} else if err != nil {
+		sh.log.Errorf("Stream error: %v", err)
+		return AttemptNetworkError, flushHeld(false) // Trigger retry
+	}
+
+	// A last line without its newline means the connection dropped mid-event
+	if lines.partial {
+		sh.log.Warnf("Stream ended in the middle of a line (%d bytes without newline), likely truncated", lines.partialBytes)
+		return AttemptNetworkError, flushHeld(false) // Trigger retry
 	}
 
 	// Stream ended without explicit completion signal
-	logrus.Debug("Stream ended without explicit completion signal")
+	sh.log.Debug("Stream ended without explicit completion signal")
+	if reasoningInThisStream && textInThisStream == "" {
+		sh.log.Debug("Stream ended while the model was still reasoning")
+	}
+	if carry.Pending() > 0 {
+		sh.log.Debugf("Dropping %d bytes of an incomplete character at end of stream", carry.Pending())
+	}
 
-	// Apply punctuation heuristic for resumed attempts
-	if sh.enablePunctuationHeuristic && attempt > 0 && sh.endsWithSentencePunctuation(lastTextChunk) {
-		*resumePunctStreak++
-		logrus.Debugf("Resume punctuation streak: %d", *resumePunctStreak)
-		if *resumePunctStreak >= 3 {
-			logrus.Info("Stream completed due to punctuation heuristic")
-			return true, nil
-		}
-	} else {
-		*resumePunctStreak = 0
+	reason := sh.endOfStreamCompletionReason(*accumulatedText, lastTextChunk, channelType, attempt, resumePunctStreak)
+	if err := flushHeld(reason == CompletionDoneToken); err != nil {
+		return AttemptIncomplete, err
+	}
+	if reason != CompletionNone {
+		sh.log.Infof("Stream completed by %s", reason)
+		*completion = reason
+		return AttemptComplete, nil
 	}
 
-	// Check if we have any content and it seems complete
-	if sh.isContentComplete(*accumulatedText, channelType) {
-		logrus.Info("Stream completed based on content analysis")
-		return true, nil
+	// An upstream that gave a finish reason closed the stream on purpose, so the answer is
+	// merely incomplete. Without one the connection dropped before the upstream was done.
+	if !finishReasonInThisStream {
+		sh.log.Warn("Stream closed before the upstream signaled completion, reconnecting")
+		return AttemptNetworkError, nil // Trigger retry
 	}
 
 	// Trigger retry
-	return false, nil
+	return AttemptIncomplete, nil
+}
+
+// parseEvent decodes an SSE data payload. Events larger than the configured threshold are
+// scanned for the hot fields only, so huge payloads such as tool-call arguments are never
+// materialized.
+func (sh *StreamHandler) parseEvent(content string) (map[string]interface{}, error) {
+	if len(content) > sh.largeEventBytes {
+		sh.log.Debugf("Extracting hot fields from large event (%d bytes)", len(content))
+		return extractHotFields([]byte(content))
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &data); err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
 // extractTextFromData extracts text from streaming data based on channel type
 func (sh *StreamHandler) extractTextFromData(data map[string]interface{}, channelType string) string {
 	switch channelType {
 	case "openai":
-		return sh.extractOpenAIText(data)
+		// Tool-call arguments are forwarded but kept out of the text, which is replayed as the
+		// previous response on retry and cannot express a call. They are counted as progress
+		// on their own.
+		text := sh.extractOpenAIText(data)
+		if sh.accumulateReasoning {
+			text = sh.extractReasoningText(data, channelType) + text
+		}
+		return text
 	case "gemini":
 		return sh.extractGeminiText(data)
 	case "anthropic":
@@ -240,14 +965,86 @@ func (sh *StreamHandler) extractOpenAIText(data map[string]interface{}) string {
 		return ""
 	}
 
-	content, ok := delta["content"].(string)
-	if ok {
+	switch content := delta["content"].(type) {
+	case string:
 		return content
+	case []interface{}:
+		// Multi-part content, as streamed by some OpenAI-compatible multi-modal upstreams
+		var text strings.Builder
+		for _, p := range content {
+			part, ok := p.(map[string]interface{})
+			if !ok || part["type"] != "text" {
+				continue
+			}
+			if partText, ok := part["text"].(string); ok {
+				text.WriteString(partText)
+			}
+		}
+		return text.String()
 	}
 
 	return ""
 }
 
+// extractOpenAIToolArguments extracts the argument fragments of the tool calls in an OpenAI
+// streaming delta, including the legacy function_call.
+func (sh *StreamHandler) extractOpenAIToolArguments(data map[string]interface{}) string {
+	delta := firstChoiceDelta(data)
+	if delta == nil {
+		return ""
+	}
+
+	var arguments strings.Builder
+	if toolCalls, ok := delta["tool_calls"].([]interface{}); ok {
+		for _, tc := range toolCalls {
+			toolCall, ok := tc.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if function, ok := toolCall["function"].(map[string]interface{}); ok {
+				if fragment, ok := function["arguments"].(string); ok {
+					arguments.WriteString(fragment)
+				}
+			}
+		}
+	}
+	if function, ok := delta["function_call"].(map[string]interface{}); ok {
+		if fragment, ok := function["arguments"].(string); ok {
+			arguments.WriteString(fragment)
+		}
+	}
+	return arguments.String()
+}
+
+// extractReasoningText extracts reasoning tokens (delta.reasoning_content, or delta.reasoning
+// on some OpenAI-compatible providers) that reasoning models stream separately from content.
+func (sh *StreamHandler) extractReasoningText(data map[string]interface{}, channelType string) string {
+	if channelType == "gemini" || channelType == "anthropic" {
+		return ""
+	}
+
+	choices, ok := data["choices"].([]interface{})
+	if !ok || len(choices) == 0 {
+		return ""
+	}
+	choice, ok := choices[0].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	delta, ok := choice["delta"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	if reasoning, ok := delta["reasoning_content"].(string); ok {
+		return reasoning
+	}
+	if reasoning, ok := delta["reasoning"].(string); ok {
+		return reasoning
+	}
+	return ""
+}
+
 // extractGeminiText extracts text from Gemini streaming format
 func (sh *StreamHandler) extractGeminiText(data map[string]interface{}) string {
 	candidates, ok := data["candidates"].([]interface{})
@@ -306,18 +1103,118 @@ func (sh *StreamHandler) extractGenericText(data map[string]interface{}) string
 	return ""
 }
 
+// CompletionReason identifies which signal ended a stream.
+//
+// When several signals co-occur the precedence is fixed, highest first:
+// explicit protocol signal > done token > punctuation heuristic > content analysis.
+type CompletionReason string
+
+const (
+	CompletionNone            CompletionReason = ""
+	CompletionProtocolSignal  CompletionReason = "protocol_signal"
+	CompletionDoneToken       CompletionReason = "done_token"
+	CompletionPunctuation     CompletionReason = "punctuation"
+	CompletionContentAnalysis CompletionReason = "content_analysis"
+)
+
+// chunkCompletionReason returns the highest-precedence completion signal carried by a single chunk.
+func (sh *StreamHandler) chunkCompletionReason(data map[string]interface{}, channelType string, accumulatedText string) CompletionReason {
+	if sh.hasProtocolSignal(data, channelType) {
+		return CompletionProtocolSignal
+	}
+	if !usesDoneToken(channelType) {
+		return CompletionNone
+	}
+	// Text that merely pauses on a bare word such as done may go on in the next chunk, so a
+	// bare word only counts in the chunk that carries the finish reason
+	if sh.containsDoneMarker(accumulatedText) || sh.extractFinishReason(data, channelType) != "" && sh.containsDoneToken(accumulatedText) {
+		return CompletionDoneToken
+	}
+	return CompletionNone
+}
+
 // isStreamComplete checks if the stream is complete based on channel-specific signals
 func (sh *StreamHandler) isStreamComplete(data map[string]interface{}, channelType string, accumulatedText string) bool {
+	return sh.chunkCompletionReason(data, channelType, accumulatedText) != CompletionNone
+}
+
+// hasProtocolSignal checks for the channel's explicit end-of-stream marker
+func (sh *StreamHandler) hasProtocolSignal(data map[string]interface{}, channelType string) bool {
 	switch channelType {
 	case "openai":
 		return sh.isOpenAIComplete(data)
 	case "gemini":
-		return sh.isGeminiComplete(data, accumulatedText)
+		return sh.isGeminiComplete(data)
 	case "anthropic":
 		return sh.isAnthropicComplete(data)
 	default:
-		return sh.isGenericComplete(data, accumulatedText)
+		return sh.isGenericComplete(data)
+	}
+}
+
+// ParseDoneTokens splits the comma-separated stream_done_tokens setting.
+func ParseDoneTokens(value string) []string {
+	return splitCommaList(value)
+}
+
+// usesDoneToken reports whether the channel relies on the injected [done] token
+func usesDoneToken(channelType string) bool {
+	return channelType != "openai" && channelType != "anthropic"
+}
+
+// stripsDoneToken reports whether a Gemini chunk completing the stream with the given reason
+// ends on a done token that must be removed before it reaches the client.
+func (sh *StreamHandler) stripsDoneToken(reason CompletionReason, channelType string, accumulatedText string) bool {
+	return channelType == "gemini" && (reason == CompletionDoneToken || reason == CompletionProtocolSignal && sh.containsDoneToken(accumulatedText))
+}
+
+// containsDoneToken checks the complete text of a stream for any configured done token. A
+// marker such as [done] counts anywhere, but a bare word such as done only as the final
+// standalone word, so text like "abandoned" or "well done." does not end the stream.
+func (sh *StreamHandler) containsDoneToken(text string) bool {
+	if sh.containsDoneMarker(text) {
+		return true
+	}
+	trimmed := strings.TrimRightFunc(text, unicode.IsSpace)
+	for _, pattern := range sh.doneTokenPatterns {
+		if isBareWord(pattern) && endsWithWord(trimmed, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsDoneMarker checks text that may still go on for a done token that is not a bare
+// word, such as [done], which counts anywhere.
+func (sh *StreamHandler) containsDoneMarker(text string) bool {
+	for _, pattern := range sh.doneTokenPatterns {
+		if !isBareWord(pattern) && strings.Contains(text, pattern) {
+			return true
+		}
 	}
+	return false
+}
+
+// isBareWord reports whether a done token consists of letters and digits only.
+func isBareWord(pattern string) bool {
+	if pattern == "" {
+		return false
+	}
+	for _, r := range pattern {
+		if !isWordRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// endsWithWord reports whether text ends with word, not as the tail of a longer word.
+func endsWithWord(text, word string) bool {
+	if !strings.HasSuffix(text, word) {
+		return false
+	}
+	before, _ := utf8.DecodeLastRuneInString(text[:len(text)-len(word)])
+	return before == utf8.RuneError || !isWordRune(before)
 }
 
 // isOpenAIComplete checks if OpenAI stream is complete
@@ -332,20 +1229,39 @@ func (sh *StreamHandler) isOpenAIComplete(data map[string]interface{}) bool {
 		return false
 	}
 
+	// Intermediate chunks carry null or, from some upstreams, an empty string
 	finishReason, ok := choice["finish_reason"].(string)
-	if ok && (finishReason == "stop" || finishReason == "length") {
+	if !ok {
+		return false
+	}
+	finishReason = strings.TrimSpace(finishReason)
+	if finishReason == "" {
+		return false
+	}
+	// A finished tool call waits for the client to run it; continuing it would corrupt the call
+	if finishReason == "tool_calls" || finishReason == "function_call" {
 		return true
 	}
 
+	for _, terminal := range sh.openAITerminalReasons {
+		if finishReason == terminal {
+			return true
+		}
+	}
 	return false
 }
 
 // isGeminiComplete checks if Gemini stream is complete
-func (sh *StreamHandler) isGeminiComplete(data map[string]interface{}, accumulatedText string) bool {
-	// Check for [done] token in accumulated text
-	for _, pattern := range sh.doneTokenPatterns {
-		if strings.Contains(accumulatedText, pattern) {
-			return true
+func (sh *StreamHandler) isGeminiComplete(data map[string]interface{}) bool {
+	// Gemini also ends truncated answers with STOP, so the done token decides unless only
+	// explicit signals are accepted, which the candidate's finish reason is
+	if candidate := firstGeminiCandidate(data); candidate != nil && sh.strictCompletion {
+		if finishReason, ok := candidate["finishReason"].(string); ok {
+			for _, terminal := range sh.geminiTerminalReasons {
+				if finishReason == terminal {
+					return true
+				}
+			}
 		}
 	}
 
@@ -367,15 +1283,14 @@ func (sh *StreamHandler) isAnthropicComplete(data map[string]interface{}) bool {
 	return false
 }
 
-// isGenericComplete checks if generic stream is complete
-func (sh *StreamHandler) isGenericComplete(data map[string]interface{}, accumulatedText string) bool {
-	// Check for [done] token in accumulated text
-	for _, pattern := range sh.doneTokenPatterns {
-		if strings.Contains(accumulatedText, pattern) {
-			return true
-		}
-	}
+// isAnthropicPing reports whether an Anthropic event is a ping heartbeat.
+func isAnthropicPing(data map[string]interface{}) bool {
+	typ, _ := data["type"].(string)
+	return typ == "ping"
+}
 
+// isGenericComplete checks if generic stream is complete
+func (sh *StreamHandler) isGenericComplete(data map[string]interface{}) bool {
 	// Check for finish reason
 	if finishReason, ok := data["finish_reason"].(string); ok {
 		if finishReason == "stop" || finishReason == "length" {
@@ -386,23 +1301,74 @@ func (sh *StreamHandler) isGenericComplete(data map[string]interface{}, accumula
 	return false
 }
 
-// isContentComplete checks if content appears complete based on heuristics
-func (sh *StreamHandler) isContentComplete(text string, channelType string) bool {
+// endOfStreamCompletionReason decides whether a stream that ended without an explicit
+// signal can be treated as complete, applying the fallbacks in precedence order.
+func (sh *StreamHandler) endOfStreamCompletionReason(accumulatedText, lastTextChunk, channelType string, attempt int, resumePunctStreak *int) CompletionReason {
+	if usesDoneToken(channelType) && sh.containsDoneToken(accumulatedText) {
+		return CompletionDoneToken
+	}
+	if sh.strictCompletion {
+		return CompletionNone
+	}
+
+	// A first attempt has no earlier attempts to confirm the punctuation, so it completes on its own
+	if sh.enablePunctuationHeuristic && sh.punctuationOnFirstAttempt && attempt == 0 && sh.endsWithSentencePunctuation(lastTextChunk) {
+		return CompletionPunctuation
+	}
+
+	// Apply punctuation heuristic for resumed attempts
+	if sh.enablePunctuationHeuristic && attempt > 0 && sh.endsWithSentencePunctuation(lastTextChunk) {
+		*resumePunctStreak++
+		sh.log.Debugf("Resume punctuation streak: %d", *resumePunctStreak)
+		if *resumePunctStreak >= 3 {
+			return CompletionPunctuation
+		}
+	} else {
+		*resumePunctStreak = 0
+	}
+
+	if sh.codeFenceCompletion && codeFencesBalanced(accumulatedText) {
+		return CompletionCodeFences
+	}
+
+	reason := sh.contentCompletionReason(accumulatedText, channelType)
+	if reason == CompletionContentAnalysis && !sh.contentAnalysisAllowed(accumulatedText, attempt) {
+		sh.log.Debugf("Content looks complete, but content analysis needs %d characters and a prior retry", sh.contentAnalysisMinChars)
+		return CompletionNone
+	}
+	return reason
+}
+
+// contentAnalysisAllowed reports whether the content analysis may complete a stream yet.
+// Without a configured minimum it always may.
+func (sh *StreamHandler) contentAnalysisAllowed(accumulatedText string, attempt int) bool {
+	if sh.contentAnalysisMinChars <= 0 {
+		return true
+	}
+	return attempt > 0 && utf8.RuneCountInString(accumulatedText) >= sh.contentAnalysisMinChars
+}
+
+// contentCompletionReason checks if content appears complete based on heuristics
+func (sh *StreamHandler) contentCompletionReason(text string, channelType string) CompletionReason {
 	if text == "" {
-		return false
+		return CompletionNone
 	}
 
 	// For Gemini, specifically check for [done] token
-	if channelType == "gemini" {
-		for _, pattern := range sh.doneTokenPatterns {
-			if strings.Contains(text, pattern) {
-				return true
-			}
-		}
+	if channelType == "gemini" && sh.containsDoneToken(text) {
+		return CompletionDoneToken
 	}
 
 	// Generic completion check
-	return sh.endsWithSentencePunctuation(text) && len(text) > 50
+	if sh.endsWithSentencePunctuation(text) && len(text) > 50 {
+		return CompletionContentAnalysis
+	}
+	return CompletionNone
+}
+
+// isContentComplete checks if content appears complete based on heuristics
+func (sh *StreamHandler) isContentComplete(text string, channelType string) bool {
+	return sh.contentCompletionReason(text, channelType) != CompletionNone
 }
 
 // endsWithSentencePunctuation checks if text ends with sentence punctuation
@@ -411,11 +1377,10 @@ func (sh *StreamHandler) endsWithSentencePunctuation(text string) bool {
 	if len(trimmed) == 0 {
 		return false
 	}
-	
+
 	runes := []rune(trimmed)
 	last := runes[len(runes)-1]
-	const punctuations = "。？！.!?…\"'\"'"
-	return strings.ContainsRune(punctuations, last)
+	return strings.ContainsRune(sh.sentencePunctuation, last)
 }
 
 // removeDoneTokensFromLine removes [done] tokens from Gemini streaming responses
@@ -423,27 +1388,29 @@ func (sh *StreamHandler) removeDoneTokensFromLine(line string, data map[string]i
 	if !strings.HasPrefix(line, "data: ") {
 		return line
 	}
-	
+
 	dataContent := strings.TrimPrefix(line, "data: ")
 	if dataContent == "[DONE]" {
 		return line // OpenAI style [DONE] should be preserved
 	}
-	
-	// Parse JSON data
+
+	// Parse JSON data, keeping numbers as written so large integers survive re-marshaling
 	var parsedData map[string]interface{}
-	if err := json.Unmarshal([]byte(dataContent), &parsedData); err != nil {
+	decoder := json.NewDecoder(strings.NewReader(dataContent))
+	decoder.UseNumber()
+	if err := decoder.Decode(&parsedData); err != nil {
 		return line
 	}
-	
+
 	// Extract text from Gemini format
 	text := sh.extractGeminiText(parsedData)
 	if text == "" {
 		return line
 	}
-	
+
 	// Remove [done] tokens from text
 	cleanText := sh.RemoveDoneTokensFromText(text)
-	
+
 	// If text was modified, reconstruct the JSON
 	if cleanText != text {
 		// Update the text in the parsed data
@@ -458,48 +1425,43 @@ func (sh *StreamHandler) removeDoneTokensFromLine(line string, data map[string]i
 				}
 			}
 		}
-		
+
 		// Marshal back to JSON
 		newDataBytes, err := json.Marshal(parsedData)
 		if err == nil {
 			return "data: " + string(newDataBytes)
 		}
 	}
-	
+
 	return line
 }
 
-// RemoveDoneTokensFromText removes [done] tokens from text
+// RemoveDoneTokensFromText removes a [done] token, and any whitespace around it, from the end
+// of text. It is meant for text that completed the stream, never for an arbitrary chunk.
 func (sh *StreamHandler) RemoveDoneTokensFromText(text string) string {
-	// Remove [done] tokens from the end of text
+	trimmed := strings.TrimRightFunc(text, unicode.IsSpace)
 	for _, pattern := range sh.doneTokenPatterns {
-		if strings.HasSuffix(text, pattern) {
-			text = strings.TrimSuffix(text, pattern)
-			// Also remove any whitespace before the token
-			text = strings.TrimSpace(text)
+		if strings.HasSuffix(trimmed, pattern) && (!isBareWord(pattern) || endsWithWord(trimmed, pattern)) {
+			// Also remove any whitespace before the token, but keep the chunk's leading space
+			text = strings.TrimRightFunc(strings.TrimSuffix(trimmed, pattern), unicode.IsSpace)
 			break
 		}
 	}
 	return text
 }
 
-// writeRetryError writes a retry error to the client
-func (sh *StreamHandler) writeRetryError(writer http.ResponseWriter, retryCount int) error {
-	errorPayload := map[string]interface{}{
-		"error": map[string]interface{}{
-			"code":    504,
-			"status":  "DEADLINE_EXCEEDED",
-			"message": fmt.Sprintf("Retry limit (%d) exceeded after stream interruption", sh.maxRetries),
-		},
-	}
+// writeAttemptsTrailer reports the attempt count as an SSE comment, since headers are already sent.
+func (sh *StreamHandler) writeAttemptsTrailer(writer http.ResponseWriter, attempts int) {
+	sh.writeTrailerComment(writer, AttemptsHeader, strconv.Itoa(attempts))
+}
 
-	errorBytes, _ := json.Marshal(errorPayload)
-	writer.Header().Set("Content-Type", "application/json")
-	writer.WriteHeader(504)
-	
-	if _, err := writer.Write(errorBytes); err != nil {
-		return fmt.Errorf("failed to write error response: %w", err)
+// writeTrailerComment writes a header-style SSE comment at the end of a stream.
+func (sh *StreamHandler) writeTrailerComment(writer http.ResponseWriter, name, value string) {
+	if _, err := fmt.Fprintf(writer, ": %s: %s\n\n", name, value); err != nil {
+		sh.log.Debugf("Failed to write %s trailer: %v", name, err)
+		return
 	}
-
-	return fmt.Errorf("retry limit exceeded")
-}
\ No newline at end of file
+	if flusher, ok := writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
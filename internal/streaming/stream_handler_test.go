@@ -1,17 +1,41 @@
 package streaming
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"gpt-load/internal/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
-	"gpt-load/internal/models"
+
+	"github.com/sirupsen/logrus"
 )
 
+// newStreamResponse builds a fake upstream response carrying the given SSE body.
+func newStreamResponse(body string) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+// geminiChunk renders a single Gemini SSE data line carrying text.
+func geminiChunk(text string) string {
+	return `data: {"candidates":[{"content":{"parts":[{"text":"` + text + `"}]}}]}` + "\n\n"
+}
+
 func TestStreamHandlerCreation(t *testing.T) {
 	config := StreamConfig{
-		MaxRetries:                3,
-		RetryDelay:                1 * time.Second,
+		MaxRetries:                 3,
+		RetryDelay:                 1 * time.Second,
 		EnablePunctuationHeuristic: true,
-		DoneTokenPatterns:         []string{"[done]", "[DONE]"},
+		DoneTokenPatterns:          []string{"[done]", "[DONE]"},
 	}
 
 	handler := NewStreamHandler(config)
@@ -28,26 +52,26 @@ func TestStreamHandlerCreation(t *testing.T) {
 
 func TestStreamProcessorFactory(t *testing.T) {
 	factory := NewStreamProcessorFactory()
-	
+
 	// Test Gemini processor
 	group := &models.Group{ChannelType: "gemini"}
 	processor := factory.CreateProcessor("gemini", group)
 	if processor == nil {
 		t.Error("Expected Gemini processor to be created")
 	}
-	
+
 	config := processor.GetStreamConfig()
 	if config.MaxRetries != 5 {
 		t.Errorf("Expected Gemini maxRetries to be 5, got %d", config.MaxRetries)
 	}
-	
+
 	// Test OpenAI processor
 	group = &models.Group{ChannelType: "openai"}
 	processor = factory.CreateProcessor("openai", group)
 	if processor == nil {
 		t.Error("Expected OpenAI processor to be created")
 	}
-	
+
 	config = processor.GetStreamConfig()
 	if config.MaxRetries != 2 {
 		t.Errorf("Expected OpenAI maxRetries to be 2, got %d", config.MaxRetries)
@@ -56,7 +80,7 @@ func TestStreamProcessorFactory(t *testing.T) {
 
 func TestEndsWithSentencePunctuation(t *testing.T) {
 	handler := NewStreamHandler(StreamConfig{})
-	
+
 	tests := []struct {
 		text     string
 		expected bool
@@ -73,11 +97,1270 @@ func TestEndsWithSentencePunctuation(t *testing.T) {
 		{"Hello world\"", true},
 		{"Hello world'", true},
 	}
-	
+
 	for _, test := range tests {
 		result := handler.endsWithSentencePunctuation(test.text)
 		if result != test.expected {
 			t.Errorf("For text '%s', expected %v, got %v", test.text, test.expected, result)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestAttemptsTrailerReflectsRetries(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 3, RetryDelay: time.Millisecond})
+	recorder := httptest.NewRecorder()
+
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		return newStreamResponse(geminiChunk("world [done]")), nil
+	}
+
+	err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk("hello")), recorder, "gemini", nil, retryFunc)
+	if err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+
+	if !strings.Contains(recorder.Body.String(), ": "+AttemptsHeader+": 2\n\n") {
+		t.Errorf("Expected attempts trailer with 2 attempts, got body %q", recorder.Body.String())
+	}
+}
+
+func TestCustomSentencePunctuation(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{SentencePunctuation: ".)"})
+
+	if handler.endsWithSentencePunctuation("Hello world\"") {
+		t.Error("Expected quote to no longer count as sentence punctuation")
+	}
+	if !handler.endsWithSentencePunctuation("call(x)") {
+		t.Error("Expected closing bracket to count as sentence punctuation")
+	}
+
+	text := strings.Repeat("a", 60) + ")"
+	if !handler.isContentComplete(text, "openai") {
+		t.Error("Expected content analysis to honour the custom punctuation set")
+	}
+	if NewStreamHandler(StreamConfig{}).isContentComplete(text, "openai") {
+		t.Error("Expected default punctuation set to reject a trailing bracket")
+	}
+}
+
+// bufferSink is an in-memory tee sink.
+type bufferSink struct {
+	strings.Builder
+	closed bool
+}
+
+func (b *bufferSink) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestTeeWriterCapturesStreamedText(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 3, RetryDelay: time.Millisecond})
+	recorder := httptest.NewRecorder()
+	sink := &bufferSink{}
+	tee := NewTeeWriter(recorder, sink, 0)
+
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		return newStreamResponse(geminiChunk("world [done]")), nil
+	}
+
+	err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk("hello")), tee, "gemini", nil, retryFunc)
+	if err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	tee.Close()
+
+	if !sink.closed {
+		t.Error("Expected tee sink to be closed")
+	}
+	if sink.String() != recorder.Body.String() {
+		t.Errorf("Expected tee sink to mirror client output\nclient: %q\nsink:   %q", recorder.Body.String(), sink.String())
+	}
+	if !strings.Contains(sink.String(), "hello") || !strings.Contains(sink.String(), "world") {
+		t.Errorf("Expected tee sink to contain text from every attempt, got %q", sink.String())
+	}
+}
+
+func TestCompletionReasonPrecedence(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{EnablePunctuationHeuristic: true, DoneTokenPatterns: []string{"[done]"}})
+
+	chunkTests := []struct {
+		name        string
+		data        map[string]interface{}
+		channelType string
+		accumulated string
+		expected    CompletionReason
+	}{
+		{"finish_reason and done token", map[string]interface{}{"finish_reason": "stop"}, "custom", "answer [done]", CompletionProtocolSignal},
+		{"gemini STOP and done token", map[string]interface{}{"metadata": map[string]interface{}{"finishReason": "STOP"}}, "gemini", "answer [done]", CompletionProtocolSignal},
+		{"done token only", map[string]interface{}{}, "custom", "answer [done]", CompletionDoneToken},
+		{"openai ignores done token", map[string]interface{}{}, "openai", "answer [done]", CompletionNone},
+		{"no signal", map[string]interface{}{}, "custom", "answer", CompletionNone},
+	}
+
+	for _, test := range chunkTests {
+		if got := handler.chunkCompletionReason(test.data, test.channelType, test.accumulated); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+
+	long := strings.Repeat("This sentence is long enough to look finished. ", 2)
+	endTests := []struct {
+		name        string
+		accumulated string
+		lastChunk   string
+		attempt     int
+		streak      int
+		expected    CompletionReason
+	}{
+		{"done token beats punctuation", long + "[done]", "end.", 1, 2, CompletionDoneToken},
+		{"punctuation beats content analysis", long, "end.", 1, 2, CompletionPunctuation},
+		{"content analysis on first attempt", long, "end.", 0, 2, CompletionContentAnalysis},
+		{"nothing conclusive", "short", "short", 0, 0, CompletionNone},
+	}
+
+	for _, test := range endTests {
+		streak := test.streak
+		if got := handler.endOfStreamCompletionReason(test.accumulated, test.lastChunk, "custom", test.attempt, &streak); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestSplitMultiByteCharacterAccumulation(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond})
+	char := "你"
+
+	// The upstream splits the three bytes of the character across two events.
+	first := newStreamResponse(geminiChunk("hello "+char[:2]) + geminiChunk(char[2:]+" world"))
+
+	var resumedFrom string
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		resumedFrom = accumulatedText
+		return newStreamResponse(geminiChunk("! [done]")), nil
+	}
+
+	if err := handler.HandleStreamingResponse(first, httptest.NewRecorder(), "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	if resumedFrom != "hello 你 world" {
+		t.Errorf("Expected accumulated text %q, got %q", "hello 你 world", resumedFrom)
+	}
+	if strings.ContainsRune(resumedFrom, '�') {
+		t.Errorf("Expected no replacement characters, got %q", resumedFrom)
+	}
+}
+
+func TestRuneCarry(t *testing.T) {
+	var carry runeCarry
+	emoji := "😀"
+	if got := carry.Append("a" + emoji[:1]); got != "a" {
+		t.Errorf("Expected incomplete rune to be held back, got %q", got)
+	}
+	if got := carry.Append(emoji[1:3]); got != "" {
+		t.Errorf("Expected still incomplete rune to be held back, got %q", got)
+	}
+	if got := carry.Append(emoji[3:] + "b"); got != emoji+"b" {
+		t.Errorf("Expected completed rune, got %q", got)
+	}
+	if carry.Pending() != 0 {
+		t.Errorf("Expected nothing pending, got %d bytes", carry.Pending())
+	}
+}
+
+// reasoningChunk renders an OpenAI-compatible chunk carrying only reasoning tokens.
+func reasoningChunk(text string) string {
+	return `data: {"choices":[{"index":0,"delta":{"reasoning_content":"` + text + `","content":null},"finish_reason":null}]}` + "\n\n"
+}
+
+// contentChunk renders an OpenAI chunk carrying content and an optional finish reason.
+func contentChunk(text, finishReason string) string {
+	reason := "null"
+	if finishReason != "" {
+		reason = `"` + finishReason + `"`
+	}
+	return `data: {"choices":[{"index":0,"delta":{"content":"` + text + `"},"finish_reason":` + reason + `}]}` + "\n\n"
+}
+
+func TestReasoningHeavyStream(t *testing.T) {
+	var stream strings.Builder
+	for i := 0; i < 20; i++ {
+		stream.WriteString(reasoningChunk("Let me think. "))
+	}
+	stream.WriteString(reasoningChunk("I am done thinking."))
+	stream.WriteString(contentChunk("The answer", ""))
+	stream.WriteString(contentChunk(" is 42", "stop"))
+
+	tests := []struct {
+		name          string
+		dropReasoning bool
+	}{
+		{"forward reasoning", false},
+		{"drop reasoning", true},
+	}
+
+	for _, test := range tests {
+		handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Millisecond, DropReasoning: test.dropReasoning})
+		retryFunc := func(accumulatedText string) (*http.Response, error) {
+			t.Errorf("%s: expected no retry for a completed reasoning stream", test.name)
+			return newStreamResponse(contentChunk("", "stop")), nil
+		}
+
+		recorder := httptest.NewRecorder()
+		if err := handler.HandleStreamingResponse(newStreamResponse(stream.String()), recorder, "openai", nil, retryFunc); err != nil {
+			t.Fatalf("%s: expected stream to complete, got %v", test.name, err)
+		}
+
+		forwarded := strings.Contains(recorder.Body.String(), "reasoning_content")
+		if forwarded == test.dropReasoning {
+			t.Errorf("%s: expected reasoning forwarded=%v, got %v", test.name, !test.dropReasoning, forwarded)
+		}
+		if !strings.Contains(recorder.Body.String(), " is 42") {
+			t.Errorf("%s: expected content to be forwarded", test.name)
+		}
+	}
+}
+
+func TestReasoningOnlyStream(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond})
+
+	// Reasoning used up the token budget; the finish_reason still ends the stream.
+	finished := reasoningChunk("Thinking.") + contentChunk("", "length")
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		t.Error("Expected a reasoning-only stream with a finish_reason not to be retried")
+		return newStreamResponse(""), nil
+	}
+	if err := handler.HandleStreamingResponse(newStreamResponse(finished), httptest.NewRecorder(), "openai", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+
+	// A stream cut off mid-reasoning is retried, without reasoning in the retry context.
+	var resumedFrom *string
+	retryFunc = func(accumulatedText string) (*http.Response, error) {
+		resumedFrom = &accumulatedText
+		return newStreamResponse(contentChunk("Answer.", "stop")), nil
+	}
+	if err := handler.HandleStreamingResponse(newStreamResponse(reasoningChunk("Still thinking")), httptest.NewRecorder(), "openai", nil, retryFunc); err != nil {
+		t.Fatalf("Expected retried stream to complete, got %v", err)
+	}
+	if resumedFrom == nil || *resumedFrom != "" {
+		t.Errorf("Expected retry without reasoning in its context, got %v", resumedFrom)
+	}
+}
+
+func TestRemoveDoneTokensPreservesLargeIntegers(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{DoneTokenPatterns: []string{"[done]"}})
+	line := `data: {"candidates":[{"content":{"parts":[{"text":"Finished. [done]"}]},"index":0}],"responseId":9007199254740993,"usageMetadata":{"totalTokenCount":12345678901234567,"ratio":0.1}}`
+
+	processed := handler.removeDoneTokensFromLine(line, nil)
+	if strings.Contains(processed, "[done]") {
+		t.Fatalf("Expected done token to be removed, got %s", processed)
+	}
+	for _, number := range []string{"9007199254740993", "12345678901234567", "0.1"} {
+		if !strings.Contains(processed, number) {
+			t.Errorf("Expected %s to survive reconstruction unchanged, got %s", number, processed)
+		}
+	}
+}
+
+func TestGroupLogLevelSuppressesRetryMessages(t *testing.T) {
+	var output strings.Builder
+	std := logrus.StandardLogger()
+	originalOut := std.Out
+	std.SetOutput(&output)
+	defer std.SetOutput(originalOut)
+
+	run := func(level string) string {
+		output.Reset()
+		group := &models.Group{Name: "noisy"}
+		group.EffectiveConfig.LogLevel = level
+		logger := NewStreamProcessorFactory().CreateProcessor("gemini", group).GetStreamConfig().Logger
+
+		handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, Logger: logger})
+		retryFunc := func(accumulatedText string) (*http.Response, error) {
+			return newStreamResponse(geminiChunk("rest [done]")), nil
+		}
+		handler.HandleStreamingResponse(newStreamResponse(geminiChunk("partial")), httptest.NewRecorder(), "gemini", nil, retryFunc)
+		return output.String()
+	}
+
+	if logs := run("error"); strings.Contains(logs, "STARTING RETRY") {
+		t.Errorf("Expected error level group to suppress retry messages, got %q", logs)
+	}
+	if logs := run("info"); !strings.Contains(logs, "STARTING RETRY") {
+		t.Errorf("Expected info level group to log retry messages, got %q", logs)
+	}
+}
+
+func TestFirstAttemptPunctuationCompletesWithoutRetry(t *testing.T) {
+	run := func(enabled int) int {
+		group := &models.Group{}
+		group.EffectiveConfig.FirstAttemptPunctuation = enabled
+		config := NewStreamProcessorFactory().CreateProcessor("custom", group).GetStreamConfig()
+		config.RetryDelay = time.Millisecond
+		handler := NewStreamHandler(config)
+
+		retries := 0
+		retryFunc := func(accumulatedText string) (*http.Response, error) {
+			retries++
+			return newStreamResponse(`data: {"text":" More.","finish_reason":"stop"}` + "\n\n"), nil
+		}
+		err := handler.HandleStreamingResponse(newStreamResponse(`data: {"text":"Short answer."}`+"\n\n"), httptest.NewRecorder(), "custom", nil, retryFunc)
+		if err != nil {
+			t.Errorf("Expected stream to complete, got %v", err)
+		}
+		return retries
+	}
+
+	if retries := run(1); retries != 0 {
+		t.Errorf("Expected first attempt ending on punctuation to complete without retry, got %d retries", retries)
+	}
+	if retries := run(0); retries == 0 {
+		t.Error("Expected default behavior to retry a short first attempt without a completion signal")
+	}
+}
+
+func TestLargeChunkIsSplitIntoSmallerEvents(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxChunkChars: 4})
+	recorder := httptest.NewRecorder()
+	stream := contentChunk("Hello, 世界!", "stop")
+
+	if err := handler.HandleStreamingResponse(newStreamResponse(stream), recorder, "openai", nil, nil); err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+
+	var pieces []string
+	var finishReasons []interface{}
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event struct {
+			Choices []struct {
+				Delta        map[string]string `json:"delta"`
+				FinishReason interface{}       `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			t.Fatalf("Expected a valid OpenAI chunk, got %q: %v", line, err)
+		}
+		if len(event.Choices) != 1 {
+			t.Fatalf("Expected one choice per chunk, got %q", line)
+		}
+		pieces = append(pieces, event.Choices[0].Delta["content"])
+		finishReasons = append(finishReasons, event.Choices[0].FinishReason)
+	}
+
+	expected := []string{"Hell", "o, 世", "界!"}
+	if strings.Join(pieces, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected pieces %q, got %q", expected, pieces)
+	}
+	for i, reason := range finishReasons {
+		if i < len(finishReasons)-1 && reason != nil {
+			t.Errorf("Expected finish_reason only on the last chunk, chunk %d has %v", i, reason)
+		}
+	}
+	if len(finishReasons) > 0 && finishReasons[len(finishReasons)-1] != "stop" {
+		t.Errorf("Expected last chunk to carry finish_reason stop, got %v", finishReasons[len(finishReasons)-1])
+	}
+}
+
+func TestStreamEndingMidLineTriggersRetry(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]"}})
+	recorder := httptest.NewRecorder()
+
+	// The first event alone looks finished, but the connection drops in the middle of the next one
+	stream := geminiChunk("This opening paragraph is long enough to look like a complete answer.") +
+		`data: {"candidates":[{"content":{"parts":[{"text":" And th`
+
+	retries := 0
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retries++
+		return newStreamResponse(geminiChunk(" And then it ends. [done]")), nil
+	}
+
+	if err := handler.HandleStreamingResponse(newStreamResponse(stream), recorder, "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete after retry, got %v", err)
+	}
+	if retries != 1 {
+		t.Errorf("Expected a truncated last line to trigger exactly one retry, got %d", retries)
+	}
+	// Only the retried event carries the sentence
+	if body := recorder.Body.String(); strings.Count(body, "And th") != 1 {
+		t.Errorf("Expected the partial line not to be forwarded, got %q", body)
+	}
+}
+
+// failingBody yields its data and then fails like a dropped connection.
+type failingBody struct {
+	data *strings.Reader
+}
+
+func (b *failingBody) Read(p []byte) (int, error) {
+	if b.data.Len() == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return b.data.Read(p)
+}
+
+func (b *failingBody) Close() error { return nil }
+
+func TestNetworkErrorBeforeTextReplaysRequestImmediately(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Second, DoneTokenPatterns: []string{"[done]"}})
+
+	var retriedWith []string
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retriedWith = append(retriedWith, accumulatedText)
+		return newStreamResponse(geminiChunk("Full answer. [done]")), nil
+	}
+
+	resp := newStreamResponse("")
+	resp.Body = &failingBody{data: strings.NewReader(": keep-alive\n\n")}
+
+	start := time.Now()
+	if err := handler.HandleStreamingResponse(resp, httptest.NewRecorder(), "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete after reconnect, got %v", err)
+	}
+	if len(retriedWith) != 1 || retriedWith[0] != "" {
+		t.Errorf("Expected one retry replaying the original request, got %q", retriedWith)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("Expected reconnect without the retry delay, took %v", elapsed)
+	}
+}
+
+func TestIncompleteStreamContinuesFromAccumulatedText(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: 50 * time.Millisecond, DoneTokenPatterns: []string{"[done]"}})
+
+	var retriedWith []string
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retriedWith = append(retriedWith, accumulatedText)
+		return newStreamResponse(geminiChunk(" the rest. [done]")), nil
+	}
+
+	start := time.Now()
+	if err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk("Half of")), httptest.NewRecorder(), "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete after retry, got %v", err)
+	}
+	if len(retriedWith) != 1 || retriedWith[0] != "Half of" {
+		t.Errorf("Expected one continuation retry with the accumulated text, got %q", retriedWith)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected continuation to wait for the retry delay, took %v", elapsed)
+	}
+}
+
+func TestLineSplitterFlagsPartialLastLine(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"data: a\n\ndata: b\n\n", false},
+		{"data: a\n\ndata: b", true},
+		{"data: a\r\n", false},
+		{"data: a\r", false},
+		{"data: a\r\rdata: b", true},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		var lines lineSplitter
+		scanner := bufio.NewScanner(strings.NewReader(test.input))
+		scanner.Split(lines.Split)
+		for scanner.Scan() {
+		}
+		if lines.partial != test.expected {
+			t.Errorf("Input %q: expected partial %v, got %v", test.input, test.expected, lines.partial)
+		}
+	}
+}
+
+// noiseBody is an endless upstream body of binary garbage lines.
+type noiseBody struct {
+	reads int
+}
+
+func (b *noiseBody) Read(p []byte) (int, error) {
+	b.reads++
+	noise := []byte("\x00\x8f\x01\xfe\x7f garbage \x03\n")
+	n := 0
+	for n+len(noise) <= len(p) && n < 4096 {
+		n += copy(p[n:], noise)
+	}
+	return n, nil
+}
+
+func (b *noiseBody) Close() error { return nil }
+
+func TestBinaryNoiseAbortsAttemptQuickly(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond})
+	recorder := httptest.NewRecorder()
+
+	noise := &noiseBody{}
+	first := newStreamResponse("")
+	first.Body = io.NopCloser(io.MultiReader(strings.NewReader(geminiChunk("Hello")), noise))
+
+	retries := 0
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retries++
+		return newStreamResponse(geminiChunk(" world. [done]")), nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.HandleStreamingResponse(first, recorder, "gemini", nil, retryFunc)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected stream to complete after retry, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected binary noise to abort the attempt instead of being scanned forever")
+	}
+
+	if retries != 1 {
+		t.Errorf("Expected the noisy attempt to be retried once, got %d retries", retries)
+	}
+	if noise.reads > 2 {
+		t.Errorf("Expected the attempt to stop after a few reads of noise, got %d", noise.reads)
+	}
+	if strings.ContainsRune(recorder.Body.String(), 0) {
+		t.Error("Expected binary noise not to be forwarded to the client")
+	}
+}
+
+func TestJSONArrayFramedGeminiStream(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]"}})
+
+	// Gemini pretty-prints each element and sends the separators between them
+	stream := "[{\n  \"candidates\": [{\"content\": {\"parts\": [{\"text\": \"Hello\"}]}}]\n}\n,\r\n" +
+		"{\n  \"candidates\": [{\"content\": {\"parts\": [{\"text\": \" world. [done]\"}]}}]\n}\n]"
+
+	retries := 0
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retries++
+		return newStreamResponse(geminiChunk("[done]")), nil
+	}
+
+	recorder := httptest.NewRecorder()
+	if err := handler.HandleStreamingResponse(newStreamResponse(stream), recorder, "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected array-framed stream to complete, got %v", err)
+	}
+	if retries != 0 {
+		t.Errorf("Expected array-framed stream to complete without retry, got %d retries", retries)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, `data: {"candidates":[{"content":{"parts":[{"text":"Hello"}]}}]}`) {
+		t.Errorf("Expected array elements to be forwarded as SSE events, got %q", body)
+	}
+
+	// An array cut off before its closing bracket is a truncated stream
+	truncated := "[{\"candidates\": [{\"content\": {\"parts\": [{\"text\": \"Hello\"}]}}]},\n{\"candidates\": [{\"con"
+	retries = 0
+	if err := handler.HandleStreamingResponse(newStreamResponse(truncated), httptest.NewRecorder(), "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected truncated stream to complete after retry, got %v", err)
+	}
+	if retries != 1 {
+		t.Errorf("Expected a truncated array to trigger one retry, got %d", retries)
+	}
+}
+
+func TestCompleteJSONResponseToStreamingRequest(t *testing.T) {
+	completion := `{"candidates": [{"content": {"parts": [{"text": "Whole answer. [done]"}]}, "finishReason": "STOP"}]}`
+
+	run := func(asJSON bool) (*httptest.ResponseRecorder, int) {
+		handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]"}, SingleJSONAsJSON: asJSON})
+		retries := 0
+		retryFunc := func(accumulatedText string) (*http.Response, error) {
+			retries++
+			return newStreamResponse(geminiChunk("[done]")), nil
+		}
+
+		resp := newStreamResponse(completion)
+		resp.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		recorder := httptest.NewRecorder()
+		if err := handler.HandleStreamingResponse(resp, recorder, "gemini", nil, retryFunc); err != nil {
+			t.Fatalf("Expected JSON response to complete the stream, got %v", err)
+		}
+		return recorder, retries
+	}
+
+	recorder, retries := run(false)
+	if retries != 0 {
+		t.Errorf("Expected a complete JSON response not to be retried, got %d retries", retries)
+	}
+	if body := recorder.Body.String(); !strings.HasPrefix(body, `data: {"candidates":[{"content":{"parts":[{"text":"Whole answer."}]},"finishReason":"STOP"}]}`+"\n\n") {
+		t.Errorf("Expected the response as a single SSE event without the done token, got %q", body)
+	}
+
+	recorder, retries = run(true)
+	if retries != 0 {
+		t.Errorf("Expected a complete JSON response not to be retried, got %d retries", retries)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected a regular JSON response, got Content-Type %q", contentType)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &decoded); err != nil {
+		t.Errorf("Expected the body to be the JSON response alone, got %q", recorder.Body.String())
+	}
+}
+
+func TestStreamProcessorFactoryRetryDelays(t *testing.T) {
+	factory := NewStreamProcessorFactory()
+
+	expected := map[string]time.Duration{
+		"gemini":    1500 * time.Millisecond,
+		"anthropic": 750 * time.Millisecond,
+		"openai":    500 * time.Millisecond,
+		"custom":    time.Second,
+	}
+	for channelType, delay := range expected {
+		config := factory.CreateProcessor(channelType, &models.Group{ChannelType: channelType}).GetStreamConfig()
+		if config.RetryDelay != delay {
+			t.Errorf("Expected %s retry delay to be %v, got %v", channelType, delay, config.RetryDelay)
+		}
+	}
+
+	group := &models.Group{ChannelType: "gemini"}
+	group.EffectiveConfig.StreamRetryDelayMs = 200
+	if delay := factory.CreateProcessor("gemini", group).GetStreamConfig().RetryDelay; delay != 200*time.Millisecond {
+		t.Errorf("Expected the group setting to override the channel retry delay, got %v", delay)
+	}
+}
+
+func TestGroupOverridesChannelStreamDefaults(t *testing.T) {
+	factory := NewStreamProcessorFactory()
+
+	group := &models.Group{ChannelType: "gemini"}
+	group.EffectiveConfig.StreamMaxRetries = 8
+	group.EffectiveConfig.StreamDoneTokens = "[done], <|end|> ,"
+	group.EffectiveConfig.StreamPunctuationHeuristic = PunctuationHeuristicOff
+	config := factory.CreateProcessor("gemini", group).GetStreamConfig()
+	if config.MaxRetries != 8 {
+		t.Errorf("Expected the group to raise the retries to 8, got %d", config.MaxRetries)
+	}
+	if len(config.DoneTokenPatterns) != 2 || config.DoneTokenPatterns[1] != "<|end|>" {
+		t.Errorf("Expected the group done tokens, got %q", config.DoneTokenPatterns)
+	}
+	if config.EnablePunctuationHeuristic {
+		t.Error("Expected the group to disable the punctuation heuristic")
+	}
+
+	// Unset values keep the channel defaults
+	group = &models.Group{ChannelType: "openai"}
+	group.EffectiveConfig.StreamPunctuationHeuristic = PunctuationHeuristicAuto
+	config = factory.CreateProcessor("openai", group).GetStreamConfig()
+	if config.MaxRetries != 2 || len(config.DoneTokenPatterns) != 0 || config.EnablePunctuationHeuristic {
+		t.Errorf("Expected the OpenAI defaults, got retries %d, done tokens %q, punctuation %v", config.MaxRetries, config.DoneTokenPatterns, config.EnablePunctuationHeuristic)
+	}
+
+	group.EffectiveConfig.StreamPunctuationHeuristic = PunctuationHeuristicOn
+	if !factory.CreateProcessor("openai", group).GetStreamConfig().EnablePunctuationHeuristic {
+		t.Error("Expected the group to enable the punctuation heuristic")
+	}
+}
+
+func TestOpenAIMultiPartDeltaContent(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond})
+
+	var data map[string]interface{}
+	event := `{"choices":[{"delta":{"content":[{"type":"text","text":"The picture shows "},{"type":"image_url","image_url":{"url":"https://example.com/a.png"}},{"type":"text","text":"a cat."}]}}]}`
+	if err := json.Unmarshal([]byte(event), &data); err != nil {
+		t.Fatal(err)
+	}
+	if text := handler.extractTextFromData(data, "openai"); text != "The picture shows a cat." {
+		t.Errorf("Expected the text parts to be concatenated, got %q", text)
+	}
+
+	retries := 0
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retries++
+		return newStreamResponse("data: [DONE]\n\n"), nil
+	}
+	if err := handler.HandleStreamingResponse(newStreamResponse("data: "+event+"\n\ndata: [DONE]\n\n"), httptest.NewRecorder(), "openai", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	if retries != 0 {
+		t.Errorf("Expected a multi-part stream not to be retried, got %d retries", retries)
+	}
+}
+
+func TestContentAnalysisMinChars(t *testing.T) {
+	short := "This opening sentence is long enough for the default check."
+	long := short + strings.Repeat(" More text follows here.", 5)
+
+	tests := []struct {
+		name     string
+		minChars int
+		text     string
+		attempt  int
+		expected CompletionReason
+	}{
+		{"default on first attempt", 0, short, 0, CompletionContentAnalysis},
+		{"long text without prior retry", 100, long, 0, CompletionNone},
+		{"short text after retry", 100, short, 1, CompletionNone},
+		{"long text after retry", 100, long, 1, CompletionContentAnalysis},
+	}
+
+	for _, test := range tests {
+		handler := NewStreamHandler(StreamConfig{ContentAnalysisMinChars: test.minChars})
+		streak := 0
+		if reason := handler.endOfStreamCompletionReason(test.text, "", "openai", test.attempt, &streak); reason != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, reason)
+		}
+	}
+}
+
+func TestRetryAnsweredWithErrorStatusFollowsRetryPolicy(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]"}})
+
+	calls := 0
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		calls++
+		if calls == 1 {
+			return nil, &RetryStatusError{StatusCode: http.StatusTooManyRequests, Message: "Rate limited", Retryable: true}
+		}
+		return newStreamResponse(geminiChunk(" the rest. [done]")), nil
+	}
+	recorder := httptest.NewRecorder()
+	if err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk("Half of")), recorder, "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected the stream to complete after the rate-limited retry, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected the rate-limited retry to be retried once, got %d retry requests", calls)
+	}
+	if body := recorder.Body.String(); strings.Contains(body, "Rate limited") || !strings.Contains(body, "the rest") {
+		t.Errorf("Expected only the successful retry to be forwarded, got %s", body)
+	}
+
+	calls = 0
+	retryFunc = func(accumulatedText string) (*http.Response, error) {
+		calls++
+		return nil, &RetryStatusError{StatusCode: http.StatusTooManyRequests, Message: "Rate limited", Retryable: true}
+	}
+	err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk("Half of")), httptest.NewRecorder(), "gemini", nil, retryFunc)
+	if !errors.Is(err, ErrRetryLimitExceeded) || calls != 2 {
+		t.Errorf("Expected the retries to run out after 2 requests, got %v after %d", err, calls)
+	}
+
+	calls = 0
+	retryFunc = func(accumulatedText string) (*http.Response, error) {
+		calls++
+		return nil, &RetryStatusError{StatusCode: http.StatusBadRequest, Message: "Invalid request"}
+	}
+	recorder = httptest.NewRecorder()
+	err = handler.HandleStreamingResponse(newStreamResponse(geminiChunk("Half of")), recorder, "gemini", nil, retryFunc)
+	if !errors.Is(err, ErrRetryLimitExceeded) || calls != 1 {
+		t.Errorf("Expected a non-retryable status to end the stream after 1 request, got %v after %d", err, calls)
+	}
+	if !strings.Contains(recorder.Body.String(), "event: error") {
+		t.Errorf("Expected the client to be told the stream failed, got %s", recorder.Body.String())
+	}
+}
+
+func TestRetryAnsweredWithErrorStatusStopsForGoneClient(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 3, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	handler.SetContext(ctx)
+
+	calls := 0
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		calls++
+		cancel()
+		return nil, &RetryStatusError{StatusCode: http.StatusTooManyRequests, Message: "Rate limited", Retryable: true}
+	}
+	err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk("Half of")), httptest.NewRecorder(), "gemini", nil, retryFunc)
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Errorf("Expected no retry after the client left, got %v after %d requests", err, calls)
+	}
+}
+
+func TestPrematureEOFReconnectsImmediately(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Second, DoneTokenPatterns: []string{"[done]"}})
+
+	var retriedWith []string
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retriedWith = append(retriedWith, accumulatedText)
+		return newStreamResponse(geminiChunk("Full answer. [done]")), nil
+	}
+
+	// The body ends cleanly, but before the upstream gave any finish reason
+	start := time.Now()
+	if err := handler.HandleStreamingResponse(newStreamResponse(": keep-alive\n\n"), httptest.NewRecorder(), "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete after reconnect, got %v", err)
+	}
+	if len(retriedWith) != 1 || retriedWith[0] != "" {
+		t.Errorf("Expected one retry replaying the original request, got %q", retriedWith)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("Expected a premature EOF to reconnect without the retry delay, took %v", elapsed)
+	}
+}
+
+func TestGracefulEOFAfterFinishReasonWaitsForRetryDelay(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: 50 * time.Millisecond, DoneTokenPatterns: []string{"[done]"}})
+
+	retries := 0
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retries++
+		return newStreamResponse(geminiChunk("Full answer. [done]")), nil
+	}
+
+	// The upstream finished its response on purpose, it just did not answer yet
+	stream := "data: {\"candidates\":[{\"finishReason\":\"STOP\"}]}\n\n"
+	start := time.Now()
+	if err := handler.HandleStreamingResponse(newStreamResponse(stream), httptest.NewRecorder(), "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete after retry, got %v", err)
+	}
+	if retries != 1 {
+		t.Errorf("Expected one retry, got %d", retries)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected a graceful close to be retried after the retry delay, took %v", elapsed)
+	}
+}
+
+func TestLongLinesWithinMaxLineBytes(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]"}})
+
+	// An inline image easily makes a single event larger than bufio's default 64KB
+	image := strings.Repeat("A", 256*1024)
+	retries := 0
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retries++
+		return newStreamResponse(geminiChunk("Retried. [done]")), nil
+	}
+
+	recorder := httptest.NewRecorder()
+	if err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk(image+" [done]")), recorder, "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	if retries != 0 || !strings.Contains(recorder.Body.String(), image) {
+		t.Errorf("Expected the long line to be forwarded without a retry, got %d retries", retries)
+	}
+
+	limited := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]"}, MaxLineBytes: 64 * 1024})
+	recorder = httptest.NewRecorder()
+	if err := limited.HandleStreamingResponse(newStreamResponse(geminiChunk(image+" [done]")), recorder, "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete after retry, got %v", err)
+	}
+	if retries != 1 || strings.Contains(recorder.Body.String(), image) {
+		t.Errorf("Expected a line over the limit to abandon the attempt, got %d retries", retries)
+	}
+
+	// The default limit is 1MB
+	oversized := strings.Repeat("A", DefaultMaxLineBytes)
+	recorder = httptest.NewRecorder()
+	if err := handler.HandleStreamingResponse(newStreamResponse(geminiChunk(oversized+" [done]")), recorder, "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete after retry, got %v", err)
+	}
+	if retries != 2 || strings.Contains(recorder.Body.String(), oversized) {
+		t.Errorf("Expected a line over the default limit to abandon the attempt, got %d retries", retries)
+	}
+}
+
+func TestMultiLineDataEventIsJoined(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]"}})
+
+	// One event whose JSON payload is wrapped over several data lines
+	stream := "data: {\"candidates\":[{\"content\":\n" +
+		"data: {\"parts\":[{\"text\":\"Hello there.\"}]}}]}\n\n" +
+		geminiChunk(" Bye. [done]")
+
+	retries := 0
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retries++
+		return newStreamResponse(geminiChunk("[done]")), nil
+	}
+
+	recorder := httptest.NewRecorder()
+	if err := handler.HandleStreamingResponse(newStreamResponse(stream), recorder, "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	if retries != 0 {
+		t.Errorf("Expected no retry, got %d", retries)
+	}
+	body := recorder.Body.String()
+	if !strings.HasPrefix(body, "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"Hello there.\"}]}}]}\n\n") {
+		t.Errorf("Expected the wrapped event to be forwarded as a single data line, got %q", body)
+	}
+
+	// Data lines that are complete events on their own are left alone
+	recorder = httptest.NewRecorder()
+	separate := strings.TrimSuffix(geminiChunk("One."), "\n") + strings.TrimSuffix(geminiChunk(" Two. [done]"), "\n") + "\n"
+	if err := handler.HandleStreamingResponse(newStreamResponse(separate), recorder, "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, `"text":"One."`) || !strings.Contains(body, `Two.`) {
+		t.Errorf("Expected both events to be forwarded, got %q", body)
+	}
+}
+
+func TestCRLFFramedStreams(t *testing.T) {
+	tests := []struct {
+		name        string
+		channelType string
+		stream      string
+		want        string
+	}{
+		{"openai crlf", "openai", "data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\r\n\r\ndata: [DONE]\r\n\r\n", `"content":"Hi"`},
+		{"openai lone cr", "openai", "data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\r\rdata: [DONE]\r\r", `"content":"Hi"`},
+		{"gemini crlf", "gemini", "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"Hi. [done]\"}]}}]}\r\n\r\n", `"text":"Hi."`},
+	}
+
+	for _, test := range tests {
+		handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]"}})
+		retries := 0
+		retryFunc := func(accumulatedText string) (*http.Response, error) {
+			retries++
+			return newStreamResponse("data: [DONE]\n\n"), nil
+		}
+
+		recorder := httptest.NewRecorder()
+		if err := handler.HandleStreamingResponse(newStreamResponse(test.stream), recorder, test.channelType, nil, retryFunc); err != nil {
+			t.Fatalf("%s: expected stream to complete, got %v", test.name, err)
+		}
+		if retries != 0 {
+			t.Errorf("%s: expected the terminator to be recognized without a retry, got %d retries", test.name, retries)
+		}
+		if body := recorder.Body.String(); !strings.Contains(body, test.want) || strings.Contains(body, "\r") {
+			t.Errorf("%s: expected the event to be forwarded without carriage returns, got %q", test.name, body)
+		}
+	}
+}
+
+func TestDoneSignalVariants(t *testing.T) {
+	for _, done := range []string{"data: [DONE]", "data:[DONE]", "data: [DONE] ", "data:  [DONE]\t", " data: [DONE]"} {
+		// The patterns are for the injected done token and play no part in recognizing [DONE]
+		handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"<end>"}})
+		retries := 0
+		retryFunc := func(accumulatedText string) (*http.Response, error) {
+			retries++
+			return newStreamResponse("data: [DONE]\n\n"), nil
+		}
+
+		recorder := httptest.NewRecorder()
+		if err := handler.HandleStreamingResponse(newStreamResponse(openAIDeltaEvent("Hi")+done+"\n\n"), recorder, "openai", nil, retryFunc); err != nil {
+			t.Fatalf("%q: expected stream to complete, got %v", done, err)
+		}
+		if retries != 0 {
+			t.Errorf("%q: expected the terminator to be recognized without a retry, got %d retries", done, retries)
+		}
+		if strings.Contains(recorder.Body.String(), "[DONE]") {
+			t.Errorf("%q: expected the terminator to be handled like data: [DONE], got %q", done, recorder.Body.String())
+		}
+	}
+
+	for _, line := range []string{"data: [DONE]x", `data: {"text":"[DONE]"}`, "event: [DONE]"} {
+		if isDoneSignal(line) {
+			t.Errorf("Expected %q not to be taken for [DONE]", line)
+		}
+	}
+}
+
+func TestClientDisconnectAbandonsStreamWithoutRetry(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	handler.SetContext(ctx)
+
+	// The upstream sends one chunk and then stalls
+	upstream, upstreamWriter := io.Pipe()
+	go func() {
+		upstreamWriter.Write([]byte(geminiChunk("Half of")))
+		cancel()
+	}()
+	resp := newStreamResponse("")
+	resp.Body = upstream
+
+	retries := 0
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retries++
+		return newStreamResponse(geminiChunk(" the rest. [done]")), nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.HandleStreamingResponse(resp, httptest.NewRecorder(), "gemini", nil, retryFunc)
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the stream to end with the client's cancellation, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a disconnected client to stop the stalled upstream read")
+	}
+	if retries != 0 {
+		t.Errorf("Expected no retry for a disconnected client, got %d", retries)
+	}
+}
+
+// openAIToolCallStream streams a get_weather call whose arguments arrive in fragments.
+const openAIToolCallStream = `data: {"choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]},"finish_reason":null}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]},"finish_reason":null}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]},"finish_reason":null}]}
+
+`
+
+func TestOpenAIToolCallStreams(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  string
+		wantErr error
+	}{
+		{"finished tool call", openAIToolCallStream + `data: {"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}` + "\n\n", nil},
+		// Neither a replay nor a continuation can extend a call the client already holds part of
+		{"truncated tool call", openAIToolCallStream, ErrRetryLimitExceeded},
+	}
+
+	for _, test := range tests {
+		handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, DedupeChunks: true})
+		retries := 0
+		retryFunc := func(accumulatedText string) (*http.Response, error) {
+			retries++
+			return newStreamResponse(openAIToolCallStream + "data: [DONE]\n\n"), nil
+		}
+
+		recorder := httptest.NewRecorder()
+		if err := handler.HandleStreamingResponse(newStreamResponse(test.stream), recorder, "openai", nil, retryFunc); !errors.Is(err, test.wantErr) {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.wantErr, err)
+		}
+		if retries != 0 {
+			t.Errorf("%s: expected no retry, got %d", test.name, retries)
+		}
+		body := recorder.Body.String()
+		if strings.Count(body, `"arguments":"{\"city\":"`) != 1 || strings.Count(body, `"arguments":"\"Paris\"}"`) != 1 {
+			t.Errorf("%s: expected every tool-call delta to be forwarded exactly once, got %q", test.name, body)
+		}
+		if test.wantErr != nil && !strings.Contains(body, "event: error") {
+			t.Errorf("%s: expected the client to be told the stream failed, got %q", test.name, body)
+		}
+	}
+}
+
+func TestToolCallArgumentsCountAsProgress(t *testing.T) {
+	var received []int
+	decider := RetryDeciderFunc(func(ctx RetryContext) RetryAction {
+		received = append(received, ctx.ReceivedChars)
+		if !ctx.ToolCallForwarded {
+			t.Error("Expected the forwarded tool call to be reported to the decider")
+		}
+		return RetryOriginal
+	})
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, RetryDecider: decider})
+
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		t.Error("Expected no retry after a tool call reached the client")
+		return newStreamResponse("data: [DONE]\n\n"), nil
+	}
+	handler.HandleStreamingResponse(newStreamResponse(openAIToolCallStream), httptest.NewRecorder(), "openai", nil, retryFunc)
+
+	if want := len(`{"city":"Paris"}`); len(received) != 1 || received[0] != want {
+		t.Errorf("Expected %d received characters of arguments, got %v", want, received)
+	}
+}
+
+func TestAccumulatedReasoning(t *testing.T) {
+	stream := reasoningChunk("Let me think.") + reasoningChunk(" Still thinking")
+
+	tests := []struct {
+		name          string
+		dropReasoning bool
+	}{
+		{"forward reasoning", false},
+		{"drop reasoning", true},
+	}
+
+	for _, test := range tests {
+		handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, AccumulateReasoning: true, DropReasoning: test.dropReasoning})
+		var resumedFrom string
+		retryFunc := func(accumulatedText string) (*http.Response, error) {
+			resumedFrom = accumulatedText
+			return newStreamResponse(contentChunk("Answer.", "stop")), nil
+		}
+
+		recorder := httptest.NewRecorder()
+		if err := handler.HandleStreamingResponse(newStreamResponse(stream), recorder, "openai", nil, retryFunc); err != nil {
+			t.Fatalf("%s: expected retried stream to complete, got %v", test.name, err)
+		}
+		if resumedFrom != "Let me think. Still thinking" {
+			t.Errorf("%s: expected the reasoning to be accumulated, got %q", test.name, resumedFrom)
+		}
+		forwarded := strings.Contains(recorder.Body.String(), strings.TrimSuffix(reasoningChunk("Let me think."), "\n\n"))
+		if forwarded == test.dropReasoning {
+			t.Errorf("%s: expected the raw reasoning line forwarded=%v, got body %q", test.name, !test.dropReasoning, recorder.Body.String())
+		}
+	}
+}
+
+// anthropicEvent renders an Anthropic SSE event with its event line.
+func anthropicEvent(typ, data string) string {
+	return "event: " + typ + "\ndata: " + data + "\n\n"
+}
+
+func TestAnthropicPingEventsAreLivenessOnly(t *testing.T) {
+	ping := anthropicEvent("ping", `{"type": "ping"}`)
+	text := func(s string) string {
+		return anthropicEvent("content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"`+s+`"}}`)
+	}
+	body := anthropicEvent("message_start", `{"type":"message_start","message":{"role":"assistant"}}`) +
+		ping + text("Hello") + ping + ping + text(" world") + ping
+
+	tests := []struct {
+		name        string
+		stream      string
+		wantRetries int
+	}{
+		{"completed", body + anthropicEvent("message_stop", `{"type":"message_stop"}`), 0},
+		{"cut off between pings", body, 1},
+	}
+
+	for _, test := range tests {
+		handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond})
+		retries := 0
+		var resumedFrom string
+		retryFunc := func(accumulatedText string) (*http.Response, error) {
+			retries++
+			resumedFrom = accumulatedText
+			return newStreamResponse(anthropicEvent("message_stop", `{"type":"message_stop"}`)), nil
+		}
+
+		recorder := httptest.NewRecorder()
+		if err := handler.HandleStreamingResponse(newStreamResponse(test.stream), recorder, "anthropic", nil, retryFunc); err != nil {
+			t.Fatalf("%s: expected stream to complete, got %v", test.name, err)
+		}
+		if retries != test.wantRetries {
+			t.Errorf("%s: expected %d retries, got %d", test.name, test.wantRetries, retries)
+		}
+		if retries > 0 && resumedFrom != "Hello world" {
+			t.Errorf("%s: expected pings to add nothing to the retry context, got %q", test.name, resumedFrom)
+		}
+		if got := strings.Count(recorder.Body.String(), `{"type": "ping"}`); got != 4 {
+			t.Errorf("%s: expected all 4 pings to be forwarded, got %d", test.name, got)
+		}
+	}
+}
+
+func TestDoneTokenMatchesWholeTokens(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{DoneTokenPatterns: []string{"[done]", "[DONE]", "done", "DONE"}})
+
+	tests := []struct {
+		text     string
+		complete bool
+		stripped string
+	}{
+		{"The project was abandoned", false, "The project was abandoned"},
+		{"You did it, well done.", false, "You did it, well done."},
+		{"It is a done deal, but", false, "It is a done deal, but"},
+		{"The task is undone", false, "The task is undone"},
+		{"The answer is 42. [done]", true, "The answer is 42."},
+		{"The answer is 42. done", true, "The answer is 42."},
+		{"The answer is 42.\nDONE\n", true, "The answer is 42."},
+		{"done", true, ""},
+	}
+
+	for _, test := range tests {
+		if got := handler.containsDoneToken(test.text); got != test.complete {
+			t.Errorf("containsDoneToken(%q) = %v, expected %v", test.text, got, test.complete)
+		}
+		if got := handler.RemoveDoneTokensFromText(test.text); got != test.stripped {
+			t.Errorf("RemoveDoneTokensFromText(%q) = %q, expected %q", test.text, got, test.stripped)
+		}
+	}
+
+	// Text that merely ends in the word is not a completed stream
+	retries := 0
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retries++
+		return newStreamResponse(geminiChunk(" the rest. [done]")), nil
+	}
+	streamHandler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]", "done"}})
+	if err := streamHandler.HandleStreamingResponse(newStreamResponse(geminiChunk("The plan was abandoned")), httptest.NewRecorder(), "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	if retries != 1 {
+		t.Errorf("Expected a word ending in done not to complete the stream, got %d retries", retries)
+	}
+}
+
+func TestDoneTokenIsStrippedOnlyFromCompletingChunk(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]", "[DONE]", "done", "DONE"}})
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		t.Error("Expected the stream to complete without a retry")
+		return newStreamResponse(""), nil
+	}
+
+	// The word undone is split so that a chunk ends in done without ending the answer
+	stream := geminiChunk("The task is un") + geminiChunk("done") + geminiChunk(" for now. [done]\\n")
+	recorder := httptest.NewRecorder()
+	if err := handler.HandleStreamingResponse(newStreamResponse(stream), recorder, "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, `"text":"done"`) {
+		t.Errorf("Expected the mid-stream word to be forwarded untouched, got %q", body)
+	}
+	if !strings.Contains(body, `"text":" for now."`) {
+		t.Errorf("Expected the trailing token to be stripped, got %q", body)
+	}
+}
+
+func TestBareDoneTokenOnChunkBoundaryDoesNotEndStream(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]", "done"}})
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		t.Error("Expected the stream to complete without a retry")
+		return newStreamResponse(""), nil
+	}
+
+	// The first chunk pauses on the word done, the answer goes on in the next one
+	stream := geminiChunk("I am done") + geminiChunk(" thinking about it. [done]")
+	recorder := httptest.NewRecorder()
+	if err := handler.HandleStreamingResponse(newStreamResponse(stream), recorder, "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, `"text":"I am done"`) || !strings.Contains(body, `"text":" thinking about it."`) {
+		t.Errorf("Expected the whole answer with only the marker stripped, got %q", body)
+	}
+
+	// A bare word still completes the chunk that carries the finish reason
+	final := `data: {"candidates":[{"content":{"parts":[{"text":"That is all. done"}]},"finishReason":"STOP"}]}` + "\n\n"
+	recorder = httptest.NewRecorder()
+	if err := handler.HandleStreamingResponse(newStreamResponse(final), recorder, "gemini", nil, retryFunc); err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, `"text":"That is all."`) {
+		t.Errorf("Expected the bare token to be stripped from the final chunk, got %q", body)
+	}
+}
+
+func TestTrailingBareDoneTokenIsStripped(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]", "done"}})
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		t.Error("Expected the stream to complete without a retry")
+		return newStreamResponse(""), nil
+	}
+
+	tests := []struct {
+		name   string
+		stream string
+	}{
+		{"at the end of the stream", geminiChunk("That is all.") + geminiChunk(" done")},
+		{"before an empty finishing chunk", geminiChunk("That is all.") + geminiChunk(" done") + `data: {"candidates":[{"finishReason":"STOP"}]}` + "\n\n"},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		if err := handler.HandleStreamingResponse(newStreamResponse(test.stream), recorder, "gemini", nil, retryFunc); err != nil {
+			t.Fatalf("%s: expected stream to complete, got %v", test.name, err)
+		}
+		body := recorder.Body.String()
+		if strings.Contains(body, "done") || !strings.Contains(body, `"text":"That is all."`) {
+			t.Errorf("%s: expected the answer without the trailing done, got %q", test.name, body)
+		}
+	}
+}
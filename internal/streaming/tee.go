@@ -0,0 +1,82 @@
+package streaming
+
+import (
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultTeeBufferSize is the number of pending writes a tee buffers before dropping copies.
+const DefaultTeeBufferSize = 256
+
+// TeeWriter mirrors everything written to a client stream into a secondary sink.
+// Copies are handed to a background goroutine so a slow sink never blocks the client;
+// when the buffer is full the copy is dropped rather than delaying the stream.
+type TeeWriter struct {
+	http.ResponseWriter
+	sink    io.WriteCloser
+	pending chan []byte
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewTeeWriter wraps w so that every write is also delivered asynchronously to sink.
+func NewTeeWriter(w http.ResponseWriter, sink io.WriteCloser, bufferSize int) *TeeWriter {
+	if bufferSize <= 0 {
+		bufferSize = DefaultTeeBufferSize
+	}
+
+	t := &TeeWriter{
+		ResponseWriter: w,
+		sink:           sink,
+		pending:        make(chan []byte, bufferSize),
+		done:           make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Write forwards p to the client and queues a copy for the sink.
+func (t *TeeWriter) Write(p []byte) (int, error) {
+	n, err := t.ResponseWriter.Write(p)
+	if n > 0 {
+		chunk := make([]byte, n)
+		copy(chunk, p[:n])
+		select {
+		case t.pending <- chunk:
+		default:
+			logrus.Warn("Stream tee buffer is full, dropping copied chunk")
+		}
+	}
+	return n, err
+}
+
+// Flush implements http.Flusher by delegating to the wrapped writer.
+func (t *TeeWriter) Flush() {
+	if flusher, ok := t.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close waits for all queued copies to reach the sink and then closes it.
+func (t *TeeWriter) Close() error {
+	var err error
+	t.once.Do(func() {
+		close(t.pending)
+		<-t.done
+		err = t.sink.Close()
+	})
+	return err
+}
+
+// run drains queued copies into the sink until the tee is closed.
+func (t *TeeWriter) run() {
+	defer close(t.done)
+	for chunk := range t.pending {
+		if _, err := t.sink.Write(chunk); err != nil {
+			logrus.Warnf("Failed to write to stream tee sink: %v", err)
+		}
+	}
+}
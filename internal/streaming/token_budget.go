@@ -0,0 +1,111 @@
+package streaming
+
+import (
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+)
+
+// charsPerToken is the rough number of characters per token used to estimate the size of
+// streamed text when the upstream reports no usage.
+const charsPerToken = 4
+
+// estimateTokens estimates the number of tokens in a text from its length.
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+}
+
+// tokenMeter tracks the output tokens a stream has produced against its budget. Usage
+// reported by the upstream only covers the current attempt, so it is added to the estimate
+// of the text received by earlier attempts; the larger of that and the estimate of all the
+// text received wins.
+type tokenMeter struct {
+	limit    int
+	base     int
+	reported int
+}
+
+// newTokenMeter returns a meter for the given budget, or nil if the budget is unlimited.
+func newTokenMeter(limit int) *tokenMeter {
+	if limit <= 0 {
+		return nil
+	}
+	return &tokenMeter{limit: limit}
+}
+
+// startAttempt resets the reported usage at the start of an attempt.
+func (m *tokenMeter) startAttempt(accumulatedText string) {
+	if m == nil {
+		return
+	}
+	m.base = estimateTokens(accumulatedText)
+	m.reported = 0
+}
+
+// observe records the output tokens an event reports, if any.
+func (m *tokenMeter) observe(data map[string]interface{}, channelType string) {
+	if m == nil {
+		return
+	}
+	if tokens := reportedOutputTokens(data, channelType); tokens > m.reported {
+		m.reported = tokens
+	}
+}
+
+// used returns the number of output tokens produced so far.
+func (m *tokenMeter) used(accumulatedText string) int {
+	return max(estimateTokens(accumulatedText), m.base+m.reported)
+}
+
+// exceeded reports whether the stream has produced more tokens than its budget allows.
+func (m *tokenMeter) exceeded(accumulatedText string) bool {
+	return m != nil && m.used(accumulatedText) > m.limit
+}
+
+// reportedOutputTokens extracts the output token count from an event's usage fields:
+// usage.completion_tokens for OpenAI, usageMetadata.candidatesTokenCount for Gemini and
+// usage.output_tokens or message.usage.output_tokens for Anthropic.
+func reportedOutputTokens(data map[string]interface{}, channelType string) int {
+	var usage map[string]interface{}
+	var field string
+	switch channelType {
+	case "gemini":
+		usage, _ = data["usageMetadata"].(map[string]interface{})
+		field = "candidatesTokenCount"
+	case "anthropic":
+		usage, _ = data["usage"].(map[string]interface{})
+		if message, ok := data["message"].(map[string]interface{}); ok && usage == nil {
+			usage, _ = message["usage"].(map[string]interface{})
+		}
+		field = "output_tokens"
+	default:
+		usage, _ = data["usage"].(map[string]interface{})
+		field = "completion_tokens"
+	}
+
+	tokens, _ := usage[field].(float64)
+	return int(tokens)
+}
+
+// writeTokenBudgetCutoff ends a stream that ran over its token budget with the channel's
+// own length-limited terminal events, so clients see a regular response cut at max tokens.
+func (sh *StreamHandler) writeTokenBudgetCutoff(writer http.ResponseWriter, channelType string) error {
+	var events string
+	switch channelType {
+	case "gemini":
+		events = `data: {"candidates":[{"content":{"parts":[{"text":""}],"role":"model"},"finishReason":"MAX_TOKENS","index":0}]}` + "\n\n"
+	case "anthropic":
+		events = "event: message_delta\n" + `data: {"type":"message_delta","delta":{"stop_reason":"max_tokens","stop_sequence":null}}` + "\n\n" +
+			"event: message_stop\n" + `data: {"type":"message_stop"}` + "\n\n"
+	default:
+		events = `data: {"choices":[{"index":0,"delta":{},"finish_reason":"length"}]}` + "\n\n" + "data: [DONE]\n\n"
+	}
+
+	if _, err := fmt.Fprint(writer, events); err != nil {
+		return fmt.Errorf("failed to write token budget cutoff: %w", err)
+	}
+	if flusher, ok := writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
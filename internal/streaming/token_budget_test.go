@@ -0,0 +1,62 @@
+package streaming
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBudgetCutsStreamWithLengthMarker(t *testing.T) {
+	// 40 characters per chunk are estimated as 10 tokens each
+	chunk := strings.Repeat("word ", 8)
+	stream := contentChunk(chunk, "") + contentChunk(chunk, "") + contentChunk(chunk, "") + contentChunk(chunk, "stop") + "data: [DONE]\n\n"
+
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Millisecond, TokenBudget: 15})
+	retries := 0
+	retryFunc := func(accumulatedText string) (*http.Response, error) {
+		retries++
+		return newStreamResponse(contentChunk("more", "stop")), nil
+	}
+
+	recorder := httptest.NewRecorder()
+	if err := handler.HandleStreamingResponse(newStreamResponse(stream), recorder, "openai", nil, retryFunc); err != nil {
+		t.Fatalf("Expected the cut stream to end cleanly, got %v", err)
+	}
+	if retries != 0 {
+		t.Errorf("Expected no retries after the budget was exceeded, got %d", retries)
+	}
+
+	body := recorder.Body.String()
+	if count := strings.Count(body, chunk); count != 2 {
+		t.Errorf("Expected the stream cut after the chunk that exceeded the budget, got %d chunks in %q", count, body)
+	}
+	cutoff := `data: {"choices":[{"index":0,"delta":{},"finish_reason":"length"}]}` + "\n\ndata: [DONE]\n\n"
+	if !strings.Contains(body, cutoff) {
+		t.Errorf("Expected a length finish reason followed by [DONE], got %q", body)
+	}
+	if !strings.Contains(body, ": "+FinishReasonHeader+": length\n\n") {
+		t.Errorf("Expected the length finish reason trailer, got %q", body)
+	}
+}
+
+func TestTokenBudgetUsesReportedUsage(t *testing.T) {
+	// The text alone is far below the budget, the reported usage is not
+	stream := `data: {"candidates":[{"content":{"parts":[{"text":"Hi"}]}}],"usageMetadata":{"candidatesTokenCount":120}}` + "\n\n" +
+		geminiChunk("never sent")
+
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, TokenBudget: 100})
+	recorder := httptest.NewRecorder()
+	if err := handler.HandleStreamingResponse(newStreamResponse(stream), recorder, "gemini", nil, nil); err != nil {
+		t.Fatalf("Expected the cut stream to end cleanly, got %v", err)
+	}
+
+	body := recorder.Body.String()
+	if strings.Contains(body, "never sent") {
+		t.Errorf("Expected nothing forwarded after the budget was exceeded, got %q", body)
+	}
+	if !strings.Contains(body, `"finishReason":"MAX_TOKENS"`) {
+		t.Errorf("Expected a MAX_TOKENS terminal event, got %q", body)
+	}
+}
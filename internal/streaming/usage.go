@@ -0,0 +1,231 @@
+package streaming
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// usageCounts are the token counts an upstream reports for a response.
+type usageCounts struct {
+	prompt     int
+	completion int
+	total      int
+}
+
+// usageTally sums the usage reported by all attempts of a stream. Every attempt is billed,
+// but each only reports its own counts, so after a retry the usage the client sees would
+// only cover the last attempt. Within an attempt the latest report wins, since Gemini repeats
+// its running counts on every event.
+type usageTally struct {
+	// includeUsage is set when the OpenAI upstream was asked for the usage chunk, which
+	// follows the chunk with the finish reason
+	includeUsage bool
+	attempts     int
+	done         usageCounts
+	current      usageCounts
+	reported     bool
+	last         map[string]interface{}
+}
+
+// startAttempt adds the usage of the previous attempt to the totals.
+func (t *usageTally) startAttempt() {
+	t.attempts++
+	t.done.prompt += t.current.prompt
+	t.done.completion += t.current.completion
+	t.done.total += t.current.total
+	t.current = usageCounts{}
+}
+
+// observe records the usage an event reports, if any.
+func (t *usageTally) observe(data map[string]interface{}, channelType string) {
+	counts, ok := reportedUsage(data, channelType)
+	if !ok {
+		return
+	}
+	t.current = counts
+	t.reported = true
+	t.last = data
+}
+
+// merging reports whether usage is held back from the client to be sent once, summed, at
+// the end of the stream. The first attempt's usage is forwarded as it arrives.
+func (t *usageTally) merging(channelType string) bool {
+	return t.attempts > 1 && (channelType == "openai" || channelType == "gemini")
+}
+
+// requestsUsage reports whether an OpenAI request asks for the usage chunk with
+// stream_options.include_usage.
+func requestsUsage(originalRequest interface{}) bool {
+	var body []byte
+	switch req := originalRequest.(type) {
+	case []byte:
+		body = req
+	case string:
+		body = []byte(req)
+	default:
+		return false
+	}
+
+	var request struct {
+		StreamOptions struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return false
+	}
+	return request.StreamOptions.IncludeUsage
+}
+
+// reportedUsage extracts the token counts of an event: usage for OpenAI and usageMetadata for
+// Gemini. Other channels are not merged.
+func reportedUsage(data map[string]interface{}, channelType string) (usageCounts, bool) {
+	var usage map[string]interface{}
+	var promptField, completionField, totalField string
+	switch channelType {
+	case "openai":
+		usage, _ = data["usage"].(map[string]interface{})
+		promptField, completionField, totalField = "prompt_tokens", "completion_tokens", "total_tokens"
+	case "gemini":
+		usage, _ = data["usageMetadata"].(map[string]interface{})
+		promptField, completionField, totalField = "promptTokenCount", "candidatesTokenCount", "totalTokenCount"
+	default:
+		return usageCounts{}, false
+	}
+	if usage == nil {
+		return usageCounts{}, false
+	}
+
+	prompt, _ := usage[promptField].(float64)
+	completion, _ := usage[completionField].(float64)
+	total, ok := usage[totalField].(float64)
+	if !ok {
+		total = prompt + completion
+	}
+	return usageCounts{prompt: int(prompt), completion: int(completion), total: int(total)}, true
+}
+
+// stripUsage removes the usage from a line forwarded while usage is merged. It returns false
+// for an event that carries nothing but usage, which is dropped.
+func stripUsage(line, channelType string) (string, bool) {
+	key, contentKey := "usage", "choices"
+	if channelType == "gemini" {
+		key, contentKey = "usageMetadata", "candidates"
+	}
+
+	dataContent, ok := strings.CutPrefix(line, "data: ")
+	if !ok || !strings.Contains(dataContent, `"`+key+`"`) {
+		return line, true
+	}
+
+	// Keep numbers as written so large integers survive re-marshaling
+	var parsedData map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(dataContent))
+	decoder.UseNumber()
+	if err := decoder.Decode(&parsedData); err != nil {
+		return line, true
+	}
+	if _, ok := parsedData[key]; !ok {
+		return line, true
+	}
+
+	delete(parsedData, key)
+	if content, _ := parsedData[contentKey].([]interface{}); len(content) == 0 {
+		return "", false
+	}
+	newDataBytes, err := json.Marshal(parsedData)
+	if err != nil {
+		return line, true
+	}
+	return "data: " + string(newDataBytes), true
+}
+
+// mergedUsageLine renders an event carrying only the usage summed over all attempts, in the
+// format of the channel, keeping the identifying fields of the last event that reported usage.
+func (t *usageTally) mergedUsageLine(channelType string) string {
+	total := usageCounts{
+		prompt:     t.done.prompt + t.current.prompt,
+		completion: t.done.completion + t.current.completion,
+		total:      t.done.total + t.current.total,
+	}
+
+	event := make(map[string]interface{})
+	if channelType == "gemini" {
+		for _, field := range []string{"modelVersion", "responseId"} {
+			if value, ok := t.last[field]; ok {
+				event[field] = value
+			}
+		}
+		event["usageMetadata"] = map[string]int{
+			"promptTokenCount":     total.prompt,
+			"candidatesTokenCount": total.completion,
+			"totalTokenCount":      total.total,
+		}
+	} else {
+		for _, field := range []string{"id", "object", "created", "model", "system_fingerprint"} {
+			if value, ok := t.last[field]; ok {
+				event[field] = value
+			}
+		}
+		event["choices"] = []interface{}{}
+		event["usage"] = map[string]int{
+			"prompt_tokens":     total.prompt,
+			"completion_tokens": total.completion,
+			"total_tokens":      total.total,
+		}
+	}
+
+	data, _ := json.Marshal(event)
+	return "data: " + string(data)
+}
+
+// writeMergedUsage sends the usage summed over all attempts, once, for a stream that needed
+// more than one attempt and whose upstream reported usage.
+func (sh *StreamHandler) writeMergedUsage(writer http.ResponseWriter, channelType string) {
+	if sh.usage == nil || !sh.usage.merging(channelType) || !sh.usage.reported {
+		return
+	}
+	if _, err := fmt.Fprintf(writer, "%s\n\n", sh.usage.mergedUsageLine(channelType)); err != nil {
+		sh.log.Debugf("Failed to write merged usage: %v", err)
+		return
+	}
+	if flusher, ok := writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// forwardUsageTrailer forwards what an OpenAI stream sends after its finish reason, up to
+// [DONE]: the usage chunk the client asked for. Without it the usage would never be read.
+func (sh *StreamHandler) forwardUsageTrailer(events *dataLineJoiner, writer http.ResponseWriter, flusher http.Flusher, channelType string) {
+	for events.Scan() {
+		if sh.clientGone() != nil {
+			return
+		}
+		line := events.Text()
+		if isDoneSignal(line) {
+			return
+		}
+		dataContent, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+
+		data, err := sh.parseEvent(dataContent)
+		if err != nil {
+			continue
+		}
+		sh.usage.observe(data, channelType)
+		if sh.usage.merging(channelType) {
+			if line, ok = stripUsage(line, channelType); !ok {
+				continue
+			}
+		}
+		if _, err := fmt.Fprintf(writer, "%s\n\n", line); err != nil {
+			sh.log.Debugf("Failed to write usage: %v", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,90 @@
+package streaming
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsageIsMergedAcrossAttempts(t *testing.T) {
+	tests := []struct {
+		name        string
+		channelType string
+		first       string
+		retry       string
+		merged      string
+		dropped     string
+	}{
+		{
+			name:        "gemini",
+			channelType: "gemini",
+			first:       `data: {"candidates":[{"content":{"parts":[{"text":"Half of"}]}}],"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":3,"totalTokenCount":13},"modelVersion":"g"}` + "\n\n",
+			retry:       `data: {"candidates":[{"content":{"parts":[{"text":" the rest. [done]"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":14,"candidatesTokenCount":5,"totalTokenCount":19},"modelVersion":"g"}` + "\n\n",
+			merged:      `data: {"modelVersion":"g","usageMetadata":{"candidatesTokenCount":8,"promptTokenCount":24,"totalTokenCount":32}}`,
+			dropped:     `"promptTokenCount":14`,
+		},
+		{
+			name:        "openai",
+			channelType: "openai",
+			first:       openAIDeltaEvent("Half of") + `data: {"id":"c1","model":"m","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":3,"total_tokens":13}}` + "\n\n",
+			retry: `data: {"id":"c2","model":"m","choices":[{"delta":{"content":" the rest."},"finish_reason":"stop"}]}` + "\n\n" +
+				`data: {"id":"c2","model":"m","choices":[],"usage":{"prompt_tokens":14,"completion_tokens":5,"total_tokens":19}}` + "\n\n" + "data: [DONE]\n\n",
+			merged:  `data: {"choices":[],"id":"c2","model":"m","usage":{"completion_tokens":8,"prompt_tokens":24,"total_tokens":32}}`,
+			dropped: `"prompt_tokens":14`,
+		},
+	}
+
+	for _, test := range tests {
+		handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]"}})
+		request := []byte(`{"stream":true,"stream_options":{"include_usage":true}}`)
+		retryFunc := func(accumulatedText string) (*http.Response, error) {
+			return newStreamResponse(test.retry), nil
+		}
+
+		recorder := httptest.NewRecorder()
+		if err := handler.HandleStreamingResponse(newStreamResponse(test.first), recorder, test.channelType, request, retryFunc); err != nil {
+			t.Fatalf("%s: expected stream to complete, got %v", test.name, err)
+		}
+		body := recorder.Body.String()
+		if strings.Count(body, test.merged) != 1 {
+			t.Errorf("%s: expected one merged usage event %s, got %q", test.name, test.merged, body)
+		}
+		if strings.Contains(body, test.dropped) {
+			t.Errorf("%s: expected the retry's own usage to be held back, got %q", test.name, body)
+		}
+		if !strings.Contains(body, " the rest.") {
+			t.Errorf("%s: expected the continuation text to be forwarded, got %q", test.name, body)
+		}
+	}
+}
+
+func TestUsageOfSingleAttemptIsForwardedUnchanged(t *testing.T) {
+	event := `data: {"candidates":[{"content":{"parts":[{"text":"All done. [done]"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":3,"totalTokenCount":13}}` + "\n\n"
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 2, RetryDelay: time.Millisecond, DoneTokenPatterns: []string{"[done]"}})
+
+	recorder := httptest.NewRecorder()
+	if err := handler.HandleStreamingResponse(newStreamResponse(event), recorder, "gemini", nil, nil); err != nil {
+		t.Fatalf("Expected stream to complete, got %v", err)
+	}
+	if count := strings.Count(recorder.Body.String(), "usageMetadata"); count != 1 {
+		t.Errorf("Expected the upstream usage only, got %d usage events in %q", count, recorder.Body.String())
+	}
+}
+
+func TestUsageChunkRequestedByGroupIsForwarded(t *testing.T) {
+	stream := `data: {"choices":[{"delta":{"content":"Hi."},"finish_reason":"stop"}]}` + "\n\n" +
+		`data: {"choices":[],"usage":{"prompt_tokens":4,"completion_tokens":1,"total_tokens":5}}` + "\n\n" + "data: [DONE]\n\n"
+
+	for _, includeUsage := range []bool{false, true} {
+		handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, IncludeUsage: includeUsage})
+		recorder := httptest.NewRecorder()
+		if err := handler.HandleStreamingResponse(newStreamResponse(stream), recorder, "openai", []byte(`{"stream":true}`), nil); err != nil {
+			t.Fatalf("Expected stream to complete, got %v", err)
+		}
+		if got := strings.Contains(recorder.Body.String(), `"prompt_tokens":4`); got != includeUsage {
+			t.Errorf("IncludeUsage %v: expected usage forwarded %v, got %q", includeUsage, includeUsage, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package streaming
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// rawByteBase offsets bytes of invalid UTF-8 sequences into a private-use range so they
+// survive JSON decoding instead of being replaced with U+FFFD.
+const rawByteBase = 0xF700
+
+// protectRawBytes maps every byte that is not part of a valid UTF-8 sequence onto a
+// private-use rune. Upstreams that split a multi-byte character across two events emit
+// such bytes at the end of one event and the start of the next.
+func protectRawBytes(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s) + 8)
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b.WriteRune(rawByteBase + rune(s[i]))
+		} else {
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// restoreRawBytes reverses protectRawBytes on text extracted from a protected event.
+func restoreRawBytes(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if r >= rawByteBase+utf8.RuneSelf && r <= rawByteBase+0xFF {
+			b.WriteByte(byte(r - rawByteBase))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// runeCarry holds back an incomplete multi-byte sequence at the end of a text chunk
+// until the following chunk completes it.
+type runeCarry struct {
+	pending string
+}
+
+// Append joins s to any pending bytes and returns the longest prefix that does not end
+// in an incomplete rune; the remainder is kept for the next call.
+func (rc *runeCarry) Append(s string) string {
+	s = rc.pending + s
+	rc.pending = ""
+
+	for i := len(s) - 1; i >= 0 && i >= len(s)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(s[i]) {
+			continue
+		}
+		if !utf8.FullRuneInString(s[i:]) {
+			rc.pending = s[i:]
+			s = s[:i]
+		}
+		break
+	}
+	return s
+}
+
+// Pending returns the number of bytes waiting for the rest of their rune.
+func (rc *runeCarry) Pending() int {
+	return len(rc.pending)
+}
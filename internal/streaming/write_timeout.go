@@ -0,0 +1,70 @@
+package streaming
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrClientWriteTimeout is returned when a write or flush to the client did not finish
+// within the configured write timeout.
+var ErrClientWriteTimeout = errors.New("client write timed out")
+
+// timeoutWriter bounds every write and flush to a slow client. The call runs in the
+// background and is abandoned once the timeout passes, so a stuck client aborts the stream
+// instead of holding the upstream connection open. After a timeout the connection's write
+// deadline is moved to now to unblock the abandoned call, and all later calls fail at once.
+type timeoutWriter struct {
+	http.ResponseWriter
+	timeout time.Duration
+	failed  bool
+}
+
+func (w *timeoutWriter) Write(p []byte) (int, error) {
+	if w.failed {
+		return 0, ErrClientWriteTimeout
+	}
+
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := w.ResponseWriter.Write(p)
+		done <- result{n, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.n, r.err
+	case <-time.After(w.timeout):
+		w.abandon()
+		return 0, ErrClientWriteTimeout
+	}
+}
+
+func (w *timeoutWriter) Flush() {
+	flusher, ok := w.ResponseWriter.(http.Flusher)
+	if !ok || w.failed {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		flusher.Flush()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(w.timeout):
+		w.abandon()
+	}
+}
+
+// abandon gives up on the client after a timed out call.
+func (w *timeoutWriter) abandon() {
+	w.failed = true
+	_ = http.NewResponseController(w.ResponseWriter).SetWriteDeadline(time.Now())
+}
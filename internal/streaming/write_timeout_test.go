@@ -0,0 +1,56 @@
+package streaming
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingWriter accepts headers but never finishes a write, like a client that stopped reading.
+type blockingWriter struct {
+	*httptest.ResponseRecorder
+	unblock chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	return 0, io.ErrClosedPipe
+}
+
+// closeTrackingBody records whether the upstream body was closed.
+type closeTrackingBody struct {
+	io.Reader
+	closed atomic.Bool
+}
+
+func (b *closeTrackingBody) Close() error {
+	b.closed.Store(true)
+	return nil
+}
+
+func TestSlowClientAbortsStreamAfterWriteTimeout(t *testing.T) {
+	handler := NewStreamHandler(StreamConfig{MaxRetries: 1, RetryDelay: time.Millisecond, WriteTimeout: 50 * time.Millisecond})
+
+	writer := &blockingWriter{ResponseRecorder: httptest.NewRecorder(), unblock: make(chan struct{})}
+	defer close(writer.unblock)
+
+	resp := newStreamResponse("")
+	body := &closeTrackingBody{Reader: strings.NewReader(geminiChunk("Hello") + geminiChunk(" world.[done]"))}
+	resp.Body = body
+
+	start := time.Now()
+	err := handler.HandleStreamingResponse(resp, writer, "gemini", nil, nil)
+	if !errors.Is(err, ErrClientWriteTimeout) {
+		t.Fatalf("Expected the stream to abort with a write timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the stream to abort shortly after the timeout, took %v", elapsed)
+	}
+	if !body.closed.Load() {
+		t.Error("Expected the upstream body to be closed")
+	}
+}
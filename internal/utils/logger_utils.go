@@ -1,10 +1,12 @@
 package utils
 
 import (
+	"gpt-load/internal/models"
 	"gpt-load/internal/types"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 )
@@ -48,3 +50,54 @@ func SetupLogger(configManager types.ConfigManager) {
 		}
 	}
 }
+
+var (
+	scopedLoggersMu sync.Mutex
+	scopedLoggers   = make(map[logrus.Level]*logrus.Logger)
+)
+
+// ScopedLogger returns a logger with its own level that shares the global logger's
+// output, formatter and hooks. An empty or invalid level yields the global logger.
+func ScopedLogger(level string) *logrus.Logger {
+	std := logrus.StandardLogger()
+	if level == "" {
+		return std
+	}
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return std
+	}
+
+	scopedLoggersMu.Lock()
+	defer scopedLoggersMu.Unlock()
+
+	logger, ok := scopedLoggers[lvl]
+	if !ok {
+		logger = &logrus.Logger{
+			Out:          standardOutput{},
+			Formatter:    std.Formatter,
+			Hooks:        std.Hooks,
+			Level:        lvl,
+			ExitFunc:     std.ExitFunc,
+			ReportCaller: std.ReportCaller,
+		}
+		scopedLoggers[lvl] = logger
+	}
+	return logger
+}
+
+// standardOutput writes to whatever output the global logger currently uses, so scoped
+// loggers follow later changes such as file logging set up by SetupLogger.
+type standardOutput struct{}
+
+func (standardOutput) Write(p []byte) (int, error) {
+	return logrus.StandardLogger().Out.Write(p)
+}
+
+// GroupLogger returns the logger honoring the group's log level override.
+func GroupLogger(group *models.Group) *logrus.Logger {
+	if group == nil {
+		return logrus.StandardLogger()
+	}
+	return ScopedLogger(group.EffectiveConfig.LogLevel)
+}